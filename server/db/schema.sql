@@ -21,12 +21,42 @@ CREATE TABLE IF NOT EXISTS products (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- Users table
+-- Tenants: organizations with their own user namespace (TCP auth server).
+-- Session limits are in seconds; NULL keeps the server's settings.
+CREATE TABLE IF NOT EXISTS tenants (
+    id VARCHAR(63) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    session_max_lifetime INTEGER,
+    session_idle_timeout INTEGER,
+    password_policy JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every existing user belongs to the default tenant
+INSERT INTO tenants (id, name) VALUES ('default', 'Default')
+ON CONFLICT (id) DO NOTHING;
+
+-- Users table. Usernames and emails are unique per tenant.
 CREATE TABLE IF NOT EXISTS users (
     id VARCHAR(50) PRIMARY KEY,
-    username VARCHAR(255) NOT NULL UNIQUE,
-    email VARCHAR(255) NOT NULL UNIQUE,
+    tenant_id VARCHAR(63) NOT NULL DEFAULT 'default' REFERENCES tenants(id),
+    username VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    -- NFKC + case folded forms used for lookup and uniqueness (TCP auth server)
+    username_canonical VARCHAR(255),
+    email_canonical VARCHAR(255),
+    -- Empty for users without a local password
     password_hash VARCHAR(255) NOT NULL,
+    max_sessions INTEGER,
+    -- Credential backend owning the account, e.g. an LDAP directory; NULL
+    -- for local accounts
+    auth_backend VARCHAR(50),
+    -- active, suspended, disabled or pending_deletion; a suspension or
+    -- disablement lapses at status_expires_at when set
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    status_reason TEXT,
+    status_expires_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -34,12 +64,187 @@ CREATE TABLE IF NOT EXISTS users (
 -- User sessions table (PostgreSQL backup, Redis is primary)
 CREATE TABLE IF NOT EXISTS user_sessions (
     id SERIAL PRIMARY KEY,
+    session_id VARCHAR(50),
     user_id VARCHAR(50) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     session_token VARCHAR(255) NOT NULL UNIQUE,
+    client_type VARCHAR(50),
+    client_ip VARCHAR(100),
+    user_agent TEXT,
+    scopes TEXT[],
     expires_at TIMESTAMP NOT NULL,
+    absolute_expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    -- Admin acting as the user in an impersonation session
+    impersonator_id VARCHAR(50),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Token signing keys (TCP auth server, shared by all replicas)
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    state VARCHAR(16) NOT NULL CHECK (state IN ('pending', 'active', 'retired')),
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    activated_at TIMESTAMP,
+    retired_at TIMESTAMP
+);
+
+-- Accounts at upstream OpenID Connect providers linked to users (TCP auth
+-- server). Users created at their first federated login have an empty
+-- password_hash until they set a password.
+CREATE TABLE IF NOT EXISTS federated_identities (
+    id VARCHAR(50) PRIMARY KEY,
+    user_id VARCHAR(50) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id VARCHAR(63) NOT NULL REFERENCES tenants(id),
+    provider VARCHAR(63) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP,
+    UNIQUE (tenant_id, provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- OAuth 2.0 clients (TCP auth server). Public clients have no secret;
+-- for confidential clients only the SHA-256 hash of the secret is stored.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    client_id VARCHAR(64) PRIMARY KEY,
+    secret_hash VARCHAR(64),
+    tenant_id VARCHAR(63) NOT NULL REFERENCES tenants(id),
+    name VARCHAR(255) NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    grant_types TEXT[] NOT NULL,
+    scopes TEXT[],
+    public BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    post_logout_redirect_uris TEXT[],
+    CONSTRAINT oauth_clients_secret CHECK (public OR secret_hash IS NOT NULL)
+);
+
+-- OAuth 2.0 authorization codes, kept until they expire so a second
+-- redemption can be detected
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    code_hash VARCHAR(64) PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id VARCHAR(50) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[],
+    code_challenge VARCHAR(128),
+    code_challenge_method VARCHAR(10),
+    session_token VARCHAR(255),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP,
+    nonce TEXT
+);
+
+-- Refresh tokens (TCP auth server). Tokens descending from one login share
+-- a family_id; only the SHA-256 hash of each token is stored.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id VARCHAR(50) PRIMARY KEY,
+    family_id VARCHAR(50) NOT NULL,
+    parent_id VARCHAR(50),
+    user_id VARCHAR(50) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    session_token VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('active', 'rotated', 'revoked')),
+    scopes TEXT[],
+    expires_at TIMESTAMP NOT NULL,
+    -- Expiry of the family's first token, which no rotation extends
+    family_expires_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP,
+    client_id VARCHAR(64) REFERENCES oauth_clients(client_id) ON DELETE CASCADE
+);
+
+-- Remember-me credentials (TCP auth server). The series is stable per device;
+-- the token rotates on every use and only its SHA-256 hash is stored.
+CREATE TABLE IF NOT EXISTS persistent_logins (
+    series VARCHAR(50) PRIMARY KEY,
+    user_id VARCHAR(50) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL,
+    client_type VARCHAR(50),
+    user_agent TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Role-based access control (TCP auth server). Permissions are colon
+-- separated names; '*' grants everything and 'orders:*' everything under orders.
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(100) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(100) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id VARCHAR(50) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(100) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role)
+);
+
+-- Service accounts: non-human principals (TCP auth server)
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    client_id VARCHAR(64) NOT NULL UNIQUE,
+    secret_hash VARCHAR(64) NOT NULL,
+    disabled BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS service_account_roles (
+    service_account_id VARCHAR(50) NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    role VARCHAR(100) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (service_account_id, role)
+);
+
+-- API keys (TCP auth server). Keys look like ak_<prefix>_<secret>; only the
+-- prefix is stored in the clear, the secret as a SHA-256 hash.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(50) PRIMARY KEY,
+    prefix VARCHAR(32) NOT NULL UNIQUE,
+    secret_hash VARCHAR(64) NOT NULL,
+    user_id VARCHAR(50) REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    scopes TEXT[],
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    service_account_id VARCHAR(50) REFERENCES service_accounts(id) ON DELETE CASCADE,
+    CONSTRAINT api_keys_single_owner CHECK ((user_id IS NULL) <> (service_account_id IS NULL))
+);
+
+-- Security and admin audit trail (TCP auth server)
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(100) NOT NULL,
+    user_id VARCHAR(50),
+    actor_id VARCHAR(50),
+    ip_address VARCHAR(100),
+    details JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    principal_type VARCHAR(20) NOT NULL DEFAULT 'user'
+);
+
 -- Cart items table
 CREATE TABLE IF NOT EXISTS cart_items (
     id SERIAL PRIMARY KEY,
@@ -78,6 +283,34 @@ CREATE TABLE IF NOT EXISTS order_items (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Upgrades for databases created before the columns above existed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_sessions INTEGER;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(63) NOT NULL DEFAULT 'default' REFERENCES tenants(id);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_canonical VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_canonical VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_backend VARCHAR(50);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_expires_at TIMESTAMP;
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS impersonator_id VARCHAR(50);
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS session_id VARCHAR(50);
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS client_type VARCHAR(50);
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS client_ip VARCHAR(100);
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMP;
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS scopes TEXT[];
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scopes TEXT[];
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id VARCHAR(64) REFERENCES oauth_clients(client_id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_expires_at TIMESTAMP;
+ALTER TABLE api_keys ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS service_account_id VARCHAR(50) REFERENCES service_accounts(id) ON DELETE CASCADE;
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS principal_type VARCHAR(20) NOT NULL DEFAULT 'user';
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS post_logout_redirect_uris TEXT[];
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS nonce TEXT;
+
 -- Indexes for performance
 CREATE INDEX IF NOT EXISTS idx_products_category ON products(category);
 CREATE INDEX IF NOT EXISTS idx_products_in_stock ON products(in_stock);
@@ -89,9 +322,29 @@ CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders(created_at);
 CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items(order_id);
 CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_username ON users(tenant_id, username);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users(tenant_id, email);
+-- Existing rows are backfilled by tcp-auth-server/cmd/normalize-identities
+DROP INDEX IF EXISTS idx_users_username_canonical;
+DROP INDEX IF EXISTS idx_users_email_canonical;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_username_canonical ON users(tenant_id, username_canonical);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email_canonical ON users(tenant_id, email_canonical);
 CREATE INDEX IF NOT EXISTS idx_user_sessions_token ON user_sessions(session_token);
 CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id);
 CREATE INDEX IF NOT EXISTS idx_user_sessions_expires_at ON user_sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_token ON refresh_tokens(session_token);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_client_id ON refresh_tokens(client_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_clients_tenant_id ON oauth_clients(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_authorization_codes_expires_at ON oauth_authorization_codes(expires_at);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role ON user_roles(role);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_service_account_id ON api_keys(service_account_id);
+CREATE INDEX IF NOT EXISTS idx_service_account_roles_role ON service_account_roles(role);
+CREATE INDEX IF NOT EXISTS idx_persistent_logins_user_id ON persistent_logins(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
 
 -- Insert sample products data
 INSERT INTO products (id, name, price, description, image, category, in_stock, rating) VALUES
@@ -104,3 +357,23 @@ INSERT INTO products (id, name, price, description, image, category, in_stock, r
 ('7', 'Backpack', 79.99, 'Durable backpack for travel and daily use', 'https://via.placeholder.com/300x300?text=Backpack', 'Accessories', false, 4.00),
 ('8', 'Bluetooth Speaker', 149.99, 'Portable Bluetooth speaker with excellent sound quality', 'https://via.placeholder.com/300x300?text=Speaker', 'Electronics', true, 4.00)
 ON CONFLICT (id) DO NOTHING;
+
+-- Default roles and permissions
+INSERT INTO permissions (name, description) VALUES
+('*', 'Every permission'),
+('auth:admin', 'Use the auth server admin requests'),
+('products:write', 'Create and edit products'),
+('orders:read', 'View every order'),
+('orders:write', 'Change the status of any order')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description) VALUES
+('admin', 'Full access'),
+('support', 'Customer support staff')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+('admin', '*'),
+('support', 'orders:read'),
+('support', 'orders:write')
+ON CONFLICT (role, permission) DO NOTHING;
@@ -0,0 +1,147 @@
+// Command export-users writes local password users as CSV or JSONL in the
+// format import-users reads, for moving accounts between deployments.
+//
+// Password hashes are only written with -hashes; without them the output
+// lists accounts but cannot be imported. Users owned by another credential
+// backend, users without a password and accounts pending deletion are
+// skipped. Account status, roles, sessions and other data do not carry
+// over: imported users start active.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"tcp-auth-server/internal/config"
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+	"tcp-auth-server/pkg/postgres"
+)
+
+// record is one row of the output, named as import-users expects
+type record struct {
+	Tenant       string `json:"tenant"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	CreatedAt    string `json:"created_at"`
+}
+
+func main() {
+	format := flag.String("format", "csv", "output format: csv or jsonl")
+	tenantID := flag.String("tenant", "", "export only this tenant (default is every tenant)")
+	hashes := flag.Bool("hashes", false, "include password hashes")
+	output := flag.String("o", "-", "output file")
+	flag.Parse()
+
+	if *format != "csv" && *format != "jsonl" {
+		log.Fatalf("Unsupported format: %s", *format)
+	}
+
+	client, err := postgres.NewClient(
+		config.String("PG_HOST", "localhost"),
+		config.String("PG_PORT", "5432"),
+		config.String("PG_USER", "postgres"),
+		config.String("PG_PASSWORD", ""),
+		config.String("PG_DATABASE", "ShoppingCS-LB"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer client.Close()
+
+	users, err := repository.NewUserRepository(client).ExportUsers(context.Background(), *tenantID)
+	if err != nil {
+		log.Fatalf("Failed to list users: %v", err)
+	}
+
+	var records []*record
+	for _, user := range users {
+		if user.Backend != "" || user.PasswordHash == "" || user.Status == models.UserStatusPendingDeletion {
+			continue
+		}
+		rec := &record{
+			Tenant:    user.TenantID,
+			Username:  user.Username,
+			Email:     user.Email,
+			CreatedAt: user.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		if *hashes {
+			rec.PasswordHash = user.PasswordHash
+		}
+		records = append(records, rec)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		// The file may hold password hashes
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("Failed to create output: %v", err)
+		}
+		out = file
+	}
+
+	writer := bufio.NewWriter(out)
+	if *format == "csv" {
+		err = writeCSV(writer, records, *hashes)
+	} else {
+		err = writeJSONL(writer, records)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d of %d users\n", len(records), len(users))
+}
+
+// writeCSV writes a header row and one row per user
+func writeCSV(w io.Writer, records []*record, hashes bool) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{"tenant", "username", "email", "created_at"}
+	if hashes {
+		header = append(header, "password_hash")
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		fields := []string{rec.Tenant, rec.Username, rec.Email, rec.CreatedAt}
+		if hashes {
+			fields = append(fields, rec.PasswordHash)
+		}
+		if err := writer.Write(fields); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeJSONL writes one JSON object per user
+func writeJSONL(w io.Writer, records []*record) error {
+	encoder := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := encoder.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
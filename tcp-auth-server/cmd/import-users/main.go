@@ -0,0 +1,432 @@
+// Command import-users creates local password users in bulk from a CSV or
+// JSONL file, such as the customer base of a system being migrated.
+//
+// Each row names a username, an email address and either a plaintext
+// password, which must meet its tenant's password policy unless
+// -skip-policy is given and is hashed with the server's settings, or a
+// password hash in a format the server verifies (bcrypt or Argon2id),
+// which is stored as is and upgraded at the user's next login. Rows may
+// also name a tenant, defaulting to -tenant, and a creation time in
+// RFC 3339.
+//
+// Rows that are invalid or whose username or email is already taken, in
+// the database or by an earlier row, are reported by line number and
+// skipped. The rest are inserted in batches with COPY. It exits with
+// status 1 when any row was rejected.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/config"
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+	"tcp-auth-server/internal/service"
+	"tcp-auth-server/pkg/identity"
+	"tcp-auth-server/pkg/password"
+	"tcp-auth-server/pkg/postgres"
+)
+
+// maxIdentifierLength is the size of the username and email columns
+const maxIdentifierLength = 255
+
+// record is one row of the input. CSV columns and JSON fields share these
+// names.
+type record struct {
+	Tenant       string `json:"tenant"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	PasswordHash string `json:"password_hash"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// row is a parsed input row with its line number
+type row struct {
+	line   int
+	record *record
+	err    error
+	user   *models.User
+}
+
+func main() {
+	format := flag.String("format", "", "input format: csv or jsonl (default from the file extension, else csv)")
+	tenantID := flag.String("tenant", "default", "tenant of rows without one")
+	batchSize := flag.Int("batch", 1000, "users inserted per COPY")
+	dryRun := flag.Bool("dry-run", false, "validate the input without inserting anything")
+	skipPolicy := flag.Bool("skip-policy", false, "accept plaintext passwords the password policy would refuse")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [file]\n\nReads standard input when no file is given.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *batchSize <= 0 {
+		log.Fatalf("-batch must be positive")
+	}
+
+	input := os.Stdin
+	path := flag.Arg(0)
+	if path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open input: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	if *format == "" {
+		*format = "csv"
+		if ext := strings.ToLower(filepath.Ext(path)); ext == ".jsonl" || ext == ".ndjson" {
+			*format = "jsonl"
+		}
+	}
+
+	var rows []*row
+	var err error
+	switch *format {
+	case "csv":
+		rows, err = readCSV(input)
+	case "jsonl":
+		rows, err = readJSONL(input)
+	default:
+		log.Fatalf("Unsupported format: %s", *format)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+
+	passwords, err := config.PasswordManager()
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+	passwordPolicy, err := config.PasswordPolicy()
+	if err != nil {
+		log.Fatalf("Failed to configure password policy: %v", err)
+	}
+
+	client, err := postgres.NewClient(
+		config.String("PG_HOST", "localhost"),
+		config.String("PG_PORT", "5432"),
+		config.String("PG_USER", "postgres"),
+		config.String("PG_PASSWORD", ""),
+		config.String("PG_DATABASE", "ShoppingCS-LB"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(client)
+
+	tenantRepo := repository.NewTenantRepository(client)
+	tenants, err := tenantRepo.ListTenants(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list tenants: %v", err)
+	}
+
+	imp := &importer{
+		defaultTenant: *tenantID,
+		tenants:       make(map[string]*models.Tenant, len(tenants)),
+		passwords:     passwords,
+	}
+	for _, tenant := range tenants {
+		imp.tenants[tenant.ID] = tenant
+	}
+	if !*skipPolicy {
+		imp.policies = service.NewTenantService(tenantRepo, passwordPolicy)
+	}
+
+	existing, err := userRepo.ListIdentities(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list users: %v", err)
+	}
+	imp.taken = make(map[string]int, 2*len(existing))
+	for _, user := range existing {
+		imp.taken[usernameKey(user.TenantID, user.Username)] = 0
+		imp.taken[emailKey(user.TenantID, user.Email)] = 0
+	}
+
+	var valid []*row
+	for _, r := range rows {
+		if r.err == nil {
+			r.user, r.err = imp.validate(r)
+		}
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", r.line, r.err)
+			continue
+		}
+		valid = append(valid, r)
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry run: %d of %d users would be imported\n", len(valid), len(rows))
+		if len(valid) < len(rows) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	imported := 0
+	for start := 0; start < len(valid); start += *batchSize {
+		end := start + *batchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+		imported += insertBatch(ctx, userRepo, valid[start:end], passwords)
+	}
+
+	fmt.Printf("Imported %d of %d users\n", imported, len(rows))
+	if imported < len(rows) {
+		fmt.Printf("%d rows rejected\n", len(rows)-imported)
+		os.Exit(1)
+	}
+}
+
+// readCSV parses CSV with a header row naming the columns
+func readCSV(input io.Reader) ([]*row, error) {
+	reader := csv.NewReader(input)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	columns := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch name {
+		case "tenant", "username", "email", "password", "password_hash", "created_at":
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	if !seen["username"] || !seen["email"] || (!seen["password"] && !seen["password_hash"]) {
+		return nil, fmt.Errorf("columns username, email and password or password_hash are required")
+	}
+
+	var rows []*row
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, &row{line: parseErr.StartLine, err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		rec := &record{}
+		for i, value := range fields {
+			switch columns[i] {
+			case "tenant":
+				rec.Tenant = value
+			case "username":
+				rec.Username = value
+			case "email":
+				rec.Email = value
+			case "password":
+				rec.Password = value
+			case "password_hash":
+				rec.PasswordHash = value
+			case "created_at":
+				rec.CreatedAt = value
+			}
+		}
+		rows = append(rows, &row{line: line, record: rec})
+	}
+}
+
+// readJSONL parses one JSON object per line. Blank lines are skipped.
+func readJSONL(input io.Reader) ([]*row, error) {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []*row
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		rec := &record{}
+		if err := decoder.Decode(rec); err != nil {
+			rows = append(rows, &row{line: line, err: fmt.Errorf("invalid JSON: %w", err)})
+			continue
+		}
+		rows = append(rows, &row{line: line, record: rec})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// importer holds what rows are validated against
+type importer struct {
+	defaultTenant string
+	tenants       map[string]*models.Tenant
+	passwords     *password.Manager
+
+	// policies provides the password policy of each tenant; nil when
+	// plaintext passwords are not checked
+	policies *service.TenantService
+
+	// taken maps identifier keys to the line that claimed them, zero for
+	// users already in the database
+	taken map[string]int
+}
+
+// validate checks a row and returns the user to insert. The row's username
+// and email are claimed, so later rows cannot reuse them.
+func (imp *importer) validate(r *row) (*models.User, error) {
+	rec := r.record
+	user := &models.User{
+		TenantID:     strings.TrimSpace(rec.Tenant),
+		Username:     strings.TrimSpace(rec.Username),
+		Email:        strings.TrimSpace(rec.Email),
+		PasswordHash: strings.TrimSpace(rec.PasswordHash),
+	}
+	if user.TenantID == "" {
+		user.TenantID = imp.defaultTenant
+	}
+	tenant, ok := imp.tenants[user.TenantID]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", user.TenantID)
+	}
+
+	switch {
+	case user.Username == "":
+		return nil, fmt.Errorf("username is required")
+	case identity.LooksLikeEmail(user.Username):
+		return nil, fmt.Errorf("username must not contain '@'")
+	case len(user.Username) > maxIdentifierLength:
+		return nil, fmt.Errorf("username is longer than %d bytes", maxIdentifierLength)
+	case user.Email == "":
+		return nil, fmt.Errorf("email is required")
+	case !identity.LooksLikeEmail(user.Email):
+		return nil, fmt.Errorf("email %q is not an email address", user.Email)
+	case len(user.Email) > maxIdentifierLength:
+		return nil, fmt.Errorf("email is longer than %d bytes", maxIdentifierLength)
+	}
+
+	switch {
+	case user.PasswordHash != "" && rec.Password != "":
+		return nil, fmt.Errorf("give either password or password_hash, not both")
+	case user.PasswordHash != "":
+		if err := imp.passwords.Check(user.PasswordHash); err != nil {
+			return nil, fmt.Errorf("password_hash: %w", err)
+		}
+	case rec.Password == "":
+		return nil, fmt.Errorf("password or password_hash is required")
+	case imp.policies != nil:
+		policy := imp.policies.PasswordPolicy(tenant)
+		if err := policy.Validate(rec.Password, user.Username, user.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	if rec.CreatedAt != "" {
+		createdAt, err := time.Parse(time.RFC3339, strings.TrimSpace(rec.CreatedAt))
+		if err != nil {
+			return nil, fmt.Errorf("created_at must be an RFC 3339 time")
+		}
+		user.CreatedAt = createdAt
+	}
+
+	keys := []struct{ field, key string }{
+		{"username", usernameKey(user.TenantID, user.Username)},
+		{"email", emailKey(user.TenantID, user.Email)},
+	}
+	for _, k := range keys {
+		if line, ok := imp.taken[k.key]; ok {
+			if line == 0 {
+				return nil, fmt.Errorf("%s already exists", k.field)
+			}
+			return nil, fmt.Errorf("%s duplicates line %d", k.field, line)
+		}
+	}
+	for _, k := range keys {
+		imp.taken[k.key] = r.line
+	}
+
+	return user, nil
+}
+
+// insertBatch hashes the plaintext passwords of a batch and inserts it,
+// returning how many users were inserted. A batch that fails as a whole,
+// for example because a user registered one of its names meanwhile, is
+// retried row by row so the failing rows can be reported.
+func insertBatch(ctx context.Context, userRepo *repository.UserRepository, batch []*row, passwords *password.Manager) int {
+	var users []*models.User
+	var ready []*row
+	for _, r := range batch {
+		if r.user.PasswordHash == "" {
+			hash, err := passwords.Hash(r.record.Password)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "line %d: %v\n", r.line, err)
+				continue
+			}
+			r.user.PasswordHash = hash
+		}
+		users = append(users, r.user)
+		ready = append(ready, r)
+	}
+	if len(users) == 0 {
+		return 0
+	}
+
+	count, err := userRepo.ImportUsers(ctx, users)
+	if err == nil {
+		return int(count)
+	}
+	if len(ready) == 1 {
+		fmt.Fprintf(os.Stderr, "line %d: %v\n", ready[0].line, err)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "lines %d-%d: %v; retrying one at a time\n", ready[0].line, ready[len(ready)-1].line, err)
+	inserted := 0
+	for _, r := range ready {
+		if _, err := userRepo.ImportUsers(ctx, []*models.User{r.user}); err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", r.line, err)
+			continue
+		}
+		inserted++
+	}
+	return inserted
+}
+
+// usernameKey and emailKey identify an identifier within its tenant
+func usernameKey(tenantID, username string) string {
+	return "username/" + tenantID + "/" + identity.CanonicalUsername(username)
+}
+
+func emailKey(tenantID, email string) string {
+	return "email/" + tenantID + "/" + identity.CanonicalEmail(email)
+}
@@ -0,0 +1,123 @@
+// Command normalize-identities backfills the canonical username and email
+// columns of existing users and reports accounts of the same tenant whose
+// identifiers collide once normalized (for example "Alice" and "alice").
+//
+// It runs as a dry run unless -apply is given. Colliding accounts are never
+// modified: they keep logging in by exact match until an operator renames or
+// merges them, after which the command can be run again. It exits with
+// status 1 while collisions remain.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+	"tcp-auth-server/pkg/identity"
+	"tcp-auth-server/pkg/postgres"
+)
+
+func main() {
+	apply := flag.Bool("apply", false, "write canonical identifiers (default is a dry run)")
+	flag.Parse()
+
+	client, err := postgres.NewClient(
+		getEnv("PG_HOST", "localhost"),
+		getEnv("PG_PORT", "5432"),
+		getEnv("PG_USER", "postgres"),
+		getEnv("PG_PASSWORD", ""),
+		getEnv("PG_DATABASE", "ShoppingCS-LB"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(client)
+
+	users, err := userRepo.ListIdentities(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list users: %v", err)
+	}
+
+	usernames := groupBy(users, func(u *models.User) string { return u.TenantID + "/" + identity.CanonicalUsername(u.Username) })
+	emails := groupBy(users, func(u *models.User) string { return u.TenantID + "/" + identity.CanonicalEmail(u.Email) })
+
+	colliding := make(map[string]bool)
+	collisions := report("username", usernames, func(u *models.User) string { return u.Username }, colliding)
+	collisions += report("email", emails, func(u *models.User) string { return u.Email }, colliding)
+
+	updated := 0
+	for _, user := range users {
+		if colliding[user.ID] {
+			continue
+		}
+		if *apply {
+			err := userRepo.SetCanonicalIdentity(ctx, user.ID,
+				identity.CanonicalUsername(user.Username),
+				identity.CanonicalEmail(user.Email),
+			)
+			if err != nil {
+				log.Fatalf("Failed to update user %s: %v", user.ID, err)
+			}
+		}
+		updated++
+	}
+
+	if *apply {
+		fmt.Printf("Normalized %d of %d users\n", updated, len(users))
+	} else {
+		fmt.Printf("Dry run: %d of %d users would be normalized (use -apply to write)\n", updated, len(users))
+	}
+
+	if collisions > 0 {
+		fmt.Printf("%d collisions involving %d users must be resolved manually\n", collisions, len(colliding))
+		os.Exit(1)
+	}
+}
+
+// groupBy groups users by a canonical key, which is prefixed with the
+// tenant since identifiers only need to be unique within one
+func groupBy(users []*models.User, key func(*models.User) string) map[string][]*models.User {
+	groups := make(map[string][]*models.User)
+	for _, user := range users {
+		k := key(user)
+		groups[k] = append(groups[k], user)
+	}
+	return groups
+}
+
+// report prints every group with more than one user, marks its members as
+// colliding and returns the number of such groups
+func report(field string, groups map[string][]*models.User, value func(*models.User) string, colliding map[string]bool) int {
+	keys := make([]string, 0, len(groups))
+	for k, members := range groups {
+		if len(members) > 1 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("Collision on %s %q:\n", field, k)
+		for _, user := range groups[k] {
+			fmt.Printf("  %s  %q  created %s\n", user.ID, value(user), user.CreatedAt.Format("2006-01-02"))
+			colliding[user.ID] = true
+		}
+	}
+	return len(keys)
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
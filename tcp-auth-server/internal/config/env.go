@@ -0,0 +1,47 @@
+// Package config reads settings from the environment. It is shared by the
+// server and the commands operating on its database, so both interpret
+// the same variables the same way.
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// String gets an environment variable or returns a default value
+func String(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Int gets an integer environment variable or returns a default value
+func Int(key string, defaultValue int) int {
+	value, err := strconv.Atoi(String(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Bool gets a boolean environment variable or returns a default value
+func Bool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(String(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// SplitList splits a comma-separated list, dropping empty entries
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"log"
+
+	"tcp-auth-server/pkg/password"
+)
+
+// PasswordManager builds the password manager from the environment. The
+// configured algorithm hashes new passwords; every supported algorithm can
+// still verify existing hashes, which are upgraded on the next login.
+func PasswordManager() (*password.Manager, error) {
+	bcryptHasher := password.NewBcryptHasher(Int("BCRYPT_COST", 10))
+
+	defaults := password.DefaultArgon2Params()
+	argon2Hasher := password.NewArgon2idHasher(password.Argon2Params{
+		Memory:      uint32(Int("ARGON2_MEMORY_KIB", int(defaults.Memory))),
+		Time:        uint32(Int("ARGON2_TIME", int(defaults.Time))),
+		Parallelism: uint8(Int("ARGON2_PARALLELISM", int(defaults.Parallelism))),
+	})
+
+	switch algorithm := String("PASSWORD_HASH_ALGORITHM", "argon2id"); algorithm {
+	case "argon2id":
+		return password.NewManager(argon2Hasher, bcryptHasher), nil
+	case "bcrypt":
+		return password.NewManager(bcryptHasher, argon2Hasher), nil
+	default:
+		return nil, fmt.Errorf("unsupported PASSWORD_HASH_ALGORITHM: %s", algorithm)
+	}
+}
+
+// PasswordPolicy builds the registration password policy from the
+// environment. Tenants may override parts of it.
+func PasswordPolicy() (*password.Policy, error) {
+	policy := &password.Policy{
+		MinLength:      Int("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:      Int("PASSWORD_MAX_LENGTH", 64),
+		MinClasses:     Int("PASSWORD_MIN_CHAR_CLASSES", 0),
+		RejectIdentity: Bool("PASSWORD_REJECT_IDENTITY", true),
+		MinEntropyBits: float64(Int("PASSWORD_MIN_ENTROPY_BITS", 0)),
+	}
+
+	if classes := String("PASSWORD_REQUIRED_CLASSES", ""); classes != "" {
+		for _, class := range SplitList(classes) {
+			switch class {
+			case password.ClassLower, password.ClassUpper, password.ClassDigit, password.ClassSymbol:
+				policy.RequiredClasses = append(policy.RequiredClasses, class)
+			default:
+				return nil, fmt.Errorf("unknown character class in PASSWORD_REQUIRED_CLASSES: %s", class)
+			}
+		}
+	}
+
+	if path := String("BREACHED_PASSWORDS_PATH", ""); path != "" {
+		breached, err := password.LoadBreachedList(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d breached password hashes from %s", breached.Len(), path)
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
@@ -2,39 +2,165 @@ package handler
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	"tcp-auth-server/internal/models"
 	"tcp-auth-server/internal/service"
+	"tcp-auth-server/pkg/password"
 	"tcp-auth-server/pkg/protocol"
 )
 
 // AuthHandler handles authentication requests
 type AuthHandler struct {
-	authService *service.AuthService
+	authService       *service.AuthService
+	oauthService      *service.OAuthService
+	federationService *service.FederationService
+	userAdminService  *service.UserAdminService
+	accountService    *service.AccountService
+	adminTokens       []string
+	adminCertNames    []string
 }
 
-// NewAuthHandler creates a new auth handler
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
+// NewAuthHandler creates a new auth handler. Requests carrying one of the
+// admin tokens, or arriving with a client certificate whose common name is
+// one of adminCertNames, may use admin request types.
+func NewAuthHandler(
+	authService *service.AuthService,
+	oauthService *service.OAuthService,
+	federationService *service.FederationService,
+	userAdminService *service.UserAdminService,
+	accountService *service.AccountService,
+	adminTokens []string,
+	adminCertNames []string,
+) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
+		authService:       authService,
+		oauthService:      oauthService,
+		federationService: federationService,
+		userAdminService:  userAdminService,
+		accountService:    accountService,
+		adminTokens:       adminTokens,
+		adminCertNames:    adminCertNames,
 	}
 }
 
-// HandleRequest processes a request and returns a response
+// HandleRequest processes a request and returns a response. Requests are
+// served in the tenant they name, or the default tenant.
 func (h *AuthHandler) HandleRequest(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	tenant, err := h.authService.GetTenantService().Get(ctx, req.Tenant)
+	if err != nil {
+		return protocol.ErrorResponse(fmt.Sprintf("unknown tenant: %s", req.Tenant)), nil
+	}
+	ctx = service.WithTenant(ctx, tenant)
+
 	switch req.Type {
 	case "register":
 		return h.handleRegister(ctx, req)
 	case "login":
 		return h.handleLogin(ctx, req)
+	case "remember_login":
+		return h.handleRememberLogin(ctx, req)
+	case "request_login_code":
+		return h.handleRequestLoginCode(ctx, req)
+	case "redeem_login_code":
+		return h.handleRedeemLoginCode(ctx, req)
+	case "list_identity_providers":
+		return h.handleListIdentityProviders(ctx, req)
+	case "begin_federated_login":
+		return h.handleBeginFederatedLogin(ctx, req)
+	case "complete_federated_login":
+		return h.handleCompleteFederatedLogin(ctx, req)
 	case "logout":
 		return h.handleLogout(ctx, req)
 	case "validate":
 		return h.handleValidate(ctx, req)
 	case "refresh":
 		return h.handleRefresh(ctx, req)
+	case "list_sessions":
+		return h.handleListSessions(ctx, req)
+	case "revoke_session":
+		return h.handleRevokeSession(ctx, req)
+	case "revoke_other_sessions":
+		return h.handleRevokeOtherSessions(ctx, req)
+	case "jwks":
+		return h.handleJWKS(ctx, req)
+	case "create_scoped_token":
+		return h.handleCreateScopedToken(ctx, req)
+	case "create_api_key":
+		return h.handleCreateAPIKey(ctx, req)
+	case "list_api_keys":
+		return h.handleListAPIKeys(ctx, req)
+	case "revoke_api_key":
+		return h.handleRevokeAPIKey(ctx, req)
+	case "begin_link_identity":
+		return h.handleBeginLinkIdentity(ctx, req)
+	case "complete_link_identity":
+		return h.handleCompleteLinkIdentity(ctx, req)
+	case "list_identities":
+		return h.handleListIdentities(ctx, req)
+	case "unlink_identity":
+		return h.handleUnlinkIdentity(ctx, req)
+	case "export_my_data":
+		return h.handleExportMyData(ctx, req)
+	case "delete_account":
+		return h.handleDeleteAccount(ctx, req)
+	case "cancel_account_deletion":
+		return h.handleCancelAccountDeletion(ctx, req)
+	case "authorize":
+		return h.handleAuthorize(ctx, req)
+	case "list_roles":
+		return h.handleListRoles(ctx, req)
+	case "assign_role":
+		return h.handleAssignRole(ctx, req)
+	case "unassign_role":
+		return h.handleUnassignRole(ctx, req)
+	case "client_credentials":
+		return h.handleClientCredentials(ctx, req)
+	case "create_service_account":
+		return h.handleCreateServiceAccount(ctx, req)
+	case "list_service_accounts":
+		return h.handleListServiceAccounts(ctx, req)
+	case "rotate_service_account_secret":
+		return h.handleRotateServiceAccountSecret(ctx, req)
+	case "disable_service_account":
+		return h.handleSetServiceAccountDisabled(ctx, req, true)
+	case "enable_service_account":
+		return h.handleSetServiceAccountDisabled(ctx, req, false)
+	case "create_tenant":
+		return h.handleCreateTenant(ctx, req)
+	case "update_tenant":
+		return h.handleUpdateTenant(ctx, req)
+	case "list_tenants":
+		return h.handleListTenants(ctx, req)
+	case "register_oauth_client":
+		return h.handleRegisterOAuthClient(ctx, req)
+	case "list_oauth_clients":
+		return h.handleListOAuthClients(ctx, req)
+	case "delete_oauth_client":
+		return h.handleDeleteOAuthClient(ctx, req)
+	case "admin_rotate_keys":
+		return h.handleRotateKeys(ctx, req)
+	case "admin_list_users":
+		return h.handleAdminListUsers(ctx, req)
+	case "admin_get_user":
+		return h.handleAdminGetUser(ctx, req)
+	case "admin_suspend_user":
+		return h.handleAdminSuspendUser(ctx, req)
+	case "admin_disable_user":
+		return h.handleAdminDisableUser(ctx, req)
+	case "admin_enable_user":
+		return h.handleAdminEnableUser(ctx, req)
+	case "admin_force_logout":
+		return h.handleAdminForceLogout(ctx, req)
+	case "admin_delete_user":
+		return h.handleAdminDeleteUser(ctx, req)
+	case "impersonate":
+		return h.handleImpersonate(ctx, req)
 	default:
 		return protocol.ErrorResponse(fmt.Sprintf("unknown request type: %s", req.Type)), nil
 	}
@@ -48,6 +174,11 @@ func (h *AuthHandler) handleRegister(ctx context.Context, req *protocol.Request)
 
 	user, err := h.authService.Register(ctx, req.Username, req.Email, req.Password)
 	if err != nil {
+		var policyErr *password.PolicyError
+		if errors.As(err, &policyErr) {
+			data := protocol.PasswordPolicyErrorData{Violations: policyErr.Violations}
+			return protocol.ErrorResponseWithData(err.Error(), data), nil
+		}
 		return protocol.ErrorResponse(err.Error()), nil
 	}
 
@@ -62,22 +193,144 @@ func (h *AuthHandler) handleRegister(ctx context.Context, req *protocol.Request)
 
 // handleLogin handles user login
 func (h *AuthHandler) handleLogin(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
-	if req.Username == "" || req.Password == "" {
-		return protocol.ErrorResponse("username and password are required"), nil
+	// Either identifier may be used to log in
+	identifier := req.Username
+	if identifier == "" {
+		identifier = req.Email
+	}
+	if identifier == "" || req.Password == "" {
+		return protocol.ErrorResponse("username or email and password are required"), nil
+	}
+	if req.RememberMe && len(req.Scopes) > 0 {
+		return protocol.ErrorResponse("remember_me cannot be combined with scopes"), nil
 	}
 
-	session, err := h.authService.Login(ctx, req.Username, req.Password)
+	session, err := h.authService.Login(ctx, identifier, req.Password, sessionOptions(req))
 	if err != nil {
 		return protocol.ErrorResponse(err.Error()), nil
 	}
 
-	data := protocol.LoginResponseData{
-		Token:     session.Token,
-		UserID:    session.UserID,
-		Username:  session.Username,
-		Email:     session.Email,
-		ExpiresAt: session.ExpiresAt.Unix(),
+	data, err := h.loginData(ctx, session, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.RememberMe {
+		remember, err := h.authService.GetRememberMeService().Issue(ctx, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue remember-me token: %w", err)
+		}
+		data.RememberToken = remember.Token
+		data.RememberTokenExpiresAt = remember.ExpiresAt.Unix()
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleRequestLoginCode sends a passwordless login code to an email address
+func (h *AuthHandler) handleRequestLoginCode(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	loginCodes := h.authService.GetLoginCodeService()
+	if loginCodes == nil {
+		return protocol.ErrorResponse("passwordless login is not enabled"), nil
+	}
+	if req.Email == "" {
+		return protocol.ErrorResponse("email is required"), nil
+	}
+
+	if err := loginCodes.RequestCode(ctx, req.Email); err != nil {
+		if errors.Is(err, service.ErrLoginCodeRateLimited) {
+			return protocol.ErrorResponse(err.Error()), nil
+		}
+		return nil, err
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "if the address belongs to an account, a login code has been sent"})
+}
+
+// handleRedeemLoginCode exchanges a login code or magic-link token for a
+// new session
+func (h *AuthHandler) handleRedeemLoginCode(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	loginCodes := h.authService.GetLoginCodeService()
+	if loginCodes == nil {
+		return protocol.ErrorResponse("passwordless login is not enabled"), nil
+	}
+	if req.Code == "" {
+		return protocol.ErrorResponse("code is required"), nil
+	}
+
+	session, err := loginCodes.Redeem(ctx, req.Email, req.Code, sessionOptions(req))
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return h.loginResponse(ctx, session, nil)
+}
+
+// handleListIdentityProviders lists the identity providers users can log
+// in with
+func (h *AuthHandler) handleListIdentityProviders(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	providers := h.federationService.Providers()
+	data := protocol.ListIdentityProvidersResponseData{Providers: make([]protocol.IdentityProviderInfo, 0, len(providers))}
+	for _, provider := range providers {
+		data.Providers = append(data.Providers, protocol.IdentityProviderInfo{
+			Name:        provider.Name,
+			DisplayName: provider.DisplayName,
+		})
+	}
+	return protocol.SuccessResponse(data)
+}
+
+// handleBeginFederatedLogin starts a login with an identity provider
+func (h *AuthHandler) handleBeginFederatedLogin(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	var opts protocol.FederatedLoginRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil || opts.Provider == "" {
+		return protocol.ErrorResponse("provider is required"), nil
+	}
+
+	authURL, state, err := h.federationService.BeginLogin(ctx, opts.Provider)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.FederatedLoginResponseData{
+		AuthorizationURL: authURL,
+		State:            state,
+	})
+}
+
+// handleCompleteFederatedLogin exchanges the code an identity provider
+// returned for a new session
+func (h *AuthHandler) handleCompleteFederatedLogin(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	var opts protocol.FederatedLoginRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil || opts.State == "" || opts.Code == "" {
+		return protocol.ErrorResponse("state and code are required"), nil
+	}
+
+	session, err := h.federationService.CompleteLogin(ctx, opts.State, opts.Code, sessionOptions(req))
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return h.loginResponse(ctx, session, nil)
+}
+
+// handleRememberLogin exchanges a remember-me token for a new session
+func (h *AuthHandler) handleRememberLogin(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.RememberToken == "" {
+		return protocol.ErrorResponse("remember_token is required"), nil
+	}
+
+	session, remember, err := h.authService.GetRememberMeService().Exchange(ctx, req.RememberToken, sessionOptions(req))
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	data, err := h.loginData(ctx, session, nil)
+	if err != nil {
+		return nil, err
 	}
+	data.RememberToken = remember.Token
+	data.RememberTokenExpiresAt = remember.ExpiresAt.Unix()
 
 	return protocol.SuccessResponse(data)
 }
@@ -93,6 +346,13 @@ func (h *AuthHandler) handleLogout(ctx context.Context, req *protocol.Request) (
 		return protocol.ErrorResponse(err.Error()), nil
 	}
 
+	// Logging out of a remembered device also forgets the device
+	if req.RememberToken != "" {
+		if err := h.authService.GetRememberMeService().Forget(ctx, req.RememberToken); err != nil {
+			log.Printf("Warning: failed to forget remember-me token on logout: %v", err)
+		}
+	}
+
 	return protocol.SuccessResponse(map[string]string{"message": "logged out successfully"})
 }
 
@@ -102,8 +362,10 @@ func (h *AuthHandler) handleValidate(ctx context.Context, req *protocol.Request)
 		return protocol.ErrorResponse("token is required"), nil
 	}
 
-	user, err := h.authService.ValidateToken(ctx, req.Token)
-	if err != nil {
+	principal, err := h.authService.ValidateToken(ctx, req.Token)
+	// Scopes in the request are the ones the caller needs the token to
+	// have; each must also be a permission the principal still holds
+	if err != nil || !models.CoversScopes(principal.Scopes, req.Scopes) || !models.GrantsScopes(principal.Permissions, req.Scopes) {
 		data := protocol.ValidateResponseData{
 			Valid: false,
 		}
@@ -111,45 +373,1279 @@ func (h *AuthHandler) handleValidate(ctx context.Context, req *protocol.Request)
 	}
 
 	data := protocol.ValidateResponseData{
-		Valid:    true,
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
+		Valid:         true,
+		PrincipalType: principal.Type,
+		PrincipalID:   principal.ID,
+		Name:          principal.Name,
+		Roles:         principal.Roles,
+		Permissions:   principal.Permissions,
+		Scopes:        principal.Scopes,
+	}
+	if principal.IsUser() {
+		data.UserID = principal.ID
+		data.Username = principal.Name
+		data.Email = principal.Email
+		data.Tenant = principal.TenantID
+		data.ImpersonatorID = principal.ImpersonatorID
 	}
 
 	return protocol.SuccessResponse(data)
 }
 
-// handleRefresh handles session refresh
+// handleRefresh handles session refresh. A refresh token is rotated and
+// exchanged for a new session; presenting only a session token continues
+// the refresh token family issued with that session.
 func (h *AuthHandler) handleRefresh(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.RefreshToken != "" {
+		session, refresh, err := h.authService.GetRefreshService().Rotate(ctx, req.RefreshToken, sessionOptions(req))
+		if err != nil {
+			return protocol.ErrorResponse(err.Error()), nil
+		}
+		return h.loginResponse(ctx, session, refresh)
+	}
+
 	if req.Token == "" {
-		return protocol.ErrorResponse("token is required"), nil
+		return protocol.ErrorResponse("refresh_token or token is required"), nil
 	}
 
 	// Validate token and get user
-	user, err := h.authService.ValidateToken(ctx, req.Token)
-	if err != nil {
+	if _, err := h.authService.ValidateToken(ctx, req.Token); err != nil {
 		return protocol.ErrorResponse("invalid or expired token"), nil
 	}
 
-	// Get session service to refresh
-	sessionService := h.authService.GetSessionService()
-	session, err := sessionService.RefreshSession(ctx, req.Token)
+	session, refresh, err := h.authService.RefreshSession(ctx, req.Token, sessionOptions(req))
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return h.loginResponse(ctx, session, refresh)
+}
+
+// handleListSessions lists the caller's sessions
+func (h *AuthHandler) handleListSessions(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	sessions, err := h.authService.ListSessions(ctx, req.Token)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	data := protocol.ListSessionsResponseData{Sessions: []protocol.SessionInfo{}}
+	for _, session := range sessions {
+		data.Sessions = append(data.Sessions, protocol.SessionInfo{
+			SessionID:  session.ID,
+			ClientType: session.ClientType,
+			ClientIP:   session.ClientIP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			ExpiresAt:  session.ExpiresAt.Unix(),
+			Current:    session.Token == req.Token,
+			Scopes:     session.Scopes,
+
+			ImpersonatorID: session.ImpersonatorID,
+		})
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleRevokeSession ends one of the caller's sessions by session ID
+func (h *AuthHandler) handleRevokeSession(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" || req.SessionID == "" {
+		return protocol.ErrorResponse("token and session_id are required"), nil
+	}
+
+	if err := h.authService.RevokeSession(ctx, req.Token, req.SessionID); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.RevokeSessionsResponseData{Revoked: 1})
+}
+
+// handleRevokeOtherSessions ends every session of the caller except the
+// one making the request
+func (h *AuthHandler) handleRevokeOtherSessions(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	revoked, err := h.authService.RevokeOtherSessions(ctx, req.Token)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.RevokeSessionsResponseData{Revoked: revoked})
+}
+
+// handleJWKS returns the public keys that verify signed access tokens
+func (h *AuthHandler) handleJWKS(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	tokenService := h.authService.GetTokenService()
+	if tokenService == nil {
+		return protocol.ErrorResponse("signed access tokens are disabled"), nil
+	}
+
+	return protocol.SuccessResponse(tokenService.JWKS())
+}
+
+// handleCreateScopedToken mints a token limited to a subset of the
+// caller's scopes
+func (h *AuthHandler) handleCreateScopedToken(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	var opts protocol.ScopedTokenRequestData
+	if len(req.Data) > 0 {
+		if err := json.Unmarshal(req.Data, &opts); err != nil {
+			return protocol.ErrorResponse("invalid data"), nil
+		}
+	}
+
+	session, err := h.authService.CreateScopedToken(ctx, req.Token, req.Scopes, time.Duration(opts.TTL)*time.Second)
 	if err != nil {
 		return protocol.ErrorResponse(err.Error()), nil
 	}
 
-	data := protocol.LoginResponseData{
+	data := protocol.ScopedTokenResponseData{
 		Token:     session.Token,
-		UserID:    session.UserID,
-		Username:  session.Username,
-		Email:     session.Email,
+		SessionID: session.ID,
+		Scopes:    session.Scopes,
 		ExpiresAt: session.ExpiresAt.Unix(),
 	}
 
+	accessToken, accessExpiresAt, err := h.authService.IssueAccessToken(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue access token: %w", err)
+	}
+	if accessToken != "" {
+		data.AccessToken = accessToken
+		data.AccessTokenExpiresAt = accessExpiresAt.Unix()
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleCreateAPIKey issues an API key owned by the caller
+func (h *AuthHandler) handleCreateAPIKey(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	var opts protocol.CreateAPIKeyRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil {
+		return protocol.ErrorResponse("invalid data"), nil
+	}
+	ttl := time.Duration(opts.ExpiresIn) * time.Second
+
+	var issued *service.IssuedAPIKey
+	var err error
+	if opts.ServiceAccountID != "" {
+		actorID, ok := h.adminActor(ctx, req)
+		if !ok {
+			return protocol.ErrorResponse("admin authorization required"), nil
+		}
+		issued, err = h.authService.GetAPIKeyService().CreateForServiceAccount(ctx, actorID, opts.ServiceAccountID, opts.Name, req.Scopes, ttl)
+	} else {
+		issued, err = h.authService.CreateAPIKey(ctx, req.Token, opts.Name, req.Scopes, ttl)
+	}
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.CreateAPIKeyResponseData{
+		Key:        issued.Key,
+		APIKeyInfo: apiKeyInfo(issued.APIKey),
+	})
+}
+
+// handleListAPIKeys lists the caller's API keys, or those of a service
+// account for admins
+func (h *AuthHandler) handleListAPIKeys(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	var target protocol.ServiceAccountRequestData
+	if len(req.Data) > 0 {
+		if err := json.Unmarshal(req.Data, &target); err != nil {
+			return protocol.ErrorResponse("invalid data"), nil
+		}
+	}
+
+	var keys []*models.APIKey
+	var err error
+	if target.ServiceAccountID != "" {
+		if _, ok := h.adminActor(ctx, req); !ok {
+			return protocol.ErrorResponse("admin authorization required"), nil
+		}
+		keys, err = h.authService.GetAPIKeyService().ListForServiceAccount(ctx, target.ServiceAccountID)
+	} else {
+		keys, err = h.authService.ListAPIKeys(ctx, req.Token)
+	}
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	data := protocol.ListAPIKeysResponseData{Keys: make([]protocol.APIKeyInfo, 0, len(keys))}
+	for _, key := range keys {
+		data.Keys = append(data.Keys, apiKeyInfo(key))
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleRevokeAPIKey revokes one of the caller's API keys
+func (h *AuthHandler) handleRevokeAPIKey(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	var target protocol.RevokeAPIKeyRequestData
+	if err := json.Unmarshal(req.Data, &target); err != nil || target.ID == "" {
+		return protocol.ErrorResponse("id is required"), nil
+	}
+
+	if target.ServiceAccountID != "" {
+		actorID, ok := h.adminActor(ctx, req)
+		if !ok {
+			return protocol.ErrorResponse("admin authorization required"), nil
+		}
+		if err := h.authService.GetAPIKeyService().RevokeForServiceAccount(ctx, actorID, target.ServiceAccountID, target.ID); err != nil {
+			return protocol.ErrorResponse(err.Error()), nil
+		}
+		return protocol.SuccessResponse(map[string]string{"message": "API key revoked"})
+	}
+
+	if err := h.authService.RevokeAPIKey(ctx, req.Token, target.ID); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "API key revoked"})
+}
+
+// handleBeginLinkIdentity starts linking an identity provider account to
+// the caller
+func (h *AuthHandler) handleBeginLinkIdentity(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+	var opts protocol.FederatedLoginRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil || opts.Provider == "" {
+		return protocol.ErrorResponse("provider is required"), nil
+	}
+
+	authURL, state, err := h.federationService.BeginLink(ctx, req.Token, opts.Provider)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.FederatedLoginResponseData{
+		AuthorizationURL: authURL,
+		State:            state,
+	})
+}
+
+// handleCompleteLinkIdentity links the identity provider account that
+// approved a link to the caller
+func (h *AuthHandler) handleCompleteLinkIdentity(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+	var opts protocol.FederatedLoginRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil || opts.State == "" || opts.Code == "" {
+		return protocol.ErrorResponse("state and code are required"), nil
+	}
+
+	identity, err := h.federationService.CompleteLink(ctx, req.Token, opts.State, opts.Code)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(federatedIdentityInfo(identity))
+}
+
+// handleListIdentities lists the identities linked to the caller
+func (h *AuthHandler) handleListIdentities(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	identities, err := h.federationService.ListIdentities(ctx, req.Token)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	data := protocol.ListIdentitiesResponseData{Identities: make([]protocol.FederatedIdentityInfo, 0, len(identities))}
+	for _, identity := range identities {
+		data.Identities = append(data.Identities, federatedIdentityInfo(identity))
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleUnlinkIdentity unlinks the caller's identity at a provider
+func (h *AuthHandler) handleUnlinkIdentity(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+	var opts protocol.FederatedLoginRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil || opts.Provider == "" {
+		return protocol.ErrorResponse("provider is required"), nil
+	}
+
+	if err := h.federationService.Unlink(ctx, req.Token, opts.Provider); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "identity unlinked"})
+}
+
+// handleExportMyData returns everything stored about the caller
+func (h *AuthHandler) handleExportMyData(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	export, err := h.accountService.ExportData(ctx, req.Token)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	data := protocol.ExportMyDataResponseData{
+		ExportedAt:  export.ExportedAt.Unix(),
+		Profile:     userInfo(export.User),
+		Roles:       export.User.Roles,
+		Permissions: export.User.Permissions,
+		Sessions:    []protocol.SessionInfo{},
+		APIKeys:     []protocol.APIKeyInfo{},
+		Identities:  []protocol.FederatedIdentityInfo{},
+		AuditEvents: []protocol.AuditEventInfo{},
+	}
+	for _, session := range export.Sessions {
+		data.Sessions = append(data.Sessions, protocol.SessionInfo{
+			SessionID:  session.ID,
+			ClientType: session.ClientType,
+			ClientIP:   session.ClientIP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: unixOrZero(session.LastUsedAt),
+			ExpiresAt:  session.ExpiresAt.Unix(),
+			Scopes:     session.Scopes,
+
+			ImpersonatorID: session.ImpersonatorID,
+		})
+	}
+	for _, key := range export.APIKeys {
+		data.APIKeys = append(data.APIKeys, apiKeyInfo(key))
+	}
+	for _, identity := range export.Identities {
+		data.Identities = append(data.Identities, federatedIdentityInfo(identity))
+	}
+	for _, event := range export.AuditEvents {
+		data.AuditEvents = append(data.AuditEvents, protocol.AuditEventInfo{
+			ID:        event.ID,
+			EventType: event.EventType,
+			UserID:    event.UserID,
+			ActorID:   event.ActorID,
+			IPAddress: event.IPAddress,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt.Unix(),
+		})
+	}
+
 	return protocol.SuccessResponse(data)
 }
 
+// handleDeleteAccount schedules the deletion of the caller's account after
+// confirming their password
+func (h *AuthHandler) handleDeleteAccount(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+
+	deleteAt, err := h.accountService.RequestDeletion(ctx, req.Token, req.Password, req.ClientIP)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.DeleteAccountResponseData{DeleteAt: deleteAt.Unix()})
+}
+
+// handleCancelAccountDeletion cancels a scheduled deletion of the account
+// whose credentials are given
+func (h *AuthHandler) handleCancelAccountDeletion(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	identifier := req.Username
+	if identifier == "" {
+		identifier = req.Email
+	}
+	if identifier == "" || req.Password == "" {
+		return protocol.ErrorResponse("username or email and password are required"), nil
+	}
+
+	if err := h.accountService.CancelDeletion(ctx, identifier, req.Password, req.ClientIP); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "account deletion cancelled"})
+}
+
+// handleAuthorize answers whether the principal owning the token holds a
+// permission, so other services can delegate authorization decisions
+func (h *AuthHandler) handleAuthorize(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if req.Token == "" {
+		return protocol.ErrorResponse("token is required"), nil
+	}
+	if req.Permission == "" {
+		return protocol.ErrorResponse("permission is required"), nil
+	}
+
+	principal, allowed, err := h.authService.Authorize(ctx, req.Token, req.Permission)
+	if err != nil {
+		return protocol.SuccessResponse(protocol.AuthorizeResponseData{Allowed: false})
+	}
+
+	data := protocol.AuthorizeResponseData{
+		Allowed:       allowed,
+		PrincipalType: principal.Type,
+		PrincipalID:   principal.ID,
+		Roles:         principal.Roles,
+	}
+	if principal.IsUser() {
+		data.UserID = principal.ID
+		data.Username = principal.Name
+		data.ImpersonatorID = principal.ImpersonatorID
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleListRoles lists every role with its permissions
+func (h *AuthHandler) handleListRoles(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := h.adminActor(ctx, req); !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	roles, err := h.authService.GetRoleService().ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := protocol.ListRolesResponseData{Roles: make([]protocol.RoleInfo, 0, len(roles))}
+	for _, role := range roles {
+		data.Roles = append(data.Roles, protocol.RoleInfo{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleAssignRole gives a user or service account a role
+func (h *AuthHandler) handleAssignRole(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	return h.changeRole(ctx, req,
+		h.authService.GetRoleService().AssignRole,
+		h.authService.GetServiceAccountService().AssignRole,
+	)
+}
+
+// handleUnassignRole takes a role away from a user or service account
+func (h *AuthHandler) handleUnassignRole(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	return h.changeRole(ctx, req,
+		h.authService.GetRoleService().UnassignRole,
+		h.authService.GetServiceAccountService().UnassignRole,
+	)
+}
+
+// changeRole parses a role assignment request and applies change to the
+// named user, or changeServiceAccount to the named service account
+func (h *AuthHandler) changeRole(
+	ctx context.Context,
+	req *protocol.Request,
+	change func(ctx context.Context, actorID, userID, role string) error,
+	changeServiceAccount func(ctx context.Context, actorID, serviceAccountID, role string) error,
+) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var assignment protocol.RoleAssignmentRequestData
+	if err := json.Unmarshal(req.Data, &assignment); err != nil {
+		return protocol.ErrorResponse("invalid data"), nil
+	}
+	if assignment.Role == "" {
+		return protocol.ErrorResponse("role is required"), nil
+	}
+
+	if assignment.ServiceAccountID != "" {
+		if err := changeServiceAccount(ctx, actorID, assignment.ServiceAccountID, assignment.Role); err != nil {
+			return protocol.ErrorResponse(err.Error()), nil
+		}
+		return protocol.SuccessResponse(map[string]string{"message": "roles updated"})
+	}
+
+	user, err := h.authService.FindUser(ctx, assignment.UserID, assignment.Username)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	if err := change(ctx, actorID, user.ID, assignment.Role); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "roles updated"})
+}
+
+// handleClientCredentials exchanges a service account's client ID and
+// secret for a short-lived token. No refresh token is issued; the service
+// requests a new token when the old one expires.
+func (h *AuthHandler) handleClientCredentials(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	var creds protocol.ClientCredentialsRequestData
+	if err := json.Unmarshal(req.Data, &creds); err != nil || creds.ClientID == "" || creds.ClientSecret == "" {
+		return protocol.ErrorResponse("client_id and client_secret are required"), nil
+	}
+
+	session, err := h.authService.ClientCredentials(ctx, creds.ClientID, creds.ClientSecret, req.Scopes, req.ClientIP)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	data := protocol.ServiceTokenResponseData{
+		Token:            session.Token,
+		ServiceAccountID: session.UserID,
+		Name:             session.Username,
+		Scopes:           session.Scopes,
+		ExpiresAt:        session.ExpiresAt.Unix(),
+	}
+
+	accessToken, accessExpiresAt, err := h.authService.IssueAccessToken(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue access token: %w", err)
+	}
+	if accessToken != "" {
+		data.AccessToken = accessToken
+		data.AccessTokenExpiresAt = accessExpiresAt.Unix()
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleCreateServiceAccount registers a service account and returns its
+// client credentials
+func (h *AuthHandler) handleCreateServiceAccount(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var opts protocol.CreateServiceAccountRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil {
+		return protocol.ErrorResponse("invalid data"), nil
+	}
+
+	issued, err := h.authService.GetServiceAccountService().Create(ctx, actorID, opts.Name, opts.Description)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.ServiceAccountSecretResponseData{
+		ClientSecret:       issued.ClientSecret,
+		ServiceAccountInfo: serviceAccountInfo(issued.ServiceAccount),
+	})
+}
+
+// handleListServiceAccounts lists every service account
+func (h *AuthHandler) handleListServiceAccounts(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := h.adminActor(ctx, req); !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	accounts, err := h.authService.GetServiceAccountService().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := protocol.ListServiceAccountsResponseData{ServiceAccounts: make([]protocol.ServiceAccountInfo, 0, len(accounts))}
+	for _, account := range accounts {
+		data.ServiceAccounts = append(data.ServiceAccounts, serviceAccountInfo(account))
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleRotateServiceAccountSecret replaces a service account's client
+// secret and returns the new one
+func (h *AuthHandler) handleRotateServiceAccountSecret(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var target protocol.ServiceAccountRequestData
+	if err := json.Unmarshal(req.Data, &target); err != nil || target.ServiceAccountID == "" {
+		return protocol.ErrorResponse("service_account_id is required"), nil
+	}
+
+	issued, err := h.authService.GetServiceAccountService().RotateSecret(ctx, actorID, target.ServiceAccountID)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.ServiceAccountSecretResponseData{
+		ClientSecret:       issued.ClientSecret,
+		ServiceAccountInfo: serviceAccountInfo(issued.ServiceAccount),
+	})
+}
+
+// handleSetServiceAccountDisabled disables or re-enables a service account
+func (h *AuthHandler) handleSetServiceAccountDisabled(ctx context.Context, req *protocol.Request, disabled bool) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var target protocol.ServiceAccountRequestData
+	if err := json.Unmarshal(req.Data, &target); err != nil || target.ServiceAccountID == "" {
+		return protocol.ErrorResponse("service_account_id is required"), nil
+	}
+
+	if err := h.authService.GetServiceAccountService().SetDisabled(ctx, actorID, target.ServiceAccountID, disabled); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	message := "service account enabled"
+	if disabled {
+		message = "service account disabled"
+	}
+	return protocol.SuccessResponse(map[string]string{"message": message})
+}
+
+// handleCreateTenant creates a tenant with its configuration
+func (h *AuthHandler) handleCreateTenant(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := h.adminActor(ctx, req); !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var info protocol.TenantInfo
+	if err := json.Unmarshal(req.Data, &info); err != nil {
+		return protocol.ErrorResponse("invalid data"), nil
+	}
+
+	tenant := tenantFromInfo(&info)
+	if err := h.authService.GetTenantService().Create(ctx, tenant); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(tenantInfo(tenant))
+}
+
+// handleUpdateTenant replaces a tenant's configuration. An empty name
+// keeps the current one.
+func (h *AuthHandler) handleUpdateTenant(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := h.adminActor(ctx, req); !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var info protocol.TenantInfo
+	if err := json.Unmarshal(req.Data, &info); err != nil || info.ID == "" {
+		return protocol.ErrorResponse("id is required"), nil
+	}
+
+	tenants := h.authService.GetTenantService()
+	current, err := tenants.Get(ctx, info.ID)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	tenant := tenantFromInfo(&info)
+	if tenant.Name == "" {
+		tenant.Name = current.Name
+	}
+	tenant.CreatedAt = current.CreatedAt
+	if err := tenants.Update(ctx, tenant); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(tenantInfo(tenant))
+}
+
+// handleListTenants lists every tenant with its configuration
+func (h *AuthHandler) handleListTenants(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := h.adminActor(ctx, req); !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	tenants, err := h.authService.GetTenantService().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := protocol.ListTenantsResponseData{Tenants: make([]protocol.TenantInfo, 0, len(tenants))}
+	for _, tenant := range tenants {
+		data.Tenants = append(data.Tenants, tenantInfo(tenant))
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleRegisterOAuthClient registers an OAuth client in the request's
+// tenant and returns its credentials
+func (h *AuthHandler) handleRegisterOAuthClient(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var opts protocol.RegisterOAuthClientRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil {
+		return protocol.ErrorResponse("invalid data"), nil
+	}
+
+	registered, err := h.oauthService.RegisterClient(ctx, actorID, &models.OAuthClient{
+		Name:         opts.Name,
+		RedirectURIs: opts.RedirectURIs,
+		GrantTypes:   opts.GrantTypes,
+		Scopes:       opts.Scopes,
+		Public:       opts.Public,
+
+		PostLogoutRedirectURIs: opts.PostLogoutRedirectURIs,
+	})
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.OAuthClientSecretResponseData{
+		ClientSecret:    registered.ClientSecret,
+		OAuthClientInfo: oauthClientInfo(registered.Client),
+	})
+}
+
+// handleListOAuthClients lists the OAuth clients of the request's tenant
+func (h *AuthHandler) handleListOAuthClients(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := h.adminActor(ctx, req); !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	clients, err := h.oauthService.ListClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := protocol.ListOAuthClientsResponseData{Clients: make([]protocol.OAuthClientInfo, 0, len(clients))}
+	for _, client := range clients {
+		data.Clients = append(data.Clients, oauthClientInfo(client))
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleDeleteOAuthClient removes an OAuth client and revokes its tokens
+func (h *AuthHandler) handleDeleteOAuthClient(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var target protocol.OAuthClientRequestData
+	if err := json.Unmarshal(req.Data, &target); err != nil || target.ClientID == "" {
+		return protocol.ErrorResponse("client_id is required"), nil
+	}
+
+	if err := h.oauthService.DeleteClient(ctx, actorID, target.ClientID); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "OAuth client deleted"})
+}
+
+// handleRotateKeys performs an immediate signing key rotation
+func (h *AuthHandler) handleRotateKeys(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := h.adminActor(ctx, req); !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	tokenService := h.authService.GetTokenService()
+	if tokenService == nil {
+		return protocol.ErrorResponse("signed access tokens are disabled"), nil
+	}
+
+	var opts protocol.RotateKeysRequestData
+	if len(req.Data) > 0 {
+		if err := json.Unmarshal(req.Data, &opts); err != nil {
+			return protocol.ErrorResponse("invalid data"), nil
+		}
+	}
+
+	key, err := tokenService.RotateKeys(ctx, opts.RevokePrevious)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+	log.Printf("Admin rotated signing keys, new key %s (previous revoked: %v)", key.ID, opts.RevokePrevious)
+
+	data := protocol.RotateKeysResponseData{
+		KeyID:           key.ID,
+		Algorithm:       key.Algorithm,
+		PreviousRevoked: opts.RevokePrevious,
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleAdminListUsers pages through the users of the request's tenant
+func (h *AuthHandler) handleAdminListUsers(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var opts protocol.AdminListUsersRequestData
+	if len(req.Data) > 0 {
+		if err := json.Unmarshal(req.Data, &opts); err != nil {
+			return protocol.ErrorResponse("invalid data"), nil
+		}
+	}
+
+	users, total, err := h.userAdminService.ListUsers(ctx, actorID, opts.Search, opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	data := protocol.AdminListUsersResponseData{Users: []protocol.UserInfo{}, Total: total}
+	for _, user := range users {
+		data.Users = append(data.Users, userInfo(user))
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleAdminGetUser returns a user with their roles and live sessions
+func (h *AuthHandler) handleAdminGetUser(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	user, _, err := h.adminTarget(ctx, req)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	details, err := h.userAdminService.GetUser(ctx, actorID, user.ID)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	data := protocol.AdminUserResponseData{
+		UserInfo:    userInfo(details.User),
+		Roles:       details.User.Roles,
+		Permissions: details.User.Permissions,
+		Sessions:    []protocol.SessionInfo{},
+	}
+	for _, session := range details.Sessions {
+		data.Sessions = append(data.Sessions, protocol.SessionInfo{
+			SessionID:  session.ID,
+			ClientType: session.ClientType,
+			ClientIP:   session.ClientIP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			ExpiresAt:  session.ExpiresAt.Unix(),
+			Scopes:     session.Scopes,
+
+			ImpersonatorID: session.ImpersonatorID,
+		})
+	}
+
+	return protocol.SuccessResponse(data)
+}
+
+// handleAdminSuspendUser stops a user from logging in, for a while or
+// until enabled, and ends their sessions
+func (h *AuthHandler) handleAdminSuspendUser(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	user, target, err := h.adminTarget(ctx, req)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	if err := h.userAdminService.Suspend(ctx, actorID, user.ID, target.Reason, statusExpiry(target.ExpiresIn)); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "user suspended"})
+}
+
+// handleAdminDisableUser stops a user from logging in and ends their
+// sessions
+func (h *AuthHandler) handleAdminDisableUser(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	user, target, err := h.adminTarget(ctx, req)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	if err := h.userAdminService.Disable(ctx, actorID, user.ID, target.Reason, statusExpiry(target.ExpiresIn)); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "user disabled"})
+}
+
+// statusExpiry converts the expires_in of an admin request to the time a
+// status lapses, or zero when it does not
+func statusExpiry(expiresIn int64) time.Time {
+	if expiresIn == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(expiresIn) * time.Second)
+}
+
+// handleAdminEnableUser lets a disabled user log in again
+func (h *AuthHandler) handleAdminEnableUser(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	user, _, err := h.adminTarget(ctx, req)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	if err := h.userAdminService.Enable(ctx, actorID, user.ID); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "user enabled"})
+}
+
+// handleAdminForceLogout ends every session of a user
+func (h *AuthHandler) handleAdminForceLogout(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	user, _, err := h.adminTarget(ctx, req)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	revoked, err := h.userAdminService.ForceLogout(ctx, actorID, user.ID)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.RevokeSessionsResponseData{Revoked: revoked})
+}
+
+// handleAdminDeleteUser deletes a user account
+func (h *AuthHandler) handleAdminDeleteUser(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	user, _, err := h.adminTarget(ctx, req)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	if err := h.userAdminService.Delete(ctx, actorID, user.ID); err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(map[string]string{"message": "user deleted"})
+}
+
+// handleImpersonate issues an admin a time-limited session acting as a
+// user. No refresh or access token is issued with it.
+func (h *AuthHandler) handleImpersonate(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	actorID, ok := h.adminActor(ctx, req)
+	if !ok {
+		return protocol.ErrorResponse("admin authorization required"), nil
+	}
+
+	var opts protocol.ImpersonateRequestData
+	if err := json.Unmarshal(req.Data, &opts); err != nil {
+		return protocol.ErrorResponse("user_id or username is required"), nil
+	}
+
+	user, err := h.tenantUser(ctx, opts.UserID, opts.Username)
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	session, err := h.userAdminService.Impersonate(ctx, actorID, user.ID, opts.Reason, time.Duration(opts.TTL)*time.Second, sessionOptions(req))
+	if err != nil {
+		return protocol.ErrorResponse(err.Error()), nil
+	}
+
+	return protocol.SuccessResponse(protocol.ImpersonateResponseData{
+		Token:          session.Token,
+		SessionID:      session.ID,
+		UserID:         session.UserID,
+		Username:       session.Username,
+		Tenant:         session.TenantID,
+		ImpersonatorID: session.ImpersonatorID,
+		ExpiresAt:      session.ExpiresAt.Unix(),
+	})
+}
+
+// adminTarget resolves the user an admin request names. Users named by ID
+// must belong to the request's tenant.
+func (h *AuthHandler) adminTarget(ctx context.Context, req *protocol.Request) (*models.User, *protocol.AdminUserRequestData, error) {
+	var target protocol.AdminUserRequestData
+	if err := json.Unmarshal(req.Data, &target); err != nil {
+		return nil, nil, fmt.Errorf("user_id or username is required")
+	}
+
+	user, err := h.tenantUser(ctx, target.UserID, target.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, &target, nil
+}
+
+// tenantUser looks a user of the request's tenant up by ID or username
+func (h *AuthHandler) tenantUser(ctx context.Context, userID, username string) (*models.User, error) {
+	user, err := h.authService.FindUser(ctx, userID, username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TenantID != service.TenantFrom(ctx).ID {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// apiKeyInfo describes an API key for responses
+func apiKeyInfo(key *models.APIKey) protocol.APIKeyInfo {
+	return protocol.APIKeyInfo{
+		ID:         key.ID,
+		Prefix:     key.Prefix,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt.Unix(),
+		ExpiresAt:  unixOrZero(key.ExpiresAt),
+		LastUsedAt: unixOrZero(key.LastUsedAt),
+		RevokedAt:  unixOrZero(key.RevokedAt),
+	}
+}
+
+// userInfo describes a user account for responses
+func userInfo(user *models.User) protocol.UserInfo {
+	info := protocol.UserInfo{
+		UserID:    user.ID,
+		Tenant:    user.TenantID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Status:    user.EffectiveStatus(),
+		Backend:   user.Backend,
+		CreatedAt: user.CreatedAt.Unix(),
+		UpdatedAt: user.UpdatedAt.Unix(),
+	}
+	if !user.Active() {
+		info.StatusReason = user.StatusReason
+		info.StatusExpiresAt = unixOrZero(user.StatusExpiresAt)
+	}
+	return info
+}
+
+// federatedIdentityInfo describes a linked identity for responses
+func federatedIdentityInfo(identity *models.FederatedIdentity) protocol.FederatedIdentityInfo {
+	return protocol.FederatedIdentityInfo{
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		CreatedAt:   identity.CreatedAt.Unix(),
+		LastLoginAt: unixOrZero(identity.LastLoginAt),
+	}
+}
+
+// serviceAccountInfo describes a service account for responses
+func serviceAccountInfo(account *models.ServiceAccount) protocol.ServiceAccountInfo {
+	return protocol.ServiceAccountInfo{
+		ID:          account.ID,
+		Name:        account.Name,
+		Description: account.Description,
+		ClientID:    account.ClientID,
+		Disabled:    account.Disabled,
+		CreatedAt:   account.CreatedAt.Unix(),
+	}
+}
+
+// oauthClientInfo describes an OAuth client for responses
+func oauthClientInfo(client *models.OAuthClient) protocol.OAuthClientInfo {
+	return protocol.OAuthClientInfo{
+		ClientID:     client.ID,
+		Name:         client.Name,
+		Tenant:       client.TenantID,
+		RedirectURIs: client.RedirectURIs,
+		GrantTypes:   client.GrantTypes,
+		Scopes:       client.Scopes,
+		Public:       client.Public,
+		CreatedAt:    client.CreatedAt.Unix(),
+
+		PostLogoutRedirectURIs: client.PostLogoutRedirectURIs,
+	}
+}
+
+// tenantFromInfo builds a tenant from request data
+func tenantFromInfo(info *protocol.TenantInfo) *models.Tenant {
+	tenant := &models.Tenant{
+		ID:                 info.ID,
+		Name:               info.Name,
+		SessionMaxLifetime: time.Duration(info.SessionMaxLifetime) * time.Second,
+		SessionIdleTimeout: time.Duration(info.SessionIdleTimeout) * time.Second,
+	}
+	if p := info.PasswordPolicy; p != nil {
+		tenant.PasswordPolicy = &models.TenantPasswordPolicy{
+			MinLength:       p.MinLength,
+			MaxLength:       p.MaxLength,
+			RequiredClasses: p.RequiredClasses,
+			MinClasses:      p.MinClasses,
+			MinEntropyBits:  p.MinEntropyBits,
+		}
+	}
+	return tenant
+}
+
+// tenantInfo describes a tenant for responses
+func tenantInfo(tenant *models.Tenant) protocol.TenantInfo {
+	info := protocol.TenantInfo{
+		ID:                 tenant.ID,
+		Name:               tenant.Name,
+		SessionMaxLifetime: int64(tenant.SessionMaxLifetime / time.Second),
+		SessionIdleTimeout: int64(tenant.SessionIdleTimeout / time.Second),
+		CreatedAt:          unixOrZero(tenant.CreatedAt),
+	}
+	if p := tenant.PasswordPolicy; p != nil {
+		info.PasswordPolicy = &protocol.TenantPasswordPolicyData{
+			MinLength:       p.MinLength,
+			MaxLength:       p.MaxLength,
+			RequiredClasses: p.RequiredClasses,
+			MinClasses:      p.MinClasses,
+			MinEntropyBits:  p.MinEntropyBits,
+		}
+	}
+	return info
+}
+
+// unixOrZero converts a time to Unix seconds, keeping the zero time as 0
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// sessionOptions collects the per-login session settings from a request
+func sessionOptions(req *protocol.Request) service.SessionOptions {
+	return service.SessionOptions{
+		ClientType: req.ClientType,
+		ClientIP:   req.ClientIP,
+		UserAgent:  req.UserAgent,
+		Scopes:     req.Scopes,
+	}
+}
+
+// adminActor authorizes an admin request. The request either arrived with
+// an admin client certificate, or its token is one of the configured admin
+// secrets or the token of a principal holding the auth:admin permission.
+// Admin users belong to the default tenant, whatever tenant the request
+// targets. It returns the acting principal's ID, which is "cert:<name>"
+// for client certificates and "admin-token:<fingerprint>" for admin
+// secrets.
+func (h *AuthHandler) adminActor(ctx context.Context, req *protocol.Request) (string, bool) {
+	if req.ClientCertName != "" {
+		for _, name := range h.adminCertNames {
+			if req.ClientCertName == name {
+				return "cert:" + name, true
+			}
+		}
+	}
+
+	if req.Token == "" {
+		return "", false
+	}
+	for _, adminToken := range h.adminTokens {
+		if subtle.ConstantTimeCompare([]byte(req.Token), []byte(adminToken)) == 1 {
+			return service.AdminTokenActor(adminToken), true
+		}
+	}
+
+	defaultTenant, err := h.authService.GetTenantService().Get(ctx, models.DefaultTenantID)
+	if err != nil {
+		return "", false
+	}
+	adminCtx := service.WithTenant(ctx, defaultTenant)
+
+	principal, allowed, err := h.authService.Authorize(adminCtx, req.Token, models.PermissionAdmin)
+	if err != nil || !allowed || principal.ImpersonatorID != "" {
+		return "", false
+	}
+	return principal.ID, true
+}
+
+// loginResponse builds the response for a newly created session
+func (h *AuthHandler) loginResponse(ctx context.Context, session *models.Session, refresh *service.IssuedRefreshToken) (*protocol.Response, error) {
+	data, err := h.loginData(ctx, session, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.SuccessResponse(data)
+}
+
+// loginData builds the login data for a newly created session, attaching
+// a signed access token when enabled. A new refresh token family is
+// started unless refresh already carries the rotated token.
+func (h *AuthHandler) loginData(ctx context.Context, session *models.Session, refresh *service.IssuedRefreshToken) (*protocol.LoginResponseData, error) {
+	data := &protocol.LoginResponseData{
+		Token:     session.Token,
+		UserID:    session.UserID,
+		Username:  session.Username,
+		Email:     session.Email,
+		ExpiresAt: session.ExpiresAt.Unix(),
+		Tenant:    session.TenantID,
+		Scopes:    session.Scopes,
+	}
+
+	accessToken, accessExpiresAt, err := h.authService.IssueAccessToken(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue access token: %w", err)
+	}
+	if accessToken != "" {
+		data.AccessToken = accessToken
+		data.AccessTokenExpiresAt = accessExpiresAt.Unix()
+	}
+
+	if refresh == nil {
+		refresh, err = h.authService.GetRefreshService().Issue(ctx, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue refresh token: %w", err)
+		}
+	}
+	data.RefreshToken = refresh.Token
+	data.RefreshTokenExpiresAt = refresh.ExpiresAt.Unix()
+
+	return data, nil
+}
+
 // ConnectionInfo tracks connection state
 type ConnectionInfo struct {
 	ConnID  string
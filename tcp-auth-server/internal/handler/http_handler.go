@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"tcp-auth-server/internal/service"
+)
+
+// HTTPHandler serves the auth server's HTTP endpoints: documents that
+// standard clients expect to fetch over HTTP, the OAuth 2.0 endpoints and
+// the OpenID Connect endpoints
+type HTTPHandler struct {
+	authService  *service.AuthService
+	oauthService *service.OAuthService
+}
+
+// NewHTTPHandler creates a new HTTP handler
+func NewHTTPHandler(authService *service.AuthService, oauthService *service.OAuthService) *HTTPHandler {
+	return &HTTPHandler{
+		authService:  authService,
+		oauthService: oauthService,
+	}
+}
+
+// Routes returns the HTTP routes
+func (h *HTTPHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jwks.json", h.handleJWKS)
+	mux.HandleFunc("/oauth/authorize", h.handleAuthorize)
+	mux.HandleFunc("/oauth/token", h.handleToken)
+	mux.HandleFunc("/oauth/introspect", h.handleIntrospect)
+	mux.HandleFunc("/oauth/revoke", h.handleRevoke)
+	mux.HandleFunc("/.well-known/openid-configuration", h.handleDiscovery)
+	mux.HandleFunc("/oauth/userinfo", h.handleUserInfo)
+	mux.HandleFunc("/oauth/logout", h.handleEndSession)
+	mux.HandleFunc("/tenants/", h.handleTenantIssuer)
+	return mux
+}
+
+// handleJWKS serves the JSON Web Key Set for signed access tokens
+func (h *HTTPHandler) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	tokenService := h.authService.GetTokenService()
+	if tokenService == nil {
+		writeJSONError(w, http.StatusNotFound, "signed access tokens are disabled")
+		return
+	}
+
+	// Keys rotate, so let verifiers cache briefly and re-fetch on unknown kid
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, tokenService.JWKS())
+}
+
+// writeJSON writes a JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing HTTP response: %v", err)
+	}
+}
+
+// writeJSONError writes a JSON error body
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
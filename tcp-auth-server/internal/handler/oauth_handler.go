@@ -0,0 +1,441 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/service"
+)
+
+// maxOAuthFormSize bounds the body of requests to the OAuth endpoints
+const maxOAuthFormSize = 64 << 10
+
+// tokenResponse is a successful token endpoint response (RFC 6749
+// section 5.1)
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// introspectionResponse is a token introspection response (RFC 7662
+// section 2.2)
+type introspectionResponse struct {
+	Active        bool   `json:"active"`
+	Scope         string `json:"scope,omitempty"`
+	ClientID      string `json:"client_id,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Subject       string `json:"sub,omitempty"`
+	TokenType     string `json:"token_type,omitempty"`
+	ExpiresAt     int64  `json:"exp,omitempty"`
+	IssuedAt      int64  `json:"iat,omitempty"`
+	Tenant        string `json:"tenant,omitempty"`
+	PrincipalType string `json:"principal_type,omitempty"`
+
+	// Actor names the admin impersonating the subject (RFC 8693 section
+	// 4.1)
+	Actor *introspectionActor `json:"act,omitempty"`
+}
+
+// introspectionActor is the act claim of an introspection response
+type introspectionActor struct {
+	Subject string `json:"sub"`
+}
+
+// authorizePage asks the user to log in and approve a client
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+<h1>{{.Client.Name}} wants to access your account</h1>
+{{if .Scopes}}<p>It is asking for:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+{{else}}<p>It is asking for full access to your account.</p>{{end}}
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<p><label>Username or email <input name="username" autocomplete="username"></label></p>
+<p><label>Password <input name="password" type="password" autocomplete="current-password"></label></p>
+<p><button name="action" value="approve">Allow</button> <button name="action" value="deny">Deny</button></p>
+</form>
+</body>
+</html>
+`))
+
+// authorizePageData is rendered by authorizePage
+type authorizePageData struct {
+	Client  *models.OAuthClient
+	Request *service.AuthorizationRequest
+	Scopes  []string
+	Scope   string
+	Error   string
+}
+
+// handleAuthorize serves the authorization endpoint (RFC 6749 section
+// 3.1). GET shows a login and consent page; POST checks the user's
+// password and redirects back to the client with a code. An app that
+// already holds a session may send it as a bearer token instead, unless
+// prompt=login asks for the password. With prompt=none the request fails
+// with login_required rather than showing the page.
+func (h *HTTPHandler) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormSize)
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	areq := &service.AuthorizationRequest{
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		ResponseType:        r.Form.Get("response_type"),
+		Scopes:              strings.Fields(r.Form.Get("scope")),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+	prompt := strings.Fields(r.Form.Get("prompt"))
+
+	// Until the redirect URI is known to belong to the client, errors are
+	// shown here instead of being redirected
+	client, redirectURI, err := h.oauthService.ResolveClient(r.Context(), areq.ClientID, areq.RedirectURI)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.oauthService.CheckAuthorization(client, areq); err != nil {
+		redirectError(w, r, redirectURI, areq.State, err)
+		return
+	}
+
+	var user *models.User
+	var granted []string
+	switch {
+	case bearerToken(r) != "" && !hasValue(prompt, "login"):
+		user, granted, err = h.oauthService.AuthenticateBearer(r.Context(), client, bearerToken(r))
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+	case hasValue(prompt, "none"):
+		redirectError(w, r, redirectURI, areq.State, &service.OAuthError{
+			Code:        service.OAuthLoginRequired,
+			Description: "the user must log in",
+		})
+		return
+	case r.Method == http.MethodGet:
+		renderAuthorizePage(w, http.StatusOK, client, areq, "")
+		return
+	case r.PostForm.Get("action") != "approve":
+		redirectError(w, r, redirectURI, areq.State, &service.OAuthError{
+			Code:        service.OAuthAccessDenied,
+			Description: "the user denied the request",
+		})
+		return
+	default:
+		user, err = h.oauthService.AuthenticateUser(r.Context(), client, r.PostForm.Get("username"), r.PostForm.Get("password"))
+		if err != nil {
+			renderAuthorizePage(w, http.StatusUnauthorized, client, areq, err.Error())
+			return
+		}
+	}
+
+	code, err := h.oauthService.IssueCode(r.Context(), client, user, granted, areq)
+	if err != nil {
+		redirectError(w, r, redirectURI, areq.State, err)
+		return
+	}
+
+	redirect(w, r, redirectURI, url.Values{"code": {code}}, areq.State)
+}
+
+// handleToken serves the token endpoint (RFC 6749 section 3.2)
+func (h *HTTPHandler) handleToken(w http.ResponseWriter, r *http.Request) {
+	form, ok := oauthForm(w, r)
+	if !ok {
+		return
+	}
+	clientID, clientSecret, err := clientCredentials(r, form)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	var issued *service.OAuthToken
+	switch grantType := form.Get("grant_type"); grantType {
+	case models.GrantClientCredentials:
+		issued, err = h.oauthService.ClientCredentials(ctx, clientID, clientSecret, strings.Fields(form.Get("scope")), clientIP(r))
+	case models.GrantAuthorizationCode, models.GrantRefreshToken:
+		var client *models.OAuthClient
+		client, err = h.oauthService.AuthenticateClient(ctx, clientID, clientSecret)
+		if err != nil {
+			break
+		}
+		if grantType == models.GrantAuthorizationCode {
+			issued, err = h.oauthService.ExchangeCode(ctx, client, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"), clientIP(r))
+		} else {
+			issued, err = h.oauthService.Refresh(ctx, client, form.Get("refresh_token"), strings.Fields(form.Get("scope")), clientIP(r))
+		}
+	case "":
+		err = &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "grant_type is required"}
+	default:
+		err = &service.OAuthError{Code: service.OAuthUnsupportedGrantType, Description: "unsupported grant type " + grantType}
+	}
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	resp := tokenResponse{
+		AccessToken: issued.Session.Token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(issued.Session.ExpiresAt) / time.Second),
+		Scope:       strings.Join(issued.Session.Scopes, " "),
+	}
+	if issued.Refresh != nil {
+		resp.RefreshToken = issued.Refresh.Token
+	}
+	resp.IDToken = issued.IDToken
+
+	noStore(w)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleIntrospect serves the token introspection endpoint (RFC 7662)
+func (h *HTTPHandler) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	client, form, ok := h.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := h.oauthService.Introspect(r.Context(), client, form.Get("token"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	resp := introspectionResponse{Active: info.Active}
+	if info.Active {
+		resp.Scope = strings.Join(info.Scopes, " ")
+		resp.ClientID = info.ClientID
+		resp.Username = info.Username
+		resp.Subject = info.Subject
+		resp.TokenType = info.TokenType
+		resp.ExpiresAt = unixOrZero(info.ExpiresAt)
+		resp.IssuedAt = unixOrZero(info.IssuedAt)
+		resp.Tenant = info.TenantID
+		resp.PrincipalType = info.PrincipalType
+		if info.Actor != "" {
+			resp.Actor = &introspectionActor{Subject: info.Actor}
+		}
+	}
+
+	noStore(w)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleRevoke serves the token revocation endpoint (RFC 7009)
+func (h *HTTPHandler) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	client, form, ok := h.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.oauthService.Revoke(r.Context(), client, form.Get("token")); err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// oauthClient parses a form posted by a registered client and
+// authenticates the client
+func (h *HTTPHandler) oauthClient(w http.ResponseWriter, r *http.Request) (*models.OAuthClient, url.Values, bool) {
+	form, ok := oauthForm(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	clientID, clientSecret, err := clientCredentials(r, form)
+	if err == nil {
+		var client *models.OAuthClient
+		if client, err = h.oauthService.AuthenticateClient(r.Context(), clientID, clientSecret); err == nil {
+			return client, form, true
+		}
+	}
+	writeOAuthError(w, err)
+	return nil, nil, false
+}
+
+// oauthForm parses the form body of a POST to the token, introspection or
+// revocation endpoint
+func oauthForm(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return nil, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormSize)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "invalid form body"})
+		return nil, false
+	}
+	return r.PostForm, true
+}
+
+// clientCredentials reads the client ID and secret from HTTP Basic
+// authentication, whose values are form-encoded (RFC 6749 section 2.3.1),
+// or from the form body. Using both at once is an error.
+func clientCredentials(r *http.Request, form url.Values) (string, string, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return form.Get("client_id"), form.Get("client_secret"), nil
+	}
+	if form.Get("client_secret") != "" {
+		return "", "", &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "use only one client authentication method"}
+	}
+
+	id, idErr := url.QueryUnescape(id)
+	secret, secretErr := url.QueryUnescape(secret)
+	if idErr != nil || secretErr != nil {
+		return "", "", &service.OAuthError{Code: service.OAuthInvalidClient, Description: "malformed client credentials"}
+	}
+	return id, secret, nil
+}
+
+// writeOAuthError writes an OAuth error response (RFC 6749 section 5.2).
+// Errors that are not OAuth errors are logged and reported as server
+// errors.
+func writeOAuthError(w http.ResponseWriter, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		log.Printf("Error handling OAuth request: %v", err)
+		noStore(w)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == service.OAuthInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		status = http.StatusUnauthorized
+	}
+	noStore(w)
+	writeJSON(w, status, map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+	})
+}
+
+// redirectError sends an authorization error back to the client's
+// redirect URI (RFC 6749 section 4.1.2.1)
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state string, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		log.Printf("Error handling authorization request: %v", err)
+		oauthErr = &service.OAuthError{Code: "server_error", Description: "internal server error"}
+	}
+	redirect(w, r, redirectURI, url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+	}, state)
+}
+
+// redirect sends the user agent to a redirect URI with params and the
+// client's state added to its query
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values, state string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	q := u.Query()
+	for key, values := range params {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+
+	noStore(w)
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// renderAuthorizePage shows the login and consent page
+func renderAuthorizePage(w http.ResponseWriter, status int, client *models.OAuthClient, areq *service.AuthorizationRequest, message string) {
+	noStore(w)
+	// The page takes a password, so it must not be framed by other sites
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	data := authorizePageData{
+		Client:  client,
+		Request: areq,
+		Scopes:  areq.Scopes,
+		Scope:   strings.Join(areq.Scopes, " "),
+		Error:   message,
+	}
+	if err := authorizePage.Execute(w, data); err != nil {
+		log.Printf("Error rendering authorization page: %v", err)
+	}
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// hasValue reports whether values contains value
+func hasValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of an HTTP request's peer
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// noStore keeps responses carrying tokens out of caches
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/service"
+)
+
+// discoveryDocument is an OpenID Provider configuration (OpenID Connect
+// Discovery section 3)
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// loggedOutPage tells the user they were logged out when the client gave
+// no post-logout redirect URI
+var loggedOutPage = template.Must(template.New("logged-out").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Logged out</title></head>
+<body>
+<h1>You have been logged out</h1>
+</body>
+</html>
+`))
+
+// handleTenantIssuer serves the endpoints that belong to the issuer of a
+// tenant other than the default one, under /tenants/{id}/
+func (h *HTTPHandler) handleTenantIssuer(w http.ResponseWriter, r *http.Request) {
+	tenantID, path, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tenants/"), "/")
+	if tenantID == "" || tenantID == models.DefaultTenantID {
+		http.NotFound(w, r)
+		return
+	}
+	tenant, err := h.authService.GetTenantService().Get(r.Context(), tenantID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	r = r.WithContext(service.WithTenant(r.Context(), tenant))
+
+	switch path {
+	case ".well-known/openid-configuration":
+		h.handleDiscovery(w, r)
+	case "oauth/userinfo":
+		h.handleUserInfo(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleDiscovery serves the OpenID Provider configuration of the
+// request's tenant
+func (h *HTTPHandler) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !h.oauthService.OIDCEnabled() {
+		writeJSONError(w, http.StatusNotFound, "OpenID Connect is disabled")
+		return
+	}
+
+	// The endpoints other than userinfo serve every tenant, since the
+	// client identifies the tenant
+	base := h.oauthService.Issuer("")
+	issuer := h.oauthService.Issuer(service.TenantFrom(r.Context()).ID)
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, discoveryDocument{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		TokenEndpoint:                     base + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		EndSessionEndpoint:                base + "/oauth/logout",
+		IntrospectionEndpoint:             base + "/oauth/introspect",
+		RevocationEndpoint:                base + "/oauth/revoke",
+		ScopesSupported:                   []string{service.ScopeOpenID, service.ScopeProfile, service.ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{h.oauthService.IDTokenAlgorithm()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{service.PKCEMethodS256},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid",
+			"preferred_username", "updated_at", "email",
+		},
+	})
+}
+
+// handleUserInfo serves the userinfo endpoint (OpenID Connect Core
+// section 5.3) of the request's tenant. The access token is sent as a
+// bearer token or, in a POST, as the access_token form field.
+func (h *HTTPHandler) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !h.oauthService.OIDCEnabled() {
+		writeJSONError(w, http.StatusNotFound, "OpenID Connect is disabled")
+		return
+	}
+
+	token := bearerToken(r)
+	if token == "" && r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormSize)
+		if err := r.ParseForm(); err == nil {
+			token = r.PostForm.Get("access_token")
+		}
+	}
+
+	info, err := h.oauthService.UserInfo(r.Context(), token)
+	if err != nil {
+		writeBearerError(w, err)
+		return
+	}
+
+	noStore(w)
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleEndSession serves the end session endpoint for RP-initiated
+// logout (OpenID Connect RP-Initiated Logout section 2). The user is sent
+// back to the client's post-logout redirect URI or shown a page saying
+// they were logged out.
+func (h *HTTPHandler) handleEndSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormSize)
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	redirectURI := r.Form.Get("post_logout_redirect_uri")
+	err := h.oauthService.EndSession(r.Context(), r.Form.Get("id_token_hint"), r.Form.Get("client_id"), redirectURI)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if redirectURI != "" {
+		redirect(w, r, redirectURI, url.Values{}, r.Form.Get("state"))
+		return
+	}
+
+	noStore(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := loggedOutPage.Execute(w, nil); err != nil {
+		log.Printf("Error rendering logout page: %v", err)
+	}
+}
+
+// writeBearerError writes an error of a resource protected by a bearer
+// token (RFC 6750 section 3). Errors that are not OAuth errors are logged
+// and reported as server errors.
+func writeBearerError(w http.ResponseWriter, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		log.Printf("Error handling userinfo request: %v", err)
+		noStore(w)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	status := http.StatusUnauthorized
+	if oauthErr.Code == service.OAuthInsufficientScope {
+		status = http.StatusForbidden
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q, error_description=%q`, oauthErr.Code, oauthErr.Description))
+	noStore(w)
+	writeJSON(w, status, map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+	})
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived credential for scripts and services. The key is
+// shown once when created; afterwards only its public prefix is visible
+// and only a hash of the secret is stored.
+type APIKey struct {
+	ID         string    `json:"id"`
+	Prefix     string    `json:"prefix"`
+	SecretHash string    `json:"-"`
+	UserID     string    `json:"user_id,omitempty"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+
+	// ServiceAccountID is set instead of UserID for service account keys
+	ServiceAccountID string `json:"service_account_id,omitempty"`
+}
+
+// Usable reports whether the key is neither revoked nor expired
+func (k *APIKey) Usable(now time.Time) bool {
+	if !k.RevokedAt.IsZero() {
+		return false
+	}
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
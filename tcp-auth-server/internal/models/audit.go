@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Audit event types
+const (
+	EventRefreshTokenReuse  = "refresh_token_reuse"
+	EventRememberTokenTheft = "remember_token_theft"
+	EventRoleAssigned       = "role_assigned"
+	EventRoleUnassigned     = "role_unassigned"
+	EventAPIKeyCreated      = "api_key_created"
+	EventAPIKeyRevoked      = "api_key_revoked"
+
+	EventServiceAccountCreated       = "service_account_created"
+	EventServiceAccountSecretRotated = "service_account_secret_rotated"
+	EventServiceAccountDisabled      = "service_account_disabled"
+	EventServiceAccountEnabled       = "service_account_enabled"
+	EventServiceAccountTokenIssued   = "service_account_token_issued"
+
+	EventOAuthClientRegistered = "oauth_client_registered"
+	EventOAuthClientDeleted    = "oauth_client_deleted"
+	EventOAuthCodeReuse        = "oauth_code_reuse"
+
+	EventIdentityLinked       = "identity_linked"
+	EventIdentityUnlinked     = "identity_unlinked"
+	EventFederatedUserCreated = "federated_user_created"
+
+	EventDirectoryUserCreated = "directory_user_created"
+
+	EventAdminUsersListed = "admin_users_listed"
+	EventAdminUserViewed  = "admin_user_viewed"
+	EventUserSuspended    = "user_suspended"
+	EventUserDisabled     = "user_disabled"
+	EventUserEnabled      = "user_enabled"
+	EventUserForcedLogout = "user_forced_logout"
+	EventUserDeleted      = "user_deleted"
+
+	EventImpersonationStarted = "impersonation_started"
+
+	EventDataExported             = "data_exported"
+	EventAccountDeletionRequested = "account_deletion_requested"
+	EventAccountDeletionCancelled = "account_deletion_cancelled"
+	EventAccountDeleted           = "account_deleted"
+)
+
+// AuditEvent records a security relevant action
+type AuditEvent struct {
+	ID        int64                  `json:"id"`
+	EventType string                 `json:"event_type"`
+	UserID    string                 `json:"user_id,omitempty"`
+	ActorID   string                 `json:"actor_id,omitempty"`
+	IPAddress string                 `json:"ip_address,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+
+	// PrincipalType tells whether UserID is a user or a service account;
+	// empty means a user
+	PrincipalType string `json:"principal_type,omitempty"`
+}
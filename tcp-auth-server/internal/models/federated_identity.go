@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// FederatedIdentity links a user to their account at an upstream OpenID
+// Connect provider. A user has at most one identity per provider.
+type FederatedIdentity struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	TenantID string `json:"tenant_id"`
+	// Provider is the name the provider is configured under
+	Provider string `json:"provider"`
+	// Subject is the provider's stable identifier for the account
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at,omitempty"`
+}
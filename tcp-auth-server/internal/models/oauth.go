@@ -0,0 +1,107 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// OAuth 2.0 grant types a registered client may use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// OAuthClient is an application registered to obtain tokens through the
+// OAuth 2.0 endpoints on behalf of users of its tenant. Public clients,
+// such as single-page and mobile apps, have no secret and must use PKCE.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	SecretHash   string    `json:"-"`
+	TenantID     string    `json:"tenant_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Scopes are the most a token issued to the client may carry; empty
+	// lets the client act with the user's full authority
+	Scopes []string `json:"scopes,omitempty"`
+
+	// PostLogoutRedirectURIs are where the user may be sent after logging
+	// out through the OpenID Connect end session endpoint
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+}
+
+// AllowsGrant reports whether the client may use a grant type
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	for _, g := range c.GrantTypes {
+		if g == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRedirectURI reports whether uri is one of the client's registered
+// redirect URIs. Redirect URIs are compared exactly.
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPostLogoutRedirectURI reports whether uri is one of the client's
+// registered post-logout redirect URIs
+func (c *OAuthClient) HasPostLogoutRedirectURI(uri string) bool {
+	for _, registered := range c.PostLogoutRedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRedirectURI checks that a redirect URI is absolute and carries
+// no fragment. Custom schemes used by native apps are allowed.
+func ValidateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() {
+		return fmt.Errorf("invalid redirect URI %q", uri)
+	}
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+		return fmt.Errorf("invalid redirect URI %q", uri)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q must not contain a fragment", uri)
+	}
+	return nil
+}
+
+// AuthorizationCode is a grant issued at the authorization endpoint and
+// redeemed once at the token endpoint. Only the hash of the code is stored.
+type AuthorizationCode struct {
+	CodeHash            string    `json:"-"`
+	ClientID            string    `json:"client_id"`
+	UserID              string    `json:"user_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scopes              []string  `json:"scopes,omitempty"`
+	CodeChallenge       string    `json:"-"`
+	CodeChallengeMethod string    `json:"code_challenge_method,omitempty"`
+	ExpiresAt           time.Time `json:"expires_at"`
+	CreatedAt           time.Time `json:"created_at"`
+	UsedAt              time.Time `json:"used_at,omitempty"`
+
+	// SessionToken is the session issued when the code was redeemed, so
+	// its tokens can be revoked if the code is presented again
+	SessionToken string `json:"-"`
+
+	// Nonce is the OpenID Connect nonce of the authorization request,
+	// returned in the ID token
+	Nonce string `json:"-"`
+}
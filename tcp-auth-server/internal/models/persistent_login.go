@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// PersistentLogin is a remember-me credential. The series identifies the
+// device and never changes; the token rotates on every use and only its
+// hash is stored. A known series presented with an old token means the
+// credential was copied.
+type PersistentLogin struct {
+	Series     string    `json:"series"`
+	UserID     string    `json:"user_id"`
+	TokenHash  string    `json:"-"`
+	ClientType string    `json:"client_type,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
@@ -0,0 +1,49 @@
+package models
+
+// Principal types
+const (
+	PrincipalUser           = "user"
+	PrincipalServiceAccount = "service_account"
+)
+
+// Principal is whoever a validated token speaks for: a user or a service
+// account
+type Principal struct {
+	Type string `json:"principal_type"`
+	ID   string `json:"id"`
+	// Name is the username of a user or the name of a service account
+	Name string `json:"name"`
+	// Email is only set for users
+	Email string `json:"email,omitempty"`
+	// TenantID is only set for users
+	TenantID string `json:"tenant_id,omitempty"`
+
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// Scopes restricts what the presented token may do; empty means the
+	// token carries the principal's full authority
+	Scopes []string `json:"scopes,omitempty"`
+
+	// ImpersonatorID is the admin acting as the user when the token is an
+	// impersonation session
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+}
+
+// UserPrincipal describes a user as a principal
+func UserPrincipal(user *User) *Principal {
+	return &Principal{
+		Type:        PrincipalUser,
+		ID:          user.ID,
+		Name:        user.Username,
+		Email:       user.Email,
+		TenantID:    user.TenantID,
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		Scopes:      user.Scopes,
+	}
+}
+
+// IsUser reports whether the principal is a user
+func (p *Principal) IsUser() bool {
+	return p.Type == PrincipalUser
+}
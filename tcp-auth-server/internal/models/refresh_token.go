@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Refresh token states
+const (
+	RefreshTokenActive  = "active"
+	RefreshTokenRotated = "rotated"
+	RefreshTokenRevoked = "revoked"
+)
+
+// RefreshToken is one link in a refresh token family. Each use rotates the
+// token: the presented token is marked rotated and a child token in the
+// same family replaces it. Only the hash of the token is stored.
+type RefreshToken struct {
+	ID           string    `json:"id"`
+	FamilyID     string    `json:"family_id"`
+	ParentID     string    `json:"parent_id,omitempty"`
+	UserID       string    `json:"user_id"`
+	TokenHash    string    `json:"-"`
+	SessionToken string    `json:"-"` // session issued together with this token
+	Status       string    `json:"status"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UsedAt       time.Time `json:"used_at,omitempty"`
+
+	// FamilyExpiresAt is when the family's first token expires. No token
+	// of the family, nor the sessions they create, outlives it.
+	FamilyExpiresAt time.Time `json:"family_expires_at"`
+
+	// Scopes of the session family, carried over on every rotation
+	Scopes []string `json:"scopes,omitempty"`
+
+	// ClientID is the OAuth client the family was issued to; empty for
+	// tokens issued over the TCP protocol
+	ClientID string `json:"client_id,omitempty"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PermissionAdmin allows use of the auth server's admin request types
+const PermissionAdmin = "auth:admin"
+
+// Role is a named set of permissions that can be assigned to users
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// maxScopeLength bounds the length of a single scope
+const maxScopeLength = 100
+
+// ValidateScopes checks that every scope is a non-empty name without
+// whitespace
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if scope == "" || len(scope) > maxScopeLength || strings.IndexFunc(scope, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// CoversScopes reports whether a token limited to granted may be narrowed to
+// requested. A nil granted list means the token is unrestricted.
+func CoversScopes(granted, requested []string) bool {
+	if len(granted) == 0 {
+		return true
+	}
+	for _, scope := range requested {
+		if !HasPermission(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// GrantsScopes reports whether permissions cover every scope, so a token
+// limited to scopes carries no authority its holder lacks. Unlike
+// CoversScopes, an empty permission list grants nothing.
+func GrantsScopes(permissions, scopes []string) bool {
+	for _, scope := range scopes {
+		if !HasPermission(permissions, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasPermission reports whether any granted permission covers permission.
+// Permissions are colon separated; "*" grants everything and "orders:*"
+// grants every permission under "orders:".
+func HasPermission(granted []string, permission string) bool {
+	for _, g := range granted {
+		if g == "*" || g == permission {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(g, "*"); ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(permission, prefix) {
+			return true
+		}
+	}
+	return false
+}
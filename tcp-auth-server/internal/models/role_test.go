@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestScopes(t *testing.T) {
+	permissions := []string{"cart:*", "orders:read"}
+
+	tests := []struct {
+		name    string
+		granted []string
+		scopes  []string
+		covers  bool
+		grants  bool
+	}{
+		{"none requested", permissions, nil, true, true},
+		{"exact", permissions, []string{"orders:read"}, true, true},
+		{"under wildcard", permissions, []string{"cart:read", "cart:write"}, true, true},
+		{"wildcard itself", permissions, []string{"cart:*"}, true, true},
+		{"not granted", permissions, []string{"orders:write"}, false, false},
+		{"prefix without colon", []string{"cart*"}, []string{"cartel"}, false, false},
+		{"everything", []string{"*"}, []string{"admin:users"}, true, true},
+		{"empty granted", nil, []string{"admin:users"}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CoversScopes(tt.granted, tt.scopes); got != tt.covers {
+				t.Errorf("CoversScopes = %v, want %v", got, tt.covers)
+			}
+			if got := GrantsScopes(tt.granted, tt.scopes); got != tt.grants {
+				t.Errorf("GrantsScopes = %v, want %v", got, tt.grants)
+			}
+		})
+	}
+}
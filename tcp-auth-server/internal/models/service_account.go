@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ServiceAccount is a non-human principal used by backend services. It has
+// no password or email and authenticates with client credentials or API
+// keys.
+type ServiceAccount struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	ClientID    string    `json:"client_id"`
+	SecretHash  string    `json:"-"`
+	Disabled    bool      `json:"disabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
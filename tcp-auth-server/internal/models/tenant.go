@@ -0,0 +1,56 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultTenantID is the tenant of requests that do not name one, and of
+// every account created before tenants existed
+const DefaultTenantID = "default"
+
+// tenantIDPattern restricts tenant IDs to short lowercase slugs, since they
+// appear in Redis keys and client configuration
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Tenant is an organization with its own namespace of users, for example
+// one storefront. Usernames and emails are unique within a tenant.
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// SessionMaxLifetime and SessionIdleTimeout override the server's
+	// session policy for the tenant's users when non-zero
+	SessionMaxLifetime time.Duration `json:"session_max_lifetime,omitempty"`
+	SessionIdleTimeout time.Duration `json:"session_idle_timeout,omitempty"`
+
+	// PasswordPolicy overrides parts of the server's password policy
+	PasswordPolicy *TenantPasswordPolicy `json:"password_policy,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TenantPasswordPolicy lists the password rules a tenant overrides; zero
+// values keep the server's setting
+type TenantPasswordPolicy struct {
+	MinLength       int      `json:"min_length,omitempty"`
+	MaxLength       int      `json:"max_length,omitempty"`
+	RequiredClasses []string `json:"required_classes,omitempty"`
+	MinClasses      int      `json:"min_classes,omitempty"`
+	MinEntropyBits  float64  `json:"min_entropy_bits,omitempty"`
+}
+
+// IsDefault reports whether t is the default tenant
+func (t *Tenant) IsDefault() bool {
+	return t.ID == DefaultTenantID
+}
+
+// ValidateTenantID checks that id is usable as a tenant ID
+func ValidateTenantID(id string) error {
+	if !tenantIDPattern.MatchString(id) {
+		return fmt.Errorf("tenant id must be 1-63 lowercase letters, digits or hyphens")
+	}
+	return nil
+}
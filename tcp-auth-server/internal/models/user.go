@@ -2,6 +2,14 @@ package models
 
 import "time"
 
+// Account statuses. Only active users can log in or use their tokens.
+const (
+	UserStatusActive          = "active"
+	UserStatusSuspended       = "suspended"
+	UserStatusDisabled        = "disabled"
+	UserStatusPendingDeletion = "pending_deletion"
+)
+
 // User represents a user in the system
 type User struct {
 	ID           string    `json:"id"`
@@ -10,16 +18,127 @@ type User struct {
 	PasswordHash string    `json:"-"` // Never serialize password hash
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	// MaxSessions overrides the default session limit when non-zero
+	MaxSessions int `json:"max_sessions,omitempty"`
+
+	// TenantID is the tenant whose namespace the username and email
+	// belong to
+	TenantID string `json:"tenant_id"`
+
+	// Backend is the credential backend that owns the account, such as an
+	// LDAP directory; empty for accounts with a local password. The row
+	// of an account owned by a backend is a shadow that sessions, roles and
+	// audit events refer to.
+	Backend string `json:"backend,omitempty"`
+
+	// Status is one of the UserStatus constants. StatusReason says why an
+	// account is not active, and StatusExpiresAt is when a suspension or
+	// disablement lapses, zero meaning it lasts until lifted, or when a
+	// pending deletion takes effect.
+	Status          string    `json:"status"`
+	StatusReason    string    `json:"status_reason,omitempty"`
+	StatusExpiresAt time.Time `json:"status_expires_at,omitempty"`
+
+	// Roles and the permissions they grant, loaded with the session
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+
+	// Scopes restricts what the presented token may do; empty means the
+	// token carries the user's full authority
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// EffectiveStatus returns the user's status, taking lapsed suspensions and
+// disablements into account. Users loaded without their status, such as
+// ones just created, are active.
+func (u *User) EffectiveStatus() string {
+	switch u.Status {
+	case "":
+		return UserStatusActive
+	case UserStatusSuspended, UserStatusDisabled:
+		if !u.StatusExpiresAt.IsZero() && !time.Now().Before(u.StatusExpiresAt) {
+			return UserStatusActive
+		}
+	}
+	return u.Status
+}
+
+// Active reports whether the user may log in and use their tokens
+func (u *User) Active() bool {
+	return u.EffectiveStatus() == UserStatusActive
 }
 
-// Session represents a user session
+// Session represents a user session.
+//
+// A session has two independent limits: it ends IdleTimeout after it was
+// last used, and at AbsoluteExpiresAt regardless of activity. ExpiresAt is
+// whichever of the two comes first.
 type Session struct {
-	Token     string    `json:"token"`
-	UserID    string    `json:"user_id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                string        `json:"id"` // public identifier, safe to show to the user
+	Token             string        `json:"token"`
+	UserID            string        `json:"user_id"`
+	Username          string        `json:"username"`
+	Email             string        `json:"email"`
+	ClientType        string        `json:"client_type,omitempty"`
+	ClientIP          string        `json:"client_ip,omitempty"`
+	UserAgent         string        `json:"user_agent,omitempty"`
+	IdleTimeout       time.Duration `json:"idle_timeout,omitempty"`
+	AbsoluteExpiresAt time.Time     `json:"absolute_expires_at"`
+	LastUsedAt        time.Time     `json:"last_used_at"`
+	ExpiresAt         time.Time     `json:"expires_at"`
+	CreatedAt         time.Time     `json:"created_at"`
+
+	// Roles and permissions are cached for the life of the session and
+	// rewritten when the user's roles change
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+
+	// Scopes limits the session to these permissions; empty for a session
+	// with the user's full authority
+	Scopes []string `json:"scopes,omitempty"`
+
+	// PrincipalType is PrincipalServiceAccount for service account
+	// sessions, whose UserID holds the service account ID. Empty for users.
+	PrincipalType string `json:"principal_type,omitempty"`
+
+	// TenantID is the tenant of the session's user; empty for service
+	// account sessions, which are not tied to a tenant
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// ClientID is the OAuth client the session was issued to; empty for
+	// sessions created over the TCP protocol
+	ClientID string `json:"client_id,omitempty"`
+
+	// ImpersonatorID is the admin acting as the user in an impersonation
+	// session; empty for the user's own sessions
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
 }
 
+// Impersonated reports whether an admin is acting as the user
+func (s *Session) Impersonated() bool {
+	return s.ImpersonatorID != ""
+}
 
+// NextExpiry returns when the session expires if it is used at now
+func (s *Session) NextExpiry(now time.Time) time.Time {
+	if s.IdleTimeout <= 0 {
+		return s.AbsoluteExpiresAt
+	}
+	if idle := now.Add(s.IdleTimeout); idle.Before(s.AbsoluteExpiresAt) {
+		return idle
+	}
+	return s.AbsoluteExpiresAt
+}
+
+// Expired reports whether either session limit has passed
+func (s *Session) Expired(now time.Time) bool {
+	if now.After(s.ExpiresAt) {
+		return true
+	}
+	// Sessions stored before absolute lifetimes existed only have ExpiresAt
+	if !s.AbsoluteExpiresAt.IsZero() && now.After(s.AbsoluteExpiresAt) {
+		return true
+	}
+	return s.IdleTimeout > 0 && now.After(s.LastUsedAt.Add(s.IdleTimeout))
+}
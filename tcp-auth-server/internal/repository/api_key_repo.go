@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// APIKeyRepository handles API keys in PostgreSQL
+type APIKeyRepository struct {
+	pool *postgres.Client
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(pool *postgres.Client) *APIKeyRepository {
+	return &APIKeyRepository{
+		pool: pool,
+	}
+}
+
+// apiKeyColumns are the columns read by scanAPIKey
+const apiKeyColumns = `id, prefix, secret_hash, COALESCE(user_id, ''), COALESCE(service_account_id, ''),
+	name, COALESCE(scopes, '{}'), created_at, expires_at, last_used_at, revoked_at`
+
+// CreateAPIKey stores a new API key
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, prefix, secret_hash, user_id, service_account_id, name, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query,
+		key.ID, key.Prefix, key.SecretHash, nullString(key.UserID), nullString(key.ServiceAccountID),
+		key.Name, key.Scopes, key.CreatedAt, nullTime(key.ExpiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return nil
+}
+
+// GetAPIKeyByPrefix retrieves an API key by its public prefix
+func (r *APIKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = $1`
+
+	key, err := scanAPIKey(r.pool.Pool().QueryRow(ctx, query, prefix))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("API key not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return key, nil
+}
+
+// ListUserAPIKeys returns a user's API keys, newest first, including
+// revoked and expired ones
+func (r *APIKeyRepository) ListUserAPIKeys(ctx context.Context, userID string) ([]*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`
+	return r.listAPIKeys(ctx, query, userID)
+}
+
+// ListServiceAccountAPIKeys returns a service account's API keys, newest
+// first
+func (r *APIKeyRepository) ListServiceAccountAPIKeys(ctx context.Context, serviceAccountID string) ([]*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE service_account_id = $1 ORDER BY created_at DESC`
+	return r.listAPIKeys(ctx, query, serviceAccountID)
+}
+
+// listAPIKeys runs a multi-row API key query
+func (r *APIKeyRepository) listAPIKeys(ctx context.Context, query string, arg string) ([]*models.APIKey, error) {
+	rows, err := r.pool.Pool().Query(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, id, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("API key not found")
+	}
+
+	return nil
+}
+
+// RevokeServiceAccountAPIKey revokes one of a service account's API keys
+func (r *APIKeyRepository) RevokeServiceAccountAPIKey(ctx context.Context, serviceAccountID, id string) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = $3
+		WHERE id = $1 AND service_account_id = $2 AND revoked_at IS NULL
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, id, serviceAccountID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("API key not found")
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that a key was used
+func (r *APIKeyRepository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`
+
+	_, err := r.pool.Pool().Exec(ctx, query, id, usedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update API key usage: %w", err)
+	}
+
+	return nil
+}
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var key models.APIKey
+	var expiresAt, lastUsedAt, revokedAt *time.Time
+	if err := row.Scan(
+		&key.ID,
+		&key.Prefix,
+		&key.SecretHash,
+		&key.UserID,
+		&key.ServiceAccountID,
+		&key.Name,
+		&key.Scopes,
+		&key.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if expiresAt != nil {
+		key.ExpiresAt = *expiresAt
+	}
+	if lastUsedAt != nil {
+		key.LastUsedAt = *lastUsedAt
+	}
+	if revokedAt != nil {
+		key.RevokedAt = *revokedAt
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+)
+
+// AuditRepository stores audit events in PostgreSQL
+type AuditRepository struct {
+	pool *postgres.Client
+}
+
+// NewAuditRepository creates a new audit repository
+func NewAuditRepository(pool *postgres.Client) *AuditRepository {
+	return &AuditRepository{
+		pool: pool,
+	}
+}
+
+// CreateEvent stores an audit event
+func (r *AuditRepository) CreateEvent(ctx context.Context, event *models.AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	query := `
+		INSERT INTO audit_events (event_type, user_id, principal_type, actor_id, ip_address, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	principalType := event.PrincipalType
+	if principalType == "" {
+		principalType = models.PrincipalUser
+	}
+
+	err = r.pool.Pool().QueryRow(ctx, query,
+		event.EventType, event.UserID, principalType, event.ActorID, event.IPAddress, details, event.CreatedAt,
+	).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create audit event: %w", err)
+	}
+
+	return nil
+}
+
+// ListUserEvents returns the events about a user or performed by them,
+// oldest first
+func (r *AuditRepository) ListUserEvents(ctx context.Context, userID string) ([]*models.AuditEvent, error) {
+	query := `
+		SELECT id, event_type, COALESCE(user_id, ''), principal_type, COALESCE(actor_id, ''),
+			COALESCE(ip_address, ''), details, created_at
+		FROM audit_events
+		WHERE (user_id = $1 AND principal_type = $2) OR actor_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query, userID, models.PrincipalUser)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var details []byte
+		if err := rows.Scan(
+			&event.ID,
+			&event.EventType,
+			&event.UserID,
+			&event.PrincipalType,
+			&event.ActorID,
+			&event.IPAddress,
+			&details,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &event.Details); err != nil {
+				return nil, fmt.Errorf("failed to decode audit details: %w", err)
+			}
+		}
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	return events, nil
+}
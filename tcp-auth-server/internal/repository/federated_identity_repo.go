@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// FederatedIdentityRepository handles links between users and upstream
+// identity provider accounts in PostgreSQL
+type FederatedIdentityRepository struct {
+	pool *postgres.Client
+}
+
+// NewFederatedIdentityRepository creates a new federated identity
+// repository
+func NewFederatedIdentityRepository(pool *postgres.Client) *FederatedIdentityRepository {
+	return &FederatedIdentityRepository{
+		pool: pool,
+	}
+}
+
+// federatedIdentityColumns are the columns read by scanFederatedIdentity
+const federatedIdentityColumns = `id, user_id, tenant_id, provider, subject, COALESCE(email, ''), created_at, last_login_at`
+
+// CreateIdentity links an upstream account to a user. It fails if the
+// account is linked already or the user has an identity at the provider.
+func (r *FederatedIdentityRepository) CreateIdentity(ctx context.Context, identity *models.FederatedIdentity) error {
+	query := `
+		INSERT INTO federated_identities (id, user_id, tenant_id, provider, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT DO NOTHING
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query,
+		identity.ID, identity.UserID, identity.TenantID, identity.Provider, identity.Subject,
+		nullString(identity.Email), identity.CreatedAt, nullTime(identity.LastLoginAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("identity is already linked")
+	}
+
+	return nil
+}
+
+// GetIdentity retrieves the identity of an upstream account in a tenant
+func (r *FederatedIdentityRepository) GetIdentity(ctx context.Context, tenantID, provider, subject string) (*models.FederatedIdentity, error) {
+	query := `SELECT ` + federatedIdentityColumns + ` FROM federated_identities
+		WHERE tenant_id = $1 AND provider = $2 AND subject = $3`
+
+	identity, err := scanFederatedIdentity(r.pool.Pool().QueryRow(ctx, query, tenantID, provider, subject))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("identity not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+// ListIdentities returns the identities linked to a user by provider
+func (r *FederatedIdentityRepository) ListIdentities(ctx context.Context, userID string) ([]*models.FederatedIdentity, error) {
+	query := `SELECT ` + federatedIdentityColumns + ` FROM federated_identities WHERE user_id = $1 ORDER BY provider`
+
+	rows, err := r.pool.Pool().Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []*models.FederatedIdentity
+	for rows.Next() {
+		identity, err := scanFederatedIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+// TouchLogin records a login through an identity and the email address
+// the provider reported
+func (r *FederatedIdentityRepository) TouchLogin(ctx context.Context, id, email string) error {
+	query := `UPDATE federated_identities SET last_login_at = $2, email = COALESCE($3, email) WHERE id = $1`
+
+	if _, err := r.pool.Pool().Exec(ctx, query, id, time.Now(), nullString(email)); err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteIdentity unlinks a user's identity at a provider
+func (r *FederatedIdentityRepository) DeleteIdentity(ctx context.Context, userID, provider string) error {
+	query := `DELETE FROM federated_identities WHERE user_id = $1 AND provider = $2`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, userID, provider)
+	if err != nil {
+		return fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("identity not found")
+	}
+
+	return nil
+}
+
+// scanFederatedIdentity reads a row selected with federatedIdentityColumns
+func scanFederatedIdentity(row pgx.Row) (*models.FederatedIdentity, error) {
+	var identity models.FederatedIdentity
+	var lastLoginAt *time.Time
+	if err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.TenantID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&lastLoginAt,
+	); err != nil {
+		return nil, err
+	}
+	if lastLoginAt != nil {
+		identity.LastLoginAt = *lastLoginAt
+	}
+	return &identity, nil
+}
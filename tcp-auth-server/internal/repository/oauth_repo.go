@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// OAuthRepository handles OAuth clients and authorization codes in
+// PostgreSQL
+type OAuthRepository struct {
+	pool *postgres.Client
+}
+
+// NewOAuthRepository creates a new OAuth repository
+func NewOAuthRepository(pool *postgres.Client) *OAuthRepository {
+	return &OAuthRepository{
+		pool: pool,
+	}
+}
+
+// oauthClientColumns are the columns read by scanOAuthClient
+const oauthClientColumns = `client_id, COALESCE(secret_hash, ''), tenant_id, name, redirect_uris, grant_types,
+	COALESCE(scopes, '{}'), public, created_at, COALESCE(post_logout_redirect_uris, '{}')`
+
+// authorizationCodeColumns are the columns read by scanAuthorizationCode
+const authorizationCodeColumns = `code_hash, client_id, user_id, redirect_uri, COALESCE(scopes, '{}'),
+	COALESCE(code_challenge, ''), COALESCE(code_challenge_method, ''), COALESCE(session_token, ''),
+	COALESCE(nonce, ''), expires_at, created_at, used_at`
+
+// CreateClient stores a new OAuth client
+func (r *OAuthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	query := `
+		INSERT INTO oauth_clients (client_id, secret_hash, tenant_id, name, redirect_uris, grant_types, scopes, public,
+			created_at, post_logout_redirect_uris)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query,
+		client.ID, nullString(client.SecretHash), client.TenantID, client.Name, client.RedirectURIs,
+		client.GrantTypes, client.Scopes, client.Public, client.CreatedAt, client.PostLogoutRedirectURIs,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create OAuth client: %w", err)
+	}
+
+	return nil
+}
+
+// GetClient retrieves an OAuth client by client ID
+func (r *OAuthRepository) GetClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE client_id = $1`
+
+	client, err := scanOAuthClient(r.pool.Pool().QueryRow(ctx, query, clientID))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("OAuth client not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth client: %w", err)
+	}
+
+	return client, nil
+}
+
+// ListClients returns the OAuth clients of a tenant by name
+func (r *OAuthRepository) ListClients(ctx context.Context, tenantID string) ([]*models.OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE tenant_id = $1 ORDER BY name`
+
+	rows, err := r.pool.Pool().Query(ctx, query, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OAuth clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*models.OAuthClient
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan OAuth client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list OAuth clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+// DeleteClient removes an OAuth client together with its authorization
+// codes and refresh tokens
+func (r *OAuthRepository) DeleteClient(ctx context.Context, clientID string) error {
+	tag, err := r.pool.Pool().Exec(ctx, `DELETE FROM oauth_clients WHERE client_id = $1`, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete OAuth client: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("OAuth client not found")
+	}
+
+	return nil
+}
+
+// CreateAuthorizationCode stores a new authorization code
+func (r *OAuthRepository) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	query := `
+		INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes,
+			code_challenge, code_challenge_method, nonce, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query,
+		code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scopes,
+		nullString(code.CodeChallenge), nullString(code.CodeChallengeMethod), nullString(code.Nonce),
+		code.ExpiresAt, code.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create authorization code: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuthorizationCode retrieves an authorization code by the hash of its
+// value
+func (r *OAuthRepository) GetAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	query := `SELECT ` + authorizationCodeColumns + ` FROM oauth_authorization_codes WHERE code_hash = $1`
+
+	code, err := scanAuthorizationCode(r.pool.Pool().QueryRow(ctx, query, codeHash))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("authorization code not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+
+	return code, nil
+}
+
+// MarkCodeUsed marks an authorization code as redeemed. It returns false
+// if the code had already been used, which means another request redeemed
+// it first.
+func (r *OAuthRepository) MarkCodeUsed(ctx context.Context, codeHash string) (bool, error) {
+	query := `
+		UPDATE oauth_authorization_codes
+		SET used_at = $2
+		WHERE code_hash = $1 AND used_at IS NULL
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, codeHash, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// SetCodeSession records the session issued for a redeemed code
+func (r *OAuthRepository) SetCodeSession(ctx context.Context, codeHash, sessionToken string) error {
+	query := `UPDATE oauth_authorization_codes SET session_token = $2 WHERE code_hash = $1`
+
+	if _, err := r.pool.Pool().Exec(ctx, query, codeHash, sessionToken); err != nil {
+		return fmt.Errorf("failed to update authorization code: %w", err)
+	}
+
+	return nil
+}
+
+// CleanExpiredCodes removes expired authorization codes
+func (r *OAuthRepository) CleanExpiredCodes(ctx context.Context) error {
+	query := `DELETE FROM oauth_authorization_codes WHERE expires_at < NOW()`
+
+	if _, err := r.pool.Pool().Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to clean expired authorization codes: %w", err)
+	}
+
+	return nil
+}
+
+// scanOAuthClient reads a row selected with oauthClientColumns
+func scanOAuthClient(row pgx.Row) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := row.Scan(
+		&client.ID,
+		&client.SecretHash,
+		&client.TenantID,
+		&client.Name,
+		&client.RedirectURIs,
+		&client.GrantTypes,
+		&client.Scopes,
+		&client.Public,
+		&client.CreatedAt,
+		&client.PostLogoutRedirectURIs,
+	); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// scanAuthorizationCode reads a row selected with authorizationCodeColumns
+func scanAuthorizationCode(row pgx.Row) (*models.AuthorizationCode, error) {
+	var code models.AuthorizationCode
+	var usedAt *time.Time
+	if err := row.Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scopes,
+		&code.CodeChallenge,
+		&code.CodeChallengeMethod,
+		&code.SessionToken,
+		&code.Nonce,
+		&code.ExpiresAt,
+		&code.CreatedAt,
+		&usedAt,
+	); err != nil {
+		return nil, err
+	}
+	if usedAt != nil {
+		code.UsedAt = *usedAt
+	}
+	return &code, nil
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PersistentLoginRepository handles remember-me credentials in PostgreSQL
+type PersistentLoginRepository struct {
+	pool *postgres.Client
+}
+
+// NewPersistentLoginRepository creates a new persistent login repository
+func NewPersistentLoginRepository(pool *postgres.Client) *PersistentLoginRepository {
+	return &PersistentLoginRepository{
+		pool: pool,
+	}
+}
+
+// CreatePersistentLogin stores a new remember-me series
+func (r *PersistentLoginRepository) CreatePersistentLogin(ctx context.Context, login *models.PersistentLogin) error {
+	query := `
+		INSERT INTO persistent_logins (series, user_id, token_hash, client_type, user_agent, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query,
+		login.Series, login.UserID, login.TokenHash, login.ClientType, login.UserAgent,
+		login.CreatedAt, login.LastUsedAt, login.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create persistent login: %w", err)
+	}
+
+	return nil
+}
+
+// GetPersistentLogin retrieves a remember-me series
+func (r *PersistentLoginRepository) GetPersistentLogin(ctx context.Context, series string) (*models.PersistentLogin, error) {
+	query := `
+		SELECT series, user_id, token_hash, COALESCE(client_type, ''), COALESCE(user_agent, ''),
+			created_at, last_used_at, expires_at
+		FROM persistent_logins
+		WHERE series = $1
+	`
+
+	var login models.PersistentLogin
+	err := r.pool.Pool().QueryRow(ctx, query, series).Scan(
+		&login.Series,
+		&login.UserID,
+		&login.TokenHash,
+		&login.ClientType,
+		&login.UserAgent,
+		&login.CreatedAt,
+		&login.LastUsedAt,
+		&login.ExpiresAt,
+	)
+
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("persistent login not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get persistent login: %w", err)
+	}
+
+	return &login, nil
+}
+
+// RotateToken replaces the token of a series if it still has the expected
+// hash. It returns false if another request rotated it first.
+func (r *PersistentLoginRepository) RotateToken(ctx context.Context, series, oldHash, newHash string) (bool, error) {
+	query := `
+		UPDATE persistent_logins
+		SET token_hash = $3, last_used_at = $4
+		WHERE series = $1 AND token_hash = $2
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, series, oldHash, newHash, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate persistent login: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeletePersistentLogin removes a remember-me series
+func (r *PersistentLoginRepository) DeletePersistentLogin(ctx context.Context, series string) error {
+	query := `DELETE FROM persistent_logins WHERE series = $1`
+
+	_, err := r.pool.Pool().Exec(ctx, query, series)
+	if err != nil {
+		return fmt.Errorf("failed to delete persistent login: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUserPersistentLogins removes every remember-me series of a user
+func (r *PersistentLoginRepository) DeleteUserPersistentLogins(ctx context.Context, userID string) error {
+	query := `DELETE FROM persistent_logins WHERE user_id = $1`
+
+	_, err := r.pool.Pool().Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete persistent logins: %w", err)
+	}
+
+	return nil
+}
+
+// CleanExpiredPersistentLogins removes expired remember-me series
+func (r *PersistentLoginRepository) CleanExpiredPersistentLogins(ctx context.Context) error {
+	query := `DELETE FROM persistent_logins WHERE expires_at < NOW()`
+
+	_, err := r.pool.Pool().Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to clean expired persistent logins: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrRefreshTokenNotFound is returned when no refresh token matches
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// RefreshTokenRepository handles refresh token families in PostgreSQL
+type RefreshTokenRepository struct {
+	pool *postgres.Client
+}
+
+// NewRefreshTokenRepository creates a new refresh token repository
+func NewRefreshTokenRepository(pool *postgres.Client) *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		pool: pool,
+	}
+}
+
+// CreateRefreshToken stores a new refresh token
+func (r *RefreshTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, family_id, parent_id, user_id, token_hash, session_token, status, scopes, expires_at, family_expires_at, created_at, client_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	var parentID *string
+	if token.ParentID != "" {
+		parentID = &token.ParentID
+	}
+
+	_, err := r.pool.Pool().Exec(ctx, query,
+		token.ID, token.FamilyID, parentID, token.UserID, token.TokenHash,
+		token.SessionToken, token.Status, token.Scopes, token.ExpiresAt, nullTime(token.FamilyExpiresAt), token.CreatedAt,
+		nullString(token.ClientID),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// refreshTokenColumns are the columns scanned by scanRefreshToken
+const refreshTokenColumns = `id, family_id, COALESCE(parent_id, ''), user_id, token_hash, session_token,
+			status, COALESCE(scopes, '{}'), expires_at, family_expires_at, created_at, used_at, COALESCE(client_id, '')`
+
+// GetRefreshTokenByHash retrieves a refresh token by the hash of its value
+func (r *RefreshTokenRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `
+		SELECT ` + refreshTokenColumns + `
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+
+	return scanRefreshToken(r.pool.Pool().QueryRow(ctx, query, tokenHash))
+}
+
+// GetActiveTokenBySession retrieves the active refresh token issued
+// together with a session
+func (r *RefreshTokenRepository) GetActiveTokenBySession(ctx context.Context, sessionToken string) (*models.RefreshToken, error) {
+	query := `
+		SELECT ` + refreshTokenColumns + `
+		FROM refresh_tokens
+		WHERE session_token = $1 AND status = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	return scanRefreshToken(r.pool.Pool().QueryRow(ctx, query, sessionToken, models.RefreshTokenActive))
+}
+
+// scanRefreshToken scans a row of refreshTokenColumns
+func scanRefreshToken(row pgx.Row) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	var familyExpiresAt, usedAt *time.Time
+	err := row.Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.ParentID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.SessionToken,
+		&token.Status,
+		&token.Scopes,
+		&token.ExpiresAt,
+		&familyExpiresAt,
+		&token.CreatedAt,
+		&usedAt,
+		&token.ClientID,
+	)
+
+	if err == pgx.ErrNoRows {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if familyExpiresAt != nil {
+		token.FamilyExpiresAt = *familyExpiresAt
+	}
+	if usedAt != nil {
+		token.UsedAt = *usedAt
+	}
+
+	return &token, nil
+}
+
+// MarkRotated marks an active refresh token as used. It returns false if
+// the token was no longer active, which means another request rotated or
+// revoked it first.
+func (r *RefreshTokenRepository) MarkRotated(ctx context.Context, id string) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET status = $2, used_at = $3
+		WHERE id = $1 AND status = $4
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, id, models.RefreshTokenRotated, time.Now(), models.RefreshTokenActive)
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeFamily revokes every token in a family and returns the session
+// tokens that were issued with them
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) ([]string, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET status = $2
+		WHERE family_id = $1
+		RETURNING session_token
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query, familyID, models.RefreshTokenRevoked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	defer rows.Close()
+
+	var sessionTokens []string
+	for rows.Next() {
+		var sessionToken string
+		if err := rows.Scan(&sessionToken); err != nil {
+			return nil, fmt.Errorf("failed to scan session token: %w", err)
+		}
+		sessionTokens = append(sessionTokens, sessionToken)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+
+	return sessionTokens, nil
+}
+
+// RevokeClientTokens revokes every refresh token issued to an OAuth client
+// and returns the session tokens that were issued with them
+func (r *RefreshTokenRepository) RevokeClientTokens(ctx context.Context, clientID string) ([]string, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET status = $2
+		WHERE client_id = $1 AND status = $3
+		RETURNING session_token
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query, clientID, models.RefreshTokenRevoked, models.RefreshTokenActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var sessionTokens []string
+	for rows.Next() {
+		var sessionToken string
+		if err := rows.Scan(&sessionToken); err != nil {
+			return nil, fmt.Errorf("failed to scan session token: %w", err)
+		}
+		sessionTokens = append(sessionTokens, sessionToken)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return sessionTokens, nil
+}
+
+// RevokeBySession revokes the family of the refresh token issued together
+// with a session
+func (r *RefreshTokenRepository) RevokeBySession(ctx context.Context, sessionToken string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET status = $2
+		WHERE family_id IN (SELECT family_id FROM refresh_tokens WHERE session_token = $1)
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query, sessionToken, models.RefreshTokenRevoked)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes every refresh token of a user
+func (r *RefreshTokenRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	query := `UPDATE refresh_tokens SET status = $2 WHERE user_id = $1 AND status <> $2`
+
+	_, err := r.pool.Pool().Exec(ctx, query, userID, models.RefreshTokenRevoked)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+// CleanExpiredTokens removes expired refresh tokens
+func (r *RefreshTokenRepository) CleanExpiredTokens(ctx context.Context) error {
+	query := `DELETE FROM refresh_tokens WHERE expires_at < NOW()`
+
+	_, err := r.pool.Pool().Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to clean expired refresh tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+)
+
+// RoleRepository handles roles, permissions and role assignments
+type RoleRepository struct {
+	pool *postgres.Client
+}
+
+// NewRoleRepository creates a new role repository
+func NewRoleRepository(pool *postgres.Client) *RoleRepository {
+	return &RoleRepository{
+		pool: pool,
+	}
+}
+
+// GetUserAuthorization returns the roles assigned to a user and the
+// permissions those roles grant
+func (r *RoleRepository) GetUserAuthorization(ctx context.Context, userID string) ([]string, []string, error) {
+	query := `
+		SELECT COALESCE(array_agg(DISTINCT ur.role), '{}'),
+		       COALESCE(array_agg(DISTINCT rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+		FROM user_roles ur
+		LEFT JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_id = $1
+	`
+
+	var roles, permissions []string
+	err := r.pool.Pool().QueryRow(ctx, query, userID).Scan(&roles, &permissions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+
+	return roles, permissions, nil
+}
+
+// GetServiceAccountAuthorization returns the roles assigned to a service
+// account and the permissions those roles grant
+func (r *RoleRepository) GetServiceAccountAuthorization(ctx context.Context, serviceAccountID string) ([]string, []string, error) {
+	query := `
+		SELECT COALESCE(array_agg(DISTINCT sr.role), '{}'),
+		       COALESCE(array_agg(DISTINCT rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+		FROM service_account_roles sr
+		LEFT JOIN role_permissions rp ON rp.role = sr.role
+		WHERE sr.service_account_id = $1
+	`
+
+	var roles, permissions []string
+	err := r.pool.Pool().QueryRow(ctx, query, serviceAccountID).Scan(&roles, &permissions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get service account roles: %w", err)
+	}
+
+	return roles, permissions, nil
+}
+
+// ListRoles returns every role with its permissions
+func (r *RoleRepository) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	query := `
+		SELECT r.name, COALESCE(r.description, ''),
+		       COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		GROUP BY r.name, r.description
+		ORDER BY r.name
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []*models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.Permissions); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, &role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// AssignRole gives a user a role. Assigning a role the user already has is
+// not an error.
+func (r *RoleRepository) AssignRole(ctx context.Context, userID, role string) error {
+	if err := r.checkRole(ctx, role); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO user_roles (user_id, role, assigned_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, role) DO NOTHING
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query, userID, role, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	return nil
+}
+
+// AssignServiceAccountRole gives a service account a role
+func (r *RoleRepository) AssignServiceAccountRole(ctx context.Context, serviceAccountID, role string) error {
+	if err := r.checkRole(ctx, role); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO service_account_roles (service_account_id, role, assigned_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (service_account_id, role) DO NOTHING
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query, serviceAccountID, role, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	return nil
+}
+
+// UnassignServiceAccountRole takes a role away from a service account
+func (r *RoleRepository) UnassignServiceAccountRole(ctx context.Context, serviceAccountID, role string) error {
+	query := `DELETE FROM service_account_roles WHERE service_account_id = $1 AND role = $2`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, serviceAccountID, role)
+	if err != nil {
+		return fmt.Errorf("failed to unassign role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("service account does not have role %s", role)
+	}
+
+	return nil
+}
+
+// checkRole returns an error if a role does not exist
+func (r *RoleRepository) checkRole(ctx context.Context, role string) error {
+	var exists bool
+	err := r.pool.Pool().QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check role: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("role not found")
+	}
+	return nil
+}
+
+// UnassignRole takes a role away from a user
+func (r *RoleRepository) UnassignRole(ctx context.Context, userID, role string) error {
+	query := `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to unassign role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user does not have role %s", role)
+	}
+
+	return nil
+}
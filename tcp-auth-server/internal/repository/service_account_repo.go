@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ServiceAccountRepository handles service accounts in PostgreSQL
+type ServiceAccountRepository struct {
+	pool *postgres.Client
+}
+
+// NewServiceAccountRepository creates a new service account repository
+func NewServiceAccountRepository(pool *postgres.Client) *ServiceAccountRepository {
+	return &ServiceAccountRepository{
+		pool: pool,
+	}
+}
+
+// serviceAccountColumns are the columns read by scanServiceAccount
+const serviceAccountColumns = `id, name, COALESCE(description, ''), client_id, secret_hash, disabled, created_at, updated_at`
+
+// CreateServiceAccount stores a new service account
+func (r *ServiceAccountRepository) CreateServiceAccount(ctx context.Context, account *models.ServiceAccount) error {
+	query := `
+		INSERT INTO service_accounts (id, name, description, client_id, secret_hash, disabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query,
+		account.ID, account.Name, account.Description, account.ClientID, account.SecretHash,
+		account.Disabled, account.CreatedAt, account.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+
+	return nil
+}
+
+// GetServiceAccountByID retrieves a service account by ID
+func (r *ServiceAccountRepository) GetServiceAccountByID(ctx context.Context, id string) (*models.ServiceAccount, error) {
+	query := `SELECT ` + serviceAccountColumns + ` FROM service_accounts WHERE id = $1`
+	return r.getServiceAccount(ctx, query, id)
+}
+
+// GetServiceAccountByClientID retrieves a service account by client ID
+func (r *ServiceAccountRepository) GetServiceAccountByClientID(ctx context.Context, clientID string) (*models.ServiceAccount, error) {
+	query := `SELECT ` + serviceAccountColumns + ` FROM service_accounts WHERE client_id = $1`
+	return r.getServiceAccount(ctx, query, clientID)
+}
+
+// ListServiceAccounts returns every service account by name
+func (r *ServiceAccountRepository) ListServiceAccounts(ctx context.Context) ([]*models.ServiceAccount, error) {
+	query := `SELECT ` + serviceAccountColumns + ` FROM service_accounts ORDER BY name`
+
+	rows, err := r.pool.Pool().Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []*models.ServiceAccount
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service account: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// UpdateSecretHash replaces a service account's client secret
+func (r *ServiceAccountRepository) UpdateSecretHash(ctx context.Context, id, secretHash string) error {
+	query := `
+		UPDATE service_accounts
+		SET secret_hash = $2, updated_at = $3
+		WHERE id = $1
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, id, secretHash, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update service account secret: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("service account not found")
+	}
+
+	return nil
+}
+
+// SetDisabled disables or re-enables a service account
+func (r *ServiceAccountRepository) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	query := `
+		UPDATE service_accounts
+		SET disabled = $2, updated_at = $3
+		WHERE id = $1
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, id, disabled, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update service account: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("service account not found")
+	}
+
+	return nil
+}
+
+// getServiceAccount runs a single-row service account query
+func (r *ServiceAccountRepository) getServiceAccount(ctx context.Context, query string, arg string) (*models.ServiceAccount, error) {
+	account, err := scanServiceAccount(r.pool.Pool().QueryRow(ctx, query, arg))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("service account not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service account: %w", err)
+	}
+
+	return account, nil
+}
+
+// scanServiceAccount reads a row selected with serviceAccountColumns
+func scanServiceAccount(row pgx.Row) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	if err := row.Scan(
+		&account.ID,
+		&account.Name,
+		&account.Description,
+		&account.ClientID,
+		&account.SecretHash,
+		&account.Disabled,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"tcp-auth-server/internal/models"
 	"tcp-auth-server/pkg/postgres"
 
 	"github.com/jackc/pgx/v5"
@@ -23,15 +24,23 @@ func NewSessionRepository(pool *postgres.Client) *SessionRepository {
 }
 
 // CreateSession creates a session record in PostgreSQL
-func (r *SessionRepository) CreateSession(ctx context.Context, userID, sessionToken string, expiresAt time.Time) error {
+func (r *SessionRepository) CreateSession(ctx context.Context, session *models.Session) error {
 	query := `
-		INSERT INTO user_sessions (user_id, session_token, expires_at, created_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO user_sessions (session_id, user_id, session_token, client_type, client_ip, user_agent,
+			scopes, expires_at, absolute_expires_at, last_used_at, created_at, impersonator_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''))
 		ON CONFLICT (session_token) DO UPDATE
-		SET expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at
+		SET expires_at = EXCLUDED.expires_at,
+			absolute_expires_at = EXCLUDED.absolute_expires_at,
+			last_used_at = EXCLUDED.last_used_at,
+			created_at = EXCLUDED.created_at
 	`
 
-	_, err := r.pool.Pool().Exec(ctx, query, userID, sessionToken, expiresAt, time.Now())
+	_, err := r.pool.Pool().Exec(ctx, query,
+		session.ID, session.UserID, session.Token, session.ClientType, session.ClientIP, session.UserAgent,
+		session.Scopes, session.ExpiresAt, session.AbsoluteExpiresAt, session.LastUsedAt, session.CreatedAt,
+		session.ImpersonatorID,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
@@ -39,6 +48,22 @@ func (r *SessionRepository) CreateSession(ctx context.Context, userID, sessionTo
 	return nil
 }
 
+// TouchSession records activity on a session and its new idle expiry
+func (r *SessionRepository) TouchSession(ctx context.Context, sessionToken string, lastUsedAt, expiresAt time.Time) error {
+	query := `
+		UPDATE user_sessions
+		SET last_used_at = $2, expires_at = $3
+		WHERE session_token = $1
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query, sessionToken, lastUsedAt, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+
+	return nil
+}
+
 // GetSession retrieves a session by token
 func (r *SessionRepository) GetSession(ctx context.Context, sessionToken string) (string, time.Time, error) {
 	query := `
@@ -61,6 +86,55 @@ func (r *SessionRepository) GetSession(ctx context.Context, sessionToken string)
 	return userID, expiresAt, nil
 }
 
+// ListUserSessions returns the sessions of a user still recorded in
+// PostgreSQL, newest first. Tokens are not loaded.
+func (r *SessionRepository) ListUserSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	query := `
+		SELECT COALESCE(session_id, ''), user_id, COALESCE(client_type, ''), COALESCE(client_ip, ''),
+			COALESCE(user_agent, ''), COALESCE(scopes, '{}'), expires_at, last_used_at,
+			COALESCE(impersonator_id, ''), created_at
+		FROM user_sessions
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		var session models.Session
+		var lastUsedAt *time.Time
+		if err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.ClientType,
+			&session.ClientIP,
+			&session.UserAgent,
+			&session.Scopes,
+			&session.ExpiresAt,
+			&lastUsedAt,
+			&session.ImpersonatorID,
+			&session.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if lastUsedAt != nil {
+			session.LastUsedAt = *lastUsedAt
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
 // DeleteSession removes a session by token
 func (r *SessionRepository) DeleteSession(ctx context.Context, sessionToken string) error {
 	query := `DELETE FROM user_sessions WHERE session_token = $1`
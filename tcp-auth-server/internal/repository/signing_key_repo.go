@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/pkg/postgres"
+	"tcp-auth-server/pkg/token"
+)
+
+// SigningKeyRepository stores token signing keys in PostgreSQL so that
+// all replicas share them. It implements token.KeyStore.
+type SigningKeyRepository struct {
+	pool *postgres.Client
+}
+
+// NewSigningKeyRepository creates a new signing key repository
+func NewSigningKeyRepository(pool *postgres.Client) *SigningKeyRepository {
+	return &SigningKeyRepository{
+		pool: pool,
+	}
+}
+
+// LoadKeys returns every stored signing key
+func (r *SigningKeyRepository) LoadKeys(ctx context.Context) ([]*token.Key, error) {
+	query := `
+		SELECT kid, algorithm, state, private_key, created_at, activated_at, retired_at
+		FROM signing_keys
+		ORDER BY created_at
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*token.Key
+	for rows.Next() {
+		var key token.Key
+		var privateKey string
+		var activatedAt, retiredAt *time.Time
+		if err := rows.Scan(
+			&key.ID,
+			&key.Algorithm,
+			&key.State,
+			&privateKey,
+			&key.CreatedAt,
+			&activatedAt,
+			&retiredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+
+		signer, err := token.ParsePrivateKeyPEM([]byte(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %s: %w", key.ID, err)
+		}
+		key.PrivateKey = signer
+		if activatedAt != nil {
+			key.ActivatedAt = *activatedAt
+		}
+		if retiredAt != nil {
+			key.RetiredAt = *retiredAt
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// SaveKey inserts a signing key or updates its state
+func (r *SigningKeyRepository) SaveKey(ctx context.Context, key *token.Key) error {
+	privateKey, err := token.MarshalPrivateKeyPEM(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO signing_keys (kid, algorithm, state, private_key, created_at, activated_at, retired_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (kid) DO UPDATE
+		SET state = EXCLUDED.state,
+			activated_at = EXCLUDED.activated_at,
+			retired_at = EXCLUDED.retired_at
+	`
+
+	_, err = r.pool.Pool().Exec(ctx, query,
+		key.ID, key.Algorithm, key.State, string(privateKey),
+		key.CreatedAt, nullTime(key.ActivatedAt), nullTime(key.RetiredAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save signing key: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteKey removes a signing key
+func (r *SigningKeyRepository) DeleteKey(ctx context.Context, kid string) error {
+	query := `DELETE FROM signing_keys WHERE kid = $1`
+
+	_, err := r.pool.Pool().Exec(ctx, query, kid)
+	if err != nil {
+		return fmt.Errorf("failed to delete signing key: %w", err)
+	}
+
+	return nil
+}
+
+// nullString maps the empty string to NULL
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
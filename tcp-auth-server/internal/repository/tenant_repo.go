@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TenantRepository handles tenants in PostgreSQL
+type TenantRepository struct {
+	pool *postgres.Client
+}
+
+// NewTenantRepository creates a new tenant repository
+func NewTenantRepository(pool *postgres.Client) *TenantRepository {
+	return &TenantRepository{
+		pool: pool,
+	}
+}
+
+// tenantColumns are the columns read by scanTenant
+const tenantColumns = `id, name, COALESCE(session_max_lifetime, 0), COALESCE(session_idle_timeout, 0),
+	password_policy, created_at, updated_at`
+
+// CreateTenant stores a new tenant
+func (r *TenantRepository) CreateTenant(ctx context.Context, tenant *models.Tenant) error {
+	policy, err := encodePasswordPolicy(tenant.PasswordPolicy)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO tenants (id, name, session_max_lifetime, session_idle_timeout, password_policy, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err = r.pool.Pool().Exec(ctx, query,
+		tenant.ID, tenant.Name,
+		int64(tenant.SessionMaxLifetime/time.Second), int64(tenant.SessionIdleTimeout/time.Second),
+		policy, tenant.CreatedAt, tenant.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create tenant: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTenant stores a tenant's name and configuration
+func (r *TenantRepository) UpdateTenant(ctx context.Context, tenant *models.Tenant) error {
+	policy, err := encodePasswordPolicy(tenant.PasswordPolicy)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE tenants
+		SET name = $2, session_max_lifetime = $3, session_idle_timeout = $4, password_policy = $5, updated_at = $6
+		WHERE id = $1
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query,
+		tenant.ID, tenant.Name,
+		int64(tenant.SessionMaxLifetime/time.Second), int64(tenant.SessionIdleTimeout/time.Second),
+		policy, tenant.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update tenant: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("tenant not found")
+	}
+
+	return nil
+}
+
+// GetTenant retrieves a tenant by ID
+func (r *TenantRepository) GetTenant(ctx context.Context, id string) (*models.Tenant, error) {
+	query := `SELECT ` + tenantColumns + ` FROM tenants WHERE id = $1`
+
+	tenant, err := scanTenant(r.pool.Pool().QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("tenant not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	return tenant, nil
+}
+
+// ListTenants returns every tenant by ID
+func (r *TenantRepository) ListTenants(ctx context.Context) ([]*models.Tenant, error) {
+	query := `SELECT ` + tenantColumns + ` FROM tenants ORDER BY id`
+
+	rows, err := r.pool.Pool().Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	defer rows.Close()
+
+	var tenants []*models.Tenant
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	return tenants, nil
+}
+
+// scanTenant reads a row selected with tenantColumns
+func scanTenant(row pgx.Row) (*models.Tenant, error) {
+	var tenant models.Tenant
+	var maxLifetime, idleTimeout int64
+	var policy []byte
+	if err := row.Scan(
+		&tenant.ID,
+		&tenant.Name,
+		&maxLifetime,
+		&idleTimeout,
+		&policy,
+		&tenant.CreatedAt,
+		&tenant.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	tenant.SessionMaxLifetime = time.Duration(maxLifetime) * time.Second
+	tenant.SessionIdleTimeout = time.Duration(idleTimeout) * time.Second
+	if len(policy) > 0 {
+		tenant.PasswordPolicy = &models.TenantPasswordPolicy{}
+		if err := json.Unmarshal(policy, tenant.PasswordPolicy); err != nil {
+			return nil, fmt.Errorf("failed to decode password policy of tenant %s: %w", tenant.ID, err)
+		}
+	}
+	return &tenant, nil
+}
+
+// encodePasswordPolicy encodes a tenant's password policy overrides, or
+// NULL when there are none
+func encodePasswordPolicy(policy *models.TenantPasswordPolicy) ([]byte, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode password policy: %w", err)
+	}
+	return data, nil
+}
@@ -2,16 +2,22 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/identity"
 	"tcp-auth-server/pkg/postgres"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
 
+// ErrUserNotFound is returned when no user matches
+var ErrUserNotFound = errors.New("user not found")
+
 // UserRepository handles user data operations
 type UserRepository struct {
 	pool *postgres.Client
@@ -24,22 +30,25 @@ func NewUserRepository(pool *postgres.Client) *UserRepository {
 	}
 }
 
-// CreateUser creates a new user
-func (r *UserRepository) CreateUser(ctx context.Context, username, email, passwordHash string) (*models.User, error) {
+// CreateUser creates a new user in a tenant
+func (r *UserRepository) CreateUser(ctx context.Context, tenantID, username, email, passwordHash string) (*models.User, error) {
 	userID := uuid.New().String()
 	now := time.Now()
 
 	query := `
-		INSERT INTO users (id, username, email, password_hash, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, username, email, created_at, updated_at
+		INSERT INTO users (id, tenant_id, username, email, username_canonical, email_canonical, password_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, tenant_id, username, email, created_at, updated_at
 	`
 
 	var user models.User
 	err := r.pool.Pool().QueryRow(ctx, query,
-		userID, username, email, passwordHash, now, now,
+		userID, tenantID, username, email,
+		identity.CanonicalUsername(username), identity.CanonicalEmail(email),
+		passwordHash, now, now,
 	).Scan(
 		&user.ID,
+		&user.TenantID,
 		&user.Username,
 		&user.Email,
 		&user.CreatedAt,
@@ -53,58 +62,83 @@ func (r *UserRepository) CreateUser(ctx context.Context, username, email, passwo
 	return &user, nil
 }
 
-// GetUserByUsername retrieves a user by username
-func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+// GetUserByUsername retrieves a user of a tenant by username. The match is
+// made on the canonical form; rows not yet backfilled by the normalization
+// migration still match exactly.
+func (r *UserRepository) GetUserByUsername(ctx context.Context, tenantID, username string) (*models.User, error) {
 	query := `
-		SELECT id, username, email, password_hash, created_at, updated_at
+		SELECT id, tenant_id, username, email, password_hash, COALESCE(max_sessions, 0), COALESCE(auth_backend, ''), status, COALESCE(status_reason, ''), status_expires_at, created_at, updated_at
 		FROM users
-		WHERE username = $1
+		WHERE tenant_id = $1
+		  AND (username_canonical = $2 OR (username_canonical IS NULL AND username = $3))
 	`
 
 	var user models.User
-	err := r.pool.Pool().QueryRow(ctx, query, username).Scan(
+	var statusExpiresAt *time.Time
+	err := r.pool.Pool().QueryRow(ctx, query, tenantID, identity.CanonicalUsername(username), username).Scan(
 		&user.ID,
+		&user.TenantID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.MaxSessions,
+		&user.Backend,
+		&user.Status,
+		&user.StatusReason,
+		&statusExpiresAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 
 	if err == pgx.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	if statusExpiresAt != nil {
+		user.StatusExpiresAt = *statusExpiresAt
+	}
 
 	return &user, nil
 }
 
-// GetUserByEmail retrieves a user by email
-func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+// GetUserByEmail retrieves a user of a tenant by email, matching on the
+// canonical form like GetUserByUsername
+func (r *UserRepository) GetUserByEmail(ctx context.Context, tenantID, email string) (*models.User, error) {
 	query := `
-		SELECT id, username, email, password_hash, created_at, updated_at
+		SELECT id, tenant_id, username, email, password_hash, COALESCE(max_sessions, 0), COALESCE(auth_backend, ''), status, COALESCE(status_reason, ''), status_expires_at, created_at, updated_at
 		FROM users
-		WHERE email = $1
+		WHERE tenant_id = $1
+		  AND (email_canonical = $2 OR (email_canonical IS NULL AND email = $3))
 	`
 
 	var user models.User
-	err := r.pool.Pool().QueryRow(ctx, query, email).Scan(
+	var statusExpiresAt *time.Time
+	err := r.pool.Pool().QueryRow(ctx, query, tenantID, identity.CanonicalEmail(email), email).Scan(
 		&user.ID,
+		&user.TenantID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.MaxSessions,
+		&user.Backend,
+		&user.Status,
+		&user.StatusReason,
+		&statusExpiresAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 
 	if err == pgx.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	if statusExpiresAt != nil {
+		user.StatusExpiresAt = *statusExpiresAt
+	}
 
 	return &user, nil
 }
@@ -112,41 +146,57 @@ func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*mod
 // GetUserByID retrieves a user by ID
 func (r *UserRepository) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
 	query := `
-		SELECT id, username, email, password_hash, created_at, updated_at
+		SELECT id, tenant_id, username, email, password_hash, COALESCE(max_sessions, 0), COALESCE(auth_backend, ''), status, COALESCE(status_reason, ''), status_expires_at, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
 
 	var user models.User
+	var statusExpiresAt *time.Time
 	err := r.pool.Pool().QueryRow(ctx, query, userID).Scan(
 		&user.ID,
+		&user.TenantID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.MaxSessions,
+		&user.Backend,
+		&user.Status,
+		&user.StatusReason,
+		&statusExpiresAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 
 	if err == pgx.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	if statusExpiresAt != nil {
+		user.StatusExpiresAt = *statusExpiresAt
+	}
 
 	return &user, nil
 }
 
-// UserExists checks if a username or email already exists
-func (r *UserRepository) UserExists(ctx context.Context, username, email string) (bool, error) {
+// UserExists checks if a username or email already exists in a tenant,
+// comparing canonical forms
+func (r *UserRepository) UserExists(ctx context.Context, tenantID, username, email string) (bool, error) {
 	query := `
 		SELECT COUNT(*) > 0
 		FROM users
-		WHERE username = $1 OR email = $2
+		WHERE tenant_id = $1
+		  AND (username_canonical = $2 OR email_canonical = $3
+		       OR username = $4 OR email = $5)
 	`
 
 	var exists bool
-	err := r.pool.Pool().QueryRow(ctx, query, username, email).Scan(&exists)
+	err := r.pool.Pool().QueryRow(ctx, query,
+		tenantID, identity.CanonicalUsername(username), identity.CanonicalEmail(email),
+		username, email,
+	).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check user existence: %w", err)
 	}
@@ -155,3 +205,401 @@ func (r *UserRepository) UserExists(ctx context.Context, username, email string)
 }
 
 
+
+// CreateBackendUser creates the shadow row of an account owned by a
+// credential backend. It has no password, so it can only log in through
+// the backend.
+func (r *UserRepository) CreateBackendUser(ctx context.Context, tenantID, backend, username, email string) (*models.User, error) {
+	userID := uuid.New().String()
+	now := time.Now()
+
+	query := `
+		INSERT INTO users (id, tenant_id, username, email, username_canonical, email_canonical, password_hash, auth_backend, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, '', $7, $8, $9)
+		RETURNING id, tenant_id, username, email, auth_backend, status, COALESCE(status_reason, ''), status_expires_at, created_at, updated_at
+	`
+
+	var user models.User
+	var statusExpiresAt *time.Time
+	err := r.pool.Pool().QueryRow(ctx, query,
+		userID, tenantID, username, email,
+		identity.CanonicalUsername(username), identity.CanonicalEmail(email),
+		backend, now, now,
+	).Scan(
+		&user.ID,
+		&user.TenantID,
+		&user.Username,
+		&user.Email,
+		&user.Backend,
+		&user.Status,
+		&user.StatusReason,
+		&statusExpiresAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	if statusExpiresAt != nil {
+		user.StatusExpiresAt = *statusExpiresAt
+	}
+
+	return &user, nil
+}
+
+// UpdateEmail replaces a user's email address
+func (r *UserRepository) UpdateEmail(ctx context.Context, userID, email string) error {
+	query := `
+		UPDATE users
+		SET email = $2, email_canonical = $3, updated_at = $4
+		WHERE id = $1
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, userID, email, identity.CanonicalEmail(email), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdatePasswordHash replaces a user's password hash
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, userID, passwordHash string) error {
+	query := `
+		UPDATE users
+		SET password_hash = $2, updated_at = $3
+		WHERE id = $1
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, userID, passwordHash, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// ListIdentities returns the ID, tenant, username and email of every user,
+// oldest first
+func (r *UserRepository) ListIdentities(ctx context.Context) ([]*models.User, error) {
+	query := `
+		SELECT id, tenant_id, username, email, created_at, updated_at
+		FROM users
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(
+			&user.ID,
+			&user.TenantID,
+			&user.Username,
+			&user.Email,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, nil
+}
+
+// SetCanonicalIdentity stores the canonical username and email of a user
+func (r *UserRepository) SetCanonicalIdentity(ctx context.Context, userID, usernameCanonical, emailCanonical string) error {
+	query := `
+		UPDATE users
+		SET username_canonical = $2, email_canonical = $3
+		WHERE id = $1
+	`
+
+	_, err := r.pool.Pool().Exec(ctx, query, userID, usernameCanonical, emailCanonical)
+	if err != nil {
+		return fmt.Errorf("failed to set canonical identity: %w", err)
+	}
+
+	return nil
+}
+
+// ListUsers returns a page of a tenant's users, oldest first, and the
+// number of users matching. A non-empty search matches the ID exactly or
+// a substring of the username or email address, ignoring case.
+func (r *UserRepository) ListUsers(ctx context.Context, tenantID, search string, limit, offset int) ([]*models.User, int, error) {
+	where := `WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	if search != "" {
+		where += ` AND (id = $2 OR username ILIKE $3 OR email ILIKE $3)`
+		args = append(args, search, "%"+escapeLike(search)+"%")
+	}
+
+	var total int
+	if err := r.pool.Pool().QueryRow(ctx, `SELECT COUNT(*) FROM users `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, tenant_id, username, email, COALESCE(max_sessions, 0), COALESCE(auth_backend, ''), status, COALESCE(status_reason, ''), status_expires_at, created_at, updated_at
+		FROM users
+		%s
+		ORDER BY created_at, id
+		LIMIT %d OFFSET %d
+	`, where, limit, offset)
+
+	rows, err := r.pool.Pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		var statusExpiresAt *time.Time
+		if err := rows.Scan(
+			&user.ID,
+			&user.TenantID,
+			&user.Username,
+			&user.Email,
+			&user.MaxSessions,
+			&user.Backend,
+			&user.Status,
+			&user.StatusReason,
+			&statusExpiresAt,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if statusExpiresAt != nil {
+			user.StatusExpiresAt = *statusExpiresAt
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// SetStatus changes a user's account status with the reason for it and
+// when it lapses, if ever
+func (r *UserRepository) SetStatus(ctx context.Context, userID, status, reason string, expiresAt time.Time) error {
+	query := `
+		UPDATE users
+		SET status = $2, status_reason = NULLIF($3, ''), status_expires_at = $4, updated_at = $5
+		WHERE id = $1
+	`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, userID, status, reason, nullTime(expiresAt), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update user status: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// ListDueDeletions returns up to limit users whose scheduled deletion is
+// due at now
+func (r *UserRepository) ListDueDeletions(ctx context.Context, now time.Time, limit int) ([]*models.User, error) {
+	query := `
+		SELECT id, tenant_id, username, email
+		FROM users
+		WHERE status = $1 AND status_expires_at <= $2
+		ORDER BY status_expires_at
+		LIMIT $3
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query, models.UserStatusPendingDeletion, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.TenantID, &user.Username, &user.Email); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list due deletions: %w", err)
+	}
+
+	return users, nil
+}
+
+// DeletePendingUser deletes a user whose scheduled deletion is due. It
+// reports false when the deletion was cancelled or done already.
+func (r *UserRepository) DeletePendingUser(ctx context.Context, userID string, now time.Time) (bool, error) {
+	query := `DELETE FROM users WHERE id = $1 AND status = $2 AND status_expires_at <= $3`
+
+	tag, err := r.pool.Pool().Exec(ctx, query, userID, models.UserStatusPendingDeletion, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// AnonymizePendingUser replaces the personal data of a user whose
+// scheduled deletion is due and removes their credentials, roles and
+// sessions, keeping only a disabled row for whatever still refers to it.
+// It reports false when the deletion was cancelled or done already.
+func (r *UserRepository) AnonymizePendingUser(ctx context.Context, userID string, now time.Time) (bool, error) {
+	// A single statement, so a failure leaves the user untouched
+	query := `
+		WITH anonymized AS (
+			UPDATE users
+			SET username = 'deleted-' || id, email = 'deleted-' || id || '@invalid',
+				username_canonical = 'deleted-' || id, email_canonical = 'deleted-' || id || '@invalid',
+				password_hash = '', auth_backend = NULL, max_sessions = NULL,
+				status = $4, status_reason = 'account deleted', status_expires_at = NULL, updated_at = $3
+			WHERE id = $1 AND status = $2 AND status_expires_at <= $3
+			RETURNING id
+		),
+		deleted_roles AS (DELETE FROM user_roles WHERE user_id IN (SELECT id FROM anonymized)),
+		deleted_keys AS (DELETE FROM api_keys WHERE user_id IN (SELECT id FROM anonymized)),
+		deleted_identities AS (DELETE FROM federated_identities WHERE user_id IN (SELECT id FROM anonymized)),
+		deleted_logins AS (DELETE FROM persistent_logins WHERE user_id IN (SELECT id FROM anonymized)),
+		deleted_refresh AS (DELETE FROM refresh_tokens WHERE user_id IN (SELECT id FROM anonymized)),
+		deleted_codes AS (DELETE FROM oauth_authorization_codes WHERE user_id IN (SELECT id FROM anonymized)),
+		deleted_sessions AS (DELETE FROM user_sessions WHERE user_id IN (SELECT id FROM anonymized))
+		SELECT COUNT(*) FROM anonymized
+	`
+
+	var count int
+	err := r.pool.Pool().QueryRow(ctx, query,
+		userID, models.UserStatusPendingDeletion, now, models.UserStatusDisabled,
+	).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to anonymize user: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteUser deletes a user. Rows referring to the user, such as roles,
+// refresh tokens and API keys, are deleted with it.
+func (r *UserRepository) DeleteUser(ctx context.Context, userID string) error {
+	tag, err := r.pool.Pool().Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// ImportUsers inserts local password users in one COPY and returns how
+// many were inserted. IDs are assigned to users without one, and users
+// without a creation time are created now. The rows are inserted all or
+// none, so a duplicate identifier fails the whole call.
+func (r *UserRepository) ImportUsers(ctx context.Context, users []*models.User) (int64, error) {
+	now := time.Now()
+	rows := make([][]interface{}, 0, len(users))
+	for _, user := range users {
+		if user.ID == "" {
+			user.ID = uuid.New().String()
+		}
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = now
+		}
+		user.UpdatedAt = now
+		user.Status = models.UserStatusActive
+
+		rows = append(rows, []interface{}{
+			user.ID, user.TenantID, user.Username, user.Email,
+			identity.CanonicalUsername(user.Username), identity.CanonicalEmail(user.Email),
+			user.PasswordHash, user.Status, user.CreatedAt, user.UpdatedAt,
+		})
+	}
+
+	count, err := r.pool.Pool().CopyFrom(ctx,
+		pgx.Identifier{"users"},
+		[]string{"id", "tenant_id", "username", "email", "username_canonical", "email_canonical", "password_hash", "status", "created_at", "updated_at"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to import users: %w", err)
+	}
+
+	return count, nil
+}
+
+// ExportUsers returns the users of a tenant, or of every tenant when
+// tenantID is empty, with their password hashes, ordered by tenant and
+// then oldest first
+func (r *UserRepository) ExportUsers(ctx context.Context, tenantID string) ([]*models.User, error) {
+	query := `
+		SELECT id, tenant_id, username, email, password_hash, COALESCE(auth_backend, ''),
+		       status, created_at, updated_at
+		FROM users
+		WHERE $1 = '' OR tenant_id = $1
+		ORDER BY tenant_id, created_at, id
+	`
+
+	rows, err := r.pool.Pool().Query(ctx, query, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(
+			&user.ID,
+			&user.TenantID,
+			&user.Username,
+			&user.Email,
+			&user.PasswordHash,
+			&user.Backend,
+			&user.Status,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to export users: %w", err)
+	}
+
+	return users, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+)
+
+// ScopeManageAccount lets a scoped token export the user's data and
+// request the account's deletion
+const ScopeManageAccount = "account:manage"
+
+// Account deletion modes
+const (
+	// DeletionModeDelete removes the user row and everything referring to it
+	DeletionModeDelete = "delete"
+	// DeletionModeAnonymize keeps a disabled row without personal data for
+	// records that still refer to the user
+	DeletionModeAnonymize = "anonymize"
+)
+
+// reauthWindow is how recently a user without a password must have logged
+// in to confirm a deletion
+const reauthWindow = 5 * time.Minute
+
+// deletionBatchSize bounds the accounts purged per run of the deletion job
+const deletionBatchSize = 100
+
+// DataExport is everything stored about a user
+type DataExport struct {
+	User        *models.User
+	Sessions    []*models.Session
+	APIKeys     []*models.APIKey
+	Identities  []*models.FederatedIdentity
+	AuditEvents []*models.AuditEvent
+	ExportedAt  time.Time
+}
+
+// AccountService lets users export their data and delete their account.
+// A deletion takes effect after a grace period, during which the account
+// cannot be used and the user can cancel it; a background job then deletes
+// or anonymizes the account.
+type AccountService struct {
+	userRepo     UserStore
+	roleRepo     RoleStore
+	sessionRepo  SessionStore
+	identityRepo *repository.FederatedIdentityRepository
+	auditRepo    AuditStore
+	authService  *AuthService
+	userAdmin    *UserAdminService
+	auditService *AuditService
+
+	gracePeriod  time.Duration
+	deletionMode string
+}
+
+// NewAccountService creates a new account service. Deletions take effect
+// gracePeriod after they are requested, in deletionMode.
+func NewAccountService(
+	userRepo UserStore,
+	roleRepo RoleStore,
+	sessionRepo SessionStore,
+	identityRepo *repository.FederatedIdentityRepository,
+	auditRepo AuditStore,
+	authService *AuthService,
+	userAdmin *UserAdminService,
+	auditService *AuditService,
+	gracePeriod time.Duration,
+	deletionMode string,
+) *AccountService {
+	return &AccountService{
+		userRepo:     userRepo,
+		roleRepo:     roleRepo,
+		sessionRepo:  sessionRepo,
+		identityRepo: identityRepo,
+		auditRepo:    auditRepo,
+		authService:  authService,
+		userAdmin:    userAdmin,
+		auditService: auditService,
+		gracePeriod:  gracePeriod,
+		deletionMode: deletionMode,
+	}
+}
+
+// ExportData collects the profile, roles, recorded sessions, API keys,
+// linked identities and audit events of the user owning token
+func (s *AccountService) ExportData(ctx context.Context, token string) (*DataExport, error) {
+	current, err := s.authService.ManagingSession(ctx, token, ScopeManageAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		return nil, err
+	}
+	user.Roles, user.Permissions, err = s.roleRepo.GetUserAuthorization(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &DataExport{User: user, ExportedAt: time.Now()}
+	if export.Sessions, err = s.sessionRepo.ListUserSessions(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.APIKeys, err = s.authService.GetAPIKeyService().List(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.Identities, err = s.identityRepo.ListIdentities(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.AuditEvents, err = s.auditRepo.ListUserEvents(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventDataExported,
+		UserID:    user.ID,
+		IPAddress: current.ClientIP,
+	})
+	return export, nil
+}
+
+// RequestDeletion schedules the deletion of the account owning token after
+// the password is confirmed, ends all of its sessions and returns when the
+// deletion takes effect. Users without a password confirm by having logged
+// in within the last few minutes.
+func (s *AccountService) RequestDeletion(ctx context.Context, token, password, clientIP string) (time.Time, error) {
+	current, err := s.authService.ManagingSession(ctx, token, ScopeManageAccount)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := s.reauthenticate(ctx, current, user, password); err != nil {
+		return time.Time{}, err
+	}
+
+	deleteAt := time.Now().Add(s.gracePeriod)
+	if err := s.userRepo.SetStatus(ctx, user.ID, models.UserStatusPendingDeletion, "deletion requested by the user", deleteAt); err != nil {
+		return time.Time{}, err
+	}
+	ended := s.userAdmin.endAllSessions(ctx, user.ID)
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventAccountDeletionRequested,
+		UserID:    user.ID,
+		IPAddress: clientIP,
+		Details: map[string]interface{}{
+			"delete_at":      deleteAt.Unix(),
+			"sessions_ended": ended,
+		},
+	})
+	return deleteAt, nil
+}
+
+// CancelDeletion cancels a scheduled deletion. The account cannot log in
+// while its deletion is pending, so the user proves ownership with their
+// credentials instead of a session.
+func (s *AccountService) CancelDeletion(ctx context.Context, identifier, password, clientIP string) error {
+	user, err := s.authService.Authenticate(ctx, identifier, password)
+	if err != nil {
+		return err
+	}
+	if user.Status != models.UserStatusPendingDeletion {
+		return fmt.Errorf("no account deletion is scheduled")
+	}
+
+	if err := s.userRepo.SetStatus(ctx, user.ID, models.UserStatusActive, "", time.Time{}); err != nil {
+		return err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventAccountDeletionCancelled,
+		UserID:    user.ID,
+		IPAddress: clientIP,
+	})
+	return nil
+}
+
+// reauthenticate confirms that the user behind a session is present. The
+// password is checked against whichever backend owns the account.
+func (s *AccountService) reauthenticate(ctx context.Context, current *models.Session, user *models.User, password string) error {
+	if user.PasswordHash == "" && user.Backend == "" {
+		if time.Since(current.CreatedAt) > reauthWindow {
+			return fmt.Errorf("log in again to confirm")
+		}
+		return nil
+	}
+
+	if password == "" {
+		return fmt.Errorf("password is required")
+	}
+	verified, err := s.authService.Authenticate(ctx, user.Username, password)
+	if err != nil || verified.ID != user.ID {
+		return fmt.Errorf("invalid password")
+	}
+	return nil
+}
+
+// RunDeletions purges accounts whose deletion is due every interval until
+// ctx is cancelled. Every replica may run it; each account is purged once.
+func (s *AccountService) RunDeletions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.purgeDue(ctx); err != nil {
+				log.Printf("Error purging deleted accounts: %v", err)
+			}
+		}
+	}
+}
+
+// purgeDue deletes or anonymizes the accounts whose grace period is over
+func (s *AccountService) purgeDue(ctx context.Context) error {
+	now := time.Now()
+	users, err := s.userRepo.ListDueDeletions(ctx, now, deletionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		// Sessions live in Redis as well, which the database cannot clean
+		s.userAdmin.endAllSessions(ctx, user.ID)
+
+		var purged bool
+		if s.deletionMode == DeletionModeAnonymize {
+			purged, err = s.userRepo.AnonymizePendingUser(ctx, user.ID, now)
+		} else {
+			purged, err = s.userRepo.DeletePendingUser(ctx, user.ID, now)
+		}
+		if err != nil {
+			fmt.Printf("Warning: failed to purge account %s: %v\n", user.ID, err)
+			continue
+		}
+		if !purged {
+			// Cancelled meanwhile, or purged by another replica
+			continue
+		}
+
+		s.auditService.Record(ctx, &models.AuditEvent{
+			EventType: models.EventAccountDeleted,
+			UserID:    user.ID,
+			Details: map[string]interface{}{
+				"tenant": user.TenantID,
+				"mode":   s.deletionMode,
+			},
+		})
+	}
+
+	return nil
+}
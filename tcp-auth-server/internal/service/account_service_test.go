@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+// requestDeletion schedules the deletion of a user with the password
+// "secret" and returns the sessions it ended
+func requestDeletion(t *testing.T, ts *testServices, user *models.User) []*models.Session {
+	t.Helper()
+	ts.setPassword(t, user, "secret")
+	sessions := []*models.Session{signedIn(t, ts, user), signedIn(t, ts, user)}
+	if _, err := ts.accountService.RequestDeletion(context.Background(), sessions[0].Token, "secret", ""); err != nil {
+		t.Fatalf("RequestDeletion: %v", err)
+	}
+	return sessions
+}
+
+// makeDeletionDue ends the grace period of a user's scheduled deletion
+func makeDeletionDue(ts *testServices, user *models.User) {
+	ts.users.mu.Lock()
+	defer ts.users.mu.Unlock()
+	ts.users.users[user.ID].StatusExpiresAt = time.Now().Add(-time.Minute)
+}
+
+func TestRequestDeletion(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	ts.setPassword(t, user, "secret")
+	sessions := []*models.Session{signedIn(t, ts, user), signedIn(t, ts, user)}
+
+	for _, wrong := range []string{"", "guess"} {
+		if _, err := ts.accountService.RequestDeletion(ctx, sessions[0].Token, wrong, ""); err == nil {
+			t.Errorf("RequestDeletion with password %q succeeded", wrong)
+		}
+	}
+	if !ts.sessionAlive(sessions[0].Token) {
+		t.Fatal("a refused request ended the session")
+	}
+
+	deleteAt, err := ts.accountService.RequestDeletion(ctx, sessions[0].Token, "secret", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("RequestDeletion: %v", err)
+	}
+	if until := time.Until(deleteAt); until < 23*time.Hour || until > 24*time.Hour {
+		t.Errorf("deletion takes effect in %v, want the grace period of 24h", until)
+	}
+	assertSignedOut(t, ts, user, sessions...)
+	if got := ts.users.users[user.ID]; got.Status != models.UserStatusPendingDeletion || !got.StatusExpiresAt.Equal(deleteAt) {
+		t.Errorf("user status = %s until %v, want %s until %v", got.Status, got.StatusExpiresAt, models.UserStatusPendingDeletion, deleteAt)
+	}
+	if event := lastEvent(t, ts, user.ID); event.EventType != models.EventAccountDeletionRequested || event.Details["sessions_ended"] != 2 {
+		t.Errorf("audit event = %+v, want %s ending 2 sessions", event, models.EventAccountDeletionRequested)
+	}
+
+	// The account cannot log in during the grace period
+	if _, err := ts.sessionService.CreateSession(ctx, ts.users.users[user.ID], SessionOptions{}); !errors.Is(err, ErrAccountPendingDeletion) {
+		t.Errorf("CreateSession during the grace period = %v, want ErrAccountPendingDeletion", err)
+	}
+}
+
+func TestRequestDeletionWithoutPassword(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+
+	// Without a password a recent login confirms the request
+	stale := &models.Session{UserID: user.ID, CreatedAt: time.Now().Add(-time.Hour)}
+	if err := ts.accountService.reauthenticate(ctx, stale, user, ""); err == nil {
+		t.Error("a login an hour old confirmed the deletion")
+	}
+
+	session := signedIn(t, ts, user)
+	if _, err := ts.accountService.RequestDeletion(ctx, session.Token, "", ""); err != nil {
+		t.Fatalf("RequestDeletion after a fresh login: %v", err)
+	}
+	if got := ts.users.users[user.ID].Status; got != models.UserStatusPendingDeletion {
+		t.Errorf("user status = %s, want %s", got, models.UserStatusPendingDeletion)
+	}
+}
+
+func TestCancelDeletion(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	requestDeletion(t, ts, user)
+
+	if err := ts.accountService.CancelDeletion(ctx, "alice", "guess", ""); err == nil {
+		t.Error("CancelDeletion with a wrong password succeeded")
+	}
+	if err := ts.accountService.CancelDeletion(ctx, "alice", "secret", "127.0.0.1"); err != nil {
+		t.Fatalf("CancelDeletion: %v", err)
+	}
+	if got := ts.users.users[user.ID]; got.Status != models.UserStatusActive || !got.StatusExpiresAt.IsZero() {
+		t.Errorf("user status = %s until %v, want active", got.Status, got.StatusExpiresAt)
+	}
+	if event := lastEvent(t, ts, user.ID); event.EventType != models.EventAccountDeletionCancelled {
+		t.Errorf("audit event = %+v, want %s", event, models.EventAccountDeletionCancelled)
+	}
+	if err := ts.accountService.CancelDeletion(ctx, "alice", "secret", ""); err == nil {
+		t.Error("CancelDeletion of an active account succeeded")
+	}
+
+	// A cancelled deletion is never carried out
+	if err := ts.accountService.purgeDue(ctx); err != nil {
+		t.Fatalf("purgeDue: %v", err)
+	}
+	if _, ok := ts.users.users[user.ID]; !ok {
+		t.Error("the user of a cancelled deletion was deleted")
+	}
+}
+
+func TestPurgeDueDeletions(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	waiting := ts.addUser("bob")
+	requestDeletion(t, ts, user)
+	requestDeletion(t, ts, waiting)
+	makeDeletionDue(ts, user)
+
+	// A session created behind the service's back, as a replica racing the
+	// request could
+	ts.users.users[user.ID].Status = models.UserStatusActive
+	late := signedIn(t, ts, user)
+	ts.users.users[user.ID].Status = models.UserStatusPendingDeletion
+
+	if err := ts.accountService.purgeDue(ctx); err != nil {
+		t.Fatalf("purgeDue: %v", err)
+	}
+	if _, ok := ts.users.users[user.ID]; ok {
+		t.Error("the user is still stored after the grace period")
+	}
+	assertSignedOut(t, ts, user, late)
+	if _, ok := ts.users.users[waiting.ID]; !ok {
+		t.Error("a user was deleted before the grace period ended")
+	}
+
+	event := lastEvent(t, ts, user.ID)
+	if event.EventType != models.EventAccountDeleted || event.Details["mode"] != DeletionModeDelete {
+		t.Errorf("audit event = %+v, want %s in %s mode", event, models.EventAccountDeleted, DeletionModeDelete)
+	}
+}
+
+func TestPurgeDueDeletionsAnonymize(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	ts.accountService.deletionMode = DeletionModeAnonymize
+	user := ts.addUser("alice")
+	requestDeletion(t, ts, user)
+	makeDeletionDue(ts, user)
+
+	if err := ts.accountService.purgeDue(ctx); err != nil {
+		t.Fatalf("purgeDue: %v", err)
+	}
+	got, ok := ts.users.users[user.ID]
+	if !ok {
+		t.Fatal("the anonymized user was deleted")
+	}
+	if got.Username == "alice" || got.Email == user.Email || got.PasswordHash != "" || got.Status != models.UserStatusDisabled {
+		t.Errorf("anonymized user = %+v, want a disabled user without personal data", got)
+	}
+	if _, err := ts.authService.Authenticate(ctx, "alice", "secret"); err == nil {
+		t.Error("the anonymized account can still log in")
+	}
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// apiKeyPrefix marks a token as an API key
+const apiKeyPrefix = "ak_"
+
+// apiKeyTouchInterval limits how often last-used times are written
+const apiKeyTouchInterval = time.Minute
+
+// ScopeManageAPIKeys lets a scoped token create, list and revoke API keys
+const ScopeManageAPIKeys = "api_keys:manage"
+
+// ErrInvalidAPIKey is returned for unknown, malformed, revoked or expired
+// API keys
+var ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+// IssuedAPIKey is a new API key with its secret, which is only ever shown
+// once
+type IssuedAPIKey struct {
+	Key    string
+	APIKey *models.APIKey
+}
+
+// APIKeyService manages long-lived API keys, owned either by a user or by
+// a service account.
+//
+// A key looks like ak_<prefix>_<secret>. The prefix is stored in the clear
+// so keys can be told apart in listings and looked up; only a hash of the
+// secret is stored.
+type APIKeyService struct {
+	apiKeyRepo      *repository.APIKeyRepository
+	userRepo        UserStore
+	roleRepo        RoleStore
+	serviceAccounts *ServiceAccountService
+	sessionService  *SessionService
+	auditService    *AuditService
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(
+	apiKeyRepo *repository.APIKeyRepository,
+	userRepo UserStore,
+	roleRepo RoleStore,
+	serviceAccounts *ServiceAccountService,
+	sessionService *SessionService,
+	auditService *AuditService,
+) *APIKeyService {
+	return &APIKeyService{
+		apiKeyRepo:      apiKeyRepo,
+		userRepo:        userRepo,
+		roleRepo:        roleRepo,
+		serviceAccounts: serviceAccounts,
+		sessionService:  sessionService,
+		auditService:    auditService,
+	}
+}
+
+// Create issues an API key owned by the user of session. The key's scopes
+// must be covered by the session's scopes; a key without scopes carries the
+// user's full authority. A zero ttl creates a key that does not expire.
+func (s *APIKeyService) Create(ctx context.Context, session *models.Session, name string, scopes []string, ttl time.Duration) (*IssuedAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if err := models.ValidateScopes(scopes); err != nil {
+		return nil, err
+	}
+	if len(session.Scopes) > 0 && len(scopes) == 0 {
+		scopes = session.Scopes
+	}
+	if !models.CoversScopes(session.Scopes, scopes) {
+		return nil, fmt.Errorf("requested scopes exceed the token's scopes")
+	}
+
+	issued, err := s.issue(ctx, &models.APIKey{
+		UserID: session.UserID,
+		Name:   name,
+		Scopes: scopes,
+	}, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventAPIKeyCreated,
+		UserID:    session.UserID,
+		ActorID:   session.UserID,
+		IPAddress: session.ClientIP,
+		Details:   apiKeyDetails(issued.APIKey),
+	})
+
+	return issued, nil
+}
+
+// CreateForServiceAccount issues an API key owned by a service account on
+// behalf of actorID. A key without scopes carries the account's full
+// authority.
+func (s *APIKeyService) CreateForServiceAccount(ctx context.Context, actorID, serviceAccountID, name string, scopes []string, ttl time.Duration) (*IssuedAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if err := models.ValidateScopes(scopes); err != nil {
+		return nil, err
+	}
+	if _, err := s.serviceAccounts.Get(ctx, serviceAccountID); err != nil {
+		return nil, err
+	}
+
+	issued, err := s.issue(ctx, &models.APIKey{
+		ServiceAccountID: serviceAccountID,
+		Name:             name,
+		Scopes:           scopes,
+	}, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType:     models.EventAPIKeyCreated,
+		UserID:        serviceAccountID,
+		ActorID:       actorID,
+		PrincipalType: models.PrincipalServiceAccount,
+		Details:       apiKeyDetails(issued.APIKey),
+	})
+
+	return issued, nil
+}
+
+// issue generates the prefix and secret of a new key and stores it
+func (s *APIKeyService) issue(ctx context.Context, key *models.APIKey, ttl time.Duration) (*IssuedAPIKey, error) {
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret, err := s.sessionService.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	key.ID = uuid.New().String()
+	key.Prefix = apiKeyPrefix + hex.EncodeToString(prefixBytes)
+	key.SecretHash = hashToken(secret)
+	key.CreatedAt = now
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+
+	if err := s.apiKeyRepo.CreateAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return &IssuedAPIKey{
+		Key:    key.Prefix + "_" + secret,
+		APIKey: key,
+	}, nil
+}
+
+// List returns a user's API keys
+func (s *APIKeyService) List(ctx context.Context, userID string) ([]*models.APIKey, error) {
+	return s.apiKeyRepo.ListUserAPIKeys(ctx, userID)
+}
+
+// ListForServiceAccount returns a service account's API keys
+func (s *APIKeyService) ListForServiceAccount(ctx context.Context, serviceAccountID string) ([]*models.APIKey, error) {
+	return s.apiKeyRepo.ListServiceAccountAPIKeys(ctx, serviceAccountID)
+}
+
+// RevokeForServiceAccount revokes one of a service account's API keys on
+// behalf of actorID
+func (s *APIKeyService) RevokeForServiceAccount(ctx context.Context, actorID, serviceAccountID, id string) error {
+	if err := s.apiKeyRepo.RevokeServiceAccountAPIKey(ctx, serviceAccountID, id); err != nil {
+		return err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType:     models.EventAPIKeyRevoked,
+		UserID:        serviceAccountID,
+		ActorID:       actorID,
+		PrincipalType: models.PrincipalServiceAccount,
+		Details: map[string]interface{}{
+			"api_key_id": id,
+		},
+	})
+	return nil
+}
+
+// Revoke revokes one of a user's API keys
+func (s *APIKeyService) Revoke(ctx context.Context, session *models.Session, id string) error {
+	if err := s.apiKeyRepo.RevokeAPIKey(ctx, session.UserID, id); err != nil {
+		return err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventAPIKeyRevoked,
+		UserID:    session.UserID,
+		ActorID:   session.UserID,
+		IPAddress: session.ClientIP,
+		Details: map[string]interface{}{
+			"api_key_id": id,
+		},
+	})
+	return nil
+}
+
+// Authenticate verifies an API key and returns its owner, a user or a
+// service account, with current roles and the key's scopes
+func (s *APIKeyService) Authenticate(ctx context.Context, presented string) (*models.Principal, error) {
+	prefix, secret, ok := parseAPIKey(presented)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeyRepo.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if !key.Usable(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	principal, err := s.owner(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	principal.Scopes = key.Scopes
+
+	if now.Sub(key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := s.apiKeyRepo.TouchAPIKey(ctx, key.ID, now); err != nil {
+			fmt.Printf("Warning: failed to record API key usage: %v\n", err)
+		}
+	}
+
+	return principal, nil
+}
+
+// owner loads the principal owning a key
+func (s *APIKeyService) owner(ctx context.Context, key *models.APIKey) (*models.Principal, error) {
+	if key.ServiceAccountID != "" {
+		principal, err := s.serviceAccounts.Principal(ctx, key.ServiceAccountID)
+		if err != nil {
+			return nil, ErrInvalidAPIKey
+		}
+		return principal, nil
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, key.UserID)
+	if err != nil || !user.Active() {
+		return nil, ErrInvalidAPIKey
+	}
+	user.Roles, user.Permissions, err = s.roleRepo.GetUserAuthorization(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return models.UserPrincipal(user), nil
+}
+
+// apiKeyDetails describes a new key for the audit trail
+func apiKeyDetails(key *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"prefix": key.Prefix,
+		"name":   key.Name,
+		"scopes": key.Scopes,
+	}
+}
+
+// IsAPIKey reports whether a token has the form of an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// parseAPIKey splits an API key into its prefix and secret
+func parseAPIKey(presented string) (string, string, bool) {
+	if !IsAPIKey(presented) {
+		return "", "", false
+	}
+	id, secret, ok := strings.Cut(strings.TrimPrefix(presented, apiKeyPrefix), "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+	return apiKeyPrefix + id, secret, true
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+// AuditService records security relevant events
+type AuditService struct {
+	auditRepo AuditStore
+}
+
+// NewAuditService creates a new audit service
+func NewAuditService(auditRepo AuditStore) *AuditService {
+	return &AuditService{
+		auditRepo: auditRepo,
+	}
+}
+
+// Record stores an audit event. Failing to store an event must not fail
+// the action being audited, so errors are logged rather than returned.
+func (s *AuditService) Record(ctx context.Context, event *models.AuditEvent) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	log.Printf("Audit: %s user=%s actor=%s details=%v", event.EventType, event.UserID, event.ActorID, event.Details)
+
+	if err := s.auditRepo.CreateEvent(ctx, event); err != nil {
+		fmt.Printf("Warning: failed to store audit event: %v\n", err)
+	}
+}
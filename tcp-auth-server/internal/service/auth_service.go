@@ -2,18 +2,37 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"tcp-auth-server/internal/models"
-	"tcp-auth-server/internal/repository"
-
-	"golang.org/x/crypto/bcrypt"
+	"tcp-auth-server/pkg/identity"
+	"tcp-auth-server/pkg/password"
+	tokenpkg "tcp-auth-server/pkg/token"
 )
 
+// ScopeManageSessions lets a scoped token list and revoke the user's sessions
+const ScopeManageSessions = "sessions:manage"
+
 // AuthService handles authentication logic
 type AuthService struct {
-	userRepo       *repository.UserRepository
+	userRepo       UserStore
 	sessionService *SessionService
+	passwords      *password.Manager
+	tenants        *TenantService
+	tokenService   *TokenService
+	refreshService *RefreshService
+	rememberMe     *RememberMeService
+	loginCodes     *LoginCodeService
+	roleService    *RoleService
+	apiKeys        *APIKeyService
+
+	serviceAccounts *ServiceAccountService
+
+	// backends are tried in order at login
+	backends []CredentialBackend
 }
 
 // GetSessionService returns the session service (for handlers that need direct access)
@@ -23,47 +42,122 @@ func (s *AuthService) GetSessionService() *SessionService {
 
 // NewAuthService creates a new authentication service
 func NewAuthService(
-	userRepo *repository.UserRepository,
+	userRepo UserStore,
 	sessionService *SessionService,
+	passwords *password.Manager,
+	tenants *TenantService,
+	tokenService *TokenService,
+	refreshService *RefreshService,
+	rememberMe *RememberMeService,
+	loginCodes *LoginCodeService,
+	roleService *RoleService,
+	apiKeys *APIKeyService,
+	serviceAccounts *ServiceAccountService,
+	backends []CredentialBackend,
 ) *AuthService {
 	return &AuthService{
 		userRepo:       userRepo,
 		sessionService: sessionService,
+		passwords:      passwords,
+		tenants:        tenants,
+		tokenService:   tokenService,
+		refreshService: refreshService,
+		rememberMe:     rememberMe,
+		loginCodes:     loginCodes,
+		roleService:    roleService,
+		apiKeys:        apiKeys,
+
+		serviceAccounts: serviceAccounts,
+		backends:        backends,
 	}
 }
 
-// HashPassword hashes a password using bcrypt
-func (s *AuthService) HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
+// GetServiceAccountService returns the service account service
+func (s *AuthService) GetServiceAccountService() *ServiceAccountService {
+	return s.serviceAccounts
+}
+
+// GetAPIKeyService returns the API key service
+func (s *AuthService) GetAPIKeyService() *APIKeyService {
+	return s.apiKeys
+}
+
+// GetTenantService returns the tenant service
+func (s *AuthService) GetTenantService() *TenantService {
+	return s.tenants
+}
+
+// GetRoleService returns the role service
+func (s *AuthService) GetRoleService() *RoleService {
+	return s.roleService
+}
+
+// GetLoginCodeService returns the passwordless login service, or nil when
+// passwordless login is disabled
+func (s *AuthService) GetLoginCodeService() *LoginCodeService {
+	return s.loginCodes
+}
+
+// GetRememberMeService returns the remember-me service
+func (s *AuthService) GetRememberMeService() *RememberMeService {
+	return s.rememberMe
+}
+
+// GetRefreshService returns the refresh token service
+func (s *AuthService) GetRefreshService() *RefreshService {
+	return s.refreshService
+}
+
+// GetTokenService returns the token service, or nil if signed access
+// tokens are disabled
+func (s *AuthService) GetTokenService() *TokenService {
+	return s.tokenService
+}
+
+// IssueAccessToken issues a signed access token for a session. It returns
+// an empty token if signed access tokens are disabled.
+func (s *AuthService) IssueAccessToken(session *models.Session) (string, time.Time, error) {
+	if s.tokenService == nil {
+		return "", time.Time{}, nil
 	}
-	return string(hash), nil
+	return s.tokenService.IssueAccessToken(session)
+}
+
+// HashPassword hashes a password with the preferred hasher
+func (s *AuthService) HashPassword(password string) (string, error) {
+	return s.passwords.Hash(password)
 }
 
-// VerifyPassword verifies a password against a hash
+// VerifyPassword verifies a password against a hash of any supported format
 func (s *AuthService) VerifyPassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	return s.passwords.Verify(hashedPassword, password)
 }
 
-// Register creates a new user account
+// Register creates a new user account in the request's tenant
 func (s *AuthService) Register(ctx context.Context, username, email, password string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	email = strings.TrimSpace(email)
+
 	// Validate input
 	if username == "" {
 		return nil, fmt.Errorf("username is required")
 	}
+	if identity.LooksLikeEmail(username) {
+		return nil, fmt.Errorf("username must not contain '@'")
+	}
 	if email == "" {
 		return nil, fmt.Errorf("email is required")
 	}
 	if password == "" {
 		return nil, fmt.Errorf("password is required")
 	}
-	if len(password) < 6 {
-		return nil, fmt.Errorf("password must be at least 6 characters")
+	tenant := TenantFrom(ctx)
+	if err := s.tenants.PasswordPolicy(tenant).Validate(password, username, email); err != nil {
+		return nil, err
 	}
 
 	// Check if user already exists
-	exists, err := s.userRepo.UserExists(ctx, username, email)
+	exists, err := s.userRepo.UserExists(ctx, tenant.ID, username, email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check user existence: %w", err)
 	}
@@ -78,7 +172,7 @@ func (s *AuthService) Register(ctx context.Context, username, email, password st
 	}
 
 	// Create user
-	user, err := s.userRepo.CreateUser(ctx, username, email, passwordHash)
+	user, err := s.userRepo.CreateUser(ctx, tenant.ID, username, email, passwordHash)
 	if err != nil {
 		return nil, err
 	}
@@ -86,34 +180,79 @@ func (s *AuthService) Register(ctx context.Context, username, email, password st
 	return user, nil
 }
 
-// Login authenticates a user and creates a session
-func (s *AuthService) Login(ctx context.Context, username, password string) (*models.Session, error) {
+// Login authenticates a user and creates a session. The identifier may be
+// either the username or the email address.
+func (s *AuthService) Login(ctx context.Context, identifier, password string, opts SessionOptions) (*models.Session, error) {
+	user, err := s.Authenticate(ctx, identifier, password)
+	if err != nil {
+		return nil, err
+	}
+	// Only tell whoever knows the password why the account is unusable
+	if err := AccountStatusError(user); err != nil {
+		return nil, err
+	}
+
+	// Create session
+	session, err := s.sessionService.CreateSession(ctx, user, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return session, nil
+}
+
+// Authenticate checks a user's password without creating a session. The
+// identifier may be either the username or the email address. Credential
+// backends are tried in order until one knows the user; a backend that
+// cannot be reached is skipped.
+func (s *AuthService) Authenticate(ctx context.Context, identifier, password string) (*models.User, error) {
 	// Validate input
-	if username == "" {
+	if identifier == "" {
 		return nil, fmt.Errorf("username is required")
 	}
 	if password == "" {
 		return nil, fmt.Errorf("password is required")
 	}
 
-	// Get user by username
-	user, err := s.userRepo.GetUserByUsername(ctx, username)
-	if err != nil {
-		return nil, fmt.Errorf("invalid username or password")
+	unavailable := false
+	for _, backend := range s.backends {
+		user, err := backend.Authenticate(ctx, identifier, password)
+		switch {
+		case err == nil:
+			return user, nil
+		case errors.Is(err, ErrUnknownUser):
+			continue
+		case errors.Is(err, ErrBackendUnavailable):
+			fmt.Printf("Warning: credential backend %s failed: %v\n", backend.Name(), err)
+			unavailable = true
+			continue
+		default:
+			if !errors.Is(err, ErrInvalidCredentials) {
+				fmt.Printf("Warning: credential backend %s failed: %v\n", backend.Name(), err)
+			}
+			return nil, fmt.Errorf("invalid username or password")
+		}
 	}
 
-	// Verify password
-	if err := s.VerifyPassword(user.PasswordHash, password); err != nil {
-		return nil, fmt.Errorf("invalid username or password")
+	// Without an answer from every backend an unknown user may just be
+	// one whose backend is down
+	if unavailable {
+		return nil, fmt.Errorf("authentication is temporarily unavailable")
 	}
+	return nil, fmt.Errorf("invalid username or password")
+}
 
-	// Create session
-	session, err := s.sessionService.CreateSession(ctx, user)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %w", err)
+// FindUser looks a user up by ID, or by username in the request's tenant
+// when no ID is given
+func (s *AuthService) FindUser(ctx context.Context, userID, username string) (*models.User, error) {
+	switch {
+	case userID != "":
+		return s.userRepo.GetUserByID(ctx, userID)
+	case username != "":
+		return s.userRepo.GetUserByUsername(ctx, TenantFrom(ctx).ID, username)
+	default:
+		return nil, fmt.Errorf("user_id or username is required")
 	}
-
-	return session, nil
 }
 
 // Logout invalidates a session
@@ -122,25 +261,250 @@ func (s *AuthService) Logout(ctx context.Context, token string) error {
 		return fmt.Errorf("token is required")
 	}
 
-	return s.sessionService.DeleteSession(ctx, token)
+	return s.endSession(ctx, token)
 }
 
-// ValidateToken validates a session token and returns user info
-func (s *AuthService) ValidateToken(ctx context.Context, token string) (*models.User, error) {
+// RefreshSession exchanges a session token for a new session and refresh
+// token. The session's refresh token family is continued, so a copy of the
+// old session token cannot be refreshed again without revoking the family.
+func (s *AuthService) RefreshSession(ctx context.Context, token string, opts SessionOptions) (*models.Session, *IssuedRefreshToken, error) {
+	session, err := s.sessionService.GetSession(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.refreshService.RotateSession(ctx, session, opts)
+}
+
+// ValidateToken validates a session token, signed access token or API key
+// and returns the principal it speaks for, a user or a service account.
+// Session tokens and API keys of users whose account is not active are
+// refused. Access tokens are verified locally without touching Redis or
+// PostgreSQL, so they stay valid until they expire.
+func (s *AuthService) ValidateToken(ctx context.Context, token string) (*models.Principal, error) {
 	if token == "" {
 		return nil, fmt.Errorf("token is required")
 	}
 
-	session, err := s.sessionService.ValidateSession(token)
+	if IsAPIKey(token) {
+		principal, err := s.apiKeys.Authenticate(ctx, token)
+		if err != nil || !inTenant(ctx, principal) {
+			return nil, fmt.Errorf("invalid or expired token")
+		}
+		return principal, nil
+	}
+
+	if s.tokenService != nil && tokenpkg.LooksLikeJWT(token) {
+		claims, err := s.tokenService.VerifyAccessToken(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid or expired token")
+		}
+		principal := &models.Principal{
+			Type:        models.PrincipalUser,
+			ID:          claims.Subject,
+			Name:        claims.Username,
+			Email:       claims.Email,
+			TenantID:    models.DefaultTenantID,
+			Roles:       claims.Roles,
+			Permissions: claims.Permissions,
+			Scopes:      strings.Fields(claims.Scope),
+		}
+		if claims.PrincipalType != "" {
+			principal.Type = claims.PrincipalType
+			principal.TenantID = ""
+		}
+		if claims.Tenant != "" {
+			principal.TenantID = claims.Tenant
+		}
+		if !inTenant(ctx, principal) {
+			return nil, fmt.Errorf("invalid or expired token")
+		}
+		return principal, nil
+	}
+
+	session, err := s.sessionService.ValidateSession(ctx, token)
 	if err != nil {
 		return nil, fmt.Errorf("invalid or expired token")
 	}
 
+	// Service account tokens stop working as soon as the account is
+	// disabled
+	if session.PrincipalType == models.PrincipalServiceAccount {
+		principal, err := s.serviceAccounts.Principal(ctx, session.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid or expired token")
+		}
+		principal.Scopes = session.Scopes
+		return principal, nil
+	}
+
 	user, err := s.userRepo.GetUserByID(ctx, session.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
+	if err := AccountStatusError(user); err != nil {
+		return nil, err
+	}
+	user.Roles = session.Roles
+	user.Permissions = session.Permissions
+	user.Scopes = session.Scopes
 
-	return user, nil
+	principal := models.UserPrincipal(user)
+	principal.ImpersonatorID = session.ImpersonatorID
+	return principal, nil
+}
+
+// inTenant reports whether a principal may act in the request's tenant.
+// Users belong to one tenant; service accounts are not tied to a tenant.
+func inTenant(ctx context.Context, principal *models.Principal) bool {
+	return !principal.IsUser() || principal.TenantID == TenantFrom(ctx).ID
 }
 
+// Authorize reports whether the principal owning token holds permission
+func (s *AuthService) Authorize(ctx context.Context, token, permission string) (*models.Principal, bool, error) {
+	if permission == "" {
+		return nil, false, fmt.Errorf("permission is required")
+	}
+
+	principal, err := s.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, false, err
+	}
+
+	allowed := models.HasPermission(principal.Permissions, permission)
+	// A scoped token is limited to its scopes on top of the principal's
+	// roles
+	if len(principal.Scopes) > 0 && !models.HasPermission(principal.Scopes, permission) {
+		allowed = false
+	}
+
+	return principal, allowed, nil
+}
+
+// ClientCredentials exchanges a service account's client ID and secret for
+// a token limited to scopes
+func (s *AuthService) ClientCredentials(ctx context.Context, clientID, clientSecret string, scopes []string, clientIP string) (*models.Session, error) {
+	return s.serviceAccounts.Authenticate(ctx, clientID, clientSecret, scopes, clientIP)
+}
+
+// CreateScopedToken mints a token restricted to scopes from the session
+// owning token. A ttl of zero keeps the session's remaining lifetime.
+func (s *AuthService) CreateScopedToken(ctx context.Context, token string, scopes []string, ttl time.Duration) (*models.Session, error) {
+	current, err := s.sessionService.ValidateSession(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+	if current.PrincipalType == models.PrincipalServiceAccount {
+		return nil, fmt.Errorf("service accounts request scoped tokens with client_credentials")
+	}
+
+	return s.sessionService.CreateScopedSession(ctx, current, scopes, ttl)
+}
+
+// ManagingSession validates a user's session token used for account
+// management. Scoped tokens need the given scope; API keys, access tokens,
+// service account tokens and impersonation sessions are not accepted.
+func (s *AuthService) ManagingSession(ctx context.Context, token, scope string) (*models.Session, error) {
+	current, err := s.sessionService.ValidateSession(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+	if current.PrincipalType == models.PrincipalServiceAccount {
+		return nil, fmt.Errorf("service account tokens cannot manage accounts")
+	}
+	if current.Impersonated() {
+		return nil, ErrImpersonated
+	}
+	if len(current.Scopes) > 0 && !models.HasPermission(current.Scopes, scope) {
+		return nil, fmt.Errorf("token lacks the %s scope", scope)
+	}
+	return current, nil
+}
+
+// CreateAPIKey issues an API key for the user owning token
+func (s *AuthService) CreateAPIKey(ctx context.Context, token, name string, scopes []string, ttl time.Duration) (*IssuedAPIKey, error) {
+	current, err := s.ManagingSession(ctx, token, ScopeManageAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	return s.apiKeys.Create(ctx, current, name, scopes, ttl)
+}
+
+// ListAPIKeys returns the API keys of the user owning token
+func (s *AuthService) ListAPIKeys(ctx context.Context, token string) ([]*models.APIKey, error) {
+	current, err := s.ManagingSession(ctx, token, ScopeManageAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	return s.apiKeys.List(ctx, current.UserID)
+}
+
+// RevokeAPIKey revokes one of the API keys of the user owning token
+func (s *AuthService) RevokeAPIKey(ctx context.Context, token, id string) error {
+	current, err := s.ManagingSession(ctx, token, ScopeManageAPIKeys)
+	if err != nil {
+		return err
+	}
+	return s.apiKeys.Revoke(ctx, current, id)
+}
+
+// ListSessions returns the live sessions of the user owning token
+func (s *AuthService) ListSessions(ctx context.Context, token string) ([]*models.Session, error) {
+	current, err := s.ManagingSession(ctx, token, ScopeManageSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sessionService.ListUserSessions(ctx, current.UserID)
+}
+
+// RevokeSession ends one of the sessions of the user owning token
+func (s *AuthService) RevokeSession(ctx context.Context, token, sessionID string) error {
+	current, err := s.ManagingSession(ctx, token, ScopeManageSessions)
+	if err != nil {
+		return err
+	}
+
+	target, err := s.sessionService.FindUserSession(ctx, current.UserID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return s.endSession(ctx, target.Token)
+}
+
+// RevokeOtherSessions ends every session of the user owning token except
+// token's own session and returns how many were ended
+func (s *AuthService) RevokeOtherSessions(ctx context.Context, token string) (int, error) {
+	current, err := s.ManagingSession(ctx, token, ScopeManageSessions)
+	if err != nil {
+		return 0, err
+	}
+
+	sessions, err := s.sessionService.ListUserSessions(ctx, current.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.Token == current.Token {
+			continue
+		}
+		if err := s.endSession(ctx, session.Token); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// endSession deletes a session together with its refresh token family
+func (s *AuthService) endSession(ctx context.Context, token string) error {
+	if err := s.refreshService.RevokeForSession(ctx, token); err != nil {
+		fmt.Printf("Warning: failed to revoke refresh tokens of session: %v\n", err)
+	}
+
+	return s.sessionService.DeleteSession(ctx, token)
+}
@@ -0,0 +1,432 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+	"tcp-auth-server/pkg/identity"
+	"tcp-auth-server/pkg/oidc"
+	"tcp-auth-server/pkg/redis"
+
+	"github.com/google/uuid"
+)
+
+// ScopeManageIdentities lets a scoped token link, list and unlink the
+// user's federated identities
+const ScopeManageIdentities = "identities:manage"
+
+// ErrInvalidFederationState is returned for unknown, used or expired
+// federated login states
+var ErrInvalidFederationState = errors.New("invalid or expired login state")
+
+// usernameUnsafe matches the characters dropped from usernames derived
+// from an upstream account
+var usernameUnsafe = regexp.MustCompile(`[^\p{L}\p{N}._-]+`)
+
+// maxGeneratedUsername bounds usernames derived from an upstream account
+const maxGeneratedUsername = 50
+
+// IdentityProvider is an upstream OpenID Connect provider users can log in
+// with
+type IdentityProvider struct {
+	// Name identifies the provider in requests and linked identities
+	Name        string
+	DisplayName string
+	Client      *oidc.Provider
+
+	// CreateUsers creates a local user when an unknown account logs in
+	CreateUsers bool
+	// TrustEmail links an unknown account to the local user with the same
+	// email address, if the provider reports the address as verified
+	TrustEmail bool
+}
+
+// federationState is a pending federated login or link stored in Redis
+type federationState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	// UserID is set when the state links an identity to a logged-in user
+	UserID string `json:"user_id,omitempty"`
+}
+
+// FederationService lets users log in with accounts at upstream OpenID
+// Connect providers. Upstream accounts are linked to local users, which
+// are created just in time at the first login when the provider allows
+// it; a successful login issues an ordinary session.
+//
+// Logins take two requests: the first returns the provider's authorization
+// URL, and the second completes the login with the code and state the
+// provider sent to the client's redirect URI.
+type FederationService struct {
+	identityRepo *repository.FederatedIdentityRepository
+	userRepo     UserStore
+	authService  *AuthService
+	auditService *AuditService
+	redisClient  *redis.Client
+	providers    map[string]*IdentityProvider
+	stateTTL     time.Duration
+}
+
+// NewFederationService creates a new federation service. A login must be
+// completed within stateTTL of being started.
+func NewFederationService(
+	identityRepo *repository.FederatedIdentityRepository,
+	userRepo UserStore,
+	authService *AuthService,
+	auditService *AuditService,
+	redisClient *redis.Client,
+	providers []*IdentityProvider,
+	stateTTL time.Duration,
+) *FederationService {
+	byName := make(map[string]*IdentityProvider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name] = provider
+	}
+	return &FederationService{
+		identityRepo: identityRepo,
+		userRepo:     userRepo,
+		authService:  authService,
+		auditService: auditService,
+		redisClient:  redisClient,
+		providers:    byName,
+		stateTTL:     stateTTL,
+	}
+}
+
+// Providers returns the configured providers by name
+func (s *FederationService) Providers() []*IdentityProvider {
+	providers := make([]*IdentityProvider, 0, len(s.providers))
+	for _, provider := range s.providers {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers
+}
+
+// BeginLogin starts a federated login in the request's tenant. It returns
+// the URL to send the user to and the state the provider will echo back.
+func (s *FederationService) BeginLogin(ctx context.Context, providerName string) (string, string, error) {
+	return s.begin(ctx, providerName, "")
+}
+
+// CompleteLogin completes a federated login with the code and state sent
+// to the client's redirect URI and creates a session for the linked user
+func (s *FederationService) CompleteLogin(ctx context.Context, state, code string, opts SessionOptions) (*models.Session, error) {
+	pending, provider, err := s.takeState(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	if pending.UserID != "" {
+		return nil, ErrInvalidFederationState
+	}
+
+	claims, err := provider.Client.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("login with %s failed: %w", provider.DisplayName, err)
+	}
+
+	user, err := s.resolveUser(ctx, provider, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.authService.GetSessionService().CreateSession(ctx, user, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return session, nil
+}
+
+// BeginLink starts linking a provider account to the user owning token
+func (s *FederationService) BeginLink(ctx context.Context, token, providerName string) (string, string, error) {
+	current, err := s.authService.ManagingSession(ctx, token, ScopeManageIdentities)
+	if err != nil {
+		return "", "", err
+	}
+	return s.begin(ctx, providerName, current.UserID)
+}
+
+// CompleteLink links the provider account that approved a link started by
+// BeginLink. The state must have been issued to the same user.
+func (s *FederationService) CompleteLink(ctx context.Context, token, state, code string) (*models.FederatedIdentity, error) {
+	current, err := s.authService.ManagingSession(ctx, token, ScopeManageIdentities)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, provider, err := s.takeState(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	if pending.UserID != current.UserID {
+		return nil, ErrInvalidFederationState
+	}
+
+	claims, err := provider.Client.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("linking %s failed: %w", provider.DisplayName, err)
+	}
+
+	tenantID := TenantFrom(ctx).ID
+	if existing, err := s.identityRepo.GetIdentity(ctx, tenantID, provider.Name, claims.Subject); err == nil {
+		if existing.UserID == current.UserID {
+			return existing, nil
+		}
+		return nil, fmt.Errorf("this %s account is linked to another user", provider.DisplayName)
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.link(ctx, user, provider, claims, current.UserID)
+}
+
+// ListIdentities returns the identities linked to the user owning token
+func (s *FederationService) ListIdentities(ctx context.Context, token string) ([]*models.FederatedIdentity, error) {
+	current, err := s.authService.ManagingSession(ctx, token, ScopeManageIdentities)
+	if err != nil {
+		return nil, err
+	}
+	return s.identityRepo.ListIdentities(ctx, current.UserID)
+}
+
+// Unlink removes the identity at a provider from the user owning token.
+// The last identity of a user without a password or credential backend
+// cannot be removed, as the user could no longer log in.
+func (s *FederationService) Unlink(ctx context.Context, token, providerName string) error {
+	current, err := s.authService.ManagingSession(ctx, token, ScopeManageIdentities)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash == "" && user.Backend == "" {
+		identities, err := s.identityRepo.ListIdentities(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if len(identities) <= 1 {
+			return fmt.Errorf("set a password before unlinking your last identity provider")
+		}
+	}
+
+	if err := s.identityRepo.DeleteIdentity(ctx, user.ID, providerName); err != nil {
+		return err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventIdentityUnlinked,
+		UserID:    user.ID,
+		ActorID:   user.ID,
+		Details: map[string]interface{}{
+			"provider": providerName,
+		},
+	})
+	return nil
+}
+
+// begin stores a new login state and builds the provider's authorization
+// URL
+func (s *FederationService) begin(ctx context.Context, providerName, userID string) (string, string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", fmt.Errorf("unknown identity provider: %s", providerName)
+	}
+
+	sessionService := s.authService.GetSessionService()
+	state, err := sessionService.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := sessionService.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.Client.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	pending := federationState{
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserID:       userID,
+	}
+	if err := s.redisClient.Set(federationStateKey(TenantFrom(ctx).ID, state), pending, s.stateTTL); err != nil {
+		return "", "", fmt.Errorf("failed to store login state: %w", err)
+	}
+
+	return authURL, state, nil
+}
+
+// takeState redeems a login state, which can be used only once
+func (s *FederationService) takeState(ctx context.Context, state string) (*federationState, *IdentityProvider, error) {
+	if state == "" {
+		return nil, nil, ErrInvalidFederationState
+	}
+	key := federationStateKey(TenantFrom(ctx).ID, state)
+
+	var pending federationState
+	if err := s.redisClient.Get(key, &pending); err != nil {
+		return nil, nil, ErrInvalidFederationState
+	}
+	taken, err := s.redisClient.Take(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to redeem login state: %w", err)
+	}
+	if !taken {
+		return nil, nil, ErrInvalidFederationState
+	}
+
+	provider, ok := s.providers[pending.Provider]
+	if !ok {
+		return nil, nil, ErrInvalidFederationState
+	}
+	return &pending, provider, nil
+}
+
+// resolveUser finds the user linked to an upstream account, linking it to
+// the user with the same verified email or creating a user when the
+// provider allows it
+func (s *FederationService) resolveUser(ctx context.Context, provider *IdentityProvider, claims *oidc.Claims) (*models.User, error) {
+	tenantID := TenantFrom(ctx).ID
+
+	if linked, err := s.identityRepo.GetIdentity(ctx, tenantID, provider.Name, claims.Subject); err == nil {
+		if err := s.identityRepo.TouchLogin(ctx, linked.ID, claims.Email); err != nil {
+			fmt.Printf("Warning: failed to record federated login: %v\n", err)
+		}
+		return s.userRepo.GetUserByID(ctx, linked.UserID)
+	}
+
+	if claims.Email == "" {
+		return nil, fmt.Errorf("%s did not share an email address", provider.DisplayName)
+	}
+
+	existing, err := s.userRepo.GetUserByEmail(ctx, tenantID, claims.Email)
+	if err == nil {
+		// Linking on email alone would let anyone who controls an
+		// unverified address at the provider take the account over
+		if !provider.TrustEmail || !bool(claims.EmailVerified) {
+			return nil, fmt.Errorf("an account with this email already exists; log in and link %s from your account", provider.DisplayName)
+		}
+		if _, err := s.link(ctx, existing, provider, claims, ""); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	if !provider.CreateUsers {
+		return nil, fmt.Errorf("no account is linked to this %s account", provider.DisplayName)
+	}
+
+	user, err := s.createUser(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.link(ctx, user, provider, claims, ""); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createUser creates a user without a password for an upstream account
+func (s *FederationService) createUser(ctx context.Context, claims *oidc.Claims) (*models.User, error) {
+	tenantID := TenantFrom(ctx).ID
+
+	base := claims.PreferredUsername
+	if base == "" || identity.LooksLikeEmail(base) {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameUnsafe.ReplaceAllString(base, "")
+	// Leave room for the suffix added when the name is taken
+	if runes := []rune(base); len(runes) > maxGeneratedUsername-5 {
+		base = string(runes[:maxGeneratedUsername-5])
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username := base
+	for attempt := 0; ; attempt++ {
+		exists, err := s.userRepo.UserExists(ctx, tenantID, username, "")
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			break
+		}
+		if attempt == 5 {
+			return nil, fmt.Errorf("could not choose a username for the new account")
+		}
+		username = fmt.Sprintf("%s-%s", base, uuid.New().String()[:4])
+	}
+
+	user, err := s.userRepo.CreateUser(ctx, tenantID, username, claims.Email, "")
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventFederatedUserCreated,
+		UserID:    user.ID,
+		Details: map[string]interface{}{
+			"issuer":  claims.Issuer,
+			"subject": claims.Subject,
+		},
+	})
+	return user, nil
+}
+
+// link records an upstream account as an identity of user. actorID is the
+// user linking it, or empty when it was linked at login.
+func (s *FederationService) link(ctx context.Context, user *models.User, provider *IdentityProvider, claims *oidc.Claims, actorID string) (*models.FederatedIdentity, error) {
+	now := time.Now()
+	linked := &models.FederatedIdentity{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		TenantID:  user.TenantID,
+		Provider:  provider.Name,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		CreatedAt: now,
+	}
+	if actorID == "" {
+		linked.LastLoginAt = now
+	}
+	if err := s.identityRepo.CreateIdentity(ctx, linked); err != nil {
+		return nil, fmt.Errorf("could not link %s account: %w", provider.DisplayName, err)
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventIdentityLinked,
+		UserID:    user.ID,
+		ActorID:   actorID,
+		Details: map[string]interface{}{
+			"provider": provider.Name,
+			"subject":  claims.Subject,
+		},
+	})
+	return linked, nil
+}
+
+func federationStateKey(tenantID, state string) string {
+	return tenantKey(tenantID, fmt.Sprintf("federation_state:%s", hashToken(state)))
+}
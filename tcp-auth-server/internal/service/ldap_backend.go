@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+	"tcp-auth-server/pkg/ldap"
+)
+
+// LDAPConfig describes a directory users can log in against
+type LDAPConfig struct {
+	// Name identifies the backend; it is stored with the users it owns
+	Name string
+	// URL is an ldap:// or ldaps:// URL of the server
+	URL       string
+	StartTLS  bool
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+
+	// BindDN and BindPassword are the service account that searches for
+	// users; empty for an anonymous search
+	BindDN       string
+	BindPassword string
+
+	// UserBaseDN and UserFilter find the entry of a login. "{username}" in
+	// the filter is replaced with the escaped identifier.
+	UserBaseDN        string
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+
+	// GroupAttribute lists the groups of a user entry, such as memberOf.
+	// When GroupFilter is set groups are searched for under GroupBaseDN
+	// instead; "{dn}" in the filter is replaced with the user's DN.
+	GroupAttribute string
+	GroupBaseDN    string
+	GroupFilter    string
+
+	// GroupRoles maps group DNs to the roles their members get. The roles
+	// are kept in sync with the groups at every login; other roles of the
+	// user are left alone.
+	GroupRoles map[string][]string
+
+	// TenantID is the tenant the directory's users belong to
+	TenantID string
+}
+
+// LDAPBackend authenticates users by binding to an LDAP directory as them.
+// Each directory account gets a shadow user, created at its first login and
+// updated at later ones.
+type LDAPBackend struct {
+	config       LDAPConfig
+	userRepo     UserStore
+	roleService  *RoleService
+	auditService *AuditService
+
+	// groupRoles is config.GroupRoles keyed by normalized DN
+	groupRoles   map[string][]string
+	managedRoles []string
+}
+
+// NewLDAPBackend creates a directory backend
+func NewLDAPBackend(config LDAPConfig, userRepo UserStore, roleService *RoleService, auditService *AuditService) *LDAPBackend {
+	groupRoles := make(map[string][]string, len(config.GroupRoles))
+	managed := make(map[string]bool)
+	for group, roles := range config.GroupRoles {
+		key := normalizeDN(group)
+		groupRoles[key] = append(groupRoles[key], roles...)
+		for _, role := range roles {
+			managed[role] = true
+		}
+	}
+
+	managedRoles := make([]string, 0, len(managed))
+	for role := range managed {
+		managedRoles = append(managedRoles, role)
+	}
+	sort.Strings(managedRoles)
+
+	return &LDAPBackend{
+		config:       config,
+		userRepo:     userRepo,
+		roleService:  roleService,
+		auditService: auditService,
+		groupRoles:   groupRoles,
+		managedRoles: managedRoles,
+	}
+}
+
+// Name returns the configured name of the backend
+func (b *LDAPBackend) Name() string {
+	return b.config.Name
+}
+
+// Authenticate finds the directory entry of the identifier, binds as it
+// with the password and returns its shadow user
+func (b *LDAPBackend) Authenticate(ctx context.Context, identifier, password string) (*models.User, error) {
+	if TenantFrom(ctx).ID != b.config.TenantID {
+		return nil, ErrUnknownUser
+	}
+
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := b.findEntry(conn, identifier)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := b.groups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsResultCode(err, ldap.ResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+
+	user, err := b.shadowUser(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.roleService.SyncRoles(ctx, user.ID, b.managedRoles, b.rolesFor(groups)); err != nil {
+		fmt.Printf("Warning: failed to sync roles of %s from %s: %v\n", user.ID, b.config.Name, err)
+	}
+
+	return user, nil
+}
+
+// connect dials the directory and binds as the search account
+func (b *LDAPBackend) connect(ctx context.Context) (*ldap.Conn, error) {
+	conn, err := ldap.Dial(ctx, b.config.URL, b.config.TLSConfig, b.config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+
+	if b.config.StartTLS {
+		if err := conn.StartTLS(b.config.TLSConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+		}
+	}
+
+	if b.config.BindDN != "" {
+		if err := conn.Bind(b.config.BindDN, b.config.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: search bind failed: %v", ErrBackendUnavailable, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// findEntry searches for the single entry matching the identifier
+func (b *LDAPBackend) findEntry(conn *ldap.Conn, identifier string) (*ldap.Entry, error) {
+	attributes := []string{b.config.UsernameAttribute, b.config.EmailAttribute}
+	if b.config.GroupFilter == "" && b.config.GroupAttribute != "" {
+		attributes = append(attributes, b.config.GroupAttribute)
+	}
+
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:     b.config.UserBaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     strings.ReplaceAll(b.config.UserFilter, "{username}", ldap.EscapeFilter(identifier)),
+		Attributes: attributes,
+		SizeLimit:  2,
+	})
+	if err != nil && !ldap.IsResultCode(err, ldap.ResultSizeLimitExceeded) {
+		if ldap.IsResultCode(err, ldap.ResultNoSuchObject) {
+			return nil, ErrUnknownUser
+		}
+		return nil, fmt.Errorf("%w: user search failed: %v", ErrBackendUnavailable, err)
+	}
+
+	switch len(entries) {
+	case 0:
+		return nil, ErrUnknownUser
+	case 1:
+		return entries[0], nil
+	default:
+		// An ambiguous filter must not let a login pick between accounts
+		fmt.Printf("Warning: %s matches several entries in %s\n", identifier, b.config.Name)
+		return nil, ErrInvalidCredentials
+	}
+}
+
+// groups returns the DNs of the groups the entry belongs to
+func (b *LDAPBackend) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	if b.config.GroupFilter == "" {
+		if b.config.GroupAttribute == "" {
+			return nil, nil
+		}
+		return entry.Values(b.config.GroupAttribute), nil
+	}
+
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:     b.config.GroupBaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     strings.ReplaceAll(b.config.GroupFilter, "{dn}", ldap.EscapeFilter(entry.DN)),
+		Attributes: []string{"1.1"}, // no attributes, only DNs
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: group search failed: %v", ErrBackendUnavailable, err)
+	}
+
+	groups := make([]string, 0, len(entries))
+	for _, group := range entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
+
+// rolesFor maps group DNs to roles
+func (b *LDAPBackend) rolesFor(groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		roles = append(roles, b.groupRoles[normalizeDN(group)]...)
+	}
+	return roles
+}
+
+// shadowUser returns the local user of a directory entry, creating it at
+// the first login and updating its email address at later ones
+func (b *LDAPBackend) shadowUser(ctx context.Context, entry *ldap.Entry) (*models.User, error) {
+	tenantID := TenantFrom(ctx).ID
+	username := entry.Value(b.config.UsernameAttribute)
+	email := entry.Value(b.config.EmailAttribute)
+	if username == "" || email == "" {
+		fmt.Printf("Warning: %s in %s has no %s or %s\n", entry.DN, b.config.Name, b.config.UsernameAttribute, b.config.EmailAttribute)
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := b.userRepo.GetUserByUsername(ctx, tenantID, username)
+	if err == nil {
+		if user.Backend != b.config.Name {
+			// A local account or one of another directory holds the name
+			fmt.Printf("Warning: %s in %s collides with user %s\n", entry.DN, b.config.Name, user.ID)
+			return nil, ErrInvalidCredentials
+		}
+		if user.Email != email {
+			if err := b.userRepo.UpdateEmail(ctx, user.ID, email); err != nil {
+				fmt.Printf("Warning: failed to update email of %s from %s: %v\n", user.ID, b.config.Name, err)
+			} else {
+				user.Email = email
+			}
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+
+	user, err = b.userRepo.CreateBackendUser(ctx, tenantID, b.config.Name, username, email)
+	if err != nil {
+		return nil, err
+	}
+
+	b.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventDirectoryUserCreated,
+		UserID:    user.ID,
+		Details: map[string]interface{}{
+			"backend": b.config.Name,
+			"dn":      entry.DN,
+		},
+	})
+	return user, nil
+}
+
+// normalizeDN lowercases a DN and drops the spaces around its separators,
+// so group DNs from the directory match the configured ones
+func normalizeDN(dn string) string {
+	rdns := strings.Split(dn, ",")
+	for i, rdn := range rdns {
+		attr, value, _ := strings.Cut(rdn, "=")
+		rdns[i] = strings.TrimSpace(attr) + "=" + strings.TrimSpace(value)
+	}
+	return strings.ToLower(strings.Join(rdns, ","))
+}
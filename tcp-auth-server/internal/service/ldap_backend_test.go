@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/ldap"
+)
+
+// unavailableUserStore fails every username lookup, as a database outage
+// would
+type unavailableUserStore struct {
+	*memoryUserStore
+}
+
+var errUnavailable = errors.New("database unavailable")
+
+func (s *unavailableUserStore) GetUserByUsername(ctx context.Context, tenantID, username string) (*models.User, error) {
+	return nil, errUnavailable
+}
+
+// directoryEntry returns the entry of a directory account
+func directoryEntry(username, email string) *ldap.Entry {
+	return &ldap.Entry{
+		DN: "uid=" + username + ",ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"uid":  {username},
+			"mail": {email},
+		},
+	}
+}
+
+// newTestLDAPBackend returns a backend of the test services that keeps its
+// users in users
+func newTestLDAPBackend(ts *testServices, users UserStore) *LDAPBackend {
+	return NewLDAPBackend(LDAPConfig{
+		Name:              "corp",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+	}, users, ts.roleService, ts.auditService)
+}
+
+func TestLDAPShadowUser(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	users := ts.users
+	backend := newTestLDAPBackend(ts, users)
+	ctx := context.Background()
+
+	created, err := backend.shadowUser(ctx, directoryEntry("alice", "alice@example.com"))
+	if err != nil {
+		t.Fatalf("shadowUser of a new account: %v", err)
+	}
+	if created.Backend != "corp" || created.TenantID != models.DefaultTenantID {
+		t.Errorf("created user = %+v, want one of the corp backend in the default tenant", created)
+	}
+	events := ts.audit.eventTypes(created.ID)
+	if len(events) != 1 || events[0] != models.EventDirectoryUserCreated {
+		t.Errorf("audit events = %v, want [%s]", events, models.EventDirectoryUserCreated)
+	}
+
+	// Later logins update the existing user
+	updated, err := backend.shadowUser(ctx, directoryEntry("alice", "alice@corp.example.com"))
+	if err != nil {
+		t.Fatalf("shadowUser of a known account: %v", err)
+	}
+	if updated.ID != created.ID || updated.Email != "alice@corp.example.com" {
+		t.Errorf("updated user = %+v, want %s with the new email", updated, created.ID)
+	}
+	if len(users.users) != 1 {
+		t.Errorf("store holds %d users, want 1", len(users.users))
+	}
+}
+
+func TestLDAPShadowUserRejects(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+
+	// A local account holds the name
+	users := ts.users
+	users.add(&models.User{Username: "alice"})
+	if _, err := newTestLDAPBackend(ts, users).shadowUser(ctx, directoryEntry("alice", "alice@example.com")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("shadowUser of a colliding account = %v, want ErrInvalidCredentials", err)
+	}
+
+	// A failed lookup is not taken for a new account
+	unavailable := &unavailableUserStore{newMemoryUserStore()}
+	if _, err := newTestLDAPBackend(ts, unavailable).shadowUser(ctx, directoryEntry("alice", "alice@example.com")); !errors.Is(err, errUnavailable) {
+		t.Errorf("shadowUser with the store unavailable = %v, want its error", err)
+	}
+	if len(unavailable.users) != 0 {
+		t.Error("a user was created although the lookup failed")
+	}
+
+	// An entry without a username cannot be shadowed
+	if _, err := newTestLDAPBackend(ts, users).shadowUser(ctx, directoryEntry("", "bob@example.com")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("shadowUser of an entry without a username = %v, want ErrInvalidCredentials", err)
+	}
+}
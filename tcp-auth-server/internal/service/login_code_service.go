@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/identity"
+	"tcp-auth-server/pkg/notify"
+	"tcp-auth-server/pkg/redis"
+)
+
+// loginLinkPrefix distinguishes magic-link tokens from numeric codes
+const loginLinkPrefix = "ml_"
+
+// ErrInvalidLoginCode is returned for wrong, used or expired login codes
+var ErrInvalidLoginCode = errors.New("invalid or expired login code")
+
+// ErrLoginCodeRateLimited is returned when too many codes were requested
+// for one address
+var ErrLoginCodeRateLimited = errors.New("too many login code requests, try again later")
+
+// LoginCodePolicy configures passwordless login
+type LoginCodePolicy struct {
+	// TTL is how long a code or link stays valid
+	TTL time.Duration
+	// MaxAttempts is the number of wrong guesses after which a code is
+	// discarded
+	MaxAttempts int
+	// RateLimit is the number of codes that may be requested for one
+	// address per RateWindow
+	RateLimit  int
+	RateWindow time.Duration
+	// LinkURL is the page that redeems magic links; the token is added as
+	// the code query parameter. Links are not sent when empty.
+	LinkURL string
+}
+
+// loginCode is a pending code stored in Redis
+type loginCode struct {
+	UserID   string `json:"user_id"`
+	CodeHash string `json:"code_hash"`
+	LinkHash string `json:"link_hash,omitempty"`
+}
+
+// LoginCodeService implements passwordless login with single-use codes and
+// magic links sent to the user's email address
+type LoginCodeService struct {
+	redisClient    *redis.Client
+	userRepo       UserStore
+	sessionService *SessionService
+	sender         notify.Sender
+	policy         LoginCodePolicy
+}
+
+// NewLoginCodeService creates a new login code service
+func NewLoginCodeService(
+	redisClient *redis.Client,
+	userRepo UserStore,
+	sessionService *SessionService,
+	sender notify.Sender,
+	policy LoginCodePolicy,
+) *LoginCodeService {
+	return &LoginCodeService{
+		redisClient:    redisClient,
+		userRepo:       userRepo,
+		sessionService: sessionService,
+		sender:         sender,
+		policy:         policy,
+	}
+}
+
+// RequestCode sends a login code, and a magic link when configured, to the
+// account registered with email. Unknown addresses succeed silently so the
+// response does not reveal which addresses have accounts.
+func (s *LoginCodeService) RequestCode(ctx context.Context, email string) error {
+	address := identity.CanonicalEmail(email)
+	if address == "" {
+		return fmt.Errorf("email is required")
+	}
+
+	tenantID := TenantFrom(ctx).ID
+	count, err := s.redisClient.IncrWindow(tenantKey(tenantID, fmt.Sprintf("login_code_rate:%s", address)), s.policy.RateWindow)
+	if err != nil {
+		return fmt.Errorf("failed to check login code rate limit: %w", err)
+	}
+	if count > int64(s.policy.RateLimit) {
+		return ErrLoginCodeRateLimited
+	}
+
+	user, err := s.userRepo.GetUserByEmail(ctx, tenantID, email)
+	if err != nil {
+		return nil
+	}
+
+	code, err := generateLoginCode()
+	if err != nil {
+		return err
+	}
+	pending := loginCode{
+		UserID:   user.ID,
+		CodeHash: hashToken(code),
+	}
+
+	var link string
+	if s.policy.LinkURL != "" {
+		token, err := s.sessionService.GenerateToken()
+		if err != nil {
+			return err
+		}
+		token = loginLinkPrefix + token
+		pending.LinkHash = hashToken(token)
+		if link, err = s.linkFor(token); err != nil {
+			return err
+		}
+	}
+
+	// A new code replaces any earlier one, including its link
+	s.discard(tenantID, address)
+
+	if err := s.redisClient.Set(loginCodeKey(tenantID, address), pending, s.policy.TTL); err != nil {
+		return fmt.Errorf("failed to store login code: %w", err)
+	}
+	if pending.LinkHash != "" {
+		if err := s.redisClient.Set(loginLinkKey(tenantID, pending.LinkHash), address, s.policy.TTL); err != nil {
+			return fmt.Errorf("failed to store login link: %w", err)
+		}
+	}
+
+	msg := &notify.Message{
+		To:      user.Email,
+		Subject: "Your login code",
+		Body:    loginCodeBody(code, link, s.policy.TTL),
+	}
+	if err := s.sender.Send(ctx, msg); err != nil {
+		s.discard(tenantID, address)
+		return fmt.Errorf("failed to send login code: %w", err)
+	}
+
+	return nil
+}
+
+// Redeem exchanges a code sent to email, or a magic-link token on its own,
+// for a new session
+func (s *LoginCodeService) Redeem(ctx context.Context, email, code string, opts SessionOptions) (*models.Session, error) {
+	if strings.HasPrefix(code, loginLinkPrefix) {
+		return s.redeemLink(ctx, code, opts)
+	}
+
+	address := identity.CanonicalEmail(email)
+	if address == "" || code == "" {
+		return nil, ErrInvalidLoginCode
+	}
+	tenantID := TenantFrom(ctx).ID
+
+	var pending loginCode
+	if err := s.redisClient.Get(loginCodeKey(tenantID, address), &pending); err != nil {
+		return nil, ErrInvalidLoginCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(code)), []byte(pending.CodeHash)) != 1 {
+		attempts, err := s.redisClient.IncrWindow(loginAttemptsKey(tenantID, address), s.policy.TTL)
+		if err != nil || attempts >= int64(s.policy.MaxAttempts) {
+			s.discard(tenantID, address)
+		}
+		return nil, ErrInvalidLoginCode
+	}
+
+	// Concurrent redemptions of the same code: only one may win
+	taken, err := s.redisClient.Take(loginCodeKey(tenantID, address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem login code: %w", err)
+	}
+	if !taken {
+		return nil, ErrInvalidLoginCode
+	}
+	s.discardLink(tenantID, &pending)
+	_ = s.redisClient.Delete(loginAttemptsKey(tenantID, address))
+
+	return s.startSession(ctx, pending.UserID, opts)
+}
+
+// redeemLink exchanges a magic-link token for a new session
+func (s *LoginCodeService) redeemLink(ctx context.Context, token string, opts SessionOptions) (*models.Session, error) {
+	tenantID := TenantFrom(ctx).ID
+	linkKey := loginLinkKey(tenantID, hashToken(token))
+
+	var address string
+	if err := s.redisClient.Get(linkKey, &address); err != nil {
+		return nil, ErrInvalidLoginCode
+	}
+
+	taken, err := s.redisClient.Take(linkKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem login link: %w", err)
+	}
+	if !taken {
+		return nil, ErrInvalidLoginCode
+	}
+
+	// The code sent with the link is spent as well
+	var pending loginCode
+	if err := s.redisClient.Get(loginCodeKey(tenantID, address), &pending); err != nil || pending.LinkHash != hashToken(token) {
+		return nil, ErrInvalidLoginCode
+	}
+	if taken, err := s.redisClient.Take(loginCodeKey(tenantID, address)); err != nil || !taken {
+		return nil, ErrInvalidLoginCode
+	}
+	_ = s.redisClient.Delete(loginAttemptsKey(tenantID, address))
+
+	return s.startSession(ctx, pending.UserID, opts)
+}
+
+// startSession creates a session exactly as a password login would
+func (s *LoginCodeService) startSession(ctx context.Context, userID string, opts SessionOptions) (*models.Session, error) {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrInvalidLoginCode
+	}
+
+	session, err := s.sessionService.CreateSession(ctx, user, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return session, nil
+}
+
+// discard deletes the pending code for an address and its link
+func (s *LoginCodeService) discard(tenantID, address string) {
+	var pending loginCode
+	if err := s.redisClient.Get(loginCodeKey(tenantID, address), &pending); err == nil {
+		s.discardLink(tenantID, &pending)
+	}
+	if err := s.redisClient.Delete(loginCodeKey(tenantID, address)); err != nil {
+		fmt.Printf("Warning: failed to delete login code: %v\n", err)
+	}
+	_ = s.redisClient.Delete(loginAttemptsKey(tenantID, address))
+}
+
+// discardLink deletes the magic link belonging to a code
+func (s *LoginCodeService) discardLink(tenantID string, pending *loginCode) {
+	if pending.LinkHash == "" {
+		return
+	}
+	if err := s.redisClient.Delete(loginLinkKey(tenantID, pending.LinkHash)); err != nil {
+		fmt.Printf("Warning: failed to delete login link: %v\n", err)
+	}
+}
+
+// linkFor builds the magic link for a token
+func (s *LoginCodeService) linkFor(token string) (string, error) {
+	u, err := url.Parse(s.policy.LinkURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid login link URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("code", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// generateLoginCode returns a uniformly random six digit code
+func generateLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate login code: %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// loginCodeBody renders the message sent to the user
+func loginCodeBody(code, link string, ttl time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your login code is %s. It expires in %s.\n", code, ttl.Round(time.Minute))
+	if link != "" {
+		fmt.Fprintf(&b, "\nOr open this link to log in:\n%s\n", link)
+	}
+	b.WriteString("\nIf you did not ask to log in, you can ignore this message.\n")
+	return b.String()
+}
+
+func loginCodeKey(tenantID, address string) string {
+	return tenantKey(tenantID, fmt.Sprintf("login_code:%s", address))
+}
+
+func loginAttemptsKey(tenantID, address string) string {
+	return tenantKey(tenantID, fmt.Sprintf("login_code_attempts:%s", address))
+}
+
+func loginLinkKey(tenantID, linkHash string) string {
+	return tenantKey(tenantID, fmt.Sprintf("login_link:%s", linkHash))
+}
@@ -0,0 +1,760 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	tokenpkg "tcp-auth-server/pkg/token"
+)
+
+// oauthClientIDPrefix and oauthSecretPrefix mark OAuth client credentials
+// so they are told apart from service account credentials
+const (
+	oauthClientIDPrefix = "oc_"
+	oauthSecretPrefix   = "ocs_"
+)
+
+// OAuthClientType is the session client type of sessions issued through
+// the OAuth endpoints, so they can be given their own session policy
+const OAuthClientType = "oauth"
+
+// PKCEMethodS256 is the only supported PKCE code challenge method
+const PKCEMethodS256 = "S256"
+
+// OAuth 2.0 error codes (RFC 6749 sections 4.1.2.1 and 5.2)
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthAccessDenied            = "access_denied"
+)
+
+// pkcePattern matches PKCE code verifiers and S256 code challenges
+// (RFC 7636 section 4.1)
+var pkcePattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// OAuthError is an error reported to OAuth clients with one of the
+// standard error codes
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Description
+}
+
+// oauthError creates an OAuth error
+func oauthError(code, format string, args ...interface{}) *OAuthError {
+	return &OAuthError{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+// AuthorizationRequest holds the parameters of a request to the
+// authorization endpoint
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// RegisteredOAuthClient is a new OAuth client with its secret, which is
+// only ever shown once. Public clients have no secret.
+type RegisteredOAuthClient struct {
+	ClientSecret string
+	Client       *models.OAuthClient
+}
+
+// OAuthToken is the result of a successful token request. The access
+// token is the session token; Refresh is nil when no refresh token is
+// issued. IDToken is set when the openid scope was granted.
+type OAuthToken struct {
+	Session *models.Session
+	Refresh *IssuedRefreshToken
+	IDToken string
+}
+
+// Introspection describes a token for the introspection endpoint
+// (RFC 7662). Only Active is set for inactive tokens.
+type Introspection struct {
+	Active        bool
+	Scopes        []string
+	ClientID      string
+	Username      string
+	Subject       string
+	PrincipalType string
+	TenantID      string
+	TokenType     string
+	ExpiresAt     time.Time
+	IssuedAt      time.Time
+	// Actor is the admin impersonating the subject, if any
+	Actor string
+}
+
+// OAuthService implements an OAuth 2.0 authorization server on top of the
+// auth and session services: the authorization code grant with PKCE, the
+// refresh token grant, the client credentials grant for service accounts,
+// token introspection and token revocation. With an issuer configured it
+// is also an OpenID Connect provider.
+//
+// Registered clients act for users of their tenant. The access tokens they
+// receive are ordinary session tokens, so resource servers validate them
+// like any other token or through introspection.
+type OAuthService struct {
+	oauthRepo    OAuthStore
+	authService  *AuthService
+	auditService *AuditService
+	codeTTL      time.Duration
+	issuer       string
+	idTokenTTL   time.Duration
+}
+
+// NewOAuthService creates a new OAuth service. Authorization codes must be
+// redeemed within codeTTL. An empty issuer disables OpenID Connect; ID
+// tokens are valid for idTokenTTL.
+func NewOAuthService(
+	oauthRepo OAuthStore,
+	authService *AuthService,
+	auditService *AuditService,
+	codeTTL time.Duration,
+	issuer string,
+	idTokenTTL time.Duration,
+) *OAuthService {
+	return &OAuthService{
+		oauthRepo:    oauthRepo,
+		authService:  authService,
+		auditService: auditService,
+		codeTTL:      codeTTL,
+		issuer:       issuer,
+		idTokenTTL:   idTokenTTL,
+	}
+}
+
+// RegisterClient registers an OAuth client in the request's tenant on
+// behalf of actorID. Clients use the authorization code grant and, unless
+// left out of GrantTypes, the refresh token grant.
+func (s *OAuthService) RegisterClient(ctx context.Context, actorID string, client *models.OAuthClient) (*RegisteredOAuthClient, error) {
+	client.Name = strings.TrimSpace(client.Name)
+	if client.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(client.RedirectURIs) == 0 {
+		return nil, fmt.Errorf("at least one redirect URI is required")
+	}
+	for _, uri := range client.RedirectURIs {
+		if err := models.ValidateRedirectURI(uri); err != nil {
+			return nil, err
+		}
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+	}
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case models.GrantAuthorizationCode, models.GrantRefreshToken:
+		case models.GrantClientCredentials:
+			return nil, fmt.Errorf("the client credentials grant is used by service accounts")
+		default:
+			return nil, fmt.Errorf("unsupported grant type %q", grantType)
+		}
+	}
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, fmt.Errorf("grant types must include %s", models.GrantAuthorizationCode)
+	}
+	if err := models.ValidateScopes(client.Scopes); err != nil {
+		return nil, err
+	}
+	for _, uri := range client.PostLogoutRedirectURIs {
+		if err := models.ValidateRedirectURI(uri); err != nil {
+			return nil, err
+		}
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate client ID: %w", err)
+	}
+	client.ID = oauthClientIDPrefix + hex.EncodeToString(idBytes)
+	client.TenantID = TenantFrom(ctx).ID
+	client.CreatedAt = time.Now()
+
+	registered := &RegisteredOAuthClient{Client: client}
+	if !client.Public {
+		secret, err := s.authService.GetSessionService().GenerateToken()
+		if err != nil {
+			return nil, err
+		}
+		registered.ClientSecret = oauthSecretPrefix + secret
+		client.SecretHash = hashToken(registered.ClientSecret)
+	}
+
+	if err := s.oauthRepo.CreateClient(ctx, client); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventOAuthClientRegistered,
+		ActorID:   actorID,
+		Details: map[string]interface{}{
+			"client_id":     client.ID,
+			"name":          client.Name,
+			"tenant_id":     client.TenantID,
+			"redirect_uris": client.RedirectURIs,
+			"public":        client.Public,
+		},
+	})
+
+	return registered, nil
+}
+
+// ListClients returns the OAuth clients of the request's tenant
+func (s *OAuthService) ListClients(ctx context.Context) ([]*models.OAuthClient, error) {
+	return s.oauthRepo.ListClients(ctx, TenantFrom(ctx).ID)
+}
+
+// DeleteClient removes an OAuth client on behalf of actorID, revoking its
+// refresh tokens and ending the sessions issued with them
+func (s *OAuthService) DeleteClient(ctx context.Context, actorID, clientID string) error {
+	client, err := s.oauthRepo.GetClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	ctx, err = s.clientContext(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	if err := s.authService.GetRefreshService().RevokeClientTokens(ctx, client.ID); err != nil {
+		return err
+	}
+	if err := s.oauthRepo.DeleteClient(ctx, client.ID); err != nil {
+		return err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventOAuthClientDeleted,
+		ActorID:   actorID,
+		Details: map[string]interface{}{
+			"client_id": client.ID,
+			"tenant_id": client.TenantID,
+		},
+	})
+	return nil
+}
+
+// ResolveClient loads the client of an authorization request and the
+// redirect URI to answer on, which may be omitted when the client has
+// registered only one. Errors returned here must be shown to the user
+// rather than sent to the redirect URI, which cannot be trusted yet.
+func (s *OAuthService) ResolveClient(ctx context.Context, clientID, redirectURI string) (*models.OAuthClient, string, error) {
+	if clientID == "" {
+		return nil, "", fmt.Errorf("client_id is required")
+	}
+	client, err := s.oauthRepo.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown client")
+	}
+
+	switch {
+	case redirectURI != "":
+		if !client.HasRedirectURI(redirectURI) {
+			return nil, "", fmt.Errorf("redirect_uri is not registered for this client")
+		}
+	case len(client.RedirectURIs) == 1:
+		redirectURI = client.RedirectURIs[0]
+	default:
+		return nil, "", fmt.Errorf("redirect_uri is required")
+	}
+
+	return client, redirectURI, nil
+}
+
+// CheckAuthorization validates an authorization request for a resolved
+// client. Requests without scopes are given the client's scopes. OpenID
+// Connect scopes may be requested by any client.
+func (s *OAuthService) CheckAuthorization(client *models.OAuthClient, req *AuthorizationRequest) error {
+	if req.ResponseType != "code" {
+		return oauthError(OAuthUnsupportedResponseType, "only the code response type is supported")
+	}
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return oauthError(OAuthUnauthorizedClient, "client may not use the authorization code grant")
+	}
+
+	if err := models.ValidateScopes(req.Scopes); err != nil {
+		return oauthError(OAuthInvalidScope, "%v", err)
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = client.Scopes
+	}
+	if !models.CoversScopes(client.Scopes, resourceScopes(req.Scopes)) {
+		return oauthError(OAuthInvalidScope, "requested scopes exceed the client's scopes")
+	}
+	if hasScope(req.Scopes, ScopeOpenID) && !s.OIDCEnabled() {
+		return oauthError(OAuthInvalidScope, "OpenID Connect is not enabled")
+	}
+	if len(req.Nonce) > maxNonceLength {
+		return oauthError(OAuthInvalidRequest, "nonce is too long")
+	}
+
+	if req.CodeChallenge == "" {
+		if client.Public {
+			return oauthError(OAuthInvalidRequest, "public clients must send a PKCE code_challenge")
+		}
+		return nil
+	}
+	if req.CodeChallengeMethod != PKCEMethodS256 {
+		return oauthError(OAuthInvalidRequest, "code_challenge_method must be %s", PKCEMethodS256)
+	}
+	if !pkcePattern.MatchString(req.CodeChallenge) {
+		return oauthError(OAuthInvalidRequest, "malformed code_challenge")
+	}
+	return nil
+}
+
+// AuthenticateUser checks the password of a user of the client's tenant
+func (s *OAuthService) AuthenticateUser(ctx context.Context, client *models.OAuthClient, identifier, password string) (*models.User, error) {
+	ctx, err := s.clientContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return s.authService.Authenticate(ctx, identifier, password)
+}
+
+// AuthenticateBearer resolves the user owning a token of the client's
+// tenant, so an app holding a session can authorize another client
+// without the password being entered again. It also returns the token's
+// scopes, which bound the scopes the user can grant with it.
+func (s *OAuthService) AuthenticateBearer(ctx context.Context, client *models.OAuthClient, token string) (*models.User, []string, error) {
+	ctx, err := s.clientContext(ctx, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	principal, err := s.authService.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !principal.IsUser() {
+		return nil, nil, fmt.Errorf("only users can authorize clients")
+	}
+	if principal.ImpersonatorID != "" {
+		return nil, nil, ErrImpersonated
+	}
+
+	user, err := s.authService.FindUser(ctx, principal.ID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, principal.Scopes, nil
+}
+
+// IssueCode issues an authorization code for a user who approved req. The
+// code's scopes must be covered by granted, the scopes of the credential
+// the user authenticated with, and by the user's permissions; nil granted
+// means the user's full authority.
+func (s *OAuthService) IssueCode(ctx context.Context, client *models.OAuthClient, user *models.User, granted []string, req *AuthorizationRequest) (string, error) {
+	if user.TenantID != client.TenantID {
+		return "", oauthError(OAuthAccessDenied, "user does not belong to the client's tenant")
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = granted
+	}
+	if !models.CoversScopes(granted, resourceScopes(scopes)) {
+		return "", oauthError(OAuthInvalidScope, "requested scopes exceed the token's scopes")
+	}
+
+	// Without a scoped credential the user's own permissions are the bound
+	permissions, err := s.authService.GetRoleService().UserPermissions(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if !models.GrantsScopes(permissions, resourceScopes(scopes)) {
+		return "", oauthError(OAuthInvalidScope, "requested scopes exceed the user's permissions")
+	}
+
+	value, err := s.authService.GetSessionService().GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	code := &models.AuthorizationCode{
+		CodeHash:            hashToken(value),
+		ClientID:            client.ID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scopes:              scopes,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		ExpiresAt:           now.Add(s.codeTTL),
+		CreatedAt:           now,
+	}
+	if err := s.oauthRepo.CreateAuthorizationCode(ctx, code); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// AuthenticateClient checks the credentials of a registered client at the
+// token, introspection and revocation endpoints. Public clients send only
+// their client ID.
+func (s *OAuthService) AuthenticateClient(ctx context.Context, clientID, clientSecret string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, oauthError(OAuthInvalidClient, "client authentication required")
+	}
+	client, err := s.oauthRepo.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, oauthError(OAuthInvalidClient, "invalid client credentials")
+	}
+
+	if client.Public {
+		if clientSecret != "" {
+			return nil, oauthError(OAuthInvalidClient, "public clients have no secret")
+		}
+		return client, nil
+	}
+	if clientSecret == "" || subtle.ConstantTimeCompare([]byte(hashToken(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, oauthError(OAuthInvalidClient, "invalid client credentials")
+	}
+	return client, nil
+}
+
+// ExchangeCode redeems an authorization code for a session, an ID token if
+// the openid scope was granted and, if the client may use the refresh
+// token grant, a refresh token. A code that is
+// presented twice has been intercepted, so the tokens issued for it are
+// revoked.
+func (s *OAuthService) ExchangeCode(ctx context.Context, client *models.OAuthClient, value, redirectURI, verifier, clientIP string) (*OAuthToken, error) {
+	ctx, err := s.clientContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, oauthError(OAuthInvalidRequest, "code is required")
+	}
+
+	code, err := s.oauthRepo.GetAuthorizationCode(ctx, hashToken(value))
+	if err != nil || code.ClientID != client.ID {
+		return nil, oauthError(OAuthInvalidGrant, "invalid or expired authorization code")
+	}
+	if !code.UsedAt.IsZero() {
+		s.revokeCode(ctx, code)
+		return nil, oauthError(OAuthInvalidGrant, "invalid or expired authorization code")
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, oauthError(OAuthInvalidGrant, "invalid or expired authorization code")
+	}
+	if code.RedirectURI != redirectURI {
+		return nil, oauthError(OAuthInvalidGrant, "redirect_uri does not match the authorization request")
+	}
+	if err := verifyPKCE(code, verifier); err != nil {
+		return nil, err
+	}
+
+	// Losing this race means a concurrent request redeemed the code
+	redeemed, err := s.oauthRepo.MarkCodeUsed(ctx, code.CodeHash)
+	if err != nil {
+		return nil, err
+	}
+	if !redeemed {
+		return nil, oauthError(OAuthInvalidGrant, "invalid or expired authorization code")
+	}
+
+	user, err := s.authService.FindUser(ctx, code.UserID, "")
+	if err != nil {
+		return nil, oauthError(OAuthInvalidGrant, "invalid or expired authorization code")
+	}
+
+	session, err := s.authService.GetSessionService().CreateSession(ctx, user, SessionOptions{
+		ClientType: OAuthClientType,
+		ClientIP:   clientIP,
+		Scopes:     code.Scopes,
+		ClientID:   client.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	if err := s.oauthRepo.SetCodeSession(ctx, code.CodeHash, session.Token); err != nil {
+		fmt.Printf("Warning: failed to record session of authorization code: %v\n", err)
+	}
+
+	issued := &OAuthToken{Session: session}
+	if client.AllowsGrant(models.GrantRefreshToken) {
+		issued.Refresh, err = s.authService.GetRefreshService().Issue(ctx, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue refresh token: %w", err)
+		}
+	}
+	if hasScope(session.Scopes, ScopeOpenID) && s.OIDCEnabled() {
+		// The code was issued right after the user authenticated
+		issued.IDToken, err = s.issueIDToken(client, user, session, code.Nonce, code.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return issued, nil
+}
+
+// Refresh rotates a refresh token issued to the client. Scopes may be
+// requested again but not widened; the refreshed session keeps the scopes
+// of the original grant. A new ID token is issued if the grant included
+// the openid scope.
+func (s *OAuthService) Refresh(ctx context.Context, client *models.OAuthClient, presented string, scopes []string, clientIP string) (*OAuthToken, error) {
+	ctx, err := s.clientContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(models.GrantRefreshToken) {
+		return nil, oauthError(OAuthUnauthorizedClient, "client may not use the refresh token grant")
+	}
+	if presented == "" {
+		return nil, oauthError(OAuthInvalidRequest, "refresh_token is required")
+	}
+
+	refreshService := s.authService.GetRefreshService()
+	if len(scopes) > 0 {
+		current, err := refreshService.Lookup(ctx, presented)
+		if err == nil && current.ClientID == client.ID && !models.CoversScopes(current.Scopes, scopes) {
+			return nil, oauthError(OAuthInvalidScope, "requested scopes exceed the original grant")
+		}
+	}
+
+	session, refresh, err := refreshService.Rotate(ctx, presented, SessionOptions{
+		ClientType: OAuthClientType,
+		ClientIP:   clientIP,
+		ClientID:   client.ID,
+	})
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		return nil, oauthError(OAuthInvalidGrant, "%v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	issued := &OAuthToken{Session: session, Refresh: refresh}
+	if hasScope(session.Scopes, ScopeOpenID) && s.OIDCEnabled() {
+		user, err := s.authService.FindUser(ctx, session.UserID, "")
+		if err != nil {
+			return nil, err
+		}
+		issued.IDToken, err = s.issueIDToken(client, user, session, "", time.Time{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return issued, nil
+}
+
+// ClientCredentials exchanges a service account's client ID and secret
+// for a token. Service accounts are not tied to a tenant, so no refresh
+// token is issued and the token is valid in the default tenant.
+func (s *OAuthService) ClientCredentials(ctx context.Context, clientID, clientSecret string, scopes []string, clientIP string) (*OAuthToken, error) {
+	if err := models.ValidateScopes(scopes); err != nil {
+		return nil, oauthError(OAuthInvalidScope, "%v", err)
+	}
+
+	session, err := s.authService.ClientCredentials(ctx, clientID, clientSecret, scopes, clientIP)
+	if errors.Is(err, ErrInvalidClientCredentials) {
+		return nil, oauthError(OAuthInvalidClient, "%v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthToken{Session: session}, nil
+}
+
+// Introspect describes a token of the client's tenant for a protected
+// resource. Session tokens, signed access tokens and API keys are
+// described to any confidential client; refresh tokens only to the client
+// they were issued to.
+func (s *OAuthService) Introspect(ctx context.Context, client *models.OAuthClient, token string) (*Introspection, error) {
+	if client.Public {
+		return nil, oauthError(OAuthUnauthorizedClient, "public clients may not introspect tokens")
+	}
+	ctx, err := s.clientContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	inactive := &Introspection{}
+	switch {
+	case token == "":
+		return nil, oauthError(OAuthInvalidRequest, "token is required")
+
+	case strings.HasPrefix(token, refreshTokenPrefix):
+		refresh, err := s.authService.GetRefreshService().Lookup(ctx, token)
+		if err != nil || refresh.ClientID != client.ID || refresh.Status != models.RefreshTokenActive || time.Now().After(refresh.ExpiresAt) {
+			return inactive, nil
+		}
+		info := &Introspection{
+			Active:    true,
+			Scopes:    refresh.Scopes,
+			ClientID:  refresh.ClientID,
+			Subject:   refresh.UserID,
+			TenantID:  client.TenantID,
+			TokenType: "refresh_token",
+			ExpiresAt: refresh.ExpiresAt,
+			IssuedAt:  refresh.CreatedAt,
+		}
+		if user, err := s.authService.FindUser(ctx, refresh.UserID, ""); err == nil {
+			info.Username = user.Username
+		}
+		return info, nil
+
+	case IsAPIKey(token):
+		principal, err := s.authService.ValidateToken(ctx, token)
+		if err != nil {
+			return inactive, nil
+		}
+		return &Introspection{
+			Active:        true,
+			Scopes:        principal.Scopes,
+			Username:      principal.Name,
+			Subject:       principal.ID,
+			PrincipalType: principal.Type,
+			TenantID:      principal.TenantID,
+			TokenType:     "api_key",
+		}, nil
+
+	case s.authService.GetTokenService() != nil && tokenpkg.LooksLikeJWT(token):
+		principal, err := s.authService.ValidateToken(ctx, token)
+		if err != nil {
+			return inactive, nil
+		}
+		claims, err := s.authService.GetTokenService().VerifyAccessToken(token)
+		if err != nil {
+			return inactive, nil
+		}
+		return &Introspection{
+			Active:        true,
+			Scopes:        principal.Scopes,
+			Username:      principal.Name,
+			Subject:       principal.ID,
+			PrincipalType: principal.Type,
+			TenantID:      principal.TenantID,
+			TokenType:     "Bearer",
+			ExpiresAt:     claims.ExpiresAt.Time,
+			IssuedAt:      claims.IssuedAt.Time,
+		}, nil
+	}
+
+	session, err := s.authService.GetSessionService().GetSession(ctx, token)
+	if err != nil {
+		return inactive, nil
+	}
+	if session.PrincipalType == models.PrincipalServiceAccount {
+		// Disabled service accounts lose their tokens at once
+		if _, err := s.authService.GetServiceAccountService().Principal(ctx, session.UserID); err != nil {
+			return inactive, nil
+		}
+	}
+
+	return &Introspection{
+		Active:        true,
+		Scopes:        session.Scopes,
+		ClientID:      session.ClientID,
+		Username:      session.Username,
+		Subject:       session.UserID,
+		PrincipalType: session.PrincipalType,
+		TenantID:      session.TenantID,
+		TokenType:     "Bearer",
+		ExpiresAt:     session.ExpiresAt,
+		IssuedAt:      session.CreatedAt,
+		Actor:         session.ImpersonatorID,
+	}, nil
+}
+
+// Revoke revokes an access or refresh token issued to the client. Revoking
+// either ends the session and its refresh token family. Unknown tokens and
+// tokens of other clients are ignored, as RFC 7009 asks.
+func (s *OAuthService) Revoke(ctx context.Context, client *models.OAuthClient, token string) error {
+	ctx, err := s.clientContext(ctx, client)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return oauthError(OAuthInvalidRequest, "token is required")
+	}
+
+	if strings.HasPrefix(token, refreshTokenPrefix) {
+		return s.authService.GetRefreshService().Revoke(ctx, token, client.ID)
+	}
+
+	session, err := s.authService.GetSessionService().GetSession(ctx, token)
+	if err != nil || session.ClientID != client.ID {
+		return nil
+	}
+	return s.authService.Logout(ctx, token)
+}
+
+// revokeCode ends the session issued for an authorization code that was
+// presented again and records a security event
+func (s *OAuthService) revokeCode(ctx context.Context, code *models.AuthorizationCode) {
+	if code.SessionToken != "" {
+		if err := s.authService.Logout(ctx, code.SessionToken); err != nil {
+			fmt.Printf("Warning: failed to revoke session of reused authorization code: %v\n", err)
+		}
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventOAuthCodeReuse,
+		UserID:    code.UserID,
+		Details: map[string]interface{}{
+			"client_id": code.ClientID,
+		},
+	})
+}
+
+// clientContext serves a request in the client's tenant
+func (s *OAuthService) clientContext(ctx context.Context, client *models.OAuthClient) (context.Context, error) {
+	tenant, err := s.authService.GetTenantService().Get(ctx, client.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	return WithTenant(ctx, tenant), nil
+}
+
+// verifyPKCE checks the code verifier against the code's challenge. A
+// verifier sent for a code issued without a challenge is rejected too.
+func verifyPKCE(code *models.AuthorizationCode, verifier string) error {
+	if code.CodeChallenge == "" {
+		if verifier != "" {
+			return oauthError(OAuthInvalidGrant, "code_verifier sent for a request without code_challenge")
+		}
+		return nil
+	}
+	if !pkcePattern.MatchString(verifier) {
+		return oauthError(OAuthInvalidGrant, "invalid code_verifier")
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+		return oauthError(OAuthInvalidGrant, "invalid code_verifier")
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+// addOAuthClient registers a client of the default tenant that may use the
+// authorization code and refresh token grants
+func (ts *testServices) addOAuthClient(public bool) *models.OAuthClient {
+	client := &models.OAuthClient{
+		ID:           oauthClientIDPrefix + "app",
+		TenantID:     models.DefaultTenantID,
+		Name:         "App",
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		Public:       public,
+	}
+	_ = ts.oauth.CreateClient(context.Background(), client)
+	return client
+}
+
+// oauthErrorCode returns the OAuth error code of err, or "" if err is not
+// an OAuth error
+func oauthErrorCode(err error) string {
+	var oerr *OAuthError
+	if errors.As(err, &oerr) {
+		return oerr.Code
+	}
+	return ""
+}
+
+// pkceChallenge returns the S256 code challenge of a verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// issueCode authorizes a client for user with the user's full authority
+func issueCode(t *testing.T, ts *testServices, client *models.OAuthClient, user *models.User, req *AuthorizationRequest) string {
+	t.Helper()
+	req.ClientID = client.ID
+	req.RedirectURI = testRedirectURI
+	req.ResponseType = "code"
+	if err := ts.oauthService.CheckAuthorization(client, req); err != nil {
+		t.Fatalf("CheckAuthorization: %v", err)
+	}
+	code, err := ts.oauthService.IssueCode(context.Background(), client, user, nil, req)
+	if err != nil {
+		t.Fatalf("IssueCode: %v", err)
+	}
+	return code
+}
+
+func TestOAuthExchangeCode(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	ts.roles.permissions["shopper"] = []string{"cart:*"}
+	user := ts.addUser("alice", "shopper")
+	client := ts.addOAuthClient(false)
+
+	code := issueCode(t, ts, client, user, &AuthorizationRequest{Scopes: []string{"cart:read"}})
+	issued, err := ts.oauthService.ExchangeCode(ctx, client, code, testRedirectURI, "", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("ExchangeCode: %v", err)
+	}
+	if !ts.sessionAlive(issued.Session.Token) {
+		t.Error("the issued session is not valid")
+	}
+	if issued.Session.ClientID != client.ID || issued.Session.ClientType != OAuthClientType {
+		t.Errorf("session client = %q (%s), want %q (%s)", issued.Session.ClientID, issued.Session.ClientType, client.ID, OAuthClientType)
+	}
+	if len(issued.Session.Scopes) != 1 || issued.Session.Scopes[0] != "cart:read" {
+		t.Errorf("Scopes = %v, want [cart:read]", issued.Session.Scopes)
+	}
+	if issued.Refresh == nil {
+		t.Fatal("no refresh token was issued")
+	}
+
+	// The refresh token is bound to the client
+	if _, err := ts.oauthService.Refresh(ctx, client, issued.Refresh.Token, nil, ""); err != nil {
+		t.Errorf("Refresh: %v", err)
+	}
+}
+
+func TestOAuthCodeReuseRevokesTokens(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	client := ts.addOAuthClient(false)
+
+	code := issueCode(t, ts, client, user, &AuthorizationRequest{})
+	issued, err := ts.oauthService.ExchangeCode(ctx, client, code, testRedirectURI, "", "")
+	if err != nil {
+		t.Fatalf("ExchangeCode: %v", err)
+	}
+
+	// A code presented twice was intercepted
+	if _, err := ts.oauthService.ExchangeCode(ctx, client, code, testRedirectURI, "", ""); oauthErrorCode(err) != OAuthInvalidGrant {
+		t.Fatalf("second ExchangeCode = %v, want %s", err, OAuthInvalidGrant)
+	}
+	if ts.sessionAlive(issued.Session.Token) {
+		t.Error("the session issued for the reused code is still valid")
+	}
+	if _, err := ts.oauthService.Refresh(ctx, client, issued.Refresh.Token, nil, ""); oauthErrorCode(err) != OAuthInvalidGrant {
+		t.Errorf("Refresh after code reuse = %v, want %s", err, OAuthInvalidGrant)
+	}
+
+	events := ts.audit.eventTypes(user.ID)
+	if len(events) != 1 || events[0] != models.EventOAuthCodeReuse {
+		t.Errorf("audit events = %v, want [%s]", events, models.EventOAuthCodeReuse)
+	}
+}
+
+func TestOAuthExchangeCodeRejects(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	client := ts.addOAuthClient(false)
+	other := &models.OAuthClient{ID: oauthClientIDPrefix + "other", TenantID: models.DefaultTenantID}
+
+	code := issueCode(t, ts, client, user, &AuthorizationRequest{})
+	expired := issueCode(t, ts, client, user, &AuthorizationRequest{})
+	ts.oauth.codes[hashToken(expired)].ExpiresAt = time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name        string
+		client      *models.OAuthClient
+		code        string
+		redirectURI string
+	}{
+		{"unknown code", client, "unknown", testRedirectURI},
+		{"other client", other, code, testRedirectURI},
+		{"other redirect URI", client, code, "https://evil.example.com/callback"},
+		{"expired", client, expired, testRedirectURI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ts.oauthService.ExchangeCode(ctx, tt.client, tt.code, tt.redirectURI, "", ""); oauthErrorCode(err) != OAuthInvalidGrant {
+				t.Errorf("ExchangeCode = %v, want %s", err, OAuthInvalidGrant)
+			}
+		})
+	}
+
+	// None of the refused attempts used the code up
+	if _, err := ts.oauthService.ExchangeCode(ctx, client, code, testRedirectURI, "", ""); err != nil {
+		t.Errorf("ExchangeCode after refused attempts: %v", err)
+	}
+}
+
+func TestOAuthPKCE(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	client := ts.addOAuthClient(true)
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	// Public clients must use PKCE
+	req := &AuthorizationRequest{ClientID: client.ID, RedirectURI: testRedirectURI, ResponseType: "code"}
+	if err := ts.oauthService.CheckAuthorization(client, req); oauthErrorCode(err) != OAuthInvalidRequest {
+		t.Errorf("CheckAuthorization without a challenge = %v, want %s", err, OAuthInvalidRequest)
+	}
+	req.CodeChallenge = pkceChallenge(verifier)
+	req.CodeChallengeMethod = "plain"
+	if err := ts.oauthService.CheckAuthorization(client, req); oauthErrorCode(err) != OAuthInvalidRequest {
+		t.Errorf("CheckAuthorization with the plain method = %v, want %s", err, OAuthInvalidRequest)
+	}
+
+	code := issueCode(t, ts, client, user, &AuthorizationRequest{
+		CodeChallenge:       pkceChallenge(verifier),
+		CodeChallengeMethod: PKCEMethodS256,
+	})
+	for _, wrong := range []string{"", "short", "aBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"} {
+		if _, err := ts.oauthService.ExchangeCode(ctx, client, code, testRedirectURI, wrong, ""); oauthErrorCode(err) != OAuthInvalidGrant {
+			t.Errorf("ExchangeCode with verifier %q = %v, want %s", wrong, err, OAuthInvalidGrant)
+		}
+	}
+	if _, err := ts.oauthService.ExchangeCode(ctx, client, code, testRedirectURI, verifier, ""); err != nil {
+		t.Errorf("ExchangeCode with the verifier: %v", err)
+	}
+
+	// A verifier sent for a code issued without a challenge is refused
+	confidential := ts.addOAuthClient(false)
+	plain := issueCode(t, ts, confidential, user, &AuthorizationRequest{})
+	if _, err := ts.oauthService.ExchangeCode(ctx, confidential, plain, testRedirectURI, verifier, ""); oauthErrorCode(err) != OAuthInvalidGrant {
+		t.Errorf("ExchangeCode with an unexpected verifier = %v, want %s", err, OAuthInvalidGrant)
+	}
+}
+
+func TestOAuthIssueCodeScopes(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	ts.roles.permissions["shopper"] = []string{"cart:*"}
+	user := ts.addUser("alice", "shopper")
+	client := ts.addOAuthClient(false)
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		granted []string
+		scopes  []string
+		want    string
+	}{
+		{"within permissions", user, nil, []string{"openid", "cart:read"}, ""},
+		{"beyond permissions", user, nil, []string{"users:delete"}, OAuthInvalidScope},
+		{"within the granted scopes", user, []string{"cart:read"}, []string{"cart:read"}, ""},
+		{"beyond the granted scopes", user, []string{"cart:read"}, []string{"cart:write"}, OAuthInvalidScope},
+		{"other tenant", &models.User{ID: user.ID, TenantID: "acme"}, nil, nil, OAuthAccessDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &AuthorizationRequest{ClientID: client.ID, RedirectURI: testRedirectURI, ResponseType: "code", Scopes: tt.scopes}
+			_, err := ts.oauthService.IssueCode(ctx, client, tt.user, tt.granted, req)
+			if got := oauthErrorCode(err); got != tt.want || (tt.want == "" && err != nil) {
+				t.Errorf("IssueCode = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
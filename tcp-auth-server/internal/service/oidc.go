@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Connect scopes. They name the claims a client may read rather
+// than permissions, so a client's scopes do not bound them.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// OpenID Connect error codes (OpenID Connect Core section 3.1.2.6) and
+// bearer token error codes (RFC 6750 section 3.1)
+const (
+	OAuthLoginRequired     = "login_required"
+	OAuthInvalidToken      = "invalid_token"
+	OAuthInsufficientScope = "insufficient_scope"
+)
+
+// maxNonceLength bounds the nonce of an authorization request, which is
+// stored with the code
+const maxNonceLength = 255
+
+// UserClaims are the standard claims about a user released for the
+// profile and email scopes
+type UserClaims struct {
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	UpdatedAt         int64  `json:"updated_at,omitempty"`
+	Email             string `json:"email,omitempty"`
+}
+
+// IDClaims are the claims of an ID token
+type IDClaims struct {
+	UserClaims
+	Nonce     string           `json:"nonce,omitempty"`
+	AuthTime  *jwt.NumericDate `json:"auth_time,omitempty"`
+	SessionID string           `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserInfo is the response of the userinfo endpoint
+type UserInfo struct {
+	Subject string `json:"sub"`
+	UserClaims
+}
+
+// OIDCEnabled reports whether the OpenID Connect layer is enabled. It
+// needs an issuer and the signed access token keys to sign ID tokens.
+func (s *OAuthService) OIDCEnabled() bool {
+	return s.issuer != "" && s.authService.GetTokenService() != nil
+}
+
+// Issuer returns the OpenID Connect issuer of a tenant. Every tenant is
+// an issuer of its own, so relying parties of one tenant never accept ID
+// tokens of another and the userinfo endpoint knows which tenant a token
+// belongs to.
+func (s *OAuthService) Issuer(tenantID string) string {
+	if tenantID == "" || tenantID == models.DefaultTenantID {
+		return s.issuer
+	}
+	return s.issuer + "/tenants/" + tenantID
+}
+
+// IDTokenAlgorithm returns the algorithm ID tokens are signed with
+func (s *OAuthService) IDTokenAlgorithm() string {
+	return s.authService.GetTokenService().Algorithm()
+}
+
+// UserInfo returns the claims about the user an access token was issued
+// for, as allowed by the token's scopes. The token must be valid in the
+// request's tenant and carry the openid scope.
+func (s *OAuthService) UserInfo(ctx context.Context, token string) (*UserInfo, error) {
+	if token == "" {
+		return nil, oauthError(OAuthInvalidToken, "access token is required")
+	}
+
+	principal, err := s.authService.ValidateToken(ctx, token)
+	if err != nil || !principal.IsUser() {
+		return nil, oauthError(OAuthInvalidToken, "invalid or expired access token")
+	}
+	if !hasScope(principal.Scopes, ScopeOpenID) {
+		return nil, oauthError(OAuthInsufficientScope, "the access token was not issued for the openid scope")
+	}
+
+	user, err := s.authService.FindUser(ctx, principal.ID, "")
+	if err != nil {
+		return nil, oauthError(OAuthInvalidToken, "invalid or expired access token")
+	}
+
+	return &UserInfo{
+		Subject:    user.ID,
+		UserClaims: userClaims(user, principal.Scopes),
+	}, nil
+}
+
+// EndSession serves RP-initiated logout: it ends the session an ID token
+// was issued with. The ID token may have expired. If postLogoutRedirectURI
+// is set it must be registered for the client the token was issued to.
+// Errors returned here are shown to the user.
+func (s *OAuthService) EndSession(ctx context.Context, idTokenHint, clientID, postLogoutRedirectURI string) error {
+	if !s.OIDCEnabled() {
+		return fmt.Errorf("OpenID Connect is not enabled")
+	}
+	if idTokenHint == "" {
+		return fmt.Errorf("id_token_hint is required")
+	}
+
+	// Logout is still allowed with an expired ID token, so only the
+	// signature is checked by the parser
+	var claims IDClaims
+	if err := s.authService.GetTokenService().ParseClaims(idTokenHint, &claims, jwt.WithoutClaimsValidation()); err != nil {
+		return fmt.Errorf("invalid id_token_hint")
+	}
+	if len(claims.Audience) != 1 || claims.Subject == "" {
+		return fmt.Errorf("invalid id_token_hint")
+	}
+	if clientID != "" && clientID != claims.Audience[0] {
+		return fmt.Errorf("id_token_hint was not issued to this client")
+	}
+
+	client, err := s.oauthRepo.GetClient(ctx, claims.Audience[0])
+	if err != nil || claims.Issuer != s.Issuer(client.TenantID) {
+		return fmt.Errorf("invalid id_token_hint")
+	}
+	if postLogoutRedirectURI != "" && !client.HasPostLogoutRedirectURI(postLogoutRedirectURI) {
+		return fmt.Errorf("post_logout_redirect_uri is not registered for this client")
+	}
+
+	ctx, err = s.clientContext(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	// The session may have ended already, which is not an error
+	session, err := s.authService.GetSessionService().FindUserSession(ctx, claims.Subject, claims.SessionID)
+	if err != nil || session.ClientID != client.ID {
+		return nil
+	}
+	return s.authService.Logout(ctx, session.Token)
+}
+
+// issueIDToken signs an ID token for a session issued to the client.
+// authTime is when the user authenticated; zero leaves it out.
+func (s *OAuthService) issueIDToken(client *models.OAuthClient, user *models.User, session *models.Session, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	claims := IDClaims{
+		UserClaims: userClaims(user, session.Scopes),
+		Nonce:      nonce,
+		SessionID:  session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.Issuer(client.TenantID),
+			Subject:   user.ID,
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.idTokenTTL)),
+		},
+	}
+	if !authTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+
+	idToken, err := s.authService.GetTokenService().SignClaims(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to issue ID token: %w", err)
+	}
+	return idToken, nil
+}
+
+// userClaims returns the claims about a user released for scopes
+func userClaims(user *models.User, scopes []string) UserClaims {
+	var claims UserClaims
+	if hasScope(scopes, ScopeProfile) {
+		claims.PreferredUsername = user.Username
+		if !user.UpdatedAt.IsZero() {
+			claims.UpdatedAt = user.UpdatedAt.Unix()
+		}
+	}
+	if hasScope(scopes, ScopeEmail) {
+		claims.Email = user.Email
+	}
+	return claims
+}
+
+// hasScope reports whether scopes contains scope exactly. Unlike
+// permissions, OpenID Connect scopes are not granted by wildcards.
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentityScope reports whether scope is an OpenID Connect scope
+func isIdentityScope(scope string) bool {
+	return scope == ScopeOpenID || scope == ScopeProfile || scope == ScopeEmail
+}
+
+// resourceScopes returns the scopes that are not OpenID Connect scopes
+func resourceScopes(scopes []string) []string {
+	var resource []string
+	for _, scope := range scopes {
+		if !isIdentityScope(scope) {
+			resource = append(resource, scope)
+		}
+	}
+	return resource
+}
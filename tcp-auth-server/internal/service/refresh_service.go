@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// refreshTokenPrefix distinguishes refresh tokens from session tokens
+const refreshTokenPrefix = "rt_"
+
+// ErrInvalidRefreshToken is returned for unknown or expired refresh tokens
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// ErrRefreshTokenReused is returned when an already rotated refresh token
+// is presented again. The whole family has been revoked by then.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected, all sessions of this login were revoked")
+
+// IssuedRefreshToken is a refresh token value handed to the client
+type IssuedRefreshToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// RefreshService issues refresh tokens and rotates them on every use.
+//
+// All tokens descending from one login form a family. Presenting a token
+// that has already been rotated means two parties hold the same token, so
+// the entire family and its sessions are revoked and a security event is
+// recorded. The legitimate user simply has to log in again; the attacker
+// loses access.
+type RefreshService struct {
+	refreshRepo    RefreshTokenStore
+	userRepo       UserStore
+	sessionService *SessionService
+	auditService   *AuditService
+	refreshTTL     time.Duration
+}
+
+// NewRefreshService creates a new refresh token service
+func NewRefreshService(
+	refreshRepo RefreshTokenStore,
+	userRepo UserStore,
+	sessionService *SessionService,
+	auditService *AuditService,
+	refreshTTL time.Duration,
+) *RefreshService {
+	return &RefreshService{
+		refreshRepo:    refreshRepo,
+		userRepo:       userRepo,
+		sessionService: sessionService,
+		auditService:   auditService,
+		refreshTTL:     refreshTTL,
+	}
+}
+
+// Issue starts a new token family for a freshly created session. The
+// family expires after the refresh token TTL, however often it rotates.
+func (s *RefreshService) Issue(ctx context.Context, session *models.Session) (*IssuedRefreshToken, error) {
+	return s.issue(ctx, uuid.New().String(), "", session, time.Now().Add(s.refreshTTL))
+}
+
+// Rotate exchanges a refresh token for a new session and a new refresh
+// token in the same family. The presented token and its session become
+// invalid. A token is only accepted from the OAuth client in opts.ClientID
+// it was issued to, or over the TCP protocol when that is empty.
+func (s *RefreshService) Rotate(ctx context.Context, presented string, opts SessionOptions) (*models.Session, *IssuedRefreshToken, error) {
+	current, err := s.refreshRepo.GetRefreshTokenByHash(ctx, hashToken(presented))
+	if err != nil || current.ClientID != opts.ClientID {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	// A token is only accepted in its user's tenant
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil || user.TenantID != TenantFrom(ctx).ID {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	return s.rotate(ctx, current, user, opts)
+}
+
+// RotateSession exchanges a session token for a new session as if the
+// refresh token issued with the session had been presented: the new
+// session and refresh token continue its family, and the family's
+// previous token counts as reused from then on. A session without a
+// refresh token is given a new family that ends with the session.
+func (s *RefreshService) RotateSession(ctx context.Context, session *models.Session, opts SessionOptions) (*models.Session, *IssuedRefreshToken, error) {
+	if session.PrincipalType == models.PrincipalServiceAccount {
+		return nil, nil, fmt.Errorf("service account tokens cannot be refreshed")
+	}
+	if session.Impersonated() {
+		return nil, nil, ErrImpersonated
+	}
+	if opts.ClientType == "" {
+		opts.ClientType = session.ClientType
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = session.UserAgent
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user not found: %w", err)
+	}
+
+	current, err := s.refreshRepo.GetActiveTokenBySession(ctx, session.Token)
+	if err == nil {
+		opts.ClientID = current.ClientID
+		return s.rotate(ctx, current, user, opts)
+	}
+	if !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil, nil, err
+	}
+
+	// The new session keeps the absolute expiry of the old one, so
+	// refreshing cannot keep a login alive forever
+	deadline := session.AbsoluteExpiresAt
+	if deadline.IsZero() {
+		deadline = session.ExpiresAt
+	}
+	if !time.Now().Before(deadline) {
+		return nil, nil, fmt.Errorf("session expired")
+	}
+
+	// Any family the session still belongs to ends with it
+	if err := s.refreshRepo.RevokeBySession(ctx, session.Token); err != nil {
+		return nil, nil, err
+	}
+	if err := s.sessionService.DeleteSession(ctx, session.Token); err != nil {
+		fmt.Printf("Warning: failed to delete refreshed session: %v\n", err)
+	}
+
+	opts.Scopes = session.Scopes
+	opts.ClientID = session.ClientID
+	opts.Lifetime = time.Until(deadline)
+	refreshed, err := s.sessionService.CreateSession(ctx, user, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	issued, err := s.issue(ctx, uuid.New().String(), "", refreshed, deadline)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return refreshed, issued, nil
+}
+
+// rotate replaces the current token of a family and its session with a
+// new session and a child token
+func (s *RefreshService) rotate(ctx context.Context, current *models.RefreshToken, user *models.User, opts SessionOptions) (*models.Session, *IssuedRefreshToken, error) {
+	switch current.Status {
+	case models.RefreshTokenRotated:
+		s.revokeFamily(ctx, current)
+		return nil, nil, ErrRefreshTokenReused
+	case models.RefreshTokenRevoked:
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	// Tokens issued before families had a deadline keep their own expiry
+	deadline := current.FamilyExpiresAt
+	if deadline.IsZero() || current.ExpiresAt.Before(deadline) {
+		deadline = current.ExpiresAt
+	}
+	if !time.Now().Before(deadline) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	// Losing this race means a concurrent request already rotated the token
+	rotated, err := s.refreshRepo.MarkRotated(ctx, current.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rotated {
+		s.revokeFamily(ctx, current)
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	if err := s.sessionService.DeleteSession(ctx, current.SessionToken); err != nil {
+		fmt.Printf("Warning: failed to delete rotated session: %v\n", err)
+	}
+
+	// A refreshed session never gains authority the family did not have,
+	// nor outlives it
+	opts.Scopes = current.Scopes
+	opts.Lifetime = time.Until(deadline)
+	session, err := s.sessionService.CreateSession(ctx, user, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	issued, err := s.issue(ctx, current.FamilyID, current.ID, session, deadline)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return session, issued, nil
+}
+
+// Lookup returns the refresh token with the presented value, whatever its
+// status
+func (s *RefreshService) Lookup(ctx context.Context, presented string) (*models.RefreshToken, error) {
+	token, err := s.refreshRepo.GetRefreshTokenByHash(ctx, hashToken(presented))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return token, nil
+}
+
+// Revoke revokes the family of a refresh token issued to an OAuth client
+// together with its sessions. Unknown tokens and tokens of other clients
+// are ignored.
+func (s *RefreshService) Revoke(ctx context.Context, presented, clientID string) error {
+	token, err := s.refreshRepo.GetRefreshTokenByHash(ctx, hashToken(presented))
+	if err != nil || token.ClientID != clientID {
+		return nil
+	}
+
+	sessionTokens, err := s.refreshRepo.RevokeFamily(ctx, token.FamilyID)
+	if err != nil {
+		return err
+	}
+	s.deleteSessions(ctx, sessionTokens)
+	return nil
+}
+
+// RevokeClientTokens revokes every refresh token issued to an OAuth client
+// and ends their sessions
+func (s *RefreshService) RevokeClientTokens(ctx context.Context, clientID string) error {
+	sessionTokens, err := s.refreshRepo.RevokeClientTokens(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	s.deleteSessions(ctx, sessionTokens)
+	return nil
+}
+
+// deleteSessions ends the sessions issued with revoked refresh tokens
+func (s *RefreshService) deleteSessions(ctx context.Context, sessionTokens []string) {
+	for _, sessionToken := range sessionTokens {
+		if err := s.sessionService.DeleteSession(ctx, sessionToken); err != nil {
+			fmt.Printf("Warning: failed to delete session of revoked refresh token: %v\n", err)
+		}
+	}
+}
+
+// RevokeForSession revokes the token family issued with a session, so
+// logging out also invalidates the refresh token
+func (s *RefreshService) RevokeForSession(ctx context.Context, sessionToken string) error {
+	return s.refreshRepo.RevokeBySession(ctx, sessionToken)
+}
+
+// RevokeUserTokens revokes every refresh token of a user
+func (s *RefreshService) RevokeUserTokens(ctx context.Context, userID string) error {
+	return s.refreshRepo.RevokeUserTokens(ctx, userID)
+}
+
+// issue creates and stores a refresh token in a family that expires at
+// familyExpiresAt
+func (s *RefreshService) issue(ctx context.Context, familyID, parentID string, session *models.Session, familyExpiresAt time.Time) (*IssuedRefreshToken, error) {
+	secret, err := s.sessionService.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	value := refreshTokenPrefix + secret
+
+	now := time.Now()
+	expiresAt := now.Add(s.refreshTTL)
+	if familyExpiresAt.Before(expiresAt) {
+		expiresAt = familyExpiresAt
+	}
+	token := &models.RefreshToken{
+		ID:           uuid.New().String(),
+		FamilyID:     familyID,
+		ParentID:     parentID,
+		UserID:       session.UserID,
+		TokenHash:    hashToken(value),
+		SessionToken: session.Token,
+		Status:       models.RefreshTokenActive,
+		ExpiresAt:    expiresAt,
+		CreatedAt:    now,
+		Scopes:       session.Scopes,
+		ClientID:     session.ClientID,
+
+		FamilyExpiresAt: familyExpiresAt,
+	}
+
+	if err := s.refreshRepo.CreateRefreshToken(ctx, token); err != nil {
+		return nil, err
+	}
+
+	return &IssuedRefreshToken{Token: value, ExpiresAt: token.ExpiresAt}, nil
+}
+
+// revokeFamily revokes a token family after reuse was detected, ends every
+// session issued in it and records a security event
+func (s *RefreshService) revokeFamily(ctx context.Context, reused *models.RefreshToken) {
+	sessionTokens, err := s.refreshRepo.RevokeFamily(ctx, reused.FamilyID)
+	if err != nil {
+		fmt.Printf("Warning: failed to revoke refresh token family %s: %v\n", reused.FamilyID, err)
+	}
+
+	for _, sessionToken := range sessionTokens {
+		if err := s.sessionService.DeleteSession(ctx, sessionToken); err != nil {
+			fmt.Printf("Warning: failed to delete session of revoked family: %v\n", err)
+		}
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventRefreshTokenReuse,
+		UserID:    reused.UserID,
+		Details: map[string]interface{}{
+			"family_id":        reused.FamilyID,
+			"token_id":         reused.ID,
+			"token_status":     reused.Status,
+			"revoked_sessions": len(sessionTokens),
+		},
+	})
+}
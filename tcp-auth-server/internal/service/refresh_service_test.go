@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+func TestRefreshRotate(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	session, refresh := ts.login(t, ts.addUser("alice"))
+
+	rotated, next, err := ts.refreshService.Rotate(ctx, refresh.Token, SessionOptions{ClientType: "web"})
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if next.Token == refresh.Token {
+		t.Error("Rotate returned the presented refresh token")
+	}
+	if ts.sessionAlive(session.Token) {
+		t.Error("the rotated session is still valid")
+	}
+	if !ts.sessionAlive(rotated.Token) {
+		t.Error("the new session is not valid")
+	}
+
+	current, err := ts.refresh.GetRefreshTokenByHash(ctx, hashToken(next.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	family := ts.refresh.family(current.FamilyID)
+	if len(family) != 2 {
+		t.Fatalf("family has %d tokens, want 2", len(family))
+	}
+	if family[0].Status != models.RefreshTokenRotated || family[1].Status != models.RefreshTokenActive {
+		t.Errorf("family statuses = %s, %s; want rotated, active", family[0].Status, family[1].Status)
+	}
+	if current.ParentID != family[0].ID {
+		t.Errorf("new token's parent = %q, want %q", current.ParentID, family[0].ID)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	_, refresh := ts.login(t, user)
+
+	rotated, next, err := ts.refreshService.Rotate(ctx, refresh.Token, SessionOptions{})
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	// Presenting the first token again means someone else holds a copy
+	if _, _, err := ts.refreshService.Rotate(ctx, refresh.Token, SessionOptions{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Rotate of a rotated token = %v, want ErrRefreshTokenReused", err)
+	}
+	if ts.sessionAlive(rotated.Token) {
+		t.Error("the session of the revoked family is still valid")
+	}
+	if _, _, err := ts.refreshService.Rotate(ctx, next.Token, SessionOptions{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate of a revoked token = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	events := ts.audit.eventTypes(user.ID)
+	if len(events) != 1 || events[0] != models.EventRefreshTokenReuse {
+		t.Errorf("audit events = %v, want [%s]", events, models.EventRefreshTokenReuse)
+	}
+}
+
+func TestRefreshRotateRejects(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	_, refresh := ts.login(t, ts.addUser("alice"))
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		presented string
+		opts      SessionOptions
+	}{
+		{"unknown token", ctx, "rt_unknown", SessionOptions{}},
+		{"other client", ctx, refresh.Token, SessionOptions{ClientID: "app"}},
+		{"other tenant", WithTenant(ctx, &models.Tenant{ID: "acme"}), refresh.Token, SessionOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ts.refreshService.Rotate(tt.ctx, tt.presented, tt.opts); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("Rotate = %v, want ErrInvalidRefreshToken", err)
+			}
+		})
+	}
+
+	// None of the refused attempts used the token up
+	if _, _, err := ts.refreshService.Rotate(ctx, refresh.Token, SessionOptions{}); err != nil {
+		t.Errorf("Rotate after refused attempts: %v", err)
+	}
+}
+
+func TestRefreshSessionContinuesFamily(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	session, refresh := ts.login(t, ts.addUser("alice"))
+
+	refreshed, next, err := ts.refreshService.RotateSession(ctx, session, SessionOptions{})
+	if err != nil {
+		t.Fatalf("RotateSession: %v", err)
+	}
+	if ts.sessionAlive(session.Token) {
+		t.Error("the refreshed session is still valid")
+	}
+	if !ts.sessionAlive(refreshed.Token) {
+		t.Error("the new session is not valid")
+	}
+
+	first, err := ts.refresh.GetRefreshTokenByHash(ctx, hashToken(refresh.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := ts.refresh.GetRefreshTokenByHash(ctx, hashToken(next.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.FamilyID != first.FamilyID {
+		t.Error("refreshing with a session token started a new family")
+	}
+
+	// The refresh token of the old session now counts as reused
+	if _, _, err := ts.refreshService.Rotate(ctx, refresh.Token, SessionOptions{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Rotate of the old session's token = %v, want ErrRefreshTokenReused", err)
+	}
+	if ts.sessionAlive(refreshed.Token) {
+		t.Error("the session of the revoked family is still valid")
+	}
+}
+
+func TestRefreshSessionWithoutFamily(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	session, err := ts.sessionService.CreateSession(ctx, user, SessionOptions{ClientType: "web", ClientID: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, next, err := ts.refreshService.RotateSession(ctx, session, SessionOptions{})
+	if err != nil {
+		t.Fatalf("RotateSession: %v", err)
+	}
+	if ts.sessionAlive(session.Token) {
+		t.Error("the refreshed session is still valid")
+	}
+	if refreshed.ClientID != "app" {
+		t.Errorf("ClientID = %q, want app", refreshed.ClientID)
+	}
+	// The lifetime is carried over as a duration, so allow for the time
+	// the refresh took
+	if refreshed.AbsoluteExpiresAt.After(session.AbsoluteExpiresAt.Add(time.Second)) {
+		t.Error("the new session outlives the original login")
+	}
+
+	current, err := ts.refresh.GetRefreshTokenByHash(ctx, hashToken(next.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.SessionToken != refreshed.Token || current.ClientID != "app" {
+		t.Errorf("new family token = %+v, want it bound to the new session and client", current)
+	}
+	if current.FamilyExpiresAt.After(session.AbsoluteExpiresAt) {
+		t.Error("the new family outlives the original login")
+	}
+}
+
+func TestRefreshSessionRejectsImpersonation(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	session, err := ts.sessionService.CreateSession(ctx, user, SessionOptions{ImpersonatorID: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ts.refreshService.RotateSession(ctx, session, SessionOptions{}); !errors.Is(err, ErrImpersonated) {
+		t.Errorf("RotateSession of an impersonation = %v, want ErrImpersonated", err)
+	}
+	if !ts.sessionAlive(session.Token) {
+		t.Error("the refused session was ended")
+	}
+}
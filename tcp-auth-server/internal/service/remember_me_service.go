@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// rememberTokenPrefix distinguishes remember-me credentials from other tokens
+const rememberTokenPrefix = "pl_"
+
+// ErrInvalidRememberToken is returned for unknown, malformed or expired
+// remember-me credentials
+var ErrInvalidRememberToken = errors.New("invalid or expired remember-me token")
+
+// ErrRememberTokenTheft is returned when a stale token of a known series is
+// presented. All of the user's sessions have been revoked by then.
+var ErrRememberTokenTheft = errors.New("remember-me token was already used, all sessions were revoked")
+
+// IssuedRememberToken is a remember-me credential handed to the client
+type IssuedRememberToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// RememberMeService manages long-lived persistent login credentials.
+//
+// A credential is a series identifier plus a token. Exchanging it for a new
+// session rotates the token but keeps the series. If a series is presented
+// with a token that no longer matches, the credential was copied and one
+// copy has already been used, so every session of the user is revoked.
+type RememberMeService struct {
+	loginRepo      PersistentLoginStore
+	userRepo       UserStore
+	sessionService *SessionService
+	refreshService *RefreshService
+	auditService   *AuditService
+	ttl            time.Duration
+}
+
+// NewRememberMeService creates a new remember-me service
+func NewRememberMeService(
+	loginRepo PersistentLoginStore,
+	userRepo UserStore,
+	sessionService *SessionService,
+	refreshService *RefreshService,
+	auditService *AuditService,
+	ttl time.Duration,
+) *RememberMeService {
+	return &RememberMeService{
+		loginRepo:      loginRepo,
+		userRepo:       userRepo,
+		sessionService: sessionService,
+		refreshService: refreshService,
+		auditService:   auditService,
+		ttl:            ttl,
+	}
+}
+
+// Issue creates a new remember-me series for the user of a session
+func (s *RememberMeService) Issue(ctx context.Context, session *models.Session) (*IssuedRememberToken, error) {
+	secret, err := s.sessionService.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	login := &models.PersistentLogin{
+		Series:     uuid.New().String(),
+		UserID:     session.UserID,
+		TokenHash:  hashToken(secret),
+		ClientType: session.ClientType,
+		UserAgent:  session.UserAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.ttl),
+	}
+
+	if err := s.loginRepo.CreatePersistentLogin(ctx, login); err != nil {
+		return nil, err
+	}
+
+	return &IssuedRememberToken{
+		Token:     formatRememberToken(login.Series, secret),
+		ExpiresAt: login.ExpiresAt,
+	}, nil
+}
+
+// Exchange trades a remember-me credential for a fresh session and a
+// rotated credential of the same series
+func (s *RememberMeService) Exchange(ctx context.Context, credential string, opts SessionOptions) (*models.Session, *IssuedRememberToken, error) {
+	series, secret, ok := parseRememberToken(credential)
+	if !ok {
+		return nil, nil, ErrInvalidRememberToken
+	}
+
+	login, err := s.loginRepo.GetPersistentLogin(ctx, series)
+	if err != nil {
+		return nil, nil, ErrInvalidRememberToken
+	}
+
+	// A token is only accepted in its user's tenant
+	user, err := s.userRepo.GetUserByID(ctx, login.UserID)
+	if err != nil || user.TenantID != TenantFrom(ctx).ID {
+		return nil, nil, ErrInvalidRememberToken
+	}
+	if time.Now().After(login.ExpiresAt) {
+		_ = s.loginRepo.DeletePersistentLogin(ctx, series)
+		return nil, nil, ErrInvalidRememberToken
+	}
+
+	presentedHash := hashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(login.TokenHash)) != 1 {
+		s.handleTheft(ctx, login)
+		return nil, nil, ErrRememberTokenTheft
+	}
+
+	newSecret, err := s.sessionService.GenerateToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Two requests racing with the same token: only one may win
+	rotated, err := s.loginRepo.RotateToken(ctx, series, presentedHash, hashToken(newSecret))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rotated {
+		return nil, nil, ErrInvalidRememberToken
+	}
+
+	// A login that is refused must leave the presented token valid, or
+	// the client's retry would look like theft
+	session, err := s.sessionService.CreateSession(ctx, user, opts)
+	if err != nil {
+		if _, rollbackErr := s.loginRepo.RotateToken(ctx, series, hashToken(newSecret), presentedHash); rollbackErr != nil {
+			fmt.Printf("Warning: failed to restore remember-me token: %v\n", rollbackErr)
+		}
+		return nil, nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return session, &IssuedRememberToken{
+		Token:     formatRememberToken(series, newSecret),
+		ExpiresAt: login.ExpiresAt,
+	}, nil
+}
+
+// Forget deletes the series of a remember-me credential
+func (s *RememberMeService) Forget(ctx context.Context, credential string) error {
+	series, _, ok := parseRememberToken(credential)
+	if !ok {
+		return ErrInvalidRememberToken
+	}
+	return s.loginRepo.DeletePersistentLogin(ctx, series)
+}
+
+// ForgetUser deletes every remember-me series of a user
+func (s *RememberMeService) ForgetUser(ctx context.Context, userID string) error {
+	return s.loginRepo.DeleteUserPersistentLogins(ctx, userID)
+}
+
+// handleTheft revokes every credential of a user whose remember-me token
+// was used twice and records a security event
+func (s *RememberMeService) handleTheft(ctx context.Context, login *models.PersistentLogin) {
+	if err := s.loginRepo.DeleteUserPersistentLogins(ctx, login.UserID); err != nil {
+		fmt.Printf("Warning: failed to delete persistent logins: %v\n", err)
+	}
+	if err := s.refreshService.RevokeUserTokens(ctx, login.UserID); err != nil {
+		fmt.Printf("Warning: failed to revoke refresh tokens: %v\n", err)
+	}
+	if err := s.sessionService.DeleteUserSessions(ctx, login.UserID); err != nil {
+		fmt.Printf("Warning: failed to delete user sessions: %v\n", err)
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventRememberTokenTheft,
+		UserID:    login.UserID,
+		Details: map[string]interface{}{
+			"series":     login.Series,
+			"user_agent": login.UserAgent,
+		},
+	})
+}
+
+// formatRememberToken joins a series and secret into one credential
+func formatRememberToken(series, secret string) string {
+	return rememberTokenPrefix + series + "." + secret
+}
+
+// parseRememberToken splits a credential into its series and secret
+func parseRememberToken(credential string) (string, string, bool) {
+	if !strings.HasPrefix(credential, rememberTokenPrefix) {
+		return "", "", false
+	}
+	series, secret, ok := strings.Cut(strings.TrimPrefix(credential, rememberTokenPrefix), ".")
+	if !ok || series == "" || secret == "" {
+		return "", "", false
+	}
+	return series, secret, true
+}
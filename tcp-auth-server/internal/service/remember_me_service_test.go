@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+// remember logs user in and issues a remember-me credential for the session
+func remember(t *testing.T, ts *testServices, user *models.User) (*models.Session, *IssuedRememberToken) {
+	t.Helper()
+	session, _ := ts.login(t, user)
+	issued, err := ts.rememberMeService.Issue(context.Background(), session)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	return session, issued
+}
+
+func TestRememberMeExchangeRotates(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	_, issued := remember(t, ts, ts.addUser("alice"))
+
+	session, rotated, err := ts.rememberMeService.Exchange(ctx, issued.Token, SessionOptions{})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if !ts.sessionAlive(session.Token) {
+		t.Error("the new session is not valid")
+	}
+	if rotated.Token == issued.Token {
+		t.Error("Exchange did not rotate the credential")
+	}
+	if !rotated.ExpiresAt.Equal(issued.ExpiresAt) {
+		t.Errorf("ExpiresAt = %v, want the series' %v", rotated.ExpiresAt, issued.ExpiresAt)
+	}
+
+	if _, _, err := ts.rememberMeService.Exchange(ctx, rotated.Token, SessionOptions{}); err != nil {
+		t.Errorf("Exchange of the rotated credential: %v", err)
+	}
+}
+
+func TestRememberMeTheft(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	first, issued := remember(t, ts, user)
+
+	session, rotated, err := ts.rememberMeService.Exchange(ctx, issued.Token, SessionOptions{})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	// A copy of the credential used after the original was exchanged
+	if _, _, err := ts.rememberMeService.Exchange(ctx, issued.Token, SessionOptions{}); !errors.Is(err, ErrRememberTokenTheft) {
+		t.Fatalf("Exchange of a used credential = %v, want ErrRememberTokenTheft", err)
+	}
+	for _, token := range []string{first.Token, session.Token} {
+		if ts.sessionAlive(token) {
+			t.Error("a session survived the theft")
+		}
+	}
+	if _, _, err := ts.rememberMeService.Exchange(ctx, rotated.Token, SessionOptions{}); !errors.Is(err, ErrInvalidRememberToken) {
+		t.Errorf("Exchange of the series after theft = %v, want ErrInvalidRememberToken", err)
+	}
+	for _, token := range ts.refresh.tokens {
+		if token.Status != models.RefreshTokenRevoked {
+			t.Error("a refresh token survived the theft")
+		}
+	}
+
+	events := ts.audit.eventTypes(user.ID)
+	if len(events) != 1 || events[0] != models.EventRememberTokenTheft {
+		t.Errorf("audit events = %v, want [%s]", events, models.EventRememberTokenTheft)
+	}
+}
+
+func TestRememberMeFailedExchangeKeepsToken(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{MaxSessions: 1, Mode: SessionLimitReject})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	session, issued := remember(t, ts, user)
+
+	// The session limit refuses the login
+	if _, _, err := ts.rememberMeService.Exchange(ctx, issued.Token, SessionOptions{}); !errors.Is(err, ErrSessionLimitReached) {
+		t.Fatalf("Exchange at the session limit = %v, want ErrSessionLimitReached", err)
+	}
+
+	// So does a suspension
+	if err := ts.users.SetStatus(ctx, user.ID, models.UserStatusSuspended, "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.sessionService.DeleteSession(ctx, session.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ts.rememberMeService.Exchange(ctx, issued.Token, SessionOptions{}); !errors.Is(err, ErrAccountSuspended) {
+		t.Fatalf("Exchange of a suspended account = %v, want ErrAccountSuspended", err)
+	}
+
+	// Neither refusal used the credential up, so the retry is no theft
+	if err := ts.users.SetStatus(ctx, user.ID, models.UserStatusActive, "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ts.rememberMeService.Exchange(ctx, issued.Token, SessionOptions{}); err != nil {
+		t.Fatalf("Exchange after the refusals: %v", err)
+	}
+	if events := ts.audit.eventTypes(user.ID); len(events) != 0 {
+		t.Errorf("audit events = %v, want none", events)
+	}
+}
+
+func TestRememberMeRejects(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	_, issued := remember(t, ts, ts.addUser("alice"))
+	_, expired := remember(t, ts, ts.addUser("bob"))
+	series, _, _ := parseRememberToken(expired.Token)
+	ts.logins.logins[series].ExpiresAt = time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		credential string
+	}{
+		{"malformed", ctx, "not-a-credential"},
+		{"unknown series", ctx, formatRememberToken("unknown", "secret")},
+		{"expired", ctx, expired.Token},
+		{"other tenant", WithTenant(ctx, &models.Tenant{ID: "acme"}), issued.Token},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ts.rememberMeService.Exchange(tt.ctx, tt.credential, SessionOptions{}); !errors.Is(err, ErrInvalidRememberToken) {
+				t.Errorf("Exchange = %v, want ErrInvalidRememberToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"tcp-auth-server/internal/models"
+)
+
+// RoleService manages role assignments
+type RoleService struct {
+	roleRepo       RoleStore
+	sessionService *SessionService
+	auditService   *AuditService
+}
+
+// NewRoleService creates a new role service
+func NewRoleService(
+	roleRepo RoleStore,
+	sessionService *SessionService,
+	auditService *AuditService,
+) *RoleService {
+	return &RoleService{
+		roleRepo:       roleRepo,
+		sessionService: sessionService,
+		auditService:   auditService,
+	}
+}
+
+// ListRoles returns every role with its permissions
+func (s *RoleService) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	return s.roleRepo.ListRoles(ctx)
+}
+
+// UserPermissions returns the permissions a user holds through their roles
+func (s *RoleService) UserPermissions(ctx context.Context, userID string) ([]string, error) {
+	_, permissions, err := s.roleRepo.GetUserAuthorization(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// AssignRole gives a user a role on behalf of actorID and applies it to
+// the user's live sessions
+func (s *RoleService) AssignRole(ctx context.Context, actorID, userID, role string) error {
+	if err := s.roleRepo.AssignRole(ctx, userID, role); err != nil {
+		return err
+	}
+	s.roleChanged(ctx, models.EventRoleAssigned, actorID, userID, role)
+	return nil
+}
+
+// UnassignRole takes a role away from a user on behalf of actorID and
+// applies the change to the user's live sessions
+func (s *RoleService) UnassignRole(ctx context.Context, actorID, userID, role string) error {
+	if err := s.roleRepo.UnassignRole(ctx, userID, role); err != nil {
+		return err
+	}
+	s.roleChanged(ctx, models.EventRoleUnassigned, actorID, userID, role)
+	return nil
+}
+
+// SyncRoles makes a user's assignments of the managed roles match granted,
+// leaving roles outside managed untouched. Credential backends use it to
+// apply roles mapped from directory groups at login.
+func (s *RoleService) SyncRoles(ctx context.Context, userID string, managed, granted []string) error {
+	current, _, err := s.roleRepo.GetUserAuthorization(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	has := make(map[string]bool, len(current))
+	for _, role := range current {
+		has[role] = true
+	}
+	grant := make(map[string]bool, len(granted))
+	for _, role := range granted {
+		grant[role] = true
+	}
+
+	for _, role := range managed {
+		switch {
+		case grant[role] && !has[role]:
+			if err := s.AssignRole(ctx, "", userID, role); err != nil {
+				return err
+			}
+		case !grant[role] && has[role]:
+			if err := s.UnassignRole(ctx, "", userID, role); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// roleChanged refreshes cached sessions and records the change
+func (s *RoleService) roleChanged(ctx context.Context, eventType, actorID, userID, role string) {
+	if err := s.sessionService.UpdateUserAuthorization(ctx, userID); err != nil {
+		fmt.Printf("Warning: failed to update roles in live sessions: %v\n", err)
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: eventType,
+		UserID:    userID,
+		ActorID:   actorID,
+		Details: map[string]interface{}{
+			"role": role,
+		},
+	})
+}
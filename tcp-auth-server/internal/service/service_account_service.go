@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// serviceClientIDPrefix and serviceSecretPrefix mark service account
+// credentials so they are recognisable in configuration and logs
+const (
+	serviceClientIDPrefix = "sa_"
+	serviceSecretPrefix   = "sas_"
+)
+
+// ErrInvalidClientCredentials is returned for unknown client IDs, wrong
+// secrets and disabled service accounts
+var ErrInvalidClientCredentials = errors.New("invalid client credentials")
+
+// IssuedServiceAccount is a service account with its client secret, which
+// is only ever shown when the account is created or its secret rotated
+type IssuedServiceAccount struct {
+	ClientSecret   string
+	ServiceAccount *models.ServiceAccount
+}
+
+// ServiceAccountService manages service accounts, the non-human principals
+// used by backend services. Service accounts have their own role
+// assignments and authenticate with a client ID and secret, which are
+// exchanged for short-lived tokens, or with API keys.
+type ServiceAccountService struct {
+	serviceAccountRepo *repository.ServiceAccountRepository
+	roleRepo           RoleStore
+	sessionService     *SessionService
+	auditService       *AuditService
+	tokenTTL           time.Duration
+}
+
+// NewServiceAccountService creates a new service account service. Tokens
+// issued for client credentials live for tokenTTL.
+func NewServiceAccountService(
+	serviceAccountRepo *repository.ServiceAccountRepository,
+	roleRepo RoleStore,
+	sessionService *SessionService,
+	auditService *AuditService,
+	tokenTTL time.Duration,
+) *ServiceAccountService {
+	return &ServiceAccountService{
+		serviceAccountRepo: serviceAccountRepo,
+		roleRepo:           roleRepo,
+		sessionService:     sessionService,
+		auditService:       auditService,
+		tokenTTL:           tokenTTL,
+	}
+}
+
+// Create registers a new service account on behalf of actorID
+func (s *ServiceAccountService) Create(ctx context.Context, actorID, name, description string) (*IssuedServiceAccount, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate client ID: %w", err)
+	}
+	secret, err := s.generateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	account := &models.ServiceAccount{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: strings.TrimSpace(description),
+		ClientID:    serviceClientIDPrefix + hex.EncodeToString(idBytes),
+		SecretHash:  hashToken(secret),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.serviceAccountRepo.CreateServiceAccount(ctx, account); err != nil {
+		return nil, err
+	}
+
+	s.record(ctx, models.EventServiceAccountCreated, account.ID, actorID, map[string]interface{}{
+		"name":      account.Name,
+		"client_id": account.ClientID,
+	})
+
+	return &IssuedServiceAccount{
+		ClientSecret:   secret,
+		ServiceAccount: account,
+	}, nil
+}
+
+// Get looks a service account up by ID
+func (s *ServiceAccountService) Get(ctx context.Context, id string) (*models.ServiceAccount, error) {
+	return s.serviceAccountRepo.GetServiceAccountByID(ctx, id)
+}
+
+// List returns every service account
+func (s *ServiceAccountService) List(ctx context.Context) ([]*models.ServiceAccount, error) {
+	return s.serviceAccountRepo.ListServiceAccounts(ctx)
+}
+
+// RotateSecret replaces a service account's client secret. The old secret
+// stops working at once; tokens already issued with it stay valid until
+// they expire.
+func (s *ServiceAccountService) RotateSecret(ctx context.Context, actorID, id string) (*IssuedServiceAccount, error) {
+	secret, err := s.generateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.serviceAccountRepo.UpdateSecretHash(ctx, id, hashToken(secret)); err != nil {
+		return nil, err
+	}
+
+	account, err := s.serviceAccountRepo.GetServiceAccountByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(ctx, models.EventServiceAccountSecretRotated, id, actorID, nil)
+
+	return &IssuedServiceAccount{
+		ClientSecret:   secret,
+		ServiceAccount: account,
+	}, nil
+}
+
+// SetDisabled disables or re-enables a service account. A disabled account
+// cannot obtain tokens, and its existing tokens and API keys are refused.
+func (s *ServiceAccountService) SetDisabled(ctx context.Context, actorID, id string, disabled bool) error {
+	if err := s.serviceAccountRepo.SetDisabled(ctx, id, disabled); err != nil {
+		return err
+	}
+
+	eventType := models.EventServiceAccountEnabled
+	if disabled {
+		eventType = models.EventServiceAccountDisabled
+	}
+	s.record(ctx, eventType, id, actorID, nil)
+	return nil
+}
+
+// Authenticate exchanges client credentials for a token limited to scopes.
+// Without scopes the token carries the account's full authority.
+func (s *ServiceAccountService) Authenticate(ctx context.Context, clientID, clientSecret string, scopes []string, clientIP string) (*models.Session, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, ErrInvalidClientCredentials
+	}
+
+	account, err := s.serviceAccountRepo.GetServiceAccountByClientID(ctx, clientID)
+	if err != nil {
+		return nil, ErrInvalidClientCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(clientSecret)), []byte(account.SecretHash)) != 1 {
+		return nil, ErrInvalidClientCredentials
+	}
+	if account.Disabled {
+		return nil, ErrInvalidClientCredentials
+	}
+
+	principal, err := s.principalFor(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.sessionService.CreateServiceSession(ctx, principal, scopes, clientIP, s.tokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType:     models.EventServiceAccountTokenIssued,
+		UserID:        account.ID,
+		ActorID:       account.ID,
+		IPAddress:     clientIP,
+		PrincipalType: models.PrincipalServiceAccount,
+		Details: map[string]interface{}{
+			"session_id": session.ID,
+			"scopes":     session.Scopes,
+		},
+	})
+
+	return session, nil
+}
+
+// Principal loads an enabled service account with its current roles
+func (s *ServiceAccountService) Principal(ctx context.Context, id string) (*models.Principal, error) {
+	account, err := s.serviceAccountRepo.GetServiceAccountByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if account.Disabled {
+		return nil, fmt.Errorf("service account is disabled")
+	}
+	return s.principalFor(ctx, account)
+}
+
+// AssignRole gives a service account a role on behalf of actorID
+func (s *ServiceAccountService) AssignRole(ctx context.Context, actorID, id, role string) error {
+	if err := s.roleRepo.AssignServiceAccountRole(ctx, id, role); err != nil {
+		return err
+	}
+	s.record(ctx, models.EventRoleAssigned, id, actorID, map[string]interface{}{"role": role})
+	return nil
+}
+
+// UnassignRole takes a role away from a service account on behalf of
+// actorID
+func (s *ServiceAccountService) UnassignRole(ctx context.Context, actorID, id, role string) error {
+	if err := s.roleRepo.UnassignServiceAccountRole(ctx, id, role); err != nil {
+		return err
+	}
+	s.record(ctx, models.EventRoleUnassigned, id, actorID, map[string]interface{}{"role": role})
+	return nil
+}
+
+// principalFor describes a service account as a principal
+func (s *ServiceAccountService) principalFor(ctx context.Context, account *models.ServiceAccount) (*models.Principal, error) {
+	roles, permissions, err := s.roleRepo.GetServiceAccountAuthorization(ctx, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Principal{
+		Type:        models.PrincipalServiceAccount,
+		ID:          account.ID,
+		Name:        account.Name,
+		Roles:       roles,
+		Permissions: permissions,
+	}, nil
+}
+
+// generateSecret returns a new client secret
+func (s *ServiceAccountService) generateSecret() (string, error) {
+	secret, err := s.sessionService.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	return serviceSecretPrefix + secret, nil
+}
+
+// record writes an audit event about a service account
+func (s *ServiceAccountService) record(ctx context.Context, eventType, id, actorID string, details map[string]interface{}) {
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType:     eventType,
+		UserID:        id,
+		ActorID:       actorID,
+		PrincipalType: models.PrincipalServiceAccount,
+		Details:       details,
+	})
+}
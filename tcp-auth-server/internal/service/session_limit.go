@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/redis"
+)
+
+// Session limit modes
+const (
+	// SessionLimitReject refuses new logins once the limit is reached
+	SessionLimitReject = "reject"
+	// SessionLimitEvictOldest ends the oldest sessions to make room
+	SessionLimitEvictOldest = "evict_oldest"
+)
+
+// ErrSessionLimitReached is returned when a login would exceed the user's
+// session limit and the limit mode is reject
+var ErrSessionLimitReached = errors.New("maximum number of active sessions reached")
+
+// SessionLimitPolicy caps the number of concurrent sessions per user
+type SessionLimitPolicy struct {
+	// MaxSessions is the default cap, 0 for no limit
+	MaxSessions int
+	// RoleLimits caps the sessions of users holding a role, 0 for no
+	// limit. It takes precedence over MaxSessions.
+	RoleLimits map[string]int
+	// Mode is SessionLimitReject or SessionLimitEvictOldest
+	Mode string
+}
+
+// sessionSetScript converts a user's session set from the plain set older
+// releases kept to a sorted set and returns its members.
+//
+// KEYS[1]  user session set
+var sessionSetScript = redis.NewScript(`
+local key = KEYS[1]
+if redis.call('TYPE', key).ok == 'set' then
+	local legacy = redis.call('SMEMBERS', key)
+	redis.call('DEL', key)
+	for _, token in ipairs(legacy) do
+		redis.call('ZADD', key, 0, token)
+	end
+end
+return redis.call('ZRANGE', key, 0, -1)
+`)
+
+// admitSessionScript registers a new session in the user's session set in
+// one atomic step, so concurrent logins on different replicas cannot
+// together exceed the limit. It only touches the key it is given, so it
+// runs on Redis Cluster too; storing and deleting the session keys is left
+// to the caller.
+//
+// KEYS[1]  user session set (sorted by creation time)
+// ARGV[1]  session token
+// ARGV[2]  creation time in milliseconds
+// ARGV[3]  session limit, 0 for none
+// ARGV[4]  limit mode
+// ARGV[5]  user session set TTL in seconds
+//
+// Returns {1, evicted tokens...} when admitted or {0} when rejected.
+var admitSessionScript = redis.NewScript(`
+local key = KEYS[1]
+
+local evicted = {}
+local limit = tonumber(ARGV[3])
+if limit > 0 then
+	local count = redis.call('ZCARD', key)
+	if count >= limit then
+		if ARGV[4] == 'reject' then
+			return {0}
+		end
+		for _, token in ipairs(redis.call('ZRANGE', key, 0, count - limit)) do
+			redis.call('ZREM', key, token)
+			table.insert(evicted, token)
+		end
+	end
+end
+
+redis.call('ZADD', key, ARGV[2], ARGV[1])
+redis.call('EXPIRE', key, ARGV[5])
+
+local result = {1}
+for _, token in ipairs(evicted) do
+	table.insert(result, token)
+end
+return result
+`)
+
+// sessionLimitFor returns the session cap for a user holding roles. A
+// per-user override takes precedence, then the limits of the user's roles,
+// then the default. Of several role limits the most permissive applies,
+// as roles add to what a user may do.
+func (s *SessionService) sessionLimitFor(user *models.User, roles []string) int {
+	if user.MaxSessions > 0 {
+		return user.MaxSessions
+	}
+
+	limit, found := 0, false
+	for _, role := range roles {
+		roleLimit, ok := s.limitPolicy.RoleLimits[role]
+		if !ok {
+			continue
+		}
+		if roleLimit == 0 {
+			return 0
+		}
+		if !found || roleLimit > limit {
+			limit, found = roleLimit, true
+		}
+	}
+	if found {
+		return limit
+	}
+	return s.limitPolicy.MaxSessions
+}
+
+// admitSession stores a session in Redis while enforcing the user's
+// session limit. It returns the tokens of sessions evicted to make room.
+func (s *SessionService) admitSession(session *models.Session, limit int) ([]string, error) {
+	setKey := userSessionsKey(session.TenantID, session.UserID)
+
+	// Forget sessions that have already expired so they do not count
+	// against the limit
+	if err := s.pruneSessionSet(session.TenantID, setKey); err != nil {
+		return nil, fmt.Errorf("failed to store session in Redis: %w", err)
+	}
+
+	// The session key is written first, so every token in the set always
+	// has a live session behind it
+	key := sessionKey(session.TenantID, session.Token)
+	if err := s.redisClient.Set(key, session, time.Until(session.ExpiresAt)); err != nil {
+		return nil, fmt.Errorf("failed to store session in Redis: %w", err)
+	}
+
+	// The set lives as long as the longest session it may hold; a tenant
+	// may allow sessions longer than the server default
+	setTTL := s.longestLifetime()
+	if remaining := time.Until(session.AbsoluteExpiresAt); remaining > setTTL {
+		setTTL = remaining
+	}
+
+	result, err := s.redisClient.RunScript(admitSessionScript,
+		[]string{setKey},
+		session.Token,
+		session.CreatedAt.UnixMilli(),
+		limit,
+		s.limitPolicy.Mode,
+		int64(setTTL/time.Second),
+	)
+	if err != nil {
+		_ = s.redisClient.Delete(key)
+		return nil, fmt.Errorf("failed to store session in Redis: %w", err)
+	}
+
+	values, ok := result.([]interface{})
+	if !ok || len(values) == 0 {
+		_ = s.redisClient.Delete(key)
+		return nil, fmt.Errorf("unexpected reply from session admission script")
+	}
+	if admitted, _ := values[0].(int64); admitted != 1 {
+		_ = s.redisClient.Delete(key)
+		return nil, ErrSessionLimitReached
+	}
+
+	evicted := make([]string, 0, len(values)-1)
+	for _, v := range values[1:] {
+		token, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if err := s.redisClient.Delete(sessionKey(session.TenantID, token)); err != nil {
+			fmt.Printf("Warning: failed to delete evicted session from Redis: %v\n", err)
+		}
+		evicted = append(evicted, token)
+	}
+	return evicted, nil
+}
+
+// pruneSessionSet removes the tokens of expired sessions from a user's
+// session set
+func (s *SessionService) pruneSessionSet(tenantID, setKey string) error {
+	result, err := s.redisClient.RunScript(sessionSetScript, []string{setKey})
+	if err != nil {
+		return err
+	}
+
+	values, _ := result.([]interface{})
+	for _, v := range values {
+		token, ok := v.(string)
+		if !ok {
+			continue
+		}
+		alive, err := s.redisClient.Exists(sessionKey(tenantID, token))
+		if err != nil {
+			return err
+		}
+		if !alive {
+			if err := s.redisClient.ZRem(setKey, token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
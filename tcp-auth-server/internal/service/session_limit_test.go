@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+func TestSessionLimitFor(t *testing.T) {
+	s := &SessionService{limitPolicy: SessionLimitPolicy{
+		MaxSessions: 3,
+		RoleLimits:  map[string]int{"admin": 1, "support": 5, "robot": 0},
+	}}
+
+	tests := []struct {
+		name  string
+		user  *models.User
+		roles []string
+		want  int
+	}{
+		{"default", &models.User{}, nil, 3},
+		{"unlimited role ignored", &models.User{}, []string{"viewer"}, 3},
+		{"role limit", &models.User{}, []string{"admin"}, 1},
+		{"most permissive role", &models.User{}, []string{"admin", "support"}, 5},
+		{"role without limit", &models.User{}, []string{"admin", "robot"}, 0},
+		{"user override", &models.User{MaxSessions: 7}, []string{"admin"}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.sessionLimitFor(tt.user, tt.roles); got != tt.want {
+				t.Errorf("sessionLimitFor = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// createSessions logs user in n times, a few milliseconds apart so the
+// sessions have distinct creation times
+func createSessions(t *testing.T, ts *testServices, user *models.User, n int) []*models.Session {
+	t.Helper()
+	sessions := make([]*models.Session, 0, n)
+	for i := 0; i < n; i++ {
+		session, err := ts.sessionService.CreateSession(context.Background(), user, SessionOptions{})
+		if err != nil {
+			t.Fatalf("CreateSession %d: %v", i, err)
+		}
+		sessions = append(sessions, session)
+		time.Sleep(2 * time.Millisecond)
+	}
+	return sessions
+}
+
+func TestSessionLimitReject(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{MaxSessions: 2, Mode: SessionLimitReject})
+	user := ts.addUser("alice")
+	sessions := createSessions(t, ts, user, 2)
+
+	if _, err := ts.sessionService.CreateSession(context.Background(), user, SessionOptions{}); !errors.Is(err, ErrSessionLimitReached) {
+		t.Fatalf("CreateSession over the limit = %v, want ErrSessionLimitReached", err)
+	}
+	for _, session := range sessions {
+		if !ts.sessionAlive(session.Token) {
+			t.Error("a rejected login ended an existing session")
+		}
+	}
+	// The refused session must not be left behind in Redis
+	if keys := ts.redis.Keys(); len(keys) != 3 {
+		t.Errorf("Redis holds %v, want the two sessions and the user's set", keys)
+	}
+}
+
+func TestSessionLimitEvictOldest(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{MaxSessions: 2, Mode: SessionLimitEvictOldest})
+	user := ts.addUser("alice")
+	ctx := context.Background()
+
+	oldest, refresh := ts.login(t, user)
+	time.Sleep(2 * time.Millisecond)
+	sessions := createSessions(t, ts, user, 2)
+
+	if ts.sessionAlive(oldest.Token) {
+		t.Error("the oldest session was not evicted")
+	}
+	for _, session := range sessions {
+		if !ts.sessionAlive(session.Token) {
+			t.Error("a newer session was evicted")
+		}
+	}
+	if _, ok := ts.sessions.sessions[oldest.Token]; ok {
+		t.Error("the evicted session is still stored")
+	}
+	if _, _, err := ts.refreshService.Rotate(ctx, refresh.Token, SessionOptions{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate of the evicted session's token = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	listed, err := ts.sessionService.ListUserSessions(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("ListUserSessions returned %d sessions, want 2", len(listed))
+	}
+}
+
+func TestSessionLimitRoles(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{
+		MaxSessions: 3,
+		RoleLimits:  map[string]int{"admin": 1},
+		Mode:        SessionLimitReject,
+	})
+	admin := ts.addUser("root", "admin")
+	user := ts.addUser("alice")
+
+	createSessions(t, ts, admin, 1)
+	if _, err := ts.sessionService.CreateSession(context.Background(), admin, SessionOptions{}); !errors.Is(err, ErrSessionLimitReached) {
+		t.Errorf("second admin session = %v, want ErrSessionLimitReached", err)
+	}
+	createSessions(t, ts, user, 3)
+}
+
+func TestSessionLimitIgnoresExpiredSessions(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{MaxSessions: 1, Mode: SessionLimitReject})
+	user := ts.addUser("alice")
+	expired := createSessions(t, ts, user, 1)[0]
+
+	// Redis expires the session key but not the entry in the user's set
+	ts.redis.Del(sessionKey(expired.TenantID, expired.Token))
+
+	createSessions(t, ts, user, 1)
+}
+
+func TestSessionLimitConvertsLegacySet(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{MaxSessions: 2, Mode: SessionLimitReject})
+	user := ts.addUser("alice")
+	legacy := createSessions(t, ts, user, 1)[0]
+
+	// Older releases kept the user's sessions in a plain set
+	setKey := userSessionsKey(user.TenantID, user.ID)
+	ts.redis.Del(setKey)
+	if _, err := ts.redis.SetAdd(setKey, legacy.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	createSessions(t, ts, user, 1)
+	if _, err := ts.sessionService.CreateSession(context.Background(), user, SessionOptions{}); !errors.Is(err, ErrSessionLimitReached) {
+		t.Errorf("CreateSession over the limit = %v, want ErrSessionLimitReached", err)
+	}
+	if !ts.sessionAlive(legacy.Token) {
+		t.Error("the session of the legacy set was lost")
+	}
+}
+
+func TestSessionLimitSkipsImpersonation(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{MaxSessions: 1, Mode: SessionLimitEvictOldest})
+	user := ts.addUser("alice")
+	own := createSessions(t, ts, user, 1)[0]
+
+	if _, err := ts.sessionService.CreateSession(context.Background(), user, SessionOptions{ImpersonatorID: "admin"}); err != nil {
+		t.Fatalf("CreateSession of an impersonation: %v", err)
+	}
+	if !ts.sessionAlive(own.Token) {
+		t.Error("an impersonation evicted the user's own session")
+	}
+}
@@ -3,38 +3,161 @@ package service
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"tcp-auth-server/internal/models"
-	"tcp-auth-server/internal/repository"
 	"tcp-auth-server/pkg/redis"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned when a user whose account is not active logs in or uses
+// a token
+var (
+	ErrAccountSuspended       = errors.New("account is suspended")
+	ErrAccountDisabled        = errors.New("account is disabled")
+	ErrAccountPendingDeletion = errors.New("account is scheduled for deletion")
 )
 
+// ErrImpersonated is returned when an impersonation session is used for
+// something only the user may do, such as managing their credentials
+var ErrImpersonated = errors.New("not allowed while impersonating a user")
+
+// AccountStatusError returns the error for a user who may not log in or
+// use their tokens, or nil for an active user. Unknown statuses count as
+// disabled.
+func AccountStatusError(user *models.User) error {
+	switch user.EffectiveStatus() {
+	case models.UserStatusActive:
+		return nil
+	case models.UserStatusSuspended:
+		if !user.StatusExpiresAt.IsZero() {
+			return fmt.Errorf("%w until %s", ErrAccountSuspended, user.StatusExpiresAt.UTC().Format(time.RFC3339))
+		}
+		return ErrAccountSuspended
+	case models.UserStatusPendingDeletion:
+		return ErrAccountPendingDeletion
+	default:
+		return ErrAccountDisabled
+	}
+}
+
+// sessionTouchInterval limits how often a sliding session is written back,
+// so busy clients do not cause a Redis and PostgreSQL write per request
+const sessionTouchInterval = time.Minute
+
+// SessionPolicy holds the two independent session limits
+type SessionPolicy struct {
+	// IdleTimeout ends a session that has not been validated for this
+	// long. Zero disables the idle limit.
+	IdleTimeout time.Duration
+	// MaxLifetime ends a session this long after it was created, no
+	// matter how active it is
+	MaxLifetime time.Duration
+}
+
+// SessionOptions carries per-login settings and device metadata for a
+// new session
+type SessionOptions struct {
+	ClientType string
+	ClientIP   string
+	UserAgent  string
+
+	// Scopes restricts the session to these permissions
+	Scopes []string
+	// Lifetime shortens the session's absolute lifetime below the policy's
+	// when set
+	Lifetime time.Duration
+	// ClientID is the OAuth client the session is issued to, if any
+	ClientID string
+	// ImpersonatorID is the admin the session is issued to when an admin
+	// impersonates the user
+	ImpersonatorID string
+}
+
 // SessionService handles session management
 type SessionService struct {
-	redisClient      *redis.Client
-	sessionRepo      *repository.SessionRepository
-	userRepo         *repository.UserRepository
-	sessionTTL       time.Duration
+	redisClient   *redis.Client
+	sessionRepo   SessionStore
+	userRepo      UserStore
+	refreshRepo   RefreshTokenStore
+	roleRepo      RoleStore
+	tenants       *TenantService
+	defaultPolicy SessionPolicy
+	policies      map[string]SessionPolicy
+	limitPolicy   SessionLimitPolicy
 }
 
-// NewSessionService creates a new session service
+// NewSessionService creates a new session service. Sessions use the policy
+// registered for their client type, or the default policy, with their
+// tenant's overrides applied.
 func NewSessionService(
 	redisClient *redis.Client,
-	sessionRepo *repository.SessionRepository,
-	userRepo *repository.UserRepository,
-	sessionTTL time.Duration,
+	sessionRepo SessionStore,
+	userRepo UserStore,
+	refreshRepo RefreshTokenStore,
+	roleRepo RoleStore,
+	tenants *TenantService,
+	defaultPolicy SessionPolicy,
+	policies map[string]SessionPolicy,
+	limitPolicy SessionLimitPolicy,
 ) *SessionService {
 	return &SessionService{
-		redisClient: redisClient,
-		sessionRepo: sessionRepo,
-		userRepo:    userRepo,
-		sessionTTL:  sessionTTL,
+		redisClient:   redisClient,
+		sessionRepo:   sessionRepo,
+		userRepo:      userRepo,
+		refreshRepo:   refreshRepo,
+		roleRepo:      roleRepo,
+		tenants:       tenants,
+		defaultPolicy: defaultPolicy,
+		policies:      policies,
+		limitPolicy:   limitPolicy,
 	}
 }
 
+// policyFor returns the session policy for a client type in a tenant
+func (s *SessionService) policyFor(tenant *models.Tenant, clientType string) SessionPolicy {
+	policy, ok := s.policies[clientType]
+	if !ok {
+		policy = s.defaultPolicy
+	}
+	if tenant.SessionMaxLifetime > 0 {
+		policy.MaxLifetime = tenant.SessionMaxLifetime
+	}
+	if tenant.SessionIdleTimeout > 0 {
+		policy.IdleTimeout = tenant.SessionIdleTimeout
+	}
+	return policy
+}
+
+// sessionKey is the Redis key of a session
+func sessionKey(tenantID, token string) string {
+	return tenantKey(tenantID, fmt.Sprintf("session:%s", token))
+}
+
+// userSessionsKey is the Redis key of the set of a user's sessions
+func userSessionsKey(tenantID, userID string) string {
+	return tenantKey(tenantID, fmt.Sprintf("user_sessions:%s", userID))
+}
+
+// longestLifetime returns the longest configured maximum lifetime, which
+// bounds how long a user's session set must live
+func (s *SessionService) longestLifetime() time.Duration {
+	longest := s.defaultPolicy.MaxLifetime
+	for _, policy := range s.policies {
+		if policy.MaxLifetime > longest {
+			longest = policy.MaxLifetime
+		}
+	}
+	return longest
+}
+
 // GenerateToken generates a cryptographically secure session token
 func (s *SessionService) GenerateToken() (string, error) {
 	b := make([]byte, 32)
@@ -44,41 +167,90 @@ func (s *SessionService) GenerateToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
+// hashToken returns the form in which long-lived secrets such as refresh
+// tokens are stored, so a database leak does not expose usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // CreateSession creates a new session for a user
-func (s *SessionService) CreateSession(ctx context.Context, user *models.User) (*models.Session, error) {
+func (s *SessionService) CreateSession(ctx context.Context, user *models.User, opts SessionOptions) (*models.Session, error) {
+	if err := AccountStatusError(user); err != nil {
+		return nil, err
+	}
+
 	token, err := s.GenerateToken()
 	if err != nil {
 		return nil, err
 	}
 
-	expiresAt := time.Now().Add(s.sessionTTL)
-	session := &models.Session{
-		Token:     token,
-		UserID:    user.ID,
-		Username:  user.Username,
-		Email:     user.Email,
-		ExpiresAt: expiresAt,
-		CreatedAt: time.Now(),
+	if err := models.ValidateScopes(opts.Scopes); err != nil {
+		return nil, err
 	}
 
-	// Store in Redis
-	sessionKey := fmt.Sprintf("session:%s", token)
-	if err := s.redisClient.Set(sessionKey, session, s.sessionTTL); err != nil {
-		return nil, fmt.Errorf("failed to store session in Redis: %w", err)
+	roles, permissions, err := s.roleRepo.GetUserAuthorization(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := s.tenants.Get(ctx, user.TenantID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add to user's session set
-	userSessionsKey := fmt.Sprintf("user_sessions:%s", user.ID)
-	if err := s.redisClient.SAdd(userSessionsKey, token); err != nil {
-		return nil, fmt.Errorf("failed to add session to user set: %w", err)
+	policy := s.policyFor(tenant, opts.ClientType)
+	if opts.Lifetime > 0 && opts.Lifetime < policy.MaxLifetime {
+		policy.MaxLifetime = opts.Lifetime
 	}
-	if err := s.redisClient.SetExpiration(userSessionsKey, s.sessionTTL); err != nil {
-		// Non-critical error, log but continue
-		fmt.Printf("Warning: failed to set expiration on user_sessions key: %v\n", err)
+	now := time.Now()
+	session := &models.Session{
+		ID:                uuid.New().String(),
+		Token:             token,
+		UserID:            user.ID,
+		Username:          user.Username,
+		Email:             user.Email,
+		ClientType:        opts.ClientType,
+		ClientIP:          opts.ClientIP,
+		UserAgent:         opts.UserAgent,
+		IdleTimeout:       policy.IdleTimeout,
+		AbsoluteExpiresAt: now.Add(policy.MaxLifetime),
+		LastUsedAt:        now,
+		CreatedAt:         now,
+		Roles:             roles,
+		Permissions:       permissions,
+		Scopes:            opts.Scopes,
+		TenantID:          tenant.ID,
+		ClientID:          opts.ClientID,
+		ImpersonatorID:    opts.ImpersonatorID,
+	}
+	session.ExpiresAt = session.NextExpiry(now)
+
+	// Store in Redis and add to the user's session set, enforcing the
+	// session limit. An impersonation must not evict or be refused for the
+	// user's own sessions.
+	limit := s.sessionLimitFor(user, roles)
+	if session.Impersonated() {
+		limit = 0
+	}
+	evicted, err := s.admitSession(session, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Evicted sessions lose their refresh tokens too, otherwise they
+	// could be refreshed straight back in
+	for _, evictedToken := range evicted {
+		if err := s.refreshRepo.RevokeBySession(ctx, evictedToken); err != nil {
+			fmt.Printf("Warning: failed to revoke refresh tokens of evicted session: %v\n", err)
+		}
+		if err := s.sessionRepo.DeleteSession(ctx, evictedToken); err != nil {
+			fmt.Printf("Warning: failed to delete evicted session from PostgreSQL: %v\n", err)
+		}
 	}
 
 	// Store in PostgreSQL as backup/audit
-	if err := s.sessionRepo.CreateSession(ctx, user.ID, token, expiresAt); err != nil {
+	if err := s.sessionRepo.CreateSession(ctx, session); err != nil {
 		// Non-critical error, log but continue
 		fmt.Printf("Warning: failed to store session in PostgreSQL: %v\n", err)
 	}
@@ -86,99 +258,267 @@ func (s *SessionService) CreateSession(ctx context.Context, user *models.User) (
 	return session, nil
 }
 
-// GetSession retrieves a session by token
-func (s *SessionService) GetSession(token string) (*models.Session, error) {
-	sessionKey := fmt.Sprintf("session:%s", token)
+// CreateServiceSession creates a session for a service account. Service
+// sessions live only in Redis: they are short-lived, are not subject to
+// per-user session limits and cannot be refreshed. The session belongs to
+// the principal's tenant or, as service accounts have none, to the tenant
+// of the request, and is only valid there.
+func (s *SessionService) CreateServiceSession(ctx context.Context, principal *models.Principal, scopes []string, clientIP string, ttl time.Duration) (*models.Session, error) {
+	if err := models.ValidateScopes(scopes); err != nil {
+		return nil, err
+	}
+
+	token, err := s.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tenantID := principal.TenantID
+	if tenantID == "" {
+		tenantID = TenantFrom(ctx).ID
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:                uuid.New().String(),
+		Token:             token,
+		UserID:            principal.ID,
+		Username:          principal.Name,
+		ClientIP:          clientIP,
+		ExpiresAt:         now.Add(ttl),
+		AbsoluteExpiresAt: now.Add(ttl),
+		LastUsedAt:        now,
+		CreatedAt:         now,
+		Roles:             principal.Roles,
+		Permissions:       principal.Permissions,
+		Scopes:            scopes,
+		TenantID:          tenantID,
+		PrincipalType:     models.PrincipalServiceAccount,
+	}
+
+	if err := s.redisClient.Set(sessionKey(session.TenantID, token), session, ttl); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return session, nil
+}
+
+// GetSession retrieves a session of the request's tenant by token,
+// enforcing both the idle timeout and the absolute lifetime
+func (s *SessionService) GetSession(ctx context.Context, token string) (*models.Session, error) {
+	return s.getSession(ctx, TenantFrom(ctx).ID, token)
+}
 
+// getSession retrieves a session of a tenant by token
+func (s *SessionService) getSession(ctx context.Context, tenantID, token string) (*models.Session, error) {
 	var session models.Session
-	if err := s.redisClient.Get(sessionKey, &session); err != nil {
+	if err := s.redisClient.Get(sessionKey(tenantID, token), &session); err != nil {
 		return nil, fmt.Errorf("session not found or expired")
 	}
 
 	// Check if session is expired
-	if time.Now().After(session.ExpiresAt) {
-		s.DeleteSession(context.Background(), token)
+	if session.Expired(time.Now()) {
+		s.removeSession(ctx, &session)
 		return nil, fmt.Errorf("session expired")
 	}
 
 	return &session, nil
 }
 
-// ValidateSession validates a session token
-func (s *SessionService) ValidateSession(token string) (*models.Session, error) {
-	return s.GetSession(token)
+// ValidateSession validates a session token and slides its idle timeout
+func (s *SessionService) ValidateSession(ctx context.Context, token string) (*models.Session, error) {
+	session, err := s.GetSession(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	s.touchSession(ctx, session)
+	return session, nil
+}
+
+// touchSession records activity on a session, pushing its idle expiry
+// forward in Redis and PostgreSQL. Writes are skipped if the session was
+// touched recently, which delays expiry by at most sessionTouchInterval.
+func (s *SessionService) touchSession(ctx context.Context, session *models.Session) {
+	now := time.Now()
+	if session.IdleTimeout <= 0 || now.Sub(session.LastUsedAt) < sessionTouchInterval {
+		return
+	}
+
+	session.LastUsedAt = now
+	session.ExpiresAt = session.NextExpiry(now)
+
+	if err := s.redisClient.Set(sessionKey(session.TenantID, session.Token), session, time.Until(session.ExpiresAt)); err != nil {
+		fmt.Printf("Warning: failed to extend session in Redis: %v\n", err)
+		return
+	}
+
+	if err := s.sessionRepo.TouchSession(ctx, session.Token, session.LastUsedAt, session.ExpiresAt); err != nil {
+		fmt.Printf("Warning: failed to extend session in PostgreSQL: %v\n", err)
+	}
+}
+
+// UpdateUserAuthorization reloads a user's roles and permissions into all
+// of their live sessions, so role changes apply without logging in again
+func (s *SessionService) UpdateUserAuthorization(ctx context.Context, userID string) error {
+	roles, permissions, err := s.roleRepo.GetUserAuthorization(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.ListUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		session.Roles = roles
+		session.Permissions = permissions
+
+		if err := s.redisClient.Set(sessionKey(session.TenantID, session.Token), session, time.Until(session.ExpiresAt)); err != nil {
+			return fmt.Errorf("failed to update session in Redis: %w", err)
+		}
+	}
+
+	return nil
 }
 
 // DeleteSession removes a session
 func (s *SessionService) DeleteSession(ctx context.Context, token string) error {
-	session, err := s.GetSession(token)
+	session, err := s.GetSession(ctx, token)
 	if err != nil {
 		// Session might already be deleted, try to clean up anyway
-		sessionKey := fmt.Sprintf("session:%s", token)
-		_ = s.redisClient.Delete(sessionKey)
+		_ = s.redisClient.Delete(sessionKey(TenantFrom(ctx).ID, token))
 		_ = s.sessionRepo.DeleteSession(ctx, token)
 		return nil
 	}
 
+	s.removeSession(ctx, session)
+	return nil
+}
+
+// removeSession deletes a session from Redis, its user's session set and
+// PostgreSQL
+func (s *SessionService) removeSession(ctx context.Context, session *models.Session) {
 	// Remove from Redis
-	sessionKey := fmt.Sprintf("session:%s", token)
-	if err := s.redisClient.Delete(sessionKey); err != nil {
+	if err := s.redisClient.Delete(sessionKey(session.TenantID, session.Token)); err != nil {
 		fmt.Printf("Warning: failed to delete session from Redis: %v\n", err)
 	}
 
 	// Remove from user's session set
-	userSessionsKey := fmt.Sprintf("user_sessions:%s", session.UserID)
-	if err := s.redisClient.SRem(userSessionsKey, token); err != nil {
+	if err := s.redisClient.ZRem(userSessionsKey(session.TenantID, session.UserID), session.Token); err != nil {
 		fmt.Printf("Warning: failed to remove session from user set: %v\n", err)
 	}
 
 	// Remove from PostgreSQL
-	if err := s.sessionRepo.DeleteSession(ctx, token); err != nil {
+	if err := s.sessionRepo.DeleteSession(ctx, session.Token); err != nil {
 		fmt.Printf("Warning: failed to delete session from PostgreSQL: %v\n", err)
 	}
-
-	return nil
 }
 
-// RefreshSession extends a session's expiration
-func (s *SessionService) RefreshSession(ctx context.Context, token string) (*models.Session, error) {
-	session, err := s.GetSession(token)
-	if err != nil {
-		return nil, err
+// CreateScopedSession mints a session for the owner of parent that is
+// limited to scopes. The scopes must be within the parent's own scopes and
+// the user's permissions, and the new session never outlives the parent.
+// A ttl of zero keeps the parent's remaining lifetime.
+func (s *SessionService) CreateScopedSession(ctx context.Context, parent *models.Session, scopes []string, ttl time.Duration) (*models.Session, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	// A scoped session would not carry the impersonator
+	if parent.Impersonated() {
+		return nil, ErrImpersonated
+	}
+	if !models.CoversScopes(parent.Scopes, scopes) {
+		return nil, fmt.Errorf("requested scopes exceed the token's scopes")
+	}
+	if !models.GrantsScopes(parent.Permissions, scopes) {
+		return nil, fmt.Errorf("requested scopes exceed the user's permissions")
 	}
 
-	// Get user to recreate session
-	user, err := s.userRepo.GetUserByID(ctx, session.UserID)
+	lifetime := time.Until(parent.AbsoluteExpiresAt)
+	if parent.AbsoluteExpiresAt.IsZero() {
+		lifetime = time.Until(parent.ExpiresAt)
+	}
+	if ttl > 0 && ttl < lifetime {
+		lifetime = ttl
+	}
+	if lifetime <= 0 {
+		return nil, fmt.Errorf("session expired")
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, parent.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
 
-	// Delete old session
-	if err := s.DeleteSession(ctx, token); err != nil {
-		fmt.Printf("Warning: failed to delete old session: %v\n", err)
+	return s.CreateSession(ctx, user, SessionOptions{
+		ClientType: parent.ClientType,
+		ClientIP:   parent.ClientIP,
+		UserAgent:  parent.UserAgent,
+		Scopes:     scopes,
+		Lifetime:   lifetime,
+	})
+}
+
+// ListUserSessions returns a user's live sessions, oldest first. Tokens
+// whose session has expired are pruned from the user's session set.
+func (s *SessionService) ListUserSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	tenantID := s.tenantOf(ctx, userID)
+	setKey := userSessionsKey(tenantID, userID)
+	tokens, err := s.redisClient.ZRange(setKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := make([]*models.Session, 0, len(tokens))
+	for _, token := range tokens {
+		session, err := s.getSession(ctx, tenantID, token)
+		if err != nil {
+			_ = s.redisClient.ZRem(setKey, token)
+			continue
+		}
+		sessions = append(sessions, session)
 	}
 
-	// Create new session
-	return s.CreateSession(ctx, user)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+// FindUserSession finds one of a user's sessions by its public session ID
+func (s *SessionService) FindUserSession(ctx context.Context, userID, sessionID string) (*models.Session, error) {
+	sessions, err := s.ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return session, nil
+		}
+	}
+	return nil, fmt.Errorf("session not found")
 }
 
 // DeleteUserSessions removes all sessions for a user
 func (s *SessionService) DeleteUserSessions(ctx context.Context, userID string) error {
-	userSessionsKey := fmt.Sprintf("user_sessions:%s", userID)
-	tokens, err := s.redisClient.SMembers(userSessionsKey)
+	tenantID := s.tenantOf(ctx, userID)
+	setKey := userSessionsKey(tenantID, userID)
+	tokens, err := s.redisClient.ZRange(setKey)
 	if err != nil {
 		// Key might not exist, continue
 	}
 
 	// Delete all sessions
 	for _, token := range tokens {
-		sessionKey := fmt.Sprintf("session:%s", token)
-		_ = s.redisClient.Delete(sessionKey)
+		_ = s.redisClient.Delete(sessionKey(tenantID, token))
 		_ = s.sessionRepo.DeleteSession(ctx, token)
 	}
 
 	// Delete user sessions set
-	_ = s.redisClient.Delete(userSessionsKey)
+	_ = s.redisClient.Delete(setKey)
 
 	// Delete from PostgreSQL
 	if err := s.sessionRepo.DeleteUserSessions(ctx, userID); err != nil {
@@ -188,4 +528,12 @@ func (s *SessionService) DeleteUserSessions(ctx context.Context, userID string)
 	return nil
 }
 
-
+// tenantOf returns the tenant a user belongs to, falling back to the
+// request's tenant if the user cannot be loaded
+func (s *SessionService) tenantOf(ctx context.Context, userID string) string {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return TenantFrom(ctx).ID
+	}
+	return user.TenantID
+}
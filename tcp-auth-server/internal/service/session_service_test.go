@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"tcp-auth-server/internal/models"
+)
+
+func TestCreateScopedSession(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	ts.roles.permissions["shopper"] = []string{"cart:*", "orders:read"}
+	user := ts.addUser("alice", "shopper")
+
+	full, err := ts.sessionService.CreateSession(ctx, user, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped, err := ts.sessionService.CreateSession(ctx, user, SessionOptions{Scopes: []string{"cart:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		parent *models.Session
+		scopes []string
+		ok     bool
+	}{
+		{"permission", full, []string{"orders:read"}, true},
+		{"under wildcard permission", full, []string{"cart:read", "cart:write"}, true},
+		{"wildcard permission", full, []string{"cart:*"}, true},
+		{"beyond permissions", full, []string{"admin:users"}, false},
+		{"partly beyond permissions", full, []string{"cart:read", "orders:write"}, false},
+		{"no scopes", full, nil, false},
+		{"within parent scopes", scoped, []string{"cart:read"}, true},
+		{"beyond parent scopes", scoped, []string{"orders:read"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := ts.sessionService.CreateScopedSession(ctx, tt.parent, tt.scopes, 0)
+			if tt.ok && err != nil {
+				t.Fatalf("CreateScopedSession(%v): %v", tt.scopes, err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("CreateScopedSession(%v) succeeded", tt.scopes)
+				}
+				return
+			}
+			if len(session.Scopes) != len(tt.scopes) {
+				t.Errorf("Scopes = %v, want %v", session.Scopes, tt.scopes)
+			}
+		})
+	}
+}
+
+func TestCreateScopedSessionRejectsImpersonation(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	ts.roles.permissions["shopper"] = []string{"cart:*"}
+	user := ts.addUser("alice", "shopper")
+
+	parent, err := ts.sessionService.CreateSession(ctx, user, SessionOptions{ImpersonatorID: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.sessionService.CreateScopedSession(ctx, parent, []string{"cart:read"}, 0); !errors.Is(err, ErrImpersonated) {
+		t.Errorf("CreateScopedSession of an impersonation = %v, want ErrImpersonated", err)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+// The stores below are the parts of the repositories the services use.
+// The repository package implements them on PostgreSQL.
+
+// UserStore persists user accounts
+type UserStore interface {
+	CreateUser(ctx context.Context, tenantID, username, email, passwordHash string) (*models.User, error)
+	CreateBackendUser(ctx context.Context, tenantID, backend, username, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID string) (*models.User, error)
+	GetUserByUsername(ctx context.Context, tenantID, username string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, tenantID, email string) (*models.User, error)
+	UserExists(ctx context.Context, tenantID, username, email string) (bool, error)
+	ListUsers(ctx context.Context, tenantID, search string, limit, offset int) ([]*models.User, int, error)
+	UpdateEmail(ctx context.Context, userID, email string) error
+	UpdatePasswordHash(ctx context.Context, userID, passwordHash string) error
+	SetStatus(ctx context.Context, userID, status, reason string, expiresAt time.Time) error
+	ListDueDeletions(ctx context.Context, now time.Time, limit int) ([]*models.User, error)
+	DeletePendingUser(ctx context.Context, userID string, now time.Time) (bool, error)
+	AnonymizePendingUser(ctx context.Context, userID string, now time.Time) (bool, error)
+	DeleteUser(ctx context.Context, userID string) error
+}
+
+// SessionStore keeps a durable copy of the sessions held in Redis
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *models.Session) error
+	TouchSession(ctx context.Context, sessionToken string, lastUsedAt, expiresAt time.Time) error
+	ListUserSessions(ctx context.Context, userID string) ([]*models.Session, error)
+	DeleteSession(ctx context.Context, sessionToken string) error
+	DeleteUserSessions(ctx context.Context, userID string) error
+}
+
+// RefreshTokenStore persists refresh token families
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	GetActiveTokenBySession(ctx context.Context, sessionToken string) (*models.RefreshToken, error)
+	MarkRotated(ctx context.Context, id string) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string) ([]string, error)
+	RevokeClientTokens(ctx context.Context, clientID string) ([]string, error)
+	RevokeBySession(ctx context.Context, sessionToken string) error
+	RevokeUserTokens(ctx context.Context, userID string) error
+}
+
+// RoleStore persists roles and their assignments
+type RoleStore interface {
+	GetUserAuthorization(ctx context.Context, userID string) ([]string, []string, error)
+	GetServiceAccountAuthorization(ctx context.Context, serviceAccountID string) ([]string, []string, error)
+	ListRoles(ctx context.Context) ([]*models.Role, error)
+	AssignRole(ctx context.Context, userID, role string) error
+	UnassignRole(ctx context.Context, userID, role string) error
+	AssignServiceAccountRole(ctx context.Context, serviceAccountID, role string) error
+	UnassignServiceAccountRole(ctx context.Context, serviceAccountID, role string) error
+}
+
+// TenantStore persists tenants
+type TenantStore interface {
+	CreateTenant(ctx context.Context, tenant *models.Tenant) error
+	UpdateTenant(ctx context.Context, tenant *models.Tenant) error
+	GetTenant(ctx context.Context, id string) (*models.Tenant, error)
+	ListTenants(ctx context.Context) ([]*models.Tenant, error)
+}
+
+// AuditStore persists audit events
+type AuditStore interface {
+	CreateEvent(ctx context.Context, event *models.AuditEvent) error
+	ListUserEvents(ctx context.Context, userID string) ([]*models.AuditEvent, error)
+}
+
+// PersistentLoginStore persists remember-me series
+type PersistentLoginStore interface {
+	CreatePersistentLogin(ctx context.Context, login *models.PersistentLogin) error
+	GetPersistentLogin(ctx context.Context, series string) (*models.PersistentLogin, error)
+	RotateToken(ctx context.Context, series, oldHash, newHash string) (bool, error)
+	DeletePersistentLogin(ctx context.Context, series string) error
+	DeleteUserPersistentLogins(ctx context.Context, userID string) error
+}
+
+// OAuthStore persists OAuth clients and authorization codes
+type OAuthStore interface {
+	CreateClient(ctx context.Context, client *models.OAuthClient) error
+	GetClient(ctx context.Context, clientID string) (*models.OAuthClient, error)
+	ListClients(ctx context.Context, tenantID string) ([]*models.OAuthClient, error)
+	DeleteClient(ctx context.Context, clientID string) error
+	CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	GetAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+	MarkCodeUsed(ctx context.Context, codeHash string) (bool, error)
+	SetCodeSession(ctx context.Context, codeHash, sessionToken string) error
+}
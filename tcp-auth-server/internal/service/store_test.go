@@ -0,0 +1,698 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+	"tcp-auth-server/pkg/password"
+	"tcp-auth-server/pkg/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The in-memory stores below stand in for PostgreSQL in service tests.
+// Each embeds its interface, so a test calling a method a store does not
+// implement fails loudly.
+
+// memoryUserStore holds users by ID
+type memoryUserStore struct {
+	UserStore
+
+	mu    sync.Mutex
+	users map[string]*models.User
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{users: make(map[string]*models.User)}
+}
+
+// add stores a copy of user, giving it an ID and the default tenant if
+// it has none
+func (s *memoryUserStore) add(user *models.User) *models.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	if user.TenantID == "" {
+		user.TenantID = models.DefaultTenantID
+	}
+	stored := *user
+	s.users[user.ID] = &stored
+	return user
+}
+
+func (s *memoryUserStore) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *memoryUserStore) GetUserByUsername(ctx context.Context, tenantID, username string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.TenantID == tenantID && user.Username == username {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (s *memoryUserStore) CreateBackendUser(ctx context.Context, tenantID, backend, username, email string) (*models.User, error) {
+	return s.add(&models.User{
+		TenantID:  tenantID,
+		Backend:   backend,
+		Username:  username,
+		Email:     email,
+		Status:    models.UserStatusActive,
+		CreatedAt: time.Now(),
+	}), nil
+}
+
+func (s *memoryUserStore) ListUsers(ctx context.Context, tenantID, search string, limit, offset int) ([]*models.User, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matching []*models.User
+	for _, user := range s.users {
+		if user.TenantID == tenantID && (strings.Contains(user.Username, search) || strings.Contains(user.Email, search)) {
+			found := *user
+			matching = append(matching, &found)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Username < matching[j].Username })
+
+	total := len(matching)
+	if offset > total {
+		offset = total
+	}
+	matching = matching[offset:]
+	if limit < len(matching) {
+		matching = matching[:limit]
+	}
+	return matching, total, nil
+}
+
+func (s *memoryUserStore) UpdateEmail(ctx context.Context, userID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.Email = email
+	}
+	return nil
+}
+
+func (s *memoryUserStore) SetStatus(ctx context.Context, userID, status, reason string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	user.Status = status
+	user.StatusReason = reason
+	user.StatusExpiresAt = expiresAt
+	return nil
+}
+
+func (s *memoryUserStore) ListDueDeletions(ctx context.Context, now time.Time, limit int) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*models.User
+	for _, user := range s.users {
+		if s.deletionDue(user, now) && len(due) < limit {
+			found := *user
+			due = append(due, &found)
+		}
+	}
+	return due, nil
+}
+
+// deletionDue reports whether the scheduled deletion of user is due at now
+func (s *memoryUserStore) deletionDue(user *models.User, now time.Time) bool {
+	return user.Status == models.UserStatusPendingDeletion && !user.StatusExpiresAt.After(now)
+}
+
+func (s *memoryUserStore) DeletePendingUser(ctx context.Context, userID string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || !s.deletionDue(user, now) {
+		return false, nil
+	}
+	delete(s.users, userID)
+	return true, nil
+}
+
+func (s *memoryUserStore) AnonymizePendingUser(ctx context.Context, userID string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || !s.deletionDue(user, now) {
+		return false, nil
+	}
+	user.Username = "deleted-" + user.ID
+	user.Email = "deleted-" + user.ID + "@invalid"
+	user.PasswordHash = ""
+	user.Status = models.UserStatusDisabled
+	user.StatusReason = "account deleted"
+	user.StatusExpiresAt = time.Time{}
+	return true, nil
+}
+
+func (s *memoryUserStore) DeleteUser(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok {
+		return repository.ErrUserNotFound
+	}
+	delete(s.users, userID)
+	return nil
+}
+
+// memorySessionStore holds the durable copies of sessions by token
+type memorySessionStore struct {
+	SessionStore
+
+	mu       sync.Mutex
+	sessions map[string]*models.Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]*models.Session)}
+}
+
+func (s *memorySessionStore) CreateSession(ctx context.Context, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *session
+	s.sessions[session.Token] = &stored
+	return nil
+}
+
+func (s *memorySessionStore) TouchSession(ctx context.Context, sessionToken string, lastUsedAt, expiresAt time.Time) error {
+	return nil
+}
+
+func (s *memorySessionStore) DeleteSession(ctx context.Context, sessionToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionToken)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteUserSessions(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+// memoryRefreshTokenStore holds refresh tokens by ID
+type memoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*models.RefreshToken
+}
+
+func newMemoryRefreshTokenStore() *memoryRefreshTokenStore {
+	return &memoryRefreshTokenStore{tokens: make(map[string]*models.RefreshToken)}
+}
+
+// family returns the tokens of a family, oldest first
+func (s *memoryRefreshTokenStore) family(familyID string) []*models.RefreshToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var family []*models.RefreshToken
+	for _, token := range s.tokens {
+		if token.FamilyID == familyID {
+			found := *token
+			family = append(family, &found)
+		}
+	}
+	sort.Slice(family, func(i, j int) bool { return family[i].CreatedAt.Before(family[j].CreatedAt) })
+	return family
+}
+
+func (s *memoryRefreshTokenStore) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *token
+	s.tokens[token.ID] = &stored
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.TokenHash == tokenHash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrRefreshTokenNotFound
+}
+
+func (s *memoryRefreshTokenStore) GetActiveTokenBySession(ctx context.Context, sessionToken string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.SessionToken == sessionToken && token.Status == models.RefreshTokenActive {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrRefreshTokenNotFound
+}
+
+func (s *memoryRefreshTokenStore) MarkRotated(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok || token.Status != models.RefreshTokenActive {
+		return false, nil
+	}
+	token.Status = models.RefreshTokenRotated
+	token.UsedAt = time.Now()
+	return true, nil
+}
+
+// revoke revokes the tokens matching and returns their session tokens
+func (s *memoryRefreshTokenStore) revoke(match func(*models.RefreshToken) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessionTokens []string
+	for _, token := range s.tokens {
+		if match(token) {
+			token.Status = models.RefreshTokenRevoked
+			sessionTokens = append(sessionTokens, token.SessionToken)
+		}
+	}
+	return sessionTokens
+}
+
+func (s *memoryRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) ([]string, error) {
+	return s.revoke(func(token *models.RefreshToken) bool { return token.FamilyID == familyID }), nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeClientTokens(ctx context.Context, clientID string) ([]string, error) {
+	return s.revoke(func(token *models.RefreshToken) bool {
+		return token.ClientID == clientID && token.Status == models.RefreshTokenActive
+	}), nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeBySession(ctx context.Context, sessionToken string) error {
+	families := make(map[string]bool)
+	s.mu.Lock()
+	for _, token := range s.tokens {
+		if token.SessionToken == sessionToken {
+			families[token.FamilyID] = true
+		}
+	}
+	s.mu.Unlock()
+	s.revoke(func(token *models.RefreshToken) bool { return families[token.FamilyID] })
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeUserTokens(ctx context.Context, userID string) error {
+	s.revoke(func(token *models.RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+// memoryRoleStore holds role assignments and the permissions of roles
+type memoryRoleStore struct {
+	RoleStore
+
+	mu          sync.Mutex
+	permissions map[string][]string // by role
+	assignments map[string][]string // roles by user ID
+}
+
+func newMemoryRoleStore() *memoryRoleStore {
+	return &memoryRoleStore{
+		permissions: make(map[string][]string),
+		assignments: make(map[string][]string),
+	}
+}
+
+func (s *memoryRoleStore) GetUserAuthorization(ctx context.Context, userID string) ([]string, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roles := append([]string(nil), s.assignments[userID]...)
+	var permissions []string
+	for _, role := range roles {
+		permissions = append(permissions, s.permissions[role]...)
+	}
+	return roles, permissions, nil
+}
+
+func (s *memoryRoleStore) AssignRole(ctx context.Context, userID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.assignments[userID] = append(s.assignments[userID], role)
+	return nil
+}
+
+// memoryPersistentLoginStore holds remember-me series
+type memoryPersistentLoginStore struct {
+	mu     sync.Mutex
+	logins map[string]*models.PersistentLogin
+}
+
+func newMemoryPersistentLoginStore() *memoryPersistentLoginStore {
+	return &memoryPersistentLoginStore{logins: make(map[string]*models.PersistentLogin)}
+}
+
+func (s *memoryPersistentLoginStore) CreatePersistentLogin(ctx context.Context, login *models.PersistentLogin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *login
+	s.logins[login.Series] = &stored
+	return nil
+}
+
+func (s *memoryPersistentLoginStore) GetPersistentLogin(ctx context.Context, series string) (*models.PersistentLogin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, ok := s.logins[series]
+	if !ok {
+		return nil, fmt.Errorf("persistent login not found")
+	}
+	found := *login
+	return &found, nil
+}
+
+func (s *memoryPersistentLoginStore) RotateToken(ctx context.Context, series, oldHash, newHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, ok := s.logins[series]
+	if !ok || login.TokenHash != oldHash {
+		return false, nil
+	}
+	login.TokenHash = newHash
+	login.LastUsedAt = time.Now()
+	return true, nil
+}
+
+func (s *memoryPersistentLoginStore) DeletePersistentLogin(ctx context.Context, series string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.logins, series)
+	return nil
+}
+
+func (s *memoryPersistentLoginStore) DeleteUserPersistentLogins(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for series, login := range s.logins {
+		if login.UserID == userID {
+			delete(s.logins, series)
+		}
+	}
+	return nil
+}
+
+// memoryOAuthStore holds OAuth clients and authorization codes
+type memoryOAuthStore struct {
+	OAuthStore
+
+	mu      sync.Mutex
+	clients map[string]*models.OAuthClient
+	codes   map[string]*models.AuthorizationCode // by hash
+}
+
+func newMemoryOAuthStore() *memoryOAuthStore {
+	return &memoryOAuthStore{
+		clients: make(map[string]*models.OAuthClient),
+		codes:   make(map[string]*models.AuthorizationCode),
+	}
+}
+
+func (s *memoryOAuthStore) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *client
+	s.clients[client.ID] = &stored
+	return nil
+}
+
+func (s *memoryOAuthStore) GetClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[clientID]
+	if !ok {
+		return nil, fmt.Errorf("client not found")
+	}
+	found := *client
+	return &found, nil
+}
+
+func (s *memoryOAuthStore) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *code
+	s.codes[code.CodeHash] = &stored
+	return nil
+}
+
+func (s *memoryOAuthStore) GetAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[codeHash]
+	if !ok {
+		return nil, fmt.Errorf("authorization code not found")
+	}
+	found := *code
+	return &found, nil
+}
+
+func (s *memoryOAuthStore) MarkCodeUsed(ctx context.Context, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[codeHash]
+	if !ok || !code.UsedAt.IsZero() {
+		return false, nil
+	}
+	code.UsedAt = time.Now()
+	return true, nil
+}
+
+func (s *memoryOAuthStore) SetCodeSession(ctx context.Context, codeHash, sessionToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code, ok := s.codes[codeHash]; ok {
+		code.SessionToken = sessionToken
+	}
+	return nil
+}
+
+// memoryTenantStore has no tenants, so every request runs in the default
+// tenant
+type memoryTenantStore struct {
+	TenantStore
+}
+
+func (s *memoryTenantStore) GetTenant(ctx context.Context, id string) (*models.Tenant, error) {
+	return nil, fmt.Errorf("tenant not found")
+}
+
+// memoryAuditStore records audit events in order
+type memoryAuditStore struct {
+	mu     sync.Mutex
+	events []*models.AuditEvent
+}
+
+func (s *memoryAuditStore) CreateEvent(ctx context.Context, event *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memoryAuditStore) ListUserEvents(ctx context.Context, userID string) ([]*models.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*models.AuditEvent
+	for _, event := range s.events {
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// eventTypes returns the types of the events recorded for a user
+func (s *memoryAuditStore) eventTypes(userID string) []string {
+	events, _ := s.ListUserEvents(context.Background(), userID)
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.EventType)
+	}
+	return types
+}
+
+// testServices wires the services to in-memory stores and a Redis server
+// running in the test process
+type testServices struct {
+	redis    *miniredis.Miniredis
+	users    *memoryUserStore
+	sessions *memorySessionStore
+	refresh  *memoryRefreshTokenStore
+	roles    *memoryRoleStore
+	audit    *memoryAuditStore
+	logins   *memoryPersistentLoginStore
+	oauth    *memoryOAuthStore
+
+	redisClient       *redis.Client
+	tenantService     *TenantService
+	sessionService    *SessionService
+	auditService      *AuditService
+	refreshService    *RefreshService
+	rememberMeService *RememberMeService
+	roleService       *RoleService
+	authService       *AuthService
+	oauthService      *OAuthService
+	userAdminService  *UserAdminService
+	accountService    *AccountService
+}
+
+// testPasswords hashes the passwords of test users at the lowest bcrypt
+// cost, which keeps the tests fast
+var testPasswords = password.NewManager(password.NewBcryptHasher(bcrypt.MinCost))
+
+// testSessionPolicy is the session policy of test services
+var testSessionPolicy = SessionPolicy{IdleTimeout: time.Hour, MaxLifetime: 24 * time.Hour}
+
+// newTestServices creates test services enforcing limitPolicy
+func newTestServices(t *testing.T, limitPolicy SessionLimitPolicy) *testServices {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	redisClient, err := redis.NewClient(mr.Host(), mr.Port(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+
+	ts := &testServices{
+		redis:       mr,
+		users:       newMemoryUserStore(),
+		sessions:    newMemorySessionStore(),
+		refresh:     newMemoryRefreshTokenStore(),
+		roles:       newMemoryRoleStore(),
+		audit:       &memoryAuditStore{},
+		logins:      newMemoryPersistentLoginStore(),
+		oauth:       newMemoryOAuthStore(),
+		redisClient: redisClient,
+	}
+	ts.tenantService = NewTenantService(&memoryTenantStore{}, nil)
+	ts.sessionService = NewSessionService(
+		redisClient,
+		ts.sessions,
+		ts.users,
+		ts.refresh,
+		ts.roles,
+		ts.tenantService,
+		testSessionPolicy,
+		nil,
+		limitPolicy,
+	)
+	ts.auditService = NewAuditService(ts.audit)
+	ts.refreshService = NewRefreshService(ts.refresh, ts.users, ts.sessionService, ts.auditService, 30*24*time.Hour)
+	ts.rememberMeService = NewRememberMeService(ts.logins, ts.users, ts.sessionService, ts.refreshService, ts.auditService, 90*24*time.Hour)
+	ts.roleService = NewRoleService(ts.roles, ts.sessionService, ts.auditService)
+	ts.authService = NewAuthService(
+		ts.users,
+		ts.sessionService,
+		testPasswords,
+		ts.tenantService,
+		nil,
+		ts.refreshService,
+		ts.rememberMeService,
+		nil,
+		ts.roleService,
+		nil,
+		nil,
+		[]CredentialBackend{NewPasswordBackend(ts.users, testPasswords)},
+	)
+	ts.oauthService = NewOAuthService(ts.oauth, ts.authService, ts.auditService, time.Minute, "", time.Hour)
+	ts.userAdminService = NewUserAdminService(ts.users, ts.roles, ts.sessionService, ts.refreshService, ts.rememberMeService, ts.auditService, time.Hour)
+	ts.accountService = NewAccountService(
+		ts.users,
+		ts.roles,
+		ts.sessions,
+		nil,
+		ts.audit,
+		ts.authService,
+		ts.userAdminService,
+		ts.auditService,
+		24*time.Hour,
+		DeletionModeDelete,
+	)
+	return ts
+}
+
+// addUser stores an active user holding roles
+func (ts *testServices) addUser(username string, roles ...string) *models.User {
+	user := ts.users.add(&models.User{
+		Username: username,
+		Email:    username + "@example.com",
+		Status:   models.UserStatusActive,
+	})
+	for _, role := range roles {
+		_ = ts.roles.AssignRole(context.Background(), user.ID, role)
+	}
+	return user
+}
+
+// setPassword gives user a password
+func (ts *testServices) setPassword(t *testing.T, user *models.User, plaintext string) {
+	t.Helper()
+	hash, err := testPasswords.Hash(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.users.mu.Lock()
+	defer ts.users.mu.Unlock()
+	ts.users.users[user.ID].PasswordHash = hash
+}
+
+// login creates a session for user and starts a refresh token family
+func (ts *testServices) login(t *testing.T, user *models.User) (*models.Session, *IssuedRefreshToken) {
+	t.Helper()
+	ctx := context.Background()
+	session, err := ts.sessionService.CreateSession(ctx, user, SessionOptions{ClientType: "web"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	refresh, err := ts.refreshService.Issue(ctx, session)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	return session, refresh
+}
+
+// sessionAlive reports whether a session token can still be used
+func (ts *testServices) sessionAlive(token string) bool {
+	_, err := ts.sessionService.GetSession(context.Background(), token)
+	return err == nil
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/password"
+)
+
+// tenantCacheTTL bounds how long a tenant's configuration is cached, and
+// so how long a change takes to apply on every server
+const tenantCacheTTL = time.Minute
+
+// tenantContextKey carries the tenant of a request in its context
+type tenantContextKey struct{}
+
+// defaultTenant stands in for the default tenant until it is loaded, and
+// when the tenants table has not been created yet
+var defaultTenant = &models.Tenant{ID: models.DefaultTenantID, Name: "Default"}
+
+// WithTenant returns a context carrying the tenant a request is made in
+func WithTenant(ctx context.Context, tenant *models.Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFrom returns the tenant a request is made in, or the default
+// tenant if none was set
+func TenantFrom(ctx context.Context) *models.Tenant {
+	if tenant, ok := ctx.Value(tenantContextKey{}).(*models.Tenant); ok {
+		return tenant
+	}
+	return defaultTenant
+}
+
+// tenantKey namespaces a Redis key by tenant. Keys of the default tenant
+// keep their unprefixed form so existing sessions survive the upgrade.
+func tenantKey(tenantID, key string) string {
+	if tenantID == "" || tenantID == models.DefaultTenantID {
+		return key
+	}
+	return "tenant:" + tenantID + ":" + key
+}
+
+// cachedTenant is a tenant with the time it was loaded
+type cachedTenant struct {
+	tenant   *models.Tenant
+	loadedAt time.Time
+}
+
+// TenantService manages tenants and their configuration. Tenants are read
+// on every request, so they are cached briefly.
+type TenantService struct {
+	tenantRepo     TenantStore
+	passwordPolicy *password.Policy
+
+	mu    sync.Mutex
+	cache map[string]cachedTenant
+}
+
+// NewTenantService creates a new tenant service. passwordPolicy is the
+// server's policy, which tenants may override in part.
+func NewTenantService(tenantRepo TenantStore, passwordPolicy *password.Policy) *TenantService {
+	return &TenantService{
+		tenantRepo:     tenantRepo,
+		passwordPolicy: passwordPolicy,
+		cache:          make(map[string]cachedTenant),
+	}
+}
+
+// Get returns a tenant by ID; an empty ID names the default tenant
+func (s *TenantService) Get(ctx context.Context, id string) (*models.Tenant, error) {
+	if id == "" {
+		id = models.DefaultTenantID
+	}
+
+	s.mu.Lock()
+	cached, ok := s.cache[id]
+	s.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < tenantCacheTTL {
+		return cached.tenant, nil
+	}
+
+	tenant, err := s.tenantRepo.GetTenant(ctx, id)
+	if err != nil {
+		if id == models.DefaultTenantID {
+			return defaultTenant, nil
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[id] = cachedTenant{tenant: tenant, loadedAt: time.Now()}
+	s.mu.Unlock()
+	return tenant, nil
+}
+
+// List returns every tenant
+func (s *TenantService) List(ctx context.Context) ([]*models.Tenant, error) {
+	return s.tenantRepo.ListTenants(ctx)
+}
+
+// Create stores a new tenant
+func (s *TenantService) Create(ctx context.Context, tenant *models.Tenant) error {
+	if err := models.ValidateTenantID(tenant.ID); err != nil {
+		return err
+	}
+	tenant.Name = strings.TrimSpace(tenant.Name)
+	if tenant.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if err := validateTenantConfig(tenant); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tenant.CreatedAt = now
+	tenant.UpdatedAt = now
+	return s.tenantRepo.CreateTenant(ctx, tenant)
+}
+
+// Update stores a tenant's name and configuration. The change applies to
+// sessions created and passwords set afterwards.
+func (s *TenantService) Update(ctx context.Context, tenant *models.Tenant) error {
+	tenant.Name = strings.TrimSpace(tenant.Name)
+	if tenant.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if err := validateTenantConfig(tenant); err != nil {
+		return err
+	}
+
+	tenant.UpdatedAt = time.Now()
+	if err := s.tenantRepo.UpdateTenant(ctx, tenant); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.cache, tenant.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+// PasswordPolicy returns the password policy for a tenant: the server's
+// policy with the tenant's overrides applied
+func (s *TenantService) PasswordPolicy(tenant *models.Tenant) *password.Policy {
+	overrides := tenant.PasswordPolicy
+	if overrides == nil {
+		return s.passwordPolicy
+	}
+
+	policy := *s.passwordPolicy
+	if overrides.MinLength > 0 {
+		policy.MinLength = overrides.MinLength
+	}
+	if overrides.MaxLength > 0 {
+		policy.MaxLength = overrides.MaxLength
+	}
+	if len(overrides.RequiredClasses) > 0 {
+		policy.RequiredClasses = overrides.RequiredClasses
+	}
+	if overrides.MinClasses > 0 {
+		policy.MinClasses = overrides.MinClasses
+	}
+	if overrides.MinEntropyBits > 0 {
+		policy.MinEntropyBits = overrides.MinEntropyBits
+	}
+	return &policy
+}
+
+// validateTenantConfig rejects configuration that could not be applied
+func validateTenantConfig(tenant *models.Tenant) error {
+	if tenant.SessionMaxLifetime < 0 || tenant.SessionIdleTimeout < 0 {
+		return fmt.Errorf("session lifetimes must not be negative")
+	}
+	overrides := tenant.PasswordPolicy
+	if overrides == nil {
+		return nil
+	}
+	if overrides.MinLength < 0 || overrides.MaxLength < 0 || overrides.MinClasses < 0 || overrides.MinEntropyBits < 0 {
+		return fmt.Errorf("password policy values must not be negative")
+	}
+	if overrides.MaxLength > 0 && overrides.MinLength > overrides.MaxLength {
+		return fmt.Errorf("password min_length exceeds max_length")
+	}
+	for _, class := range overrides.RequiredClasses {
+		switch class {
+		case password.ClassLower, password.ClassUpper, password.ClassDigit, password.ClassSymbol:
+		default:
+			return fmt.Errorf("unknown character class %q", class)
+		}
+	}
+	return nil
+}
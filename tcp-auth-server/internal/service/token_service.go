@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/pkg/token"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// AccessClaims are the claims carried by a signed access token
+type AccessClaims struct {
+	Username    string   `json:"username"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// Scope is the space separated list of scopes of a restricted token
+	Scope string `json:"scope,omitempty"`
+	// PrincipalType is set when the subject is not a user
+	PrincipalType string `json:"principal_type,omitempty"`
+	// Tenant is the tenant of a user subject
+	Tenant string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// keyRotator is implemented by key sets that support rotation
+type keyRotator interface {
+	Rotate(ctx context.Context, revokePrevious bool) (*token.Key, error)
+}
+
+// TokenService issues and verifies short-lived signed access tokens
+type TokenService struct {
+	keys      token.KeySet
+	issuer    string
+	audience  string
+	accessTTL time.Duration
+}
+
+// NewTokenService creates a new token service
+func NewTokenService(keys token.KeySet, issuer, audience string, accessTTL time.Duration) *TokenService {
+	return &TokenService{
+		keys:      keys,
+		issuer:    issuer,
+		audience:  audience,
+		accessTTL: accessTTL,
+	}
+}
+
+// IssueAccessToken signs an access token for a session. The token never
+// outlives the session it was issued for.
+func (s *TokenService) IssueAccessToken(session *models.Session) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.accessTTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	claims := AccessClaims{
+		Username:      session.Username,
+		Email:         session.Email,
+		Roles:         session.Roles,
+		Permissions:   session.Permissions,
+		Scope:         strings.Join(session.Scopes, " "),
+		PrincipalType: session.PrincipalType,
+		Tenant:        session.TenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    s.issuer,
+			Subject:   session.UserID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	if s.audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.audience}
+	}
+
+	signed, err := token.Sign(s.keys, claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// VerifyAccessToken checks an access token's signature, issuer, audience
+// and expiry and returns its claims
+func (s *TokenService) VerifyAccessToken(tokenString string) (*AccessClaims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(s.issuer),
+		jwt.WithExpirationRequired(),
+	}
+	if s.audience != "" {
+		opts = append(opts, jwt.WithAudience(s.audience))
+	}
+
+	var claims AccessClaims
+	if err := token.Parse(s.keys, tokenString, &claims, opts...); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token: missing subject")
+	}
+	return &claims, nil
+}
+
+// SignClaims signs other claims with the access token signing key, for
+// tokens such as OpenID Connect ID tokens that are verified with the same
+// JWKS
+func (s *TokenService) SignClaims(claims jwt.Claims) (string, error) {
+	return token.Sign(s.keys, claims)
+}
+
+// ParseClaims verifies a token signed by SignClaims and decodes its claims
+func (s *TokenService) ParseClaims(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	return token.Parse(s.keys, tokenString, claims, opts...)
+}
+
+// Algorithm returns the algorithm of the current signing key
+func (s *TokenService) Algorithm() string {
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		return ""
+	}
+	return key.Algorithm
+}
+
+// JWKS returns the public keys that verify issued tokens
+func (s *TokenService) JWKS() token.JWKS {
+	return token.BuildJWKS(s.keys)
+}
+
+// RotateKeys activates a new signing key. If revokePrevious is set, tokens
+// signed by the previous key stop verifying immediately.
+func (s *TokenService) RotateKeys(ctx context.Context, revokePrevious bool) (*token.Key, error) {
+	rotator, ok := s.keys.(keyRotator)
+	if !ok {
+		return nil, fmt.Errorf("key rotation requires JWT_KEY_STORE to be configured")
+	}
+	return rotator.Rotate(ctx, revokePrevious)
+}
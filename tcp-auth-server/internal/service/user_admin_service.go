@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp-auth-server/internal/models"
+)
+
+// adminTokenActorPrefix marks the actor IDs of requests authorized by a
+// shared admin secret
+const adminTokenActorPrefix = "admin-token:"
+
+// AdminTokenActor returns the actor ID recorded for requests authorized by
+// a shared admin secret. It names the secret by a short fingerprint, so
+// audit entries tell the configured secrets apart without revealing them.
+func AdminTokenActor(token string) string {
+	return adminTokenActorPrefix + hashToken(token)[:12]
+}
+
+// Page sizes of ListUsers
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 500
+)
+
+// UserDetails is a user with their roles and live sessions
+type UserDetails struct {
+	User     *models.User
+	Sessions []*models.Session
+}
+
+// UserAdminService lets administrators inspect and manage user accounts.
+// Every call is recorded in the audit trail with the acting admin.
+type UserAdminService struct {
+	userRepo       UserStore
+	roleRepo       RoleStore
+	sessionService *SessionService
+	refreshService *RefreshService
+	rememberMe     *RememberMeService
+	auditService   *AuditService
+
+	// impersonationTTL caps the lifetime of impersonation sessions
+	impersonationTTL time.Duration
+}
+
+// NewUserAdminService creates a new user administration service.
+// Impersonation sessions last at most impersonationTTL.
+func NewUserAdminService(
+	userRepo UserStore,
+	roleRepo RoleStore,
+	sessionService *SessionService,
+	refreshService *RefreshService,
+	rememberMe *RememberMeService,
+	auditService *AuditService,
+	impersonationTTL time.Duration,
+) *UserAdminService {
+	return &UserAdminService{
+		userRepo:       userRepo,
+		roleRepo:       roleRepo,
+		sessionService: sessionService,
+		refreshService: refreshService,
+		rememberMe:     rememberMe,
+		auditService:   auditService,
+
+		impersonationTTL: impersonationTTL,
+	}
+}
+
+// ListUsers returns a page of the request tenant's users matching search
+// and the number of users matching
+func (s *UserAdminService) ListUsers(ctx context.Context, actorID, search string, limit, offset int) ([]*models.User, int, error) {
+	if limit <= 0 {
+		limit = defaultUserPageSize
+	}
+	if limit > maxUserPageSize {
+		limit = maxUserPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	users, total, err := s.userRepo.ListUsers(ctx, TenantFrom(ctx).ID, search, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.record(ctx, models.EventAdminUsersListed, "", actorID, map[string]interface{}{
+		"tenant": TenantFrom(ctx).ID,
+		"search": search,
+		"limit":  limit,
+		"offset": offset,
+	})
+	return users, total, nil
+}
+
+// GetUser returns a user with their roles and live sessions
+func (s *UserAdminService) GetUser(ctx context.Context, actorID, userID string) (*UserDetails, error) {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	user.Roles, user.Permissions, err = s.roleRepo.GetUserAuthorization(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.sessionService.ListUserSessions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(ctx, models.EventAdminUserViewed, user.ID, actorID, nil)
+	return &UserDetails{User: user, Sessions: sessions}, nil
+}
+
+// Suspend stops a user from logging in until expiresAt, or until the
+// account is enabled again when expiresAt is zero, and ends their sessions
+func (s *UserAdminService) Suspend(ctx context.Context, actorID, userID, reason string, expiresAt time.Time) error {
+	if actorID != "" && actorID == userID {
+		return fmt.Errorf("you cannot suspend your own account")
+	}
+	return s.deactivate(ctx, actorID, userID, models.UserStatusSuspended, models.EventUserSuspended, reason, expiresAt)
+}
+
+// Disable stops a user from logging in and ends their sessions. Their API
+// keys stop working until the account is enabled again or a non-zero
+// expiresAt passes.
+func (s *UserAdminService) Disable(ctx context.Context, actorID, userID, reason string, expiresAt time.Time) error {
+	if actorID != "" && actorID == userID {
+		return fmt.Errorf("you cannot disable your own account")
+	}
+	return s.deactivate(ctx, actorID, userID, models.UserStatusDisabled, models.EventUserDisabled, reason, expiresAt)
+}
+
+// deactivate sets an inactive status and ends the user's sessions at once,
+// so tokens issued before do not outlive the change
+func (s *UserAdminService) deactivate(ctx context.Context, actorID, userID, status, eventType, reason string, expiresAt time.Time) error {
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return fmt.Errorf("expiry must be in the future")
+	}
+	if err := s.userRepo.SetStatus(ctx, userID, status, reason, expiresAt); err != nil {
+		return err
+	}
+	ended := s.endAllSessions(ctx, userID)
+
+	details := map[string]interface{}{
+		"reason":         reason,
+		"sessions_ended": ended,
+	}
+	if !expiresAt.IsZero() {
+		details["expires_at"] = expiresAt.Unix()
+	}
+	s.record(ctx, eventType, userID, actorID, details)
+	return nil
+}
+
+// Enable lets a suspended or disabled user log in again
+func (s *UserAdminService) Enable(ctx context.Context, actorID, userID string) error {
+	if err := s.userRepo.SetStatus(ctx, userID, models.UserStatusActive, "", time.Time{}); err != nil {
+		return err
+	}
+
+	s.record(ctx, models.EventUserEnabled, userID, actorID, nil)
+	return nil
+}
+
+// ForceLogout ends every session of a user, with their refresh tokens and
+// remember-me logins, and returns how many sessions were ended
+func (s *UserAdminService) ForceLogout(ctx context.Context, actorID, userID string) (int, error) {
+	if _, err := s.userRepo.GetUserByID(ctx, userID); err != nil {
+		return 0, err
+	}
+	ended := s.endAllSessions(ctx, userID)
+
+	s.record(ctx, models.EventUserForcedLogout, userID, actorID, map[string]interface{}{
+		"sessions_ended": ended,
+	})
+	return ended, nil
+}
+
+// Delete ends a user's sessions and deletes the account with everything
+// that refers to it
+func (s *UserAdminService) Delete(ctx context.Context, actorID, userID string) error {
+	if actorID != "" && actorID == userID {
+		return fmt.Errorf("you cannot delete your own account")
+	}
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Sessions live in Redis as well, which does not cascade
+	s.endAllSessions(ctx, user.ID)
+	if err := s.userRepo.DeleteUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	s.record(ctx, models.EventUserDeleted, user.ID, actorID, map[string]interface{}{
+		"tenant":   user.TenantID,
+		"username": user.Username,
+		"email":    user.Email,
+	})
+	return nil
+}
+
+// Impersonate issues the admin a session acting as a user, so support staff
+// see what the user sees. The session records the admin, lasts at most
+// the configured impersonation TTL, or ttl when shorter, and cannot be
+// refreshed or used to manage the user's credentials. It does not count
+// towards the user's session limit. Administrators cannot be impersonated.
+func (s *UserAdminService) Impersonate(ctx context.Context, actorID, userID, reason string, ttl time.Duration, opts SessionOptions) (*models.Session, error) {
+	// A shared secret does not tell who is acting as the user
+	if actorID == "" || strings.HasPrefix(actorID, adminTokenActorPrefix) {
+		return nil, fmt.Errorf("impersonation requires an admin user or client certificate")
+	}
+	if actorID == userID {
+		return nil, fmt.Errorf("you cannot impersonate yourself")
+	}
+	if reason == "" {
+		return nil, fmt.Errorf("reason is required")
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	_, permissions, err := s.roleRepo.GetUserAuthorization(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if models.HasPermission(permissions, models.PermissionAdmin) {
+		return nil, fmt.Errorf("administrators cannot be impersonated")
+	}
+
+	opts.Lifetime = s.impersonationTTL
+	if ttl > 0 && ttl < opts.Lifetime {
+		opts.Lifetime = ttl
+	}
+	opts.ClientID = ""
+	opts.ImpersonatorID = actorID
+
+	session, err := s.sessionService.CreateSession(ctx, user, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: models.EventImpersonationStarted,
+		UserID:    user.ID,
+		ActorID:   actorID,
+		IPAddress: opts.ClientIP,
+		Details: map[string]interface{}{
+			"reason":     reason,
+			"session_id": session.ID,
+			"expires_at": session.AbsoluteExpiresAt.Unix(),
+		},
+	})
+	return session, nil
+}
+
+// endAllSessions revokes every credential a user logged in with and
+// returns how many sessions were ended. Failures are logged, as the
+// action that triggered it has taken effect already.
+func (s *UserAdminService) endAllSessions(ctx context.Context, userID string) int {
+	sessions, err := s.sessionService.ListUserSessions(ctx, userID)
+	if err != nil {
+		fmt.Printf("Warning: failed to list sessions of user %s: %v\n", userID, err)
+	}
+
+	if err := s.refreshService.RevokeUserTokens(ctx, userID); err != nil {
+		fmt.Printf("Warning: failed to revoke refresh tokens: %v\n", err)
+	}
+	if err := s.rememberMe.ForgetUser(ctx, userID); err != nil {
+		fmt.Printf("Warning: failed to delete persistent logins: %v\n", err)
+	}
+	if err := s.sessionService.DeleteUserSessions(ctx, userID); err != nil {
+		fmt.Printf("Warning: failed to delete user sessions: %v\n", err)
+	}
+
+	return len(sessions)
+}
+
+// record stores an audit event for an admin action
+func (s *UserAdminService) record(ctx context.Context, eventType, userID, actorID string, details map[string]interface{}) {
+	s.auditService.Record(ctx, &models.AuditEvent{
+		EventType: eventType,
+		UserID:    userID,
+		ActorID:   actorID,
+		Details:   details,
+	})
+}
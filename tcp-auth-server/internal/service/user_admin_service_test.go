@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tcp-auth-server/internal/models"
+	"tcp-auth-server/internal/repository"
+)
+
+// signedIn logs user in with a refresh token and a remember-me credential
+func signedIn(t *testing.T, ts *testServices, user *models.User) *models.Session {
+	t.Helper()
+	session, _ := remember(t, ts, user)
+	return session
+}
+
+// assertSignedOut fails the test if any credential of user still works
+func assertSignedOut(t *testing.T, ts *testServices, user *models.User, sessions ...*models.Session) {
+	t.Helper()
+	for _, session := range sessions {
+		if ts.sessionAlive(session.Token) {
+			t.Error("a session survived")
+		}
+	}
+	for _, token := range ts.refresh.tokens {
+		if token.UserID == user.ID && token.Status != models.RefreshTokenRevoked {
+			t.Error("a refresh token survived")
+		}
+	}
+	for _, login := range ts.logins.logins {
+		if login.UserID == user.ID {
+			t.Error("a remember-me login survived")
+		}
+	}
+}
+
+// lastEvent returns the last audit event recorded for a user
+func lastEvent(t *testing.T, ts *testServices, userID string) *models.AuditEvent {
+	t.Helper()
+	events, _ := ts.audit.ListUserEvents(context.Background(), userID)
+	if len(events) == 0 {
+		t.Fatal("no audit event was recorded")
+	}
+	return events[len(events)-1]
+}
+
+func TestUserAdminDisableAndEnable(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	first := signedIn(t, ts, user)
+	second := signedIn(t, ts, user)
+
+	if err := ts.userAdminService.Disable(ctx, "admin-id", user.ID, "chargebacks", time.Time{}); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	assertSignedOut(t, ts, user, first, second)
+	if got := ts.users.users[user.ID]; got.Status != models.UserStatusDisabled || got.StatusReason != "chargebacks" {
+		t.Errorf("user status = %s (%q), want disabled (chargebacks)", got.Status, got.StatusReason)
+	}
+	event := lastEvent(t, ts, user.ID)
+	if event.EventType != models.EventUserDisabled || event.ActorID != "admin-id" || event.Details["sessions_ended"] != 2 {
+		t.Errorf("audit event = %+v, want %s by admin-id ending 2 sessions", event, models.EventUserDisabled)
+	}
+
+	if err := ts.userAdminService.Enable(ctx, "admin-id", user.ID); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if got := ts.users.users[user.ID]; got.Status != models.UserStatusActive || got.StatusReason != "" {
+		t.Errorf("user status = %s (%q), want active", got.Status, got.StatusReason)
+	}
+	if event := lastEvent(t, ts, user.ID); event.EventType != models.EventUserEnabled || event.ActorID != "admin-id" {
+		t.Errorf("audit event = %+v, want %s by admin-id", event, models.EventUserEnabled)
+	}
+}
+
+func TestUserAdminDisableRejects(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	admin := ts.addUser("root")
+	user := ts.addUser("alice")
+	session := signedIn(t, ts, user)
+
+	if err := ts.userAdminService.Disable(ctx, admin.ID, admin.ID, "", time.Time{}); err == nil {
+		t.Error("an admin disabled their own account")
+	}
+	if err := ts.userAdminService.Suspend(ctx, admin.ID, user.ID, "", time.Now().Add(-time.Minute)); err == nil {
+		t.Error("Suspend accepted an expiry in the past")
+	}
+	if err := ts.userAdminService.Disable(ctx, admin.ID, "unknown", "", time.Time{}); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Disable of an unknown user = %v, want ErrUserNotFound", err)
+	}
+
+	if !ts.sessionAlive(session.Token) {
+		t.Error("a refused action ended the user's session")
+	}
+	if got := ts.users.users[user.ID].Status; got != models.UserStatusActive {
+		t.Errorf("user status = %s, want active", got)
+	}
+}
+
+func TestUserAdminForceLogout(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+	other := ts.addUser("bob")
+	sessions := []*models.Session{signedIn(t, ts, user), signedIn(t, ts, user)}
+	kept := signedIn(t, ts, other)
+
+	ended, err := ts.userAdminService.ForceLogout(ctx, "admin-id", user.ID)
+	if err != nil {
+		t.Fatalf("ForceLogout: %v", err)
+	}
+	if ended != 2 {
+		t.Errorf("ForceLogout ended %d sessions, want 2", ended)
+	}
+	assertSignedOut(t, ts, user, sessions...)
+	if !ts.sessionAlive(kept.Token) {
+		t.Error("the session of another user was ended")
+	}
+	if event := lastEvent(t, ts, user.ID); event.EventType != models.EventUserForcedLogout || event.ActorID != "admin-id" {
+		t.Errorf("audit event = %+v, want %s by admin-id", event, models.EventUserForcedLogout)
+	}
+}
+
+func TestUserAdminDelete(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	admin := ts.addUser("root")
+	user := ts.addUser("alice")
+	session := signedIn(t, ts, user)
+
+	if err := ts.userAdminService.Delete(ctx, admin.ID, admin.ID); err == nil {
+		t.Error("an admin deleted their own account")
+	}
+	if err := ts.userAdminService.Delete(ctx, admin.ID, user.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := ts.users.users[user.ID]; ok {
+		t.Error("the user is still stored")
+	}
+	assertSignedOut(t, ts, user, session)
+
+	event := lastEvent(t, ts, user.ID)
+	if event.EventType != models.EventUserDeleted || event.ActorID != admin.ID || event.Details["username"] != "alice" {
+		t.Errorf("audit event = %+v, want %s of alice by %s", event, models.EventUserDeleted, admin.ID)
+	}
+}
+
+func TestUserAdminListUsers(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	for _, name := range []string{"alice", "alina", "bob"} {
+		ts.addUser(name)
+	}
+	ts.users.add(&models.User{TenantID: "acme", Username: "alfred"})
+
+	users, total, err := ts.userAdminService.ListUsers(ctx, "admin-id", "al", 1, 1)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if total != 2 || len(users) != 1 || users[0].Username != "alina" {
+		t.Errorf("ListUsers = %d of %d, want alina of 2", len(users), total)
+	}
+
+	// Out-of-range paging falls back to the defaults
+	users, total, err = ts.userAdminService.ListUsers(ctx, "admin-id", "", 0, -1)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if total != 3 || len(users) != 3 {
+		t.Errorf("ListUsers = %d of %d, want all 3", len(users), total)
+	}
+
+	if event := lastEvent(t, ts, ""); event.EventType != models.EventAdminUsersListed || event.Details["limit"] != defaultUserPageSize {
+		t.Errorf("audit event = %+v, want %s with the default page size", event, models.EventAdminUsersListed)
+	}
+}
+
+func TestImpersonate(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	ts.roles.permissions["admin"] = []string{models.PermissionAdmin}
+	admin := ts.addUser("root", "admin")
+	user := ts.addUser("alice")
+
+	session, err := ts.userAdminService.Impersonate(ctx, admin.ID, user.ID, "ticket 42", 10*time.Minute, SessionOptions{ClientID: "app"})
+	if err != nil {
+		t.Fatalf("Impersonate: %v", err)
+	}
+	if session.UserID != user.ID || session.ImpersonatorID != admin.ID || session.ClientID != "" {
+		t.Errorf("session = %+v, want one of %s impersonated by %s without a client", session, user.ID, admin.ID)
+	}
+	if session.AbsoluteExpiresAt.After(time.Now().Add(10 * time.Minute)) {
+		t.Errorf("session expires at %v, want within the requested 10 minutes", session.AbsoluteExpiresAt)
+	}
+
+	principal, err := ts.authService.ValidateToken(ctx, session.Token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if principal.ImpersonatorID != admin.ID {
+		t.Errorf("principal impersonator = %q, want %q", principal.ImpersonatorID, admin.ID)
+	}
+
+	// The session cannot manage the user's credentials or outlive itself
+	if _, err := ts.authService.ManagingSession(ctx, session.Token, ScopeManageAccount); !errors.Is(err, ErrImpersonated) {
+		t.Errorf("ManagingSession = %v, want ErrImpersonated", err)
+	}
+	if _, err := ts.sessionService.CreateScopedSession(ctx, session, []string{ScopeManageAccount}, 0); !errors.Is(err, ErrImpersonated) {
+		t.Errorf("CreateScopedSession = %v, want ErrImpersonated", err)
+	}
+	if _, _, err := ts.refreshService.RotateSession(ctx, session, SessionOptions{}); !errors.Is(err, ErrImpersonated) {
+		t.Errorf("RotateSession = %v, want ErrImpersonated", err)
+	}
+
+	event := lastEvent(t, ts, user.ID)
+	if event.EventType != models.EventImpersonationStarted || event.ActorID != admin.ID || event.Details["reason"] != "ticket 42" {
+		t.Errorf("audit event = %+v, want %s by %s for ticket 42", event, models.EventImpersonationStarted, admin.ID)
+	}
+}
+
+func TestImpersonateLifetime(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	user := ts.addUser("alice")
+
+	// The configured TTL of an hour caps longer and unset requests
+	for _, ttl := range []time.Duration{0, 48 * time.Hour} {
+		session, err := ts.userAdminService.Impersonate(ctx, "admin-id", user.ID, "ticket 42", ttl, SessionOptions{})
+		if err != nil {
+			t.Fatalf("Impersonate for %v: %v", ttl, err)
+		}
+		if session.AbsoluteExpiresAt.After(time.Now().Add(time.Hour)) {
+			t.Errorf("session for %v expires at %v, want within an hour", ttl, session.AbsoluteExpiresAt)
+		}
+	}
+}
+
+func TestImpersonateRejects(t *testing.T) {
+	ts := newTestServices(t, SessionLimitPolicy{})
+	ctx := context.Background()
+	ts.roles.permissions["admin"] = []string{models.PermissionAdmin}
+	admin := ts.addUser("root", "admin")
+	other := ts.addUser("ops", "admin")
+	user := ts.addUser("alice")
+
+	tests := []struct {
+		name    string
+		actorID string
+		userID  string
+		reason  string
+	}{
+		{"no actor", "", user.ID, "ticket 42"},
+		{"shared secret", AdminTokenActor("secret"), user.ID, "ticket 42"},
+		{"self", admin.ID, admin.ID, "ticket 42"},
+		{"no reason", admin.ID, user.ID, ""},
+		{"administrator", admin.ID, other.ID, "ticket 42"},
+		{"unknown user", admin.ID, "unknown", "ticket 42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ts.userAdminService.Impersonate(ctx, tt.actorID, tt.userID, tt.reason, 0, SessionOptions{}); err == nil {
+				t.Error("Impersonate succeeded")
+			}
+		})
+	}
+	if keys := ts.redis.Keys(); len(keys) != 0 {
+		t.Errorf("Redis holds %v, want no sessions", keys)
+	}
+}
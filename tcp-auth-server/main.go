@@ -3,23 +3,34 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"tcp-auth-server/internal/config"
 	"tcp-auth-server/internal/handler"
+	"tcp-auth-server/internal/models"
 	"tcp-auth-server/internal/repository"
 	"tcp-auth-server/internal/service"
+	"tcp-auth-server/pkg/notify"
+	"tcp-auth-server/pkg/oidc"
+	"tcp-auth-server/pkg/password"
 	"tcp-auth-server/pkg/postgres"
 	"tcp-auth-server/pkg/protocol"
 	"tcp-auth-server/pkg/redis"
+	"tcp-auth-server/pkg/token"
 
 	"github.com/google/uuid"
 )
@@ -31,6 +42,8 @@ type Server struct {
 	redisClient    *redis.Client
 	postgresClient *postgres.Client
 	authHandler    *handler.AuthHandler
+	httpServer     *http.Server
+	tlsConfig      *tls.Config
 	connections    map[string]*Connection
 	mu             sync.RWMutex
 	ctx            context.Context
@@ -47,22 +60,41 @@ type Connection struct {
 }
 
 // NewServer creates a new TCP server
-func NewServer(host, port string) (*Server, error) {
+func NewServer(host, port, httpPort string) (*Server, error) {
 	// Load environment variables
-	redisHost := getEnv("REDIS_HOST", "localhost")
-	redisPort := getEnv("REDIS_PORT", "6379")
-	redisPassword := getEnv("REDIS_PASSWORD", "")
-
-	pgHost := getEnv("PG_HOST", "localhost")
-	pgPort := getEnv("PG_PORT", "5432")
-	pgUser := getEnv("PG_USER", "postgres")
-	pgPassword := getEnv("PG_PASSWORD", "")
-	pgDatabase := getEnv("PG_DATABASE", "ShoppingCS-LB")
-
-	sessionTTLSeconds := getEnv("SESSION_TTL", "86400")
-	sessionTTL, err := strconv.Atoi(sessionTTLSeconds)
+	redisHost := config.String("REDIS_HOST", "localhost")
+	redisPort := config.String("REDIS_PORT", "6379")
+	redisPassword := config.String("REDIS_PASSWORD", "")
+
+	pgHost := config.String("PG_HOST", "localhost")
+	pgPort := config.String("PG_PORT", "5432")
+	pgUser := config.String("PG_USER", "postgres")
+	pgPassword := config.String("PG_PASSWORD", "")
+	pgDatabase := config.String("PG_DATABASE", "ShoppingCS-LB")
+
+	defaultSessionPolicy, sessionPolicies := newSessionPolicies()
+
+	sessionLimitPolicy := service.SessionLimitPolicy{
+		MaxSessions: config.Int("SESSION_LIMIT", 0),
+		Mode:        config.String("SESSION_LIMIT_MODE", service.SessionLimitEvictOldest),
+	}
+	if mode := sessionLimitPolicy.Mode; mode != service.SessionLimitReject && mode != service.SessionLimitEvictOldest {
+		return nil, fmt.Errorf("unsupported SESSION_LIMIT_MODE: %s", mode)
+	}
+	roleLimits, err := parseRoleLimits(config.String("SESSION_LIMIT_ROLES", ""))
+	if err != nil {
+		return nil, err
+	}
+	sessionLimitPolicy.RoleLimits = roleLimits
+
+	passwords, err := config.PasswordManager()
+	if err != nil {
+		return nil, err
+	}
+
+	passwordPolicy, err := config.PasswordPolicy()
 	if err != nil {
-		sessionTTL = 86400 // Default 24 hours
+		return nil, err
 	}
 
 	// Initialize Redis client
@@ -81,18 +113,191 @@ func NewServer(host, port string) (*Server, error) {
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(postgresClient)
 	sessionRepo := repository.NewSessionRepository(postgresClient)
+	signingKeyRepo := repository.NewSigningKeyRepository(postgresClient)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(postgresClient)
+	auditRepo := repository.NewAuditRepository(postgresClient)
+	persistentLoginRepo := repository.NewPersistentLoginRepository(postgresClient)
+	roleRepo := repository.NewRoleRepository(postgresClient)
+	apiKeyRepo := repository.NewAPIKeyRepository(postgresClient)
+	serviceAccountRepo := repository.NewServiceAccountRepository(postgresClient)
+	tenantRepo := repository.NewTenantRepository(postgresClient)
+	oauthRepo := repository.NewOAuthRepository(postgresClient)
+	identityRepo := repository.NewFederatedIdentityRepository(postgresClient)
+
+	idTokenTTL := time.Duration(config.Int("OIDC_ID_TOKEN_TTL", 3600)) * time.Second
+
+	tokenService, keyManager, err := newTokenService(signingKeyRepo, idTokenTTL)
+	if err != nil {
+		redisClient.Close()
+		postgresClient.Close()
+		return nil, err
+	}
 
 	// Initialize services
+	tenantService := service.NewTenantService(tenantRepo, passwordPolicy)
 	sessionService := service.NewSessionService(
 		redisClient,
 		sessionRepo,
 		userRepo,
-		time.Duration(sessionTTL)*time.Second,
+		refreshTokenRepo,
+		roleRepo,
+		tenantService,
+		defaultSessionPolicy,
+		sessionPolicies,
+		sessionLimitPolicy,
+	)
+	auditService := service.NewAuditService(auditRepo)
+	refreshService := service.NewRefreshService(
+		refreshTokenRepo,
+		userRepo,
+		sessionService,
+		auditService,
+		time.Duration(config.Int("REFRESH_TOKEN_TTL", 30*24*3600))*time.Second,
+	)
+	rememberMeService := service.NewRememberMeService(
+		persistentLoginRepo,
+		userRepo,
+		sessionService,
+		refreshService,
+		auditService,
+		time.Duration(config.Int("REMEMBER_ME_TTL", 90*24*3600))*time.Second,
+	)
+	loginCodeService, err := newLoginCodeService(redisClient, userRepo, sessionService)
+	if err != nil {
+		redisClient.Close()
+		postgresClient.Close()
+		return nil, err
+	}
+	roleService := service.NewRoleService(roleRepo, sessionService, auditService)
+	serviceAccountService := service.NewServiceAccountService(
+		serviceAccountRepo,
+		roleRepo,
+		sessionService,
+		auditService,
+		time.Duration(config.Int("SERVICE_TOKEN_TTL", 3600))*time.Second,
+	)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, userRepo, roleRepo, serviceAccountService, sessionService, auditService)
+	credentialBackends, err := newCredentialBackends(userRepo, passwords, roleService, auditService)
+	if err != nil {
+		redisClient.Close()
+		postgresClient.Close()
+		return nil, err
+	}
+	authService := service.NewAuthService(
+		userRepo,
+		sessionService,
+		passwords,
+		tenantService,
+		tokenService,
+		refreshService,
+		rememberMeService,
+		loginCodeService,
+		roleService,
+		apiKeyService,
+		serviceAccountService,
+		credentialBackends,
+	)
+
+	oidcIssuer := strings.TrimSuffix(config.String("OIDC_ISSUER", ""), "/")
+	if oidcIssuer != "" {
+		// ID tokens are signed with the access token keys, so the issuers
+		// must differ for an ID token never to pass as an access token
+		if tokenService == nil || oidcIssuer == config.String("JWT_ISSUER", "tcp-auth-server") {
+			redisClient.Close()
+			postgresClient.Close()
+			return nil, fmt.Errorf("OIDC_ISSUER requires JWT_ENABLED and a different JWT_ISSUER")
+		}
+	}
+	oauthService := service.NewOAuthService(
+		oauthRepo,
+		authService,
+		auditService,
+		time.Duration(config.Int("OAUTH_CODE_TTL", 60))*time.Second,
+		oidcIssuer,
+		idTokenTTL,
+	)
+
+	identityProviders, err := newIdentityProviders()
+	if err != nil {
+		redisClient.Close()
+		postgresClient.Close()
+		return nil, err
+	}
+	federationService := service.NewFederationService(
+		identityRepo,
+		userRepo,
+		authService,
+		auditService,
+		redisClient,
+		identityProviders,
+		time.Duration(config.Int("FEDERATION_STATE_TTL", 600))*time.Second,
+	)
+
+	userAdminService := service.NewUserAdminService(
+		userRepo,
+		roleRepo,
+		sessionService,
+		refreshService,
+		rememberMeService,
+		auditService,
+		time.Duration(config.Int("IMPERSONATION_TTL", 900))*time.Second,
+	)
+
+	deletionMode := config.String("ACCOUNT_DELETION_MODE", service.DeletionModeDelete)
+	if deletionMode != service.DeletionModeDelete && deletionMode != service.DeletionModeAnonymize {
+		redisClient.Close()
+		postgresClient.Close()
+		return nil, fmt.Errorf("unknown ACCOUNT_DELETION_MODE %q", deletionMode)
+	}
+	accountService := service.NewAccountService(
+		userRepo,
+		roleRepo,
+		sessionRepo,
+		identityRepo,
+		auditRepo,
+		authService,
+		userAdminService,
+		auditService,
+		time.Duration(config.Int("ACCOUNT_DELETION_GRACE_PERIOD", 2592000))*time.Second,
+		deletionMode,
 	)
-	authService := service.NewAuthService(userRepo, sessionService)
+
+	tlsConfig, err := newServerTLSConfig()
+	if err != nil {
+		redisClient.Close()
+		postgresClient.Close()
+		return nil, err
+	}
+	adminCertNames := config.SplitList(config.String("ADMIN_CLIENT_CERT_NAMES", ""))
+	for _, name := range adminCertNames {
+		// Certificate actors are audited as "cert:<name>" in a 50 character
+		// column
+		if len(name) > 45 {
+			redisClient.Close()
+			postgresClient.Close()
+			return nil, fmt.Errorf("admin client certificate name %q is longer than 45 characters", name)
+		}
+	}
+	if len(adminCertNames) > 0 && (tlsConfig == nil || tlsConfig.ClientCAs == nil) {
+		fmt.Printf("Warning: ADMIN_CLIENT_CERT_NAMES is set but client certificates are not verified\n")
+	}
 
 	// Initialize handler
-	authHandler := handler.NewAuthHandler(authService)
+	authHandler := handler.NewAuthHandler(
+		authService,
+		oauthService,
+		federationService,
+		userAdminService,
+		accountService,
+		config.SplitList(config.String("ADMIN_TOKENS", "")),
+		adminCertNames,
+	)
+	httpHandler := handler.NewHTTPHandler(authService, oauthService)
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf("%s:%s", host, httpPort),
+		Handler:           httpHandler.Routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -102,6 +307,8 @@ func NewServer(host, port string) (*Server, error) {
 		redisClient:    redisClient,
 		postgresClient: postgresClient,
 		authHandler:    authHandler,
+		httpServer:     httpServer,
+		tlsConfig:      tlsConfig,
 		connections:    make(map[string]*Connection),
 		ctx:            ctx,
 		cancel:         cancel,
@@ -110,6 +317,16 @@ func NewServer(host, port string) (*Server, error) {
 	// Start connection cleanup goroutine
 	go server.cleanupConnections()
 
+	// Start purging accounts whose deletion is due
+	deletionInterval := time.Duration(config.Int("ACCOUNT_DELETION_INTERVAL", 3600)) * time.Second
+	go accountService.RunDeletions(ctx, deletionInterval)
+
+	// Start signing key rotation
+	if keyManager != nil {
+		reloadInterval := time.Duration(config.Int("JWT_KEY_RELOAD_INTERVAL", 60)) * time.Second
+		go keyManager.Run(ctx, reloadInterval)
+	}
+
 	return server, nil
 }
 
@@ -122,7 +339,19 @@ func (s *Server) Start() error {
 	}
 	defer listener.Close()
 
-	log.Printf("TCP Authentication Server listening on %s", addr)
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+		log.Printf("TCP Authentication Server listening on %s (TLS)", addr)
+	} else {
+		log.Printf("TCP Authentication Server listening on %s", addr)
+	}
+
+	go func() {
+		log.Printf("HTTP endpoints listening on %s", s.httpServer.Addr)
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
 
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -133,6 +362,10 @@ func (s *Server) Start() error {
 		log.Println("Shutting down server...")
 		s.cancel()
 		listener.Close()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.httpServer.Shutdown(shutdownCtx)
 	}()
 
 	// Accept connections
@@ -181,6 +414,12 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 	log.Printf("New connection from %s (ID: %s)", conn.RemoteAddr(), connID)
 
+	clientCertName, err := clientCertificateName(conn)
+	if err != nil {
+		log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
 	scanner := bufio.NewScanner(conn)
 	for scanner.Scan() {
 		connection.LastSeen = time.Now()
@@ -198,6 +437,13 @@ func (s *Server) handleConnection(conn net.Conn) {
 			continue
 		}
 
+		// Record the peer address unless a trusted proxy forwarded the
+		// original client's address
+		if req.ClientIP == "" {
+			req.ClientIP = remoteIP(conn)
+		}
+		req.ClientCertName = clientCertName
+
 		// Handle request
 		resp, err := s.authHandler.HandleRequest(s.ctx, &req)
 		if err != nil {
@@ -246,6 +492,15 @@ func (s *Server) sendResponse(conn net.Conn, resp *protocol.Response) error {
 	return err
 }
 
+// remoteIP returns the IP address of a connection's peer
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
 // cleanupConnections periodically cleans up stale connections
 func (s *Server) cleanupConnections() {
 	ticker := time.NewTicker(5 * time.Minute)
@@ -294,19 +549,317 @@ func (s *Server) Close() error {
 	return nil
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// newSessionPolicies builds the default session policy and the per client
+// type overrides from the environment. SESSION_TTL remains the default
+// maximum lifetime for compatibility.
+func newSessionPolicies() (service.SessionPolicy, map[string]service.SessionPolicy) {
+	defaultPolicy := service.SessionPolicy{
+		IdleTimeout: time.Duration(config.Int("SESSION_IDLE_TIMEOUT", 0)) * time.Second,
+		MaxLifetime: time.Duration(config.Int("SESSION_MAX_LIFETIME", config.Int("SESSION_TTL", 86400))) * time.Second,
+	}
+
+	policies := make(map[string]service.SessionPolicy)
+	for _, clientType := range config.SplitList(config.String("SESSION_CLIENT_TYPES", "")) {
+		suffix := strings.ToUpper(clientType)
+		policies[clientType] = service.SessionPolicy{
+			IdleTimeout: time.Duration(config.Int("SESSION_IDLE_TIMEOUT_"+suffix, int(defaultPolicy.IdleTimeout/time.Second))) * time.Second,
+			MaxLifetime: time.Duration(config.Int("SESSION_MAX_LIFETIME_"+suffix, int(defaultPolicy.MaxLifetime/time.Second))) * time.Second,
+		}
+	}
+
+	return defaultPolicy, policies
+}
+
+// parseRoleLimits parses SESSION_LIMIT_ROLES, a comma-separated list of
+// role:limit pairs
+func parseRoleLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range config.SplitList(value) {
+		role, limit, ok := strings.Cut(entry, ":")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if !ok || strings.TrimSpace(role) == "" || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid SESSION_LIMIT_ROLES entry %q, expected <role>:<limit>", entry)
+		}
+		limits[strings.TrimSpace(role)] = n
+	}
+	return limits, nil
+}
+
+// newTokenService builds the signed access token service from the
+// environment. It returns nil when JWT issuing is disabled. When a key
+// store is configured, the returned key manager must be run to rotate keys.
+// The keys also sign OIDC ID tokens, which live for idTokenTTL.
+func newTokenService(signingKeyRepo *repository.SigningKeyRepository, idTokenTTL time.Duration) (*service.TokenService, *token.Manager, error) {
+	if !config.Bool("JWT_ENABLED", false) {
+		return nil, nil, nil
+	}
+
+	algorithm := config.String("JWT_ALGORITHM", token.AlgRS256)
+	accessTTL := time.Duration(config.Int("JWT_ACCESS_TTL", 300)) * time.Second
+
+	var keys token.KeySet
+	var keyManager *token.Manager
+
+	var store token.KeyStore
+	switch storeType := config.String("JWT_KEY_STORE", ""); storeType {
+	case "":
+	case "file":
+		store = token.NewFileKeyStore(config.String("JWT_KEY_STORE_PATH", "signing-keys.json"))
+	case "postgres":
+		store = signingKeyRepo
+	default:
+		return nil, nil, fmt.Errorf("unsupported JWT_KEY_STORE: %s", storeType)
+	}
+
+	if store != nil {
+		rotationInterval := time.Duration(config.Int("JWT_KEY_ROTATION_INTERVAL", 7*24*3600)) * time.Second
+		// Retired keys must verify every access and ID token they signed,
+		// plus clock skew
+		retention := max(accessTTL, idTokenTTL) + 5*time.Minute
+
+		keyManager = token.NewManager(store, algorithm, rotationInterval, retention)
+		if err := keyManager.Init(context.Background()); err != nil {
+			return nil, nil, fmt.Errorf("failed to initialize signing keys: %w", err)
+		}
+		keys = keyManager
+	} else {
+		var key *token.Key
+		var err error
+		if keyFile := config.String("JWT_SIGNING_KEY_FILE", ""); keyFile != "" {
+			key, err = token.LoadKeyFile(keyFile, algorithm)
+		} else {
+			log.Printf("Warning: JWT_SIGNING_KEY_FILE not set, generating an ephemeral %s signing key", algorithm)
+			key, err = token.GenerateKey(algorithm)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load JWT signing key: %w", err)
+		}
+		keys = token.NewStaticKeySet(key)
+	}
+
+	tokenService := service.NewTokenService(
+		keys,
+		config.String("JWT_ISSUER", "tcp-auth-server"),
+		config.String("JWT_AUDIENCE", ""),
+		accessTTL,
+	)
+	return tokenService, keyManager, nil
+}
+
+// newLoginCodeService configures passwordless login from the environment.
+// It returns nil when LOGIN_CODE_SENDER is not set.
+func newLoginCodeService(redisClient *redis.Client, userRepo *repository.UserRepository, sessionService *service.SessionService) (*service.LoginCodeService, error) {
+	var sender notify.Sender
+	switch senderType := config.String("LOGIN_CODE_SENDER", ""); senderType {
+	case "":
+		return nil, nil
+	case "outbox":
+		sender = notify.NewFileOutbox(config.String("LOGIN_CODE_OUTBOX_PATH", "login-outbox.jsonl"))
+	case "smtp":
+		sender = notify.NewSMTPSender(
+			config.String("SMTP_HOST", "localhost"),
+			config.String("SMTP_PORT", "587"),
+			config.String("SMTP_USERNAME", ""),
+			config.String("SMTP_PASSWORD", ""),
+			config.String("SMTP_FROM", "no-reply@localhost"),
+		)
+	default:
+		return nil, fmt.Errorf("unsupported LOGIN_CODE_SENDER: %s", senderType)
+	}
+
+	policy := service.LoginCodePolicy{
+		TTL:         time.Duration(config.Int("LOGIN_CODE_TTL", 600)) * time.Second,
+		MaxAttempts: config.Int("LOGIN_CODE_MAX_ATTEMPTS", 5),
+		RateLimit:   config.Int("LOGIN_CODE_RATE_LIMIT", 5),
+		RateWindow:  time.Duration(config.Int("LOGIN_CODE_RATE_WINDOW", 3600)) * time.Second,
+		LinkURL:     config.String("LOGIN_LINK_URL", ""),
+	}
+	if policy.LinkURL != "" {
+		if _, err := url.ParseRequestURI(policy.LinkURL); err != nil {
+			return nil, fmt.Errorf("invalid LOGIN_LINK_URL: %w", err)
+		}
+	}
+
+	return service.NewLoginCodeService(redisClient, userRepo, sessionService, sender, policy), nil
+}
+
+// newCredentialBackends builds the login backends listed in AUTH_BACKENDS
+// in the order they are tried. "postgres" is the local password table;
+// every other name is an LDAP directory configured by LDAP_<NAME>_*.
+func newCredentialBackends(userRepo *repository.UserRepository, passwords *password.Manager, roleService *service.RoleService, auditService *service.AuditService) ([]service.CredentialBackend, error) {
+	var backends []service.CredentialBackend
+	seen := make(map[string]bool)
+	for _, name := range config.SplitList(config.String("AUTH_BACKENDS", service.PasswordBackendName)) {
+		if seen[name] {
+			return nil, fmt.Errorf("credential backend %s is listed twice", name)
+		}
+		seen[name] = true
+
+		if name == service.PasswordBackendName {
+			backends = append(backends, service.NewPasswordBackend(userRepo, passwords))
+			continue
+		}
+
+		ldapConfig, err := newLDAPConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, service.NewLDAPBackend(ldapConfig, userRepo, roleService, auditService))
+	}
+	return backends, nil
+}
+
+// newLDAPConfig reads the LDAP_<NAME>_* settings of a directory
+func newLDAPConfig(name string) (service.LDAPConfig, error) {
+	prefix := "LDAP_" + strings.ToUpper(name) + "_"
+	ldapConfig := service.LDAPConfig{
+		Name:              name,
+		URL:               config.String(prefix+"URL", ""),
+		StartTLS:          config.Bool(prefix+"START_TLS", false),
+		Timeout:           time.Duration(config.Int(prefix+"TIMEOUT", 5)) * time.Second,
+		BindDN:            config.String(prefix+"BIND_DN", ""),
+		BindPassword:      config.String(prefix+"BIND_PASSWORD", ""),
+		UserBaseDN:        config.String(prefix+"USER_BASE_DN", ""),
+		UserFilter:        config.String(prefix+"USER_FILTER", "(uid={username})"),
+		UsernameAttribute: config.String(prefix+"USERNAME_ATTRIBUTE", "uid"),
+		EmailAttribute:    config.String(prefix+"EMAIL_ATTRIBUTE", "mail"),
+		GroupAttribute:    config.String(prefix+"GROUP_ATTRIBUTE", "memberOf"),
+		GroupBaseDN:       config.String(prefix+"GROUP_BASE_DN", ""),
+		GroupFilter:       config.String(prefix+"GROUP_FILTER", ""),
+		GroupRoles:        make(map[string][]string),
+		TenantID:          config.String(prefix+"TENANT", models.DefaultTenantID),
+	}
+	if ldapConfig.URL == "" || ldapConfig.UserBaseDN == "" {
+		return ldapConfig, fmt.Errorf("LDAP backend %s needs %sURL and %sUSER_BASE_DN", name, prefix, prefix)
+	}
+	if ldapConfig.GroupFilter != "" && ldapConfig.GroupBaseDN == "" {
+		return ldapConfig, fmt.Errorf("LDAP backend %s needs %sGROUP_BASE_DN with %sGROUP_FILTER", name, prefix, prefix)
+	}
+
+	// Group DNs contain commas, so mappings are separated by semicolons
+	// and a group is separated from its role by the last colon
+	for _, mapping := range strings.Split(config.String(prefix+"GROUP_ROLES", ""), ";") {
+		if mapping = strings.TrimSpace(mapping); mapping == "" {
+			continue
+		}
+		sep := strings.LastIndex(mapping, ":")
+		if sep <= 0 || sep == len(mapping)-1 {
+			return ldapConfig, fmt.Errorf("invalid %sGROUP_ROLES entry %q, expected <group DN>:<role>", prefix, mapping)
+		}
+		group := strings.TrimSpace(mapping[:sep])
+		ldapConfig.GroupRoles[group] = append(ldapConfig.GroupRoles[group], strings.TrimSpace(mapping[sep+1:]))
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := config.String(prefix+"CA_FILE", ""); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return ldapConfig, fmt.Errorf("failed to read %sCA_FILE: %w", prefix, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return ldapConfig, fmt.Errorf("%sCA_FILE contains no certificates", prefix)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	ldapConfig.TLSConfig = tlsConfig
+
+	return ldapConfig, nil
+}
+
+// newServerTLSConfig returns the TLS configuration of the TCP listener, or
+// nil when TCP_AUTH_TLS_CERT_FILE is unset and the listener is plain TCP.
+// With TCP_AUTH_TLS_CLIENT_CA_FILE, clients may present certificates issued
+// by those CAs; unverifiable certificates fail the handshake.
+func newServerTLSConfig() (*tls.Config, error) {
+	certFile := config.String("TCP_AUTH_TLS_CERT_FILE", "")
+	if certFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, config.String("TCP_AUTH_TLS_KEY_FILE", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile := config.String("TCP_AUTH_TLS_CLIENT_CA_FILE", ""); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TCP_AUTH_TLS_CLIENT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TCP_AUTH_TLS_CLIENT_CA_FILE contains no certificates")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// clientCertificateName completes the TLS handshake of a connection and
+// returns the common name of its verified client certificate. It returns
+// an empty name for plain connections and clients without a certificate.
+func clientCertificateName(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return "", nil
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName, nil
+}
+
+// newIdentityProviders configures the upstream OpenID Connect providers
+// listed in OIDC_PROVIDERS, each from its OIDC_PROVIDER_<NAME>_* variables
+func newIdentityProviders() ([]*service.IdentityProvider, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	var providers []*service.IdentityProvider
+	for _, name := range config.SplitList(config.String("OIDC_PROVIDERS", "")) {
+		prefix := "OIDC_PROVIDER_" + strings.ToUpper(name) + "_"
+		providerConfig := oidc.Config{
+			Issuer:       config.String(prefix+"ISSUER", ""),
+			ClientID:     config.String(prefix+"CLIENT_ID", ""),
+			ClientSecret: config.String(prefix+"CLIENT_SECRET", ""),
+			RedirectURI:  config.String(prefix+"REDIRECT_URI", ""),
+			Scopes:       strings.Fields(config.String(prefix+"SCOPES", "openid profile email")),
+		}
+		if providerConfig.Issuer == "" || providerConfig.ClientID == "" || providerConfig.RedirectURI == "" {
+			return nil, fmt.Errorf("identity provider %s needs %sISSUER, %sCLIENT_ID and %sREDIRECT_URI", name, prefix, prefix, prefix)
+		}
+
+		providers = append(providers, &service.IdentityProvider{
+			Name:        name,
+			DisplayName: config.String(prefix+"DISPLAY_NAME", name),
+			Client:      oidc.NewProvider(providerConfig, httpClient),
+			CreateUsers: config.Bool(prefix+"CREATE_USERS", true),
+			TrustEmail:  config.Bool(prefix+"TRUST_EMAIL", false),
+		})
 	}
-	return defaultValue
+	return providers, nil
 }
 
 func main() {
-	host := getEnv("TCP_AUTH_HOST", "0.0.0.0")
-	port := getEnv("TCP_AUTH_PORT", "9090")
+	host := config.String("TCP_AUTH_HOST", "0.0.0.0")
+	port := config.String("TCP_AUTH_PORT", "9090")
+	httpPort := config.String("HTTP_AUTH_PORT", "9091")
 
-	server, err := NewServer(host, port)
+	server, err := NewServer(host, port, httpPort)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
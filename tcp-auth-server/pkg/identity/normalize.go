@@ -0,0 +1,39 @@
+// Package identity canonicalizes the identifiers users log in with, so that
+// visually identical usernames and emails map to the same account.
+package identity
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Canonicalize applies Unicode NFKC compatibility normalization, full case
+// folding and whitespace trimming. "Alice", "ALICE" and the fullwidth
+// "Ａｌｉｃｅ" all canonicalize to "alice".
+func Canonicalize(s string) string {
+	s = norm.NFKC.String(s)
+	// Folding can produce sequences that are no longer NFKC normalized
+	s = norm.NFKC.String(cases.Fold().String(s))
+	return strings.TrimSpace(s)
+}
+
+// CanonicalUsername returns the form of a username used for uniqueness and
+// lookup
+func CanonicalUsername(username string) string {
+	return Canonicalize(username)
+}
+
+// CanonicalEmail returns the form of an email address used for uniqueness
+// and lookup. The whole address is folded: although the local part is case
+// sensitive in theory, no mail provider in practice treats it that way.
+func CanonicalEmail(email string) string {
+	return Canonicalize(email)
+}
+
+// LooksLikeEmail reports whether a login identifier should be treated as an
+// email address rather than a username
+func LooksLikeEmail(identifier string) bool {
+	return strings.Contains(identifier, "@")
+}
@@ -0,0 +1,81 @@
+package identity
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"ascii", "alice", "alice"},
+		{"upper case", "ALICE", "alice"},
+		{"mixed case", "AlIcE", "alice"},
+		{"fullwidth", "Ａｌｉｃｅ", "alice"},
+		{"fullwidth digits", "ｕｓｅｒ１２３", "user123"},
+		{"fi ligature", "ﬁnance", "finance"},
+		{"ffl ligature", "waﬄe", "waffle"},
+		{"German sharp s", "Straße", "strasse"},
+		{"German capital sharp s", "STRAẞE", "strasse"},
+		{"Turkish dotted capital I", "\u0130stanbul", "i\u0307stanbul"},
+		{"Turkish dotless i", "\u0131stanbul", "\u0131stanbul"},
+		{"Kelvin sign", "\u212a", "k"},
+		{"superscript", "x²", "x2"},
+		{"decomposed accent", "Jose\u0301", "jos\u00e9"},
+		{"composed accent", "JOS\u00c9", "jos\u00e9"},
+		{"surrounding whitespace", "  alice\t\n", "alice"},
+		{"ideographic space", "　alice　", "alice"},
+		{"inner space kept", "alice smith", "alice smith"},
+		{"email", "Alice@Example.COM", "alice@example.com"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Canonicalize(tt.input); got != tt.want {
+				t.Errorf("Canonicalize(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeCollisions(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		collide bool
+	}{
+		{"alice", "Ａｌｉｃｅ", true},
+		{"strasse", "Straße", true},
+		{"office", "oﬃce", true},
+		{"bob@example.com", "BOB@EXAMPLE.COM", true},
+		// Without Turkish tailoring the dotted capital I keeps its dot, and the
+		// dotless i stays distinct, so none of these collide with "istanbul"
+		{"istanbul", "İstanbul", false},
+		{"istanbul", "ıstanbul", false},
+		{"alice", "alice1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := CanonicalUsername(tt.a) == CanonicalUsername(tt.b); got != tt.collide {
+				t.Errorf("CanonicalUsername(%q) == CanonicalUsername(%q) is %v, want %v", tt.a, tt.b, got, tt.collide)
+			}
+		})
+	}
+}
+
+func TestLooksLikeEmail(t *testing.T) {
+	tests := []struct {
+		identifier string
+		want       bool
+	}{
+		{"alice@example.com", true},
+		{"alice＠example.com", false},
+		{"@", true},
+		{"alice", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := LooksLikeEmail(tt.identifier); got != tt.want {
+			t.Errorf("LooksLikeEmail(%q) = %v, want %v", tt.identifier, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package ldap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// BER tag classes and the constructed bit (X.690 section 8.1.2)
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags used by LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10 | constructed
+	tagSet         = 0x11 | constructed
+)
+
+// maxElementSize bounds a single element read from the server
+const maxElementSize = 16 << 20
+
+// element is a BER encoded value. Constructed elements hold their
+// children; primitive ones their contents.
+type element struct {
+	tag      byte
+	value    []byte
+	children []*element
+}
+
+func newConstructed(tag byte, children ...*element) *element {
+	return &element{tag: tag | constructed, children: children}
+}
+
+func newOctetString(tag byte, s string) *element {
+	return &element{tag: tag, value: []byte(s)}
+}
+
+func newInteger(tag byte, n int64) *element {
+	// Minimal two's complement encoding
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return &element{tag: tag, value: b}
+}
+
+func newBoolean(b bool) *element {
+	if b {
+		return &element{tag: tagBoolean, value: []byte{0xff}}
+	}
+	return &element{tag: tagBoolean, value: []byte{0x00}}
+}
+
+// encode returns the DER style encoding of the element
+func (e *element) encode() []byte {
+	contents := e.value
+	if e.tag&constructed != 0 {
+		contents = nil
+		for _, child := range e.children {
+			contents = append(contents, child.encode()...)
+		}
+	}
+
+	out := []byte{e.tag}
+	out = append(out, encodeLength(len(contents))...)
+	return append(out, contents...)
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// readElement reads one element from the stream. Constructed elements
+// are parsed into their children.
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, fmt.Errorf("ldap: multi-byte tags are not supported")
+	}
+
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return parseElement(tag, value)
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first&0x80 == 0 {
+		return int(first), nil
+	}
+
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, fmt.Errorf("ldap: unsupported length encoding")
+	}
+	length := 0
+	for i := 0; i < count; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length > maxElementSize {
+		return 0, fmt.Errorf("ldap: element of %d bytes is too large", length)
+	}
+	return length, nil
+}
+
+func parseElement(tag byte, value []byte) (*element, error) {
+	e := &element{tag: tag, value: value}
+	if tag&constructed == 0 {
+		return e, nil
+	}
+
+	for rest := value; len(rest) > 0; {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("ldap: truncated element")
+		}
+		childTag := rest[0]
+		length, n, err := decodeLength(rest[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + n
+		if length > len(rest)-start {
+			return nil, fmt.Errorf("ldap: truncated element")
+		}
+		child, err := parseElement(childTag, rest[start:start+length])
+		if err != nil {
+			return nil, err
+		}
+		e.children = append(e.children, child)
+		rest = rest[start+length:]
+	}
+	return e, nil
+}
+
+// decodeLength decodes a length prefix and returns it with its size
+func decodeLength(b []byte) (int, int, error) {
+	if b[0]&0x80 == 0 {
+		return int(b[0]), 1, nil
+	}
+	count := int(b[0] & 0x7f)
+	if count == 0 || count > 4 || len(b) < 1+count {
+		return 0, 0, fmt.Errorf("ldap: unsupported length encoding")
+	}
+	length := 0
+	for _, c := range b[1 : 1+count] {
+		length = length<<8 | int(c)
+	}
+	return length, 1 + count, nil
+}
+
+// int returns the value of an INTEGER or ENUMERATED element
+func (e *element) int() int64 {
+	var n int64
+	for i, b := range e.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(b)
+	}
+	return n
+}
+
+// str returns the contents of an element as a string
+func (e *element) str() string {
+	return string(e.value)
+}
@@ -0,0 +1,379 @@
+// Package ldap is a minimal LDAPv3 client (RFC 4511): simple bind, search
+// and StartTLS, which is what authenticating against a directory needs.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocol operation tags
+const (
+	opBindRequest      = classApplication | constructed | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | constructed | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opSearchReference  = classApplication | constructed | 19
+	opExtendedRequest  = classApplication | constructed | 23
+	opExtendedResponse = classApplication | constructed | 24
+)
+
+// startTLSOID names the StartTLS extended operation (RFC 4511 section 4.14)
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Result codes (RFC 4511 section 4.1.9)
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+	ResultUnavailable        = 52
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// Error is a non-success result returned by the server
+type Error struct {
+	ResultCode int
+	MatchedDN  string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("ldap: result code %d: %s", e.ResultCode, e.Message)
+	}
+	return fmt.Sprintf("ldap: result code %d", e.ResultCode)
+}
+
+// IsResultCode reports whether err is a server result with the given code
+func IsResultCode(err error, code int) bool {
+	var ldapErr *Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == code
+}
+
+// SearchRequest describes a search
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	// SizeLimit bounds the number of entries returned; zero means no limit
+	SizeLimit int
+}
+
+// Entry is a search result
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of an attribute. Attribute names are matched
+// case-insensitively.
+func (e *Entry) Values(attribute string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Value returns the first value of an attribute, or an empty string
+func (e *Entry) Value(attribute string) string {
+	if values := e.Values(attribute); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Conn is a connection to a directory server. Operations are sent one at
+// a time; a Conn is safe for concurrent use but does not pipeline.
+type Conn struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	host    string
+	timeout time.Duration
+	nextID  int64
+}
+
+// Dial connects to an ldap:// or ldaps:// URL. timeout bounds the dial and
+// every later operation.
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL: %w", err)
+	}
+
+	host := u.Host
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: failed to connect to %s: %w", host, err)
+	}
+
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(conn, withServerName(tlsConfig, u.Hostname()))
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: TLS handshake with %s failed: %w", host, err)
+		}
+		conn = tlsConn
+	}
+
+	return &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		host:    u.Hostname(),
+		timeout: timeout,
+	}, nil
+}
+
+// StartTLS upgrades a plain connection to TLS. It must be called before
+// binding.
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request := newConstructed(opExtendedRequest, newOctetString(classContext|0, startTLSOID))
+	if _, err := c.roundTrip(request, opExtendedResponse); err != nil {
+		return fmt.Errorf("ldap: StartTLS failed: %w", err)
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("ldap: TLS handshake failed: %w", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates the connection with a simple bind. An empty password
+// is refused, since servers treat it as an unauthenticated bind that
+// succeeds for any DN (RFC 4513 section 5.1.2).
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return &Error{ResultCode: ResultInvalidCredentials, Message: "empty password"}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request := newConstructed(opBindRequest,
+		newInteger(tagInteger, 3),
+		newOctetString(tagOctetString, dn),
+		newOctetString(classContext|0, password),
+	)
+	_, err := c.roundTrip(request, opBindResponse)
+	return err
+}
+
+// Search returns the entries matching a search request
+func (c *Conn) Search(req *SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := &element{tag: tagSequence}
+	for _, attribute := range req.Attributes {
+		attributes.children = append(attributes.children, newOctetString(tagOctetString, attribute))
+	}
+	request := newConstructed(opSearchRequest,
+		newOctetString(tagOctetString, req.BaseDN),
+		newInteger(tagEnumerated, int64(req.Scope)),
+		newInteger(tagEnumerated, 0), // never dereference aliases
+		newInteger(tagInteger, int64(req.SizeLimit)),
+		newInteger(tagInteger, int64(c.timeout/time.Second)),
+		newBoolean(false),
+		filter,
+		attributes,
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchReference:
+			// Referrals to other servers are not followed
+		case opSearchDone:
+			if err := resultError(op); err != nil {
+				return entries, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x to search", op.tag)
+		}
+	}
+}
+
+// Close unbinds and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The server sends no response to an unbind
+	_, _ = c.send(&element{tag: opUnbindRequest})
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and reads its single response, which must
+// have the expected tag and a success result. The caller must hold c.mu.
+func (c *Conn) roundTrip(request *element, responseTag byte) (*element, error) {
+	id, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if op.tag != responseTag {
+		return nil, fmt.Errorf("ldap: unexpected response 0x%02x", op.tag)
+	}
+	if err := resultError(op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// send writes a request in a new LDAPMessage and returns its message ID
+func (c *Conn) send(request *element) (int64, error) {
+	c.nextID++
+	message := newConstructed(tagSequence, newInteger(tagInteger, c.nextID), request)
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(message.encode()); err != nil {
+		return 0, fmt.Errorf("ldap: write failed: %w", err)
+	}
+	return c.nextID, nil
+}
+
+// receive reads the next response to message id and returns its protocol
+// operation
+func (c *Conn) receive(id int64) (*element, error) {
+	for {
+		message, err := readElement(c.reader)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: read failed: %w", err)
+		}
+		if message.tag != tagSequence || len(message.children) < 2 {
+			return nil, fmt.Errorf("ldap: malformed message")
+		}
+
+		messageID := message.children[0].int()
+		op := message.children[1]
+		if messageID == 0 && op.tag == opExtendedResponse {
+			// Notice of disconnection (RFC 4511 section 4.4.1)
+			if err := resultError(op); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("ldap: server closed the connection")
+		}
+		if messageID != id {
+			continue
+		}
+		return op, nil
+	}
+}
+
+// resultError returns the error carried by an LDAPResult, or nil on
+// success
+func resultError(op *element) error {
+	if len(op.children) < 3 {
+		return fmt.Errorf("ldap: malformed result")
+	}
+	code := int(op.children[0].int())
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{
+		ResultCode: code,
+		MatchedDN:  op.children[1].str(),
+		Message:    op.children[2].str(),
+	}
+}
+
+func parseEntry(op *element) (*Entry, error) {
+	if len(op.children) < 2 {
+		return nil, fmt.Errorf("ldap: malformed search entry")
+	}
+
+	entry := &Entry{
+		DN:         op.children[0].str(),
+		Attributes: make(map[string][]string),
+	}
+	for _, attribute := range op.children[1].children {
+		if len(attribute.children) < 2 {
+			return nil, fmt.Errorf("ldap: malformed attribute in %s", entry.DN)
+		}
+		name := attribute.children[0].str()
+		for _, value := range attribute.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], value.str())
+		}
+	}
+	return entry, nil
+}
+
+// withServerName returns a TLS config that verifies the given host unless
+// the config names one already
+func withServerName(tlsConfig *tls.Config, host string) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName != "" {
+		return tlsConfig
+	}
+	config := tlsConfig.Clone()
+	config.ServerName = host
+	return config
+}
@@ -0,0 +1,58 @@
+package ldap
+
+import "testing"
+
+func TestEscapeFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "alice", "alice"},
+		{"empty", "", ""},
+		{"asterisk", "*", `\2a`},
+		{"wildcard login", "a*", `a\2a`},
+		{"parentheses", "(admin)", `\28admin\29`},
+		{"backslash", `dom\user`, `dom\5cuser`},
+		{"NUL", "alice\x00", `alice\00`},
+		{"injection", "*)(uid=*))(|(uid=*", `\2a\29\28uid=\2a\29\29\28|\28uid=\2a`},
+		{"already escaped", `\2a`, `\5c2a`},
+		{"other specials kept", "a=b&c|d!e~f<g>h", "a=b&c|d!e~f<g>h"},
+		{"UTF-8 kept", "Jürgen", "Jürgen"},
+		{"DN value", "cn=Smith\\, John,ou=people", `cn=Smith\5c, John,ou=people`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EscapeFilter(tt.input); got != tt.want {
+				t.Errorf("EscapeFilter(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEscapeFilterRoundTrip checks that an escaped value compiles to a
+// single equality match on exactly that value
+func TestEscapeFilterRoundTrip(t *testing.T) {
+	for _, value := range []string{"alice", "*", "*)(uid=*", `a\b`, "nul\x00byte", "(|(a=b))", "Jürgen"} {
+		e, err := compileFilter("(uid=" + EscapeFilter(value) + ")")
+		if err != nil {
+			t.Errorf("compileFilter of escaped %q: %v", value, err)
+			continue
+		}
+		if e.tag != filterEquality || len(e.children) != 2 {
+			t.Errorf("escaped %q compiled to tag %#x with %d children, want an equality match", value, e.tag, len(e.children))
+			continue
+		}
+		if attr, got := string(e.children[0].value), string(e.children[1].value); attr != "uid" || got != value {
+			t.Errorf("escaped %q compiled to %s=%q", value, attr, got)
+		}
+	}
+}
+
+func TestCompileFilterInvalid(t *testing.T) {
+	for _, filter := range []string{"(uid=alice", "(uid=a)b", "(=alice)", `(uid=\2)`, `(uid=\zz)`, "(&(uid=a)"} {
+		if _, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q) succeeded", filter)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Package notify delivers one-off messages such as login codes to users
+// over mail or SMS.
+package notify
+
+import (
+	"context"
+)
+
+// Message is a single notification to one recipient
+type Message struct {
+	// To is the recipient address: an email address or phone number
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages. Implementations decide the transport.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
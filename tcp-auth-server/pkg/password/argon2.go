@@ -0,0 +1,199 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// Bounds on the hashes a hasher verifies. Hashes may come from other
+// systems, and one whose cost is out of proportion to the configured
+// parameters would exhaust the server's memory or CPU at login.
+const (
+	// maxCostFactor bounds memory, time and parallelism as a multiple of
+	// the hasher's own parameters
+	maxCostFactor = 4
+
+	minSaltLength = 8
+	maxSaltLength = 64
+	minKeyLength  = 16
+	maxKeyLength  = 64
+)
+
+// Argon2Params holds the tunable Argon2id parameters
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Time        uint32 // iterations
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params returns the RFC 9106 second recommended option
+// (64 MiB, 3 passes), which fits comfortably in a small container
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      64 * 1024,
+		Time:        3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Argon2idHasher hashes passwords with Argon2id. Hashes use the PHC string
+// format: $argon2id$v=19$m=<memory>,t=<time>,p=<parallelism>$<salt>$<hash>
+type Argon2idHasher struct {
+	params Argon2Params
+}
+
+// NewArgon2idHasher creates an Argon2id hasher with the given parameters
+func NewArgon2idHasher(params Argon2Params) *Argon2idHasher {
+	defaults := DefaultArgon2Params()
+	if params.Memory == 0 {
+		params.Memory = defaults.Memory
+	}
+	if params.Time == 0 {
+		params.Time = defaults.Time
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = defaults.Parallelism
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = defaults.SaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = defaults.KeyLength
+	}
+	return &Argon2idHasher{params: params}
+}
+
+// Hash hashes a password using Argon2id
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt,
+		h.params.Time, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.params.Memory, h.params.Time, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify verifies a password against an Argon2id PHC string
+func (h *Argon2idHasher) Verify(encoded, password string) error {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt,
+		params.Time, params.Memory, params.Parallelism, uint32(len(key)))
+
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+// Recognizes reports whether encoded is an Argon2id PHC string
+func (h *Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+// Check verifies that encoded is a parseable Argon2id PHC string whose
+// parameters are within the bounds this hasher verifies
+func (h *Argon2idHasher) Check(encoded string) error {
+	_, _, _, err := h.decode(encoded)
+	return err
+}
+
+// NeedsRehash reports whether encoded was hashed with different parameters
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.params.Memory ||
+		params.Time != h.params.Time ||
+		params.Parallelism != h.params.Parallelism ||
+		uint32(len(salt)) != h.params.SaltLength ||
+		uint32(len(key)) != h.params.KeyLength
+}
+
+// decode parses an Argon2id PHC string and rejects parameters too costly
+// to verify or too weak to be a real hash
+func (h *Argon2idHasher) decode(encoded string) (Argon2Params, []byte, []byte, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	switch {
+	case uint64(params.Memory) > maxCostFactor*uint64(h.params.Memory):
+		return params, nil, nil, fmt.Errorf("argon2id memory %d KiB exceeds the limit of %d KiB", params.Memory, maxCostFactor*uint64(h.params.Memory))
+	case uint64(params.Time) > maxCostFactor*uint64(h.params.Time):
+		return params, nil, nil, fmt.Errorf("argon2id time %d exceeds the limit of %d", params.Time, maxCostFactor*uint64(h.params.Time))
+	case int(params.Parallelism) > maxCostFactor*int(h.params.Parallelism):
+		return params, nil, nil, fmt.Errorf("argon2id parallelism %d exceeds the limit of %d", params.Parallelism, maxCostFactor*int(h.params.Parallelism))
+	case len(salt) < minSaltLength || len(salt) > maxSaltLength:
+		return params, nil, nil, fmt.Errorf("argon2id salt must be %d to %d bytes", minSaltLength, maxSaltLength)
+	case len(key) < minKeyLength || len(key) > maxKeyLength:
+		return params, nil, nil, fmt.Errorf("argon2id hash must be %d to %d bytes", minKeyLength, maxKeyLength)
+	}
+	return params, salt, key, nil
+}
+
+// decodeArgon2id parses an Argon2id PHC string
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d",
+		&params.Memory, &params.Time, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	// argon2.IDKey panics on these rather than returning an error
+	if params.Time < 1 || params.Parallelism < 1 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
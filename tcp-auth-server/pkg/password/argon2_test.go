@@ -0,0 +1,183 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testArgon2Params keeps the tests fast
+var testArgon2Params = Argon2Params{
+	Memory:      1024,
+	Time:        1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2Params)
+
+	for _, password := range []string{"correct horse", "", "pässwörd ✓", strings.Repeat("x", 200)} {
+		encoded, err := h.Hash(password)
+		if err != nil {
+			t.Fatalf("Hash(%q): %v", password, err)
+		}
+		if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+			t.Errorf("Hash(%q) = %q, want a PHC string with the hasher's parameters", password, encoded)
+		}
+		if !h.Recognizes(encoded) {
+			t.Errorf("Recognizes(%q) = false", encoded)
+		}
+		if err := h.Check(encoded); err != nil {
+			t.Errorf("Check(%q): %v", encoded, err)
+		}
+		if err := h.Verify(encoded, password); err != nil {
+			t.Errorf("Verify(%q): %v", password, err)
+		}
+		if err := h.Verify(encoded, password+"!"); !errors.Is(err, ErrMismatchedPassword) {
+			t.Errorf("Verify with a wrong password = %v, want ErrMismatchedPassword", err)
+		}
+	}
+}
+
+func TestArgon2idSaltsDiffer(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2Params)
+
+	first, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("two hashes of the same password are equal: %q", first)
+	}
+}
+
+func TestArgon2idMalformed(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2Params)
+	valid, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	salt, key := parts[4], parts[5]
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+		{"argon2i", "$argon2i$v=19$m=1024,t=1,p=1$" + salt + "$" + key},
+		{"missing hash", "$argon2id$v=19$m=1024,t=1,p=1$" + salt},
+		{"extra field", valid + "$AAAA"},
+		{"bad version", "$argon2id$v=x$m=1024,t=1,p=1$" + salt + "$" + key},
+		{"old version", "$argon2id$v=16$m=1024,t=1,p=1$" + salt + "$" + key},
+		{"bad parameters", "$argon2id$v=19$m=1024;t=1;p=1$" + salt + "$" + key},
+		{"zero time", "$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key},
+		{"zero parallelism", "$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key},
+		{"bad salt", "$argon2id$v=19$m=1024,t=1,p=1$!!!$" + key},
+		{"bad hash", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$!!!"},
+		{"empty hash", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := h.Check(tt.encoded); err == nil {
+				t.Errorf("Check(%q) succeeded", tt.encoded)
+			}
+			if err := h.Verify(tt.encoded, "secret"); err == nil {
+				t.Errorf("Verify(%q) succeeded", tt.encoded)
+			}
+		})
+	}
+}
+
+func TestArgon2idBounds(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2Params)
+
+	// 16 bytes of salt and 32 of key, base64 encoded without padding
+	salt := strings.Repeat("A", 22)
+	key := strings.Repeat("A", 43)
+	encode := func(params, salt, key string) string {
+		return "$argon2id$v=19$" + params + "$" + salt + "$" + key
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		ok      bool
+	}{
+		{"configured", encode("m=1024,t=1,p=1", salt, key), true},
+		{"cheaper", encode("m=64,t=1,p=1", salt, key), true},
+		{"at the limit", encode("m=4096,t=4,p=4", salt, key), true},
+		{"memory above", encode("m=4097,t=1,p=1", salt, key), false},
+		{"huge memory", encode("m=4294967295,t=1,p=1", salt, key), false},
+		{"time above", encode("m=1024,t=5,p=1", salt, key), false},
+		{"huge time", encode("m=1024,t=4294967295,p=1", salt, key), false},
+		{"parallelism above", encode("m=1024,t=1,p=5", salt, key), false},
+		{"short salt", encode("m=1024,t=1,p=1", strings.Repeat("A", 6), key), false},
+		{"long salt", encode("m=1024,t=1,p=1", strings.Repeat("A", 88), key), false},
+		{"short key", encode("m=1024,t=1,p=1", salt, strings.Repeat("A", 16)), false},
+		{"long key", encode("m=1024,t=1,p=1", salt, strings.Repeat("A", 88)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.Check(tt.encoded)
+			if tt.ok && err != nil {
+				t.Errorf("Check(%q): %v", tt.encoded, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Check(%q) succeeded", tt.encoded)
+			}
+			if !tt.ok {
+				// Verify must refuse before running Argon2id with the parameters
+				if err := h.Verify(tt.encoded, "secret"); err == nil || errors.Is(err, ErrMismatchedPassword) {
+					t.Errorf("Verify(%q) = %v, want a format error", tt.encoded, err)
+				}
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2Params)
+	current, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := func(change func(*Argon2Params)) string {
+		params := testArgon2Params
+		change(&params)
+		encoded, err := NewArgon2idHasher(params).Hash("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		want    bool
+	}{
+		{"current", current, false},
+		{"memory", changed(func(p *Argon2Params) { p.Memory = 2048 }), true},
+		{"time", changed(func(p *Argon2Params) { p.Time = 2 }), true},
+		{"parallelism", changed(func(p *Argon2Params) { p.Parallelism = 2 }), true},
+		{"salt length", changed(func(p *Argon2Params) { p.SaltLength = 32 }), true},
+		{"key length", changed(func(p *Argon2Params) { p.KeyLength = 64 }), true},
+		{"malformed", "$argon2id$garbage", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.NeedsRehash(tt.encoded); got != tt.want {
+				t.Errorf("NeedsRehash(%q) = %v, want %v", tt.encoded, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptHashLength is the length of an encoded bcrypt hash: prefix, cost,
+// 22 characters of salt and 31 of hash
+const bcryptHashLength = 60
+
+// maxCostMargin bounds the cost of a verified bcrypt hash above the
+// hasher's own. Each step doubles the work, so this is the same fourfold
+// limit as maxCostFactor.
+const maxCostMargin = 2
+
+// BcryptHasher hashes passwords with bcrypt. Hashes use the standard
+// modular crypt format ($2a$<cost>$...), which already encodes the cost.
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher creates a bcrypt hasher with the given cost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{cost: cost}
+}
+
+// Hash hashes a password using bcrypt
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify verifies a password against a bcrypt hash
+func (h *BcryptHasher) Verify(encoded, password string) error {
+	if err := h.checkCost(encoded); err != nil {
+		return err
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatchedPassword
+	}
+	return err
+}
+
+// Recognizes reports whether encoded is a bcrypt hash
+func (h *BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// Check verifies that encoded has the length and cost of a bcrypt hash
+func (h *BcryptHasher) Check(encoded string) error {
+	if len(encoded) != bcryptHashLength {
+		return fmt.Errorf("bcrypt hash must be %d characters", bcryptHashLength)
+	}
+	return h.checkCost(encoded)
+}
+
+// checkCost rejects hashes whose cost is out of proportion to the
+// hasher's own
+func (h *BcryptHasher) checkCost(encoded string) error {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	if cost > h.cost+maxCostMargin {
+		return fmt.Errorf("bcrypt cost %d exceeds the limit of %d", cost, h.cost+maxCostMargin)
+	}
+	return nil
+}
+
+// NeedsRehash reports whether encoded was hashed with a different cost
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost != h.cost
+}
@@ -0,0 +1,134 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLength is the SHA-1 prefix length used by k-anonymity range files
+const prefixLength = 5
+
+// BreachedList is an in-memory set of SHA-1 hashes of breached passwords.
+// Hashes are grouped by their 5 character prefix, mirroring the layout of
+// k-anonymity range files, so a directory of range files can be loaded
+// without reshaping.
+type BreachedList struct {
+	ranges map[string]map[string]struct{}
+	count  int
+}
+
+// LoadBreachedList loads breached password hashes from path.
+//
+// If path is a directory, every file whose name is a 5 character hex
+// prefix is read as a range file of "SUFFIX:COUNT" lines. Otherwise path
+// is read as a single file of full "HASH:COUNT" (or bare "HASH") lines.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+
+	list := &BreachedList{ranges: make(map[string]map[string]struct{})}
+
+	if !info.IsDir() {
+		if err := list.loadFile(path, ""); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read breached password directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := strings.ToUpper(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		if entry.IsDir() || len(name) != prefixLength || !isHex(name) {
+			continue
+		}
+		if err := list.loadFile(filepath.Join(path, entry.Name()), name); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// loadFile reads hashes from one file. A non-empty prefix means the file
+// contains suffixes only.
+func (l *BreachedList) loadFile(path, prefix string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+
+		hash := strings.ToUpper(prefix + line)
+		if len(hash) != sha1.Size*2 || !isHex(hash) {
+			continue
+		}
+		l.add(hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// add inserts a full uppercase SHA-1 hex hash
+func (l *BreachedList) add(hash string) {
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+	suffixes, ok := l.ranges[prefix]
+	if !ok {
+		suffixes = make(map[string]struct{})
+		l.ranges[prefix] = suffixes
+	}
+	if _, ok := suffixes[suffix]; !ok {
+		suffixes[suffix] = struct{}{}
+		l.count++
+	}
+}
+
+// Contains reports whether the password appears in the list
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, ok := l.ranges[hash[:prefixLength]]
+	if !ok {
+		return false
+	}
+	_, found := suffixes[hash[prefixLength:]]
+	return found
+}
+
+// Len returns the number of hashes in the list
+func (l *BreachedList) Len() int {
+	return l.count
+}
+
+// isHex reports whether s contains only hexadecimal digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789ABCDEFabcdef", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// SHA-1 hashes of test passwords
+const (
+	hashPassword = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8" // "password"
+	hash123456   = "7C4A8D09CA3762AF61E59520943DC26494F8941B" // "123456"
+	hashLetmein  = "B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3" // "letmein"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadBreachedListRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	// Range files hold suffixes only; names and suffixes may be lowercase
+	writeFile(t, filepath.Join(dir, "5BAA6"), "# comment\n\n"+
+		hashPassword[5:]+":3861493\n"+
+		// Same prefix as "password", different suffix
+		"0000000000000000000000000000000000A:1\n")
+	writeFile(t, filepath.Join(dir, "7c4a8.txt"), "d09ca3762af61e59520943dc26494f8941b:37359195\n")
+	// Not a range file name, so it is skipped even though it holds a hash
+	writeFile(t, filepath.Join(dir, "README"), hashLetmein[5:]+":1\n")
+	// Malformed lines are skipped
+	writeFile(t, filepath.Join(dir, "B7A87"), "not-a-hash\n"+hashLetmein[5:10]+"\n")
+
+	list, err := LoadBreachedList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list.Len(); got != 3 {
+		t.Errorf("Len() = %d, want 3", got)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"123456", true},
+		{"letmein", false},
+		{"Password", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := list.Contains(tt.password); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestLoadBreachedListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	writeFile(t, path, "# full hashes, with or without counts\n"+
+		hashPassword+":3861493\n"+
+		"  7c4a8d09ca3762af61e59520943dc26494f8941b  \n"+
+		hashPassword+"\n"+
+		// A bare suffix is too short to be a full hash
+		hashLetmein[5:]+"\n")
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2 (duplicates count once)", got)
+	}
+	for password, want := range map[string]bool{"password": true, "123456": true, "letmein": false} {
+		if got := list.Contains(password); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", password, got, want)
+		}
+	}
+}
+
+func TestLoadBreachedListMissing(t *testing.T) {
+	if _, err := LoadBreachedList(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadBreachedList of a missing path succeeded")
+	}
+}
+
+func TestBreachedListPrefixAndSuffix(t *testing.T) {
+	list := &BreachedList{ranges: make(map[string]map[string]struct{})}
+	// Shares the prefix of "password" but not the suffix
+	list.add(hashPassword[:5] + hash123456[5:])
+	// Shares the suffix of "password" but not the prefix
+	list.add(hash123456[:5] + hashPassword[5:])
+
+	if list.Contains("password") || list.Contains("123456") {
+		t.Error("a hash matched on its prefix or suffix alone")
+	}
+
+	list.add(hashPassword)
+	if !list.Contains("password") {
+		t.Error("Contains(\"password\") = false after adding its hash")
+	}
+	if got := list.Len(); got != 3 {
+		t.Errorf("Len() = %d, want 3", got)
+	}
+}
@@ -0,0 +1,100 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMismatchedPassword is returned when a password does not match its hash
+var ErrMismatchedPassword = errors.New("password does not match")
+
+// ErrUnknownHashFormat is returned when no hasher recognizes an encoded hash
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// Hasher hashes and verifies passwords with a single algorithm.
+// Encoded hashes are self-describing, so the algorithm and its
+// parameters can be recovered from the stored string alone.
+type Hasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+
+	// Verify checks password against an encoded hash
+	Verify(encoded, password string) error
+
+	// Recognizes reports whether encoded was produced by this algorithm
+	Recognizes(encoded string) bool
+
+	// Check returns an error unless encoded is a well-formed hash of this
+	// algorithm, without verifying any password against it
+	Check(encoded string) error
+
+	// NeedsRehash reports whether encoded uses parameters other than the
+	// hasher's current ones
+	NeedsRehash(encoded string) bool
+}
+
+// Manager hashes new passwords with a preferred hasher and verifies
+// existing hashes with any registered hasher
+type Manager struct {
+	preferred Hasher
+	hashers   []Hasher
+}
+
+// NewManager creates a password manager. The preferred hasher is used for
+// all new hashes; legacy hashers are only used for verification.
+func NewManager(preferred Hasher, legacy ...Hasher) *Manager {
+	hashers := append([]Hasher{preferred}, legacy...)
+	return &Manager{
+		preferred: preferred,
+		hashers:   hashers,
+	}
+}
+
+// Hash hashes a password with the preferred hasher
+func (m *Manager) Hash(password string) (string, error) {
+	hash, err := m.preferred.Hash(password)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return hash, nil
+}
+
+// Verify checks a password against an encoded hash of any known format
+func (m *Manager) Verify(encoded, password string) error {
+	h := m.hasherFor(encoded)
+	if h == nil {
+		return ErrUnknownHashFormat
+	}
+	return h.Verify(encoded, password)
+}
+
+// Check returns ErrUnknownHashFormat unless encoded is in a known format,
+// or an error describing why it is malformed. Hashes imported from other
+// systems are checked before they are stored.
+func (m *Manager) Check(encoded string) error {
+	h := m.hasherFor(encoded)
+	if h == nil {
+		return ErrUnknownHashFormat
+	}
+	return h.Check(encoded)
+}
+
+// NeedsRehash reports whether encoded should be replaced by a hash from
+// the preferred hasher, either because it uses another algorithm or
+// because its parameters are outdated
+func (m *Manager) NeedsRehash(encoded string) bool {
+	if !m.preferred.Recognizes(encoded) {
+		return true
+	}
+	return m.preferred.NeedsRehash(encoded)
+}
+
+// hasherFor returns the first hasher that recognizes encoded
+func (m *Manager) hasherFor(encoded string) Hasher {
+	for _, h := range m.hashers {
+		if h.Recognizes(encoded) {
+			return h
+		}
+	}
+	return nil
+}
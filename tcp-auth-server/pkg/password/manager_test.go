@@ -0,0 +1,131 @@
+package password
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestManagerPreferredAndLegacy(t *testing.T) {
+	argon2Hasher := NewArgon2idHasher(testArgon2Params)
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+
+	m := NewManager(argon2Hasher, bcryptHasher)
+
+	preferred, err := m.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !argon2Hasher.Recognizes(preferred) {
+		t.Errorf("Hash = %q, want an Argon2id hash from the preferred hasher", preferred)
+	}
+
+	legacy, err := bcryptHasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		encoded     string
+		password    string
+		wantErr     error
+		needsRehash bool
+	}{
+		{"preferred", preferred, "secret", nil, false},
+		{"preferred wrong password", preferred, "wrong", ErrMismatchedPassword, false},
+		{"legacy", legacy, "secret", nil, true},
+		{"legacy wrong password", legacy, "wrong", ErrMismatchedPassword, true},
+		{"unknown format", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret", ErrUnknownHashFormat, true},
+		{"empty", "", "", ErrUnknownHashFormat, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Verify(tt.encoded, tt.password); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify = %v, want %v", err, tt.wantErr)
+			}
+			if got := m.NeedsRehash(tt.encoded); got != tt.needsRehash {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestManagerBcryptPreferred(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	argon2Hasher := NewArgon2idHasher(testArgon2Params)
+	m := NewManager(bcryptHasher, argon2Hasher)
+
+	legacy, err := argon2Hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(legacy, "secret"); err != nil {
+		t.Errorf("Verify of an Argon2id hash with bcrypt preferred: %v", err)
+	}
+	if !m.NeedsRehash(legacy) {
+		t.Error("an Argon2id hash does not need a rehash with bcrypt preferred")
+	}
+
+	otherCost, err := NewBcryptHasher(bcrypt.MinCost + 1).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.NeedsRehash(otherCost) {
+		t.Error("a bcrypt hash of another cost does not need a rehash")
+	}
+}
+
+func TestManagerCheck(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	m := NewManager(NewArgon2idHasher(testArgon2Params), bcryptHasher)
+
+	valid, err := bcryptHasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Valid, err := m.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		ok      bool
+	}{
+		{"bcrypt", valid, true},
+		{"bcrypt 2y", "$2y$" + valid[4:], true},
+		{"argon2id", argon2Valid, true},
+		{"truncated bcrypt", valid[:40], false},
+		{"bcrypt bad cost", "$2a$xx$" + valid[7:], false},
+		{"bcrypt cost too high", "$2a$99$" + valid[7:], false},
+		{"bcrypt cost within margin", "$2a$06$" + valid[7:], true},
+		{"bcrypt too costly", "$2a$07$" + valid[7:], false},
+		{"unknown format", "md5$abc", false},
+		{"argon2id too costly", "$argon2id$v=19$m=1048576,t=1,p=1$AAAAAAAAAAAAAAAAAAAAAA$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Check(tt.encoded)
+			if tt.ok && err != nil {
+				t.Errorf("Check(%q): %v", tt.encoded, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Check(%q) succeeded", tt.encoded)
+			}
+		})
+	}
+	if err := m.Check("md5$abc"); !errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("Check of an unknown format = %v, want ErrUnknownHashFormat", err)
+	}
+
+	costly, err := NewBcryptHasher(bcrypt.MinCost + maxCostMargin + 1).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(costly, "secret"); err == nil || errors.Is(err, ErrMismatchedPassword) {
+		t.Errorf("Verify of a too costly bcrypt hash = %v, want a cost error", err)
+	}
+}
@@ -0,0 +1,189 @@
+package password
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Character classes that a policy can require
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// Violation describes a single way in which a password breaks the policy
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PolicyError is returned when a password violates one or more rules
+type PolicyError struct {
+	Violations []Violation
+}
+
+// Error joins the violation messages into a single line
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password does not meet policy: " + strings.Join(messages, "; ")
+}
+
+// Policy is a configurable set of password rules
+type Policy struct {
+	MinLength       int      // minimum length in characters
+	MaxLength       int      // maximum length in characters, 0 for no limit
+	RequiredClasses []string // character classes that must all be present
+	MinClasses      int      // minimum number of distinct character classes
+	RejectIdentity  bool     // reject passwords containing the username or email
+	MinEntropyBits  float64  // minimum estimated entropy, 0 to disable
+	Breached        *BreachedList
+}
+
+// Check validates a password and returns every rule it violates
+func (p *Policy) Check(password, username, email string) []Violation {
+	var violations []Violation
+	add := func(code, format string, args ...interface{}) {
+		violations = append(violations, Violation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add("too_short", "password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add("too_long", "password must be at most %d characters", p.MaxLength)
+	}
+
+	classes := characterClasses(password)
+	for _, class := range p.RequiredClasses {
+		if !classes[class] {
+			add("missing_"+class, "password must contain at least one %s character", classDescription(class))
+		}
+	}
+	if len(classes) < p.MinClasses {
+		add("too_few_classes", "password must mix at least %d of lowercase, uppercase, digits and symbols", p.MinClasses)
+	}
+
+	if p.RejectIdentity && containsIdentity(password, username, email) {
+		add("contains_identity", "password must not contain your username or email")
+	}
+
+	if p.MinEntropyBits > 0 {
+		if bits := EstimateEntropy(password); bits < p.MinEntropyBits {
+			add("too_weak", "password is too easy to guess (estimated %.0f bits, need %.0f)", bits, p.MinEntropyBits)
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		add("breached", "password has appeared in a data breach")
+	}
+
+	return violations
+}
+
+// Validate returns a *PolicyError if the password violates the policy
+func (p *Policy) Validate(password, username, email string) error {
+	if violations := p.Check(password, username, email); len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// EstimateEntropy estimates the entropy of a password in bits. It starts
+// from the brute-force search space of the character classes used and
+// discounts characters that repeat or continue a sequence, since those
+// add little to an attacker's work.
+func EstimateEntropy(password string) float64 {
+	pool := 0
+	for class := range characterClasses(password) {
+		switch class {
+		case ClassLower, ClassUpper:
+			pool += 26
+		case ClassDigit:
+			pool += 10
+		case ClassSymbol:
+			pool += 33
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	seen := make(map[rune]bool)
+	var prev rune
+	for i, r := range []rune(password) {
+		switch {
+		case seen[r]:
+			bits += perChar / 4
+		case i > 0 && (r == prev+1 || r == prev-1):
+			bits += perChar / 2
+		default:
+			bits += perChar
+		}
+		seen[r] = true
+		prev = r
+	}
+	return bits
+}
+
+// characterClasses returns the set of character classes present
+func characterClasses(password string) map[string]bool {
+	classes := make(map[string]bool)
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			classes[ClassLower] = true
+		case unicode.IsUpper(r):
+			classes[ClassUpper] = true
+		case unicode.IsDigit(r):
+			classes[ClassDigit] = true
+		default:
+			classes[ClassSymbol] = true
+		}
+	}
+	return classes
+}
+
+// classDescription returns a human readable class name
+func classDescription(class string) string {
+	switch class {
+	case ClassLower:
+		return "lowercase"
+	case ClassUpper:
+		return "uppercase"
+	case ClassDigit:
+		return "digit"
+	default:
+		return "symbol"
+	}
+}
+
+// containsIdentity reports whether the password contains the username,
+// the email address or the email's local part, ignoring case
+func containsIdentity(password, username, email string) bool {
+	lower := strings.ToLower(password)
+
+	candidates := []string{username, email}
+	if at := strings.Index(email, "@"); at > 0 {
+		candidates = append(candidates, email[:at])
+	}
+
+	for _, c := range candidates {
+		c = strings.ToLower(strings.TrimSpace(c))
+		// Very short identifiers match too many passwords by accident
+		if len(c) >= 3 && strings.Contains(lower, c) {
+			return true
+		}
+	}
+	return false
+}
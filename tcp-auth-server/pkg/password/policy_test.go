@@ -0,0 +1,132 @@
+package password
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func violationCodes(violations []Violation) []string {
+	var codes []string
+	for _, v := range violations {
+		codes = append(codes, v.Code)
+	}
+	return codes
+}
+
+func TestPolicyCheck(t *testing.T) {
+	breached := &BreachedList{ranges: make(map[string]map[string]struct{})}
+	// SHA-1 of "password"
+	breached.add("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8")
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		username string
+		email    string
+		want     []string
+	}{
+		{"empty policy", Policy{}, "", "", "", nil},
+		{"too short", Policy{MinLength: 8}, "short", "", "", []string{"too_short"}},
+		{"length in characters", Policy{MinLength: 4, MaxLength: 4}, "äöüß", "", "", nil},
+		{"too long", Policy{MaxLength: 4}, "longer", "", "", []string{"too_long"}},
+		{"no maximum", Policy{}, "a very long password indeed", "", "", nil},
+		{"missing lower", Policy{RequiredClasses: []string{ClassLower}}, "ABC123", "", "", []string{"missing_lower"}},
+		{"missing upper", Policy{RequiredClasses: []string{ClassUpper}}, "abc123", "", "", []string{"missing_upper"}},
+		{"missing digit", Policy{RequiredClasses: []string{ClassDigit}}, "abcDEF", "", "", []string{"missing_digit"}},
+		{"missing symbol", Policy{RequiredClasses: []string{ClassSymbol}}, "abc123", "", "", []string{"missing_symbol"}},
+		{"space is a symbol", Policy{RequiredClasses: []string{ClassSymbol}}, "abc 123", "", "", nil},
+		{"non-ASCII letters", Policy{RequiredClasses: []string{ClassLower, ClassUpper}}, "éÉ", "", "", nil},
+		{"too few classes", Policy{MinClasses: 3}, "abcDEF", "", "", []string{"too_few_classes"}},
+		{"enough classes", Policy{MinClasses: 3}, "abcDEF1", "", "", nil},
+		{"contains username", Policy{RejectIdentity: true}, "xxAliceXX", "alice", "", []string{"contains_identity"}},
+		{"contains email", Policy{RejectIdentity: true}, "bob@example.com!", "", "bob@example.com", []string{"contains_identity"}},
+		{"contains email local part", Policy{RejectIdentity: true}, "Carol2024", "", "carol@example.com", []string{"contains_identity"}},
+		{"short identity ignored", Policy{RejectIdentity: true}, "joe-is-here", "jo", "", nil},
+		{"identity allowed", Policy{}, "alice", "alice", "", nil},
+		{"too weak", Policy{MinEntropyBits: 40}, "aaaaaaaa", "", "", []string{"too_weak"}},
+		{"strong enough", Policy{MinEntropyBits: 40}, "q7#Vx!2mLp", "", "", nil},
+		{"breached", Policy{Breached: breached}, "password", "", "", []string{"breached"}},
+		{"not breached", Policy{Breached: breached}, "Password", "", "", nil},
+		{
+			"every violation",
+			Policy{
+				MinLength:       20,
+				RequiredClasses: []string{ClassUpper, ClassDigit},
+				MinClasses:      2,
+				RejectIdentity:  true,
+				MinEntropyBits:  60,
+				Breached:        breached,
+			},
+			"password", "pass", "", []string{"too_short", "missing_upper", "missing_digit", "too_few_classes", "contains_identity", "too_weak", "breached"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violationCodes(tt.policy.Check(tt.password, tt.username, tt.email))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	policy := &Policy{MinLength: 8, MinClasses: 2}
+
+	if err := policy.Validate("abcdefg1", "", ""); err != nil {
+		t.Errorf("Validate of a valid password: %v", err)
+	}
+
+	err := policy.Validate("abc", "", "")
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("Validate = %v, want a *PolicyError", err)
+	}
+	if got := violationCodes(policyErr.Violations); !reflect.DeepEqual(got, []string{"too_short", "too_few_classes"}) {
+		t.Errorf("violations = %v", got)
+	}
+	want := "password does not meet policy: password must be at least 8 characters; " +
+		"password must mix at least 2 of lowercase, uppercase, digits and symbols"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestEstimateEntropy(t *testing.T) {
+	lower := math.Log2(26)
+	mixed := math.Log2(52)
+	all := math.Log2(26 + 26 + 10 + 33)
+
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"a", lower},
+		{"qz", 2 * lower},
+		{"aaaa", lower + 3*lower/4},
+		{"abc", lower + 2*lower/2},
+		{"cba", lower + 2*lower/2},
+		{"abab", lower + lower/2 + 2*lower/4},
+		{"qZ", 2 * mixed},
+		{"q7#Z", 4 * all},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			if got := EstimateEntropy(tt.password); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("EstimateEntropy(%q) = %f, want %f", tt.password, got, tt.want)
+			}
+		})
+	}
+
+	// Repeats and sequences must count for less than random characters
+	if EstimateEntropy("aaaaaaaa") >= EstimateEntropy("qmzrtxwp") {
+		t.Error("a repeated character is not weaker than random characters")
+	}
+	if EstimateEntropy("abcdefgh") >= EstimateEntropy("qmzrtxwp") {
+		t.Error("a sequence is not weaker than random characters")
+	}
+}
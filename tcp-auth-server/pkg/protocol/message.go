@@ -1,15 +1,35 @@
 package protocol
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"tcp-auth-server/pkg/password"
+)
 
 // Request represents a client request message
 type Request struct {
-	Type     string          `json:"type"`
-	Username string          `json:"username,omitempty"`
-	Email    string          `json:"email,omitempty"`
-	Password string          `json:"password,omitempty"`
-	Token    string          `json:"token,omitempty"`
-	Data     json.RawMessage `json:"data,omitempty"`
+	Type          string          `json:"type"`
+	Tenant        string          `json:"tenant,omitempty"`
+	Username      string          `json:"username,omitempty"`
+	Email         string          `json:"email,omitempty"`
+	Password      string          `json:"password,omitempty"`
+	Token         string          `json:"token,omitempty"`
+	RefreshToken  string          `json:"refresh_token,omitempty"`
+	ClientType    string          `json:"client_type,omitempty"`
+	ClientIP      string          `json:"client_ip,omitempty"`
+	UserAgent     string          `json:"user_agent,omitempty"`
+	SessionID     string          `json:"session_id,omitempty"`
+	RememberMe    bool            `json:"remember_me,omitempty"`
+	RememberToken string          `json:"remember_token,omitempty"`
+	Code          string          `json:"code,omitempty"`
+	Permission    string          `json:"permission,omitempty"`
+	Scopes        []string        `json:"scopes,omitempty"`
+	Data          json.RawMessage `json:"data,omitempty"`
+
+	// ClientCertName is the common name of the verified TLS client
+	// certificate of the connection. The server sets it; it is never read
+	// from the message.
+	ClientCertName string `json:"-"`
 }
 
 // Response represents a server response message
@@ -39,6 +59,15 @@ func ErrorResponse(message string) *Response {
 	}
 }
 
+// ErrorResponseWithData creates an error response carrying details
+func ErrorResponseWithData(message string, data interface{}) *Response {
+	resp := ErrorResponse(message)
+	if dataBytes, err := json.Marshal(data); err == nil {
+		resp.Data = dataBytes
+	}
+	return resp
+}
+
 // LoginResponseData contains login response data
 type LoginResponseData struct {
 	Token     string `json:"token"`
@@ -46,6 +75,22 @@ type LoginResponseData struct {
 	Username  string `json:"username"`
 	Email     string `json:"email"`
 	ExpiresAt int64  `json:"expires_at"`
+	Tenant    string `json:"tenant,omitempty"`
+
+	// Scopes the session is limited to, absent for full authority
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Signed access token, present when JWT issuing is enabled
+	AccessToken          string `json:"access_token,omitempty"`
+	AccessTokenExpiresAt int64  `json:"access_token_expires_at,omitempty"`
+
+	// Long-lived refresh token, exchanged for a new session by refresh
+	RefreshToken          string `json:"refresh_token,omitempty"`
+	RefreshTokenExpiresAt int64  `json:"refresh_token_expires_at,omitempty"`
+
+	// Persistent credential, present when the login asked to be remembered
+	RememberToken          string `json:"remember_token,omitempty"`
+	RememberTokenExpiresAt int64  `json:"remember_token_expires_at,omitempty"`
 }
 
 // RegisterResponseData contains registration response data
@@ -55,12 +100,427 @@ type RegisterResponseData struct {
 	Email    string `json:"email"`
 }
 
-// ValidateResponseData contains token validation response data
+// PasswordPolicyErrorData lists the password policy rules a request violated
+type PasswordPolicyErrorData struct {
+	Violations []password.Violation `json:"violations"`
+}
+
+// ValidateResponseData contains token validation response data. The user
+// fields are only set when the principal is a user.
 type ValidateResponseData struct {
-	Valid    bool   `json:"valid"`
+	Valid         bool     `json:"valid"`
+	PrincipalType string   `json:"principal_type,omitempty"`
+	PrincipalID   string   `json:"principal_id,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	UserID        string   `json:"user_id,omitempty"`
+	Username      string   `json:"username,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	Tenant        string   `json:"tenant,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+
+	// ImpersonatorID is the admin acting as the user when the token is an
+	// impersonation session
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+}
+
+// ScopedTokenRequestData contains options for create_scoped_token
+type ScopedTokenRequestData struct {
+	// TTL in seconds, capped at the parent session's remaining lifetime
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// ScopedTokenResponseData describes a newly minted scoped token
+type ScopedTokenResponseData struct {
+	Token     string   `json:"token"`
+	SessionID string   `json:"session_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expires_at"`
+
+	AccessToken          string `json:"access_token,omitempty"`
+	AccessTokenExpiresAt int64  `json:"access_token_expires_at,omitempty"`
+}
+
+// CreateAPIKeyRequestData contains options for create_api_key
+type CreateAPIKeyRequestData struct {
+	Name string `json:"name"`
+	// ExpiresIn in seconds; the key does not expire when zero
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// ServiceAccountID issues the key to a service account instead of the
+	// caller; admin only
+	ServiceAccountID string `json:"service_account_id,omitempty"`
+}
+
+// APIKeyInfo describes an API key without its secret
+type APIKeyInfo struct {
+	ID         string   `json:"id"`
+	Prefix     string   `json:"prefix"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at,omitempty"`
+	LastUsedAt int64    `json:"last_used_at,omitempty"`
+	RevokedAt  int64    `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyResponseData contains a new API key. Key is only ever
+// returned here.
+type CreateAPIKeyResponseData struct {
+	Key string `json:"key"`
+	APIKeyInfo
+}
+
+// ListAPIKeysResponseData contains the caller's API keys
+type ListAPIKeysResponseData struct {
+	Keys []APIKeyInfo `json:"keys"`
+}
+
+// RevokeAPIKeyRequestData names the API key to revoke
+type RevokeAPIKeyRequestData struct {
+	ID               string `json:"id"`
+	ServiceAccountID string `json:"service_account_id,omitempty"`
+}
+
+// AuthorizeResponseData answers whether a principal holds a permission.
+// The user fields are only set when the principal is a user.
+type AuthorizeResponseData struct {
+	Allowed       bool     `json:"allowed"`
+	PrincipalType string   `json:"principal_type,omitempty"`
+	PrincipalID   string   `json:"principal_id,omitempty"`
+	UserID        string   `json:"user_id,omitempty"`
+	Username      string   `json:"username,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+}
+
+// ClientCredentialsRequestData contains a service account's credentials
+type ClientCredentialsRequestData struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// ServiceTokenResponseData contains a token issued to a service account
+type ServiceTokenResponseData struct {
+	Token            string   `json:"token"`
+	ServiceAccountID string   `json:"service_account_id"`
+	Name             string   `json:"name"`
+	Scopes           []string `json:"scopes,omitempty"`
+	ExpiresAt        int64    `json:"expires_at"`
+
+	AccessToken          string `json:"access_token,omitempty"`
+	AccessTokenExpiresAt int64  `json:"access_token_expires_at,omitempty"`
+}
+
+// ServiceAccountRequestData names a service account
+type ServiceAccountRequestData struct {
+	ServiceAccountID string `json:"service_account_id"`
+}
+
+// CreateServiceAccountRequestData contains options for
+// create_service_account
+type CreateServiceAccountRequestData struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// ServiceAccountInfo describes a service account without its secret
+type ServiceAccountInfo struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	ClientID    string `json:"client_id"`
+	Disabled    bool   `json:"disabled"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// ServiceAccountSecretResponseData contains a service account with its new
+// client secret. ClientSecret is only ever returned here.
+type ServiceAccountSecretResponseData struct {
+	ClientSecret string `json:"client_secret"`
+	ServiceAccountInfo
+}
+
+// ListServiceAccountsResponseData contains every service account
+type ListServiceAccountsResponseData struct {
+	ServiceAccounts []ServiceAccountInfo `json:"service_accounts"`
+}
+
+// OAuthClientRequestData names an OAuth client
+type OAuthClientRequestData struct {
+	ClientID string `json:"client_id"`
+}
+
+// RegisterOAuthClientRequestData contains options for register_oauth_client
+type RegisterOAuthClientRequestData struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	// GrantTypes defaults to authorization_code and refresh_token
+	GrantTypes []string `json:"grant_types,omitempty"`
+	// Scopes bounds the scopes the client may request; empty lets it act
+	// with the user's full authority
+	Scopes []string `json:"scopes,omitempty"`
+	// Public registers a client without a secret, which must use PKCE
+	Public bool `json:"public,omitempty"`
+	// PostLogoutRedirectURIs are where the OpenID Connect end session
+	// endpoint may send the user after logging out
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+}
+
+// OAuthClientInfo describes an OAuth client without its secret
+type OAuthClientInfo struct {
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	Tenant       string   `json:"tenant"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes,omitempty"`
+	Public       bool     `json:"public"`
+	CreatedAt    int64    `json:"created_at"`
+
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+}
+
+// OAuthClientSecretResponseData contains a newly registered OAuth client.
+// ClientSecret is only ever returned here and is empty for public clients.
+type OAuthClientSecretResponseData struct {
+	ClientSecret string `json:"client_secret,omitempty"`
+	OAuthClientInfo
+}
+
+// ListOAuthClientsResponseData contains the OAuth clients of a tenant
+type ListOAuthClientsResponseData struct {
+	Clients []OAuthClientInfo `json:"clients"`
+}
+
+// FederatedLoginRequestData names an identity provider to start a login or
+// link with, or carries the state and code the provider returned
+type FederatedLoginRequestData struct {
+	Provider string `json:"provider,omitempty"`
+	State    string `json:"state,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// FederatedLoginResponseData contains the provider URL to send the user
+// to and the state it will return with the code
+type FederatedLoginResponseData struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+// IdentityProviderInfo describes a configured identity provider
+type IdentityProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// ListIdentityProvidersResponseData contains the configured identity
+// providers
+type ListIdentityProvidersResponseData struct {
+	Providers []IdentityProviderInfo `json:"providers"`
+}
+
+// FederatedIdentityInfo describes an identity linked to the caller
+type FederatedIdentityInfo struct {
+	Provider    string `json:"provider"`
+	Subject     string `json:"subject"`
+	Email       string `json:"email,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+	LastLoginAt int64  `json:"last_login_at,omitempty"`
+}
+
+// ListIdentitiesResponseData contains the identities linked to the caller
+type ListIdentitiesResponseData struct {
+	Identities []FederatedIdentityInfo `json:"identities"`
+}
+
+// RoleInfo describes a role and the permissions it grants
+type RoleInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// ListRolesResponseData contains every defined role
+type ListRolesResponseData struct {
+	Roles []RoleInfo `json:"roles"`
+}
+
+// RoleAssignmentRequestData names a user, by ID or username, or a service
+// account, and a role
+type RoleAssignmentRequestData struct {
+	UserID           string `json:"user_id,omitempty"`
+	Username         string `json:"username,omitempty"`
+	ServiceAccountID string `json:"service_account_id,omitempty"`
+	Role             string `json:"role"`
+}
+
+// TenantPasswordPolicyData lists the password rules a tenant overrides;
+// zero values keep the server's setting
+type TenantPasswordPolicyData struct {
+	MinLength       int      `json:"min_length,omitempty"`
+	MaxLength       int      `json:"max_length,omitempty"`
+	RequiredClasses []string `json:"required_classes,omitempty"`
+	MinClasses      int      `json:"min_classes,omitempty"`
+	MinEntropyBits  float64  `json:"min_entropy_bits,omitempty"`
+}
+
+// TenantInfo describes a tenant and its configuration. It is also the
+// data of create_tenant and update_tenant.
+type TenantInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Session limits in seconds; zero keeps the server's setting
+	SessionMaxLifetime int64                     `json:"session_max_lifetime,omitempty"`
+	SessionIdleTimeout int64                     `json:"session_idle_timeout,omitempty"`
+	PasswordPolicy     *TenantPasswordPolicyData `json:"password_policy,omitempty"`
+	CreatedAt          int64                     `json:"created_at,omitempty"`
+}
+
+// ListTenantsResponseData contains every tenant
+type ListTenantsResponseData struct {
+	Tenants []TenantInfo `json:"tenants"`
+}
+
+// RotateKeysRequestData contains admin key rotation options
+type RotateKeysRequestData struct {
+	// RevokePrevious deletes the previous key instead of retiring it, so
+	// tokens it signed stop verifying at once. Use after a key compromise.
+	RevokePrevious bool `json:"revoke_previous"`
+}
+
+// RotateKeysResponseData describes the newly active signing key
+type RotateKeysResponseData struct {
+	KeyID           string `json:"kid"`
+	Algorithm       string `json:"alg"`
+	PreviousRevoked bool   `json:"previous_revoked"`
+}
+
+// SessionInfo describes one session without exposing its token
+type SessionInfo struct {
+	SessionID  string `json:"session_id"`
+	ClientType string `json:"client_type,omitempty"`
+	ClientIP   string `json:"client_ip,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	Current    bool   `json:"current"`
+
+	Scopes []string `json:"scopes,omitempty"`
+
+	// ImpersonatorID is set for sessions of an admin acting as the user
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+}
+
+// ListSessionsResponseData contains the caller's sessions
+type ListSessionsResponseData struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// RevokeSessionsResponseData reports how many sessions were revoked
+type RevokeSessionsResponseData struct {
+	Revoked int `json:"revoked"`
+}
+
+// AdminListUsersRequestData pages through the users of the request's
+// tenant
+type AdminListUsersRequestData struct {
+	Search string `json:"search,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+// AdminUserRequestData names the user of an admin request by ID or by
+// username in the request's tenant
+type AdminUserRequestData struct {
 	UserID   string `json:"user_id,omitempty"`
 	Username string `json:"username,omitempty"`
-	Email    string `json:"email,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+
+	// ExpiresIn in seconds lifts a suspension or disablement
+	// automatically; zero means it lasts until the user is enabled
+	ExpiresIn int64 `json:"expires_in,omitempty"`
 }
 
+// UserInfo describes a user account for administrators
+type UserInfo struct {
+	UserID    string `json:"user_id"`
+	Tenant    string `json:"tenant"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	Backend   string `json:"backend,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+
+	// StatusReason and StatusExpiresAt are set for inactive accounts
+	StatusReason    string `json:"status_reason,omitempty"`
+	StatusExpiresAt int64  `json:"status_expires_at,omitempty"`
+}
+
+// AdminListUsersResponseData contains a page of users and the number of
+// users matching the search
+type AdminListUsersResponseData struct {
+	Users []UserInfo `json:"users"`
+	Total int        `json:"total"`
+}
 
+// AdminUserResponseData contains a user with their roles and live sessions
+type AdminUserResponseData struct {
+	UserInfo
+	Roles       []string      `json:"roles"`
+	Permissions []string      `json:"permissions"`
+	Sessions    []SessionInfo `json:"sessions"`
+}
+
+// AuditEventInfo describes an audit event
+type AuditEventInfo struct {
+	ID        int64                  `json:"id"`
+	EventType string                 `json:"event_type"`
+	UserID    string                 `json:"user_id,omitempty"`
+	ActorID   string                 `json:"actor_id,omitempty"`
+	IPAddress string                 `json:"ip_address,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt int64                  `json:"created_at"`
+}
+
+// ExportMyDataResponseData is the archive of everything stored about the
+// caller
+type ExportMyDataResponseData struct {
+	ExportedAt  int64                   `json:"exported_at"`
+	Profile     UserInfo                `json:"profile"`
+	Roles       []string                `json:"roles"`
+	Permissions []string                `json:"permissions"`
+	Sessions    []SessionInfo           `json:"sessions"`
+	APIKeys     []APIKeyInfo            `json:"api_keys"`
+	Identities  []FederatedIdentityInfo `json:"identities"`
+	AuditEvents []AuditEventInfo        `json:"audit_events"`
+}
+
+// DeleteAccountResponseData says when a requested deletion takes effect
+type DeleteAccountResponseData struct {
+	DeleteAt int64 `json:"delete_at"`
+}
+
+// ImpersonateRequestData names the user an admin impersonates and why
+type ImpersonateRequestData struct {
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Reason   string `json:"reason"`
+
+	// TTL in seconds, capped at the configured impersonation lifetime
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// ImpersonateResponseData contains an impersonation session
+type ImpersonateResponseData struct {
+	Token          string `json:"token"`
+	SessionID      string `json:"session_id"`
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	Tenant         string `json:"tenant"`
+	ImpersonatorID string `json:"impersonator_id"`
+	ExpiresAt      int64  `json:"expires_at"`
+}
@@ -64,6 +64,16 @@ func (c *Client) Delete(key string) error {
 	return c.rdb.Del(c.ctx, key).Err()
 }
 
+// Take deletes a key and reports whether it existed. Of several callers
+// taking the same key, exactly one sees true.
+func (c *Client) Take(key string) (bool, error) {
+	count, err := c.rdb.Del(c.ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // Exists checks if a key exists
 func (c *Client) Exists(key string) (bool, error) {
 	count, err := c.rdb.Exists(c.ctx, key).Result()
@@ -88,6 +98,60 @@ func (c *Client) SRem(key string, members ...interface{}) error {
 	return c.rdb.SRem(c.ctx, key, members...).Err()
 }
 
+// ZAdd adds a member to a sorted set with a score
+func (c *Client) ZAdd(key string, score float64, member interface{}) error {
+	return c.rdb.ZAdd(c.ctx, key, redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZRange returns all members of a sorted set, lowest score first
+func (c *Client) ZRange(key string) ([]string, error) {
+	return c.rdb.ZRange(c.ctx, key, 0, -1).Result()
+}
+
+// ZRem removes members from a sorted set
+func (c *Client) ZRem(key string, members ...interface{}) error {
+	return c.rdb.ZRem(c.ctx, key, members...).Err()
+}
+
+// Script is a Lua script executed atomically by the server
+type Script struct {
+	script *redis.Script
+}
+
+// NewScript creates a script from Lua source
+func NewScript(src string) *Script {
+	return &Script{script: redis.NewScript(src)}
+}
+
+// RunScript runs a script, loading it into the server's cache if needed
+func (c *Client) RunScript(script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.script.Run(c.ctx, c.rdb, keys, args...).Result()
+}
+
+// incrWindowScript increments a counter and starts its window on the first
+// increment, so the counter can never be left without an expiry
+var incrWindowScript = NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// IncrWindow increments a counter that resets window after its first
+// increment and returns the new count
+func (c *Client) IncrWindow(key string, window time.Duration) (int64, error) {
+	result, err := c.RunScript(incrWindowScript, []string{key}, window.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	count, ok := result.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply from counter script")
+	}
+	return count, nil
+}
+
 // SetExpiration sets expiration on a key
 func (c *Client) SetExpiration(key string, expiration time.Duration) error {
 	return c.rdb.Expire(c.ctx, key, expiration).Err()
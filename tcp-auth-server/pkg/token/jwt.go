@@ -0,0 +1,54 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Sign signs claims with the key set's current signing key
+func Sign(keys KeySet, claims jwt.Claims) (string, error) {
+	key, err := keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	t := jwt.NewWithClaims(key.SigningMethod(), claims)
+	t.Header["kid"] = key.ID
+
+	signed, err := t.SignedString(key.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// Parse verifies a signed token against the key set and decodes its claims.
+// The key is selected by the token's kid header and must match the
+// algorithm the token claims to use.
+func Parse(keys KeySet, tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("token algorithm %s does not match key", t.Method.Alg())
+		}
+		return key.PublicKey(), nil
+	}, opts...)
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	return nil
+}
+
+// LooksLikeJWT reports whether a string has the shape of a compact JWS,
+// which lets callers tell signed tokens from opaque session tokens
+func LooksLikeJWT(s string) bool {
+	return strings.Count(s, ".") == 2
+}
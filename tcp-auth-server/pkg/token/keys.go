@@ -0,0 +1,254 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key lifecycle states
+const (
+	// KeyPending keys are published for verification but not yet used for
+	// signing, so verifiers can cache them before the first token appears
+	KeyPending = "pending"
+	// KeyActive keys sign new tokens
+	KeyActive = "active"
+	// KeyRetired keys no longer sign but still verify tokens issued before
+	// they were retired
+	KeyRetired = "retired"
+)
+
+// ErrKeyNotFound is returned when no key matches a key ID
+var ErrKeyNotFound = errors.New("signing key not found")
+
+// Key is an asymmetric signing key identified by its key ID
+type Key struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	State       string
+	CreatedAt   time.Time
+	ActivatedAt time.Time
+	RetiredAt   time.Time
+}
+
+// KeySet provides the key for signing new tokens and the public keys
+// that are still trusted for verification
+type KeySet interface {
+	// SigningKey returns the key new tokens are signed with
+	SigningKey() (*Key, error)
+
+	// VerificationKey returns the key with the given ID
+	VerificationKey(kid string) (*Key, error)
+
+	// VerificationKeys returns every key that is still trusted
+	VerificationKeys() []*Key
+}
+
+// PublicKey returns the public half of the key
+func (k *Key) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// SigningMethod returns the JWT signing method for the key's algorithm
+func (k *Key) SigningMethod() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodRS256
+	}
+}
+
+// GenerateKey creates a new key for the given algorithm. The key ID is the
+// RFC 7638 thumbprint of the public key.
+func GenerateKey(algorithm string) (*Key, error) {
+	var signer crypto.Signer
+	switch algorithm {
+	case AlgRS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		signer = rsaKey
+	case AlgEdDSA:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		signer = edKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+
+	return NewKey(algorithm, signer)
+}
+
+// NewKey wraps an existing private key, deriving its key ID
+func NewKey(algorithm string, signer crypto.Signer) (*Key, error) {
+	if err := checkAlgorithm(algorithm, signer); err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		Algorithm:  algorithm,
+		PrivateKey: signer,
+		State:      KeyActive,
+		CreatedAt:  time.Now(),
+	}
+	kid, err := Thumbprint(key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	key.ID = kid
+	return key, nil
+}
+
+// LoadKeyFile reads a PEM encoded PKCS#8 or PKCS#1 private key
+func LoadKeyFile(path, algorithm string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	signer, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(algorithm, signer)
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded PKCS#8 or PKCS#1 private key
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+
+	rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	return rsaKey, nil
+}
+
+// MarshalPrivateKeyPEM encodes a private key as PEM encoded PKCS#8
+func MarshalPrivateKeyPEM(signer crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signing key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of a public key
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	var members interface{}
+	// Required members only, in lexicographic order
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{encodeExponent(k.E), "RSA", b64(k.N.Bytes())}
+	case ed25519.PublicKey:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{"Ed25519", "OKP", b64(k)}
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return b64(sum[:]), nil
+}
+
+// checkAlgorithm verifies that a key type can be used with an algorithm
+func checkAlgorithm(algorithm string, signer crypto.Signer) error {
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == AlgRS256 {
+			return nil
+		}
+	case ed25519.PrivateKey:
+		if algorithm == AlgEdDSA {
+			return nil
+		}
+	}
+	return fmt.Errorf("key type %T cannot be used with %s", signer, algorithm)
+}
+
+// StaticKeySet is a KeySet holding a single key
+type StaticKeySet struct {
+	key *Key
+}
+
+// NewStaticKeySet creates a key set that signs and verifies with one key
+func NewStaticKeySet(key *Key) *StaticKeySet {
+	return &StaticKeySet{key: key}
+}
+
+// SigningKey returns the only key
+func (s *StaticKeySet) SigningKey() (*Key, error) {
+	return s.key, nil
+}
+
+// VerificationKey returns the only key if its ID matches
+func (s *StaticKeySet) VerificationKey(kid string) (*Key, error) {
+	if kid != s.key.ID {
+		return nil, ErrKeyNotFound
+	}
+	return s.key, nil
+}
+
+// VerificationKeys returns the only key
+func (s *StaticKeySet) VerificationKeys() []*Key {
+	return []*Key{s.key}
+}
+
+// b64 encodes bytes as unpadded base64url
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// encodeExponent encodes an RSA public exponent as base64url big-endian bytes
+func encodeExponent(e int) string {
+	var buf []byte
+	for ; e > 0; e >>= 8 {
+		buf = append([]byte{byte(e)}, buf...)
+	}
+	return b64(buf)
+}
@@ -0,0 +1,182 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// KeyStore persists signing keys so that every replica signs with the same
+// active key and trusts the same set of verification keys
+type KeyStore interface {
+	// LoadKeys returns every stored key
+	LoadKeys(ctx context.Context) ([]*Key, error)
+
+	// SaveKey inserts or updates a key
+	SaveKey(ctx context.Context, key *Key) error
+
+	// DeleteKey removes a key
+	DeleteKey(ctx context.Context, kid string) error
+}
+
+// storedKey is the serialized form of a key
+type storedKey struct {
+	ID          string    `json:"kid"`
+	Algorithm   string    `json:"alg"`
+	State       string    `json:"state"`
+	PrivateKey  string    `json:"private_key"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatedAt time.Time `json:"activated_at,omitempty"`
+	RetiredAt   time.Time `json:"retired_at,omitempty"`
+}
+
+// FileKeyStore keeps keys in a JSON file. It is meant for single-node
+// deployments; replicas that share a file must share a filesystem.
+type FileKeyStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileKeyStore creates a key store backed by the file at path
+func NewFileKeyStore(path string) *FileKeyStore {
+	return &FileKeyStore{path: path}
+}
+
+// LoadKeys reads every key from the file
+func (s *FileKeyStore) LoadKeys(ctx context.Context) ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(stored))
+	for _, sk := range stored {
+		signer, err := ParsePrivateKeyPEM([]byte(sk.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", sk.ID, err)
+		}
+		keys = append(keys, &Key{
+			ID:          sk.ID,
+			Algorithm:   sk.Algorithm,
+			PrivateKey:  signer,
+			State:       sk.State,
+			CreatedAt:   sk.CreatedAt,
+			ActivatedAt: sk.ActivatedAt,
+			RetiredAt:   sk.RetiredAt,
+		})
+	}
+	return keys, nil
+}
+
+// SaveKey inserts or replaces a key in the file
+func (s *FileKeyStore) SaveKey(ctx context.Context, key *Key) error {
+	pemData, err := MarshalPrivateKeyPEM(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	entry := storedKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		State:       key.State,
+		PrivateKey:  string(pemData),
+		CreatedAt:   key.CreatedAt,
+		ActivatedAt: key.ActivatedAt,
+		RetiredAt:   key.RetiredAt,
+	}
+
+	replaced := false
+	for i := range stored {
+		if stored[i].ID == key.ID {
+			stored[i] = entry
+			replaced = true
+		}
+	}
+	if !replaced {
+		stored = append(stored, entry)
+	}
+
+	return s.write(stored)
+}
+
+// DeleteKey removes a key from the file
+func (s *FileKeyStore) DeleteKey(ctx context.Context, kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	kept := stored[:0]
+	for _, sk := range stored {
+		if sk.ID != kid {
+			kept = append(kept, sk)
+		}
+	}
+	return s.write(kept)
+}
+
+// read loads the file, treating a missing file as empty
+func (s *FileKeyStore) read() ([]storedKey, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key store: %w", err)
+	}
+
+	var stored []storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse key store: %w", err)
+	}
+	return stored, nil
+}
+
+// write replaces the file atomically so readers never see a partial store
+func (s *FileKeyStore) write(stored []storedKey) error {
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	return nil
+}
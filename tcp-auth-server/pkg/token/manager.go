@@ -0,0 +1,268 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manager is a KeySet backed by a KeyStore that rotates keys on a schedule.
+//
+// At any time there is one active key that signs tokens and one pending key
+// that is already published so verifiers can cache it before rotation.
+// Rotation promotes the pending key and retires the previous active key.
+// Retired keys stay published until every token they signed has expired.
+type Manager struct {
+	store            KeyStore
+	algorithm        string
+	rotationInterval time.Duration
+	retention        time.Duration
+	now              func() time.Time // clock, replaced in tests
+
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+// NewManager creates a key manager. Retention must be at least the
+// lifetime of the longest-lived token signed by the keys.
+func NewManager(store KeyStore, algorithm string, rotationInterval, retention time.Duration) *Manager {
+	return &Manager{
+		store:            store,
+		algorithm:        algorithm,
+		rotationInterval: rotationInterval,
+		retention:        retention,
+		now:              time.Now,
+	}
+}
+
+// Init loads keys from the store and creates missing ones
+func (m *Manager) Init(ctx context.Context) error {
+	return m.maintain(ctx)
+}
+
+// Run reloads keys and performs due rotations until ctx is cancelled.
+// Reloading picks up rotations made by other replicas.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.maintain(ctx); err != nil {
+				log.Printf("Error maintaining signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// Rotate immediately promotes the pending key and retires the active one.
+// If revokePrevious is set the previous key is deleted instead of retired,
+// invalidating every token it signed; use this when a key is compromised.
+func (m *Manager) Rotate(ctx context.Context, revokePrevious bool) (*Key, error) {
+	if err := m.reload(ctx); err != nil {
+		return nil, err
+	}
+
+	previous := m.active()
+	next, err := m.rotate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokePrevious && previous != nil {
+		if err := m.store.DeleteKey(ctx, previous.ID); err != nil {
+			return nil, fmt.Errorf("failed to revoke key %s: %w", previous.ID, err)
+		}
+		log.Printf("Revoked signing key %s", previous.ID)
+	}
+
+	if err := m.maintain(ctx); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// SigningKey returns the active key
+func (m *Manager) SigningKey() (*Key, error) {
+	if key := m.active(); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no active signing key")
+}
+
+// VerificationKey returns any published key with the given ID
+func (m *Manager) VerificationKey(kid string) (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.ID == kid {
+			return key, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// VerificationKeys returns every published key: pending, active and retired
+func (m *Manager) VerificationKeys() []*Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*Key, len(m.keys))
+	copy(keys, m.keys)
+	return keys
+}
+
+// Keys returns every key with its lifecycle state
+func (m *Manager) Keys() []*Key {
+	return m.VerificationKeys()
+}
+
+// maintain brings the store to a consistent state: exactly one active key
+// that is not overdue for rotation, a pending key, and no retired keys past
+// their retention
+func (m *Manager) maintain(ctx context.Context) error {
+	if err := m.reload(ctx); err != nil {
+		return err
+	}
+
+	active := m.active()
+	if active == nil || m.now().Sub(active.ActivatedAt) >= m.rotationInterval {
+		if _, err := m.rotate(ctx); err != nil {
+			return err
+		}
+	}
+
+	if m.pending() == nil {
+		if _, err := m.createPending(ctx); err != nil {
+			return err
+		}
+	}
+
+	current := m.active()
+	for _, key := range m.VerificationKeys() {
+		if key.State == KeyActive && key.ID != current.ID {
+			retired := *key
+			retired.State = KeyRetired
+			retired.RetiredAt = m.now()
+			if err := m.store.SaveKey(ctx, &retired); err != nil {
+				return fmt.Errorf("failed to retire key %s: %w", key.ID, err)
+			}
+		}
+		if key.State == KeyRetired && m.now().Sub(key.RetiredAt) > m.retention {
+			if err := m.store.DeleteKey(ctx, key.ID); err != nil {
+				return fmt.Errorf("failed to delete retired key %s: %w", key.ID, err)
+			}
+			log.Printf("Deleted retired signing key %s", key.ID)
+		}
+	}
+
+	return m.reload(ctx)
+}
+
+// rotate promotes the pending key (creating one if needed) and retires
+// every other active key
+func (m *Manager) rotate(ctx context.Context) (*Key, error) {
+	next := m.pending()
+	if next == nil {
+		var err error
+		if next, err = m.createPending(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	now := m.now()
+	for _, key := range m.VerificationKeys() {
+		if key.State != KeyActive {
+			continue
+		}
+		retired := *key
+		retired.State = KeyRetired
+		retired.RetiredAt = now
+		if err := m.store.SaveKey(ctx, &retired); err != nil {
+			return nil, fmt.Errorf("failed to retire key %s: %w", key.ID, err)
+		}
+	}
+
+	activated := *next
+	activated.State = KeyActive
+	activated.ActivatedAt = now
+	if err := m.store.SaveKey(ctx, &activated); err != nil {
+		return nil, fmt.Errorf("failed to activate key %s: %w", next.ID, err)
+	}
+	log.Printf("Activated signing key %s", activated.ID)
+
+	if err := m.reload(ctx); err != nil {
+		return nil, err
+	}
+	return &activated, nil
+}
+
+// createPending generates and stores a new pending key
+func (m *Manager) createPending(ctx context.Context) (*Key, error) {
+	key, err := GenerateKey(m.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	key.State = KeyPending
+	key.CreatedAt = m.now()
+
+	if err := m.store.SaveKey(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to store pending key: %w", err)
+	}
+
+	m.mu.Lock()
+	m.keys = append(m.keys, key)
+	m.mu.Unlock()
+
+	return key, nil
+}
+
+// reload replaces the in-memory keys with the store's contents
+func (m *Manager) reload(ctx context.Context) error {
+	keys, err := m.store.LoadKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	m.mu.Lock()
+	m.keys = keys
+	m.mu.Unlock()
+	return nil
+}
+
+// active returns the most recently activated active key. Two replicas
+// rotating at the same moment can briefly leave two active keys; the
+// newest wins and the next maintenance pass retires the other.
+func (m *Manager) active() *Key {
+	return m.newest(KeyActive, func(k *Key) time.Time { return k.ActivatedAt })
+}
+
+// pending returns the newest pending key
+func (m *Manager) pending() *Key {
+	return m.newest(KeyPending, func(k *Key) time.Time { return k.CreatedAt })
+}
+
+// newest returns the key in state with the latest timestamp
+func (m *Manager) newest(state string, at func(*Key) time.Time) *Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found *Key
+	for _, key := range m.keys {
+		if key.State == state && (found == nil || at(key).After(at(found))) {
+			found = key
+		}
+	}
+	return found
+}
@@ -0,0 +1,251 @@
+package token
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryKeyStore is a KeyStore that keeps copies of keys in memory
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]Key
+}
+
+func newMemoryKeyStore() *memoryKeyStore {
+	return &memoryKeyStore{keys: make(map[string]Key)}
+}
+
+func (s *memoryKeyStore) LoadKeys(ctx context.Context) ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		key := key
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+func (s *memoryKeyStore) SaveKey(ctx context.Context, key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = *key
+	return nil
+}
+
+func (s *memoryKeyStore) DeleteKey(ctx context.Context, kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, kid)
+	return nil
+}
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+const (
+	testRotationInterval = 24 * time.Hour
+	testRetention        = time.Hour
+)
+
+func newTestManager(t *testing.T) (*Manager, *memoryKeyStore, *fakeClock) {
+	t.Helper()
+	store := newMemoryKeyStore()
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	m := NewManager(store, AlgEdDSA, testRotationInterval, testRetention)
+	m.now = clock.Now
+	if err := m.Init(context.Background()); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return m, store, clock
+}
+
+// states maps every published key ID to its state
+func states(m *Manager) map[string]string {
+	published := make(map[string]string)
+	for _, key := range m.VerificationKeys() {
+		published[key.ID] = key.State
+	}
+	return published
+}
+
+// jwksKids returns the sorted key IDs published in the JWKS document
+func jwksKids(m *Manager) []string {
+	var kids []string
+	for _, jwk := range BuildJWKS(m).Keys {
+		kids = append(kids, jwk.Kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+func sortedKids(kids ...string) []string {
+	sort.Strings(kids)
+	return kids
+}
+
+func TestManagerInit(t *testing.T) {
+	m, _, _ := newTestManager(t)
+
+	active := m.active()
+	pending := m.pending()
+	if active == nil || pending == nil {
+		t.Fatalf("Init left active %v and pending %v, want both", active, pending)
+	}
+	if got := len(m.VerificationKeys()); got != 2 {
+		t.Errorf("published %d keys, want 2", got)
+	}
+
+	signing, err := m.SigningKey()
+	if err != nil || signing.ID != active.ID {
+		t.Errorf("SigningKey() = %v, %v, want the active key", signing, err)
+	}
+	if _, err := m.VerificationKey(pending.ID); err != nil {
+		t.Errorf("pending key is not published: %v", err)
+	}
+}
+
+func TestManagerLifecycle(t *testing.T) {
+	ctx := context.Background()
+	m, _, clock := newTestManager(t)
+
+	first := m.active().ID
+	second := m.pending().ID
+
+	// Nothing is due before the rotation interval has passed
+	clock.Advance(testRotationInterval - time.Minute)
+	if err := m.maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := states(m), map[string]string{first: KeyActive, second: KeyPending}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("before rotation: %v, want %v", got, want)
+	}
+
+	// The pending key is promoted, the active key retired and a new key is
+	// made pending
+	clock.Advance(time.Minute)
+	rotatedAt := clock.Now()
+	if err := m.maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	third := m.pending().ID
+	want := map[string]string{first: KeyRetired, second: KeyActive, third: KeyPending}
+	if got := states(m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after rotation: %v, want %v", got, want)
+	}
+	retired, err := m.VerificationKey(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !retired.RetiredAt.Equal(rotatedAt) {
+		t.Errorf("RetiredAt = %v, want %v", retired.RetiredAt, rotatedAt)
+	}
+	if signing, _ := m.SigningKey(); signing.ID != second {
+		t.Errorf("signing with %s, want the promoted key %s", signing.ID, second)
+	}
+
+	// The retired key stays published for the whole retention
+	clock.Advance(testRetention)
+	if err := m.maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jwksKids(m), sortedKids(first, second, third); !reflect.DeepEqual(got, want) {
+		t.Errorf("JWKS within retention = %v, want %v", got, want)
+	}
+
+	// and is pruned once the retention has passed
+	clock.Advance(time.Second)
+	if err := m.maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jwksKids(m), sortedKids(second, third); !reflect.DeepEqual(got, want) {
+		t.Errorf("JWKS after retention = %v, want %v", got, want)
+	}
+	if _, err := m.VerificationKey(first); err != ErrKeyNotFound {
+		t.Errorf("VerificationKey of a pruned key = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestManagerRotate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		revokePrevious bool
+	}{
+		{"retire", false},
+		{"revoke", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, store, clock := newTestManager(t)
+			previous := m.active().ID
+			pending := m.pending().ID
+
+			clock.Advance(time.Minute)
+			next, err := m.Rotate(ctx, tt.revokePrevious)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next.ID != pending {
+				t.Errorf("Rotate activated %s, want the published pending key %s", next.ID, pending)
+			}
+			if !next.ActivatedAt.Equal(clock.Now()) {
+				t.Errorf("ActivatedAt = %v, want %v", next.ActivatedAt, clock.Now())
+			}
+
+			_, stored := store.keys[previous]
+			published := states(m)[previous]
+			if tt.revokePrevious {
+				if stored || published != "" {
+					t.Errorf("revoked key is still stored (%v) or published (%q)", stored, published)
+				}
+			} else if published != KeyRetired {
+				t.Errorf("previous key is %q, want %q", published, KeyRetired)
+			}
+			if m.pending() == nil {
+				t.Error("no pending key after rotation")
+			}
+		})
+	}
+}
+
+func TestManagerReloadsOtherReplicas(t *testing.T) {
+	ctx := context.Background()
+	m, store, clock := newTestManager(t)
+
+	// A second replica sharing the store rotates first
+	other := NewManager(store, AlgEdDSA, testRotationInterval, testRetention)
+	other.now = clock.Now
+	clock.Advance(time.Minute)
+	rotated, err := other.Rotate(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if signing, _ := m.SigningKey(); signing.ID != rotated.ID {
+		t.Errorf("signing with %s after reload, want %s", signing.ID, rotated.ID)
+	}
+	if got, want := jwksKids(m), jwksKids(other); !reflect.DeepEqual(got, want) {
+		t.Errorf("replicas publish %v and %v", got, want)
+	}
+}